@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// baseImages is the catalog of base images' repositories (e.g.
+// "docker.io/library/debian"); base images aren't detected when it's empty.
+var baseImages []string
+
+// showScanBaseImage detects the base image of a scan (id param) among the
+// catalog's latest scans, recommending its newer tags with fewer
+// vulnerabilities.
+func showScanBaseImage(ctx echo.Context) error {
+
+	if len(baseImages) == 0 {
+		return echo.NewHTTPError(http.StatusNotImplemented, "base images catalog is not configured")
+	}
+
+	s, err := db.GetStorage().GetScanByID(ctx.Param("id"))
+
+	if err != nil {
+		return scanLookupError(err)
+	}
+
+	catalog, err := loadBaseImagesCatalog()
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, scan.DetectBaseImages(s, catalog))
+}
+
+// loadBaseImagesCatalog gets the latest completed scan of each tag of the
+// base images' repositories.
+func loadBaseImagesCatalog() ([]scan.Scan, error) {
+	return db.GetStorage().GetLatestScansByRepositories(baseImages)
+}
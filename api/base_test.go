@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowScanBaseImage(t *testing.T) {
+
+	layers := func(digests ...string) []scan.Layer {
+		var layers []scan.Layer
+
+		for _, digest := range digests {
+			layers = append(layers, scan.Layer{Digest: digest})
+		}
+
+		return layers
+	}
+
+	high := []scan.Finding{{CVE: "CVE-2018-1000001", Package: "glibc", Severity: "High"}}
+
+	scans := map[string]scan.Scan{
+		"app": {
+			ID:     "app",
+			Image:  "docker.io/tsuru/cst:latest",
+			Result: []scan.Result{{Scanner: "clair", Layers: layers("sha256:debian-9", "sha256:app"), Findings: high}},
+		},
+		"debian-9": {
+			ID:     "debian-9",
+			Image:  "docker.io/library/debian:9",
+			Result: []scan.Result{{Scanner: "clair", Layers: layers("sha256:debian-9"), Findings: high}},
+		},
+		"debian-10": {
+			ID:     "debian-10",
+			Image:  "docker.io/library/debian:10",
+			Result: []scan.Result{{Scanner: "clair", Layers: layers("sha256:debian-10")}},
+		},
+	}
+
+	db.SetStorage(&db.MockStorage{
+		MockGetScanByID: func(id string) (scan.Scan, error) {
+			if s, ok := scans[id]; ok {
+				return s, nil
+			}
+
+			return scan.Scan{}, db.ErrScanNotFound
+		},
+		MockGetLatestScansByRepositories: func(repositories []string) ([]scan.Scan, error) {
+			assert.Equal(t, []string{"docker.io/library/debian"}, repositories)
+
+			return []scan.Scan{scans["debian-9"], scans["debian-10"]}, nil
+		},
+	})
+
+	request := func(id string) *httptest.ResponseRecorder {
+		e := echo.New()
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(id)
+
+		if err := showScanBaseImage(ctx); err != nil {
+			e.HTTPErrorHandler(err, ctx)
+		}
+
+		return recorder
+	}
+
+	t.Run(`When catalog is not configured, should return 501 status code`, func(t *testing.T) {
+		baseImages = nil
+
+		assert.Equal(t, http.StatusNotImplemented, request("app").Code)
+	})
+
+	t.Run(`When image is built from a catalog image, should return it and its newer tags`, func(t *testing.T) {
+		baseImages = []string{"docker.io/library/debian"}
+
+		defer func() { baseImages = nil }()
+
+		recorder := request("app")
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var reports []scan.BaseImageReport
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &reports))
+		require.Len(t, reports, 1)
+		require.NotNil(t, reports[0].Base)
+
+		assert.Equal(t, "docker.io/library/debian:9", reports[0].Base.Image)
+		require.Len(t, reports[0].Recommendations, 1)
+		assert.Equal(t, "docker.io/library/debian:10", reports[0].Recommendations[0].Image)
+	})
+
+	t.Run(`When scan does not exist, should return 404 status code`, func(t *testing.T) {
+		baseImages = []string{"docker.io/library/debian"}
+
+		defer func() { baseImages = nil }()
+
+		assert.Equal(t, http.StatusNotFound, request("unknown").Code)
+	})
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/registry"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+// batchRequest lists the images to be scanned and/or a registry repository
+// whose tags matching a glob pattern (e.g. "v1.*") should be scanned.
+type batchRequest struct {
+	Images     []string `json:"images"`
+	Repository string   `json:"repository"`
+	Tags       string   `json:"tags"`
+}
+
+// batchRewrite routes "/v1/scans:batch" to "/v1/scans/batch", since colons
+// start path params on echo routes.
+var batchRewrite = middleware.Rewrite(map[string]string{
+	"^/v1/scans:batch$": "/v1/scans/batch",
+})
+
+// listTags returns the tags of a registry repository (e.g. "tsuru/cst").
+var listTags = func(repository string) ([]string, error) {
+
+	address, name := registry.ParseRepository(repository)
+
+	return crawler.Credentials.Client(address).Tags(name)
+}
+
+func createBatch(ctx echo.Context) error {
+
+	var request batchRequest
+
+	if err := ctx.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	images := make([]string, 0, len(request.Images))
+
+	for _, image := range request.Images {
+		images = append(images, strings.Replace(image, " ", "", -1))
+	}
+
+	if request.Repository != "" {
+		tags, err := repositoryImages(request.Repository, request.Tags)
+
+		if err != nil {
+			return err
+		}
+
+		images = append(images, tags...)
+	}
+
+	if len(images) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "the images or repository key is required")
+	}
+
+	batch, err := schd.ScheduleBatch(ctx.Request().Context(), scheduler, images)
+
+	switch err {
+	case nil:
+		return ctx.JSON(http.StatusCreated, batch)
+	case schd.ErrBatchTooLarge:
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+}
+
+// repositoryImages expands a repository to its images whose tags match
+// pattern (all tags when empty).
+func repositoryImages(repository, pattern string) ([]string, error) {
+
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "the tags key is not a valid pattern")
+	}
+
+	tags, err := listTags(repository)
+
+	switch err {
+	case nil:
+	case registry.ErrNotFound:
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "repository not found on registry")
+	case registry.ErrUnauthorized:
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "unauthorized to list repository tags")
+	default:
+		return nil, echo.NewHTTPError(http.StatusBadGateway, "could not list repository tags")
+	}
+
+	var images []string
+
+	for _, tag := range tags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			images = append(images, repository+":"+tag)
+		}
+	}
+
+	return images, nil
+}
+
+func showBatch(ctx echo.Context) error {
+
+	storage := db.GetStorage()
+
+	batch, err := storage.GetBatchByID(ctx.Param("id"))
+
+	if err == db.ErrBatchNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "batch not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	scans, err := storage.GetScansByIDs(batch.ScanIDs())
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, batch.Progress(scans))
+}
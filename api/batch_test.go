@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/registry"
+	"github.com/tsuru/cst/scan"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+func TestCreateBatch(t *testing.T) {
+	defer func(original schd.Scheduler, originalListTags func(string) ([]string, error)) {
+		scheduler = original
+		listTags = originalListTags
+	}(scheduler, listTags)
+
+	t.Run(`Ensure images and repository tags matching the pattern are scheduled`, func(t *testing.T) {
+		var scheduled []string
+
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+				scheduled = append(scheduled, image)
+
+				return scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: image}, nil
+			},
+		}
+
+		listTags = func(repository string) ([]string, error) {
+			assert.Equal(t, "registry.tld/tsuru/api", repository)
+
+			return []string{"latest", "v1.0", "v1.1", "v2.0"}, nil
+		}
+
+		db.SetStorage(&db.MockStorage{})
+
+		e := echo.New()
+		e.Pre(batchRewrite)
+		e.POST("/v1/scans/batch", createBatch)
+
+		body := `{"images": ["tsuru/cst:latest"], "repository": "registry.tld/tsuru/api", "tags": "v1.*"}`
+		request := httptest.NewRequest(http.MethodPost, "/v1/scans:batch", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+
+		e.ServeHTTP(recorder, request)
+
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, []string{"docker.io/tsuru/cst:latest", "registry.tld/tsuru/api:v1.0", "registry.tld/tsuru/api:v1.1"}, scheduled)
+
+		var batch scan.Batch
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &batch))
+		assert.NotEmpty(t, batch.ID)
+		assert.Len(t, batch.Items, 3)
+	})
+
+	t.Run(`When there are no images, should return 400 status code`, func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"images": []}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createBatch(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run(`When repository is not found on registry, should return 400 status code`, func(t *testing.T) {
+		listTags = func(string) ([]string, error) {
+			return nil, registry.ErrNotFound
+		}
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"repository": "tsuru/nothing"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createBatch(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run(`When batch has too many images, should return 413 status code`, func(t *testing.T) {
+		images := make([]string, schd.MaxBatchSize+1)
+
+		for i := range images {
+			images[i] = "tsuru/cst:latest"
+		}
+
+		body, _ := json.Marshal(map[string][]string{"images": images})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createBatch(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+}
+
+func TestShowBatch(t *testing.T) {
+	t.Run(`Ensure the batch is returned with its scans' progress`, func(t *testing.T) {
+		var gotIDs []string
+
+		db.SetStorage(&db.MockStorage{
+			MockGetBatchByID: func(id string) (scan.Batch, error) {
+				return scan.Batch{
+					ID: id,
+					Items: []scan.BatchItem{
+						{Image: "tsuru/cst:latest", Status: scan.BatchItemCreated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+						{Image: "tsuru/api:latest", Status: scan.BatchItemDeduplicated, ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf"},
+					},
+				}, nil
+			},
+
+			MockGetScansByIDs: func(ids []string) ([]scan.Scan, error) {
+				gotIDs = ids
+
+				return []scan.Scan{
+					{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: scan.StatusFinished},
+					{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Status: scan.StatusRunning},
+				}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11")
+
+		require.NoError(t, showBatch(ctx))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, []string{"2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf"}, gotIDs)
+
+		var progress scan.BatchProgress
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &progress))
+		assert.Equal(t, "f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11", progress.ID)
+		assert.Equal(t, 2, progress.Total)
+		assert.Equal(t, 1, progress.Completed)
+		assert.False(t, progress.Done)
+	})
+
+	t.Run(`When batch does not exist, should return 404 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetBatchByID: func(string) (scan.Batch, error) {
+				return scan.Batch{}, db.ErrBatchNotFound
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11")
+
+		err := showBatch(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run(`When storage returns any error, should return 500 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByIDs: func([]string) ([]scan.Scan, error) {
+				return nil, errors.New("just another error on storage")
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11")
+
+		err := showBatch(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/registry"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+var crawler = &registry.Crawler{}
+
+func createCrawl(ctx echo.Context) error {
+
+	var spec registry.CrawlSpec
+
+	if err := ctx.Bind(&spec); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	if spec.Target == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "the target key is required")
+	}
+
+	images, err := crawler.Crawl(spec)
+
+	switch err {
+	case nil:
+	case registry.ErrInvalidPattern, registry.ErrNotFound, registry.ErrUnauthorized:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusBadGateway, "could not crawl registry")
+	}
+
+	if len(images) == 0 {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	batches, err := schd.ScheduleBatches(ctx.Request().Context(), scheduler, images)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusCreated, batches)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+func TestCreateCrawl(t *testing.T) {
+	defer func(original schd.Scheduler) {
+		scheduler = original
+	}(scheduler)
+
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			json.NewEncoder(w).Encode(map[string][]string{"repositories": {"team/api", "team/web"}})
+		case "/v2/team/api/tags/list", "/v2/team/web/tags/list":
+			json.NewEncoder(w).Encode(map[string][]string{"tags": {"v1", "v2"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer registryServer.Close()
+
+	host := strings.TrimPrefix(registryServer.URL, "http://")
+
+	t.Run(`Ensure crawled images are scheduled in a batch`, func(t *testing.T) {
+		var scheduled []string
+
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+				scheduled = append(scheduled, image)
+
+				return scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"}, nil
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{})
+
+		body := `{"target": "` + registryServer.URL + `/team/*", "latestTags": 1}`
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, createCrawl(ctx))
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, []string{host + "/team/api:v2", host + "/team/web:v2"}, scheduled)
+
+		var batches []scan.Batch
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &batches))
+		require.Len(t, batches, 1)
+		assert.Len(t, batches[0].Items, 2)
+	})
+
+	t.Run(`When no images are found, should return 204 status code`, func(t *testing.T) {
+		body := `{"target": "` + registryServer.URL + `/other/*"}`
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, createCrawl(ctx))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	})
+
+	t.Run(`When repository is not found on registry, should return 400 status code`, func(t *testing.T) {
+		body := `{"target": "` + registryServer.URL + `/team/nothing"}`
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createCrawl(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run(`When target is missing, should return 400 status code`, func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createCrawl(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+func showDeadLetters(ctx echo.Context) error {
+
+	scans, err := db.GetStorage().GetScansByStatus(scan.StatusDeadLetter)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if len(scans) == 0 {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	return ctx.JSON(http.StatusOK, scans)
+}
+
+func redriveDeadLetter(ctx echo.Context) error {
+
+	scan, err := scheduler.Redrive(ctx.Request().Context(), ctx.Param("id"))
+
+	switch err {
+	case nil:
+		return ctx.JSON(http.StatusAccepted, scan)
+	case db.ErrScanNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case schd.ErrScanIsNotDeadLetter:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+func TestShowDeadLetters(t *testing.T) {
+	t.Run(`When there are no scans in dead-letter status, should return 204 status code`, func(t *testing.T) {
+		gotStatus := scan.Status("")
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByStatus: func(status scan.Status) ([]scan.Scan, error) {
+				gotStatus = status
+
+				return []scan.Scan{}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, showDeadLetters(ctx))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Equal(t, scan.StatusDeadLetter, gotStatus)
+	})
+
+	t.Run(`When storage returns any error, should return 500 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByStatus: func(status scan.Status) ([]scan.Scan, error) {
+				return nil, errors.New("just another error on storage")
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := showDeadLetters(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
+
+func TestRedriveDeadLetter(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{`When scan is re-driven, should return 202 status code`, nil, http.StatusAccepted},
+		{`When scan does not exist, should return 404 status code`, db.ErrScanNotFound, http.StatusNotFound},
+		{`When scan is not in dead-letter status, should return 409 status code`, schd.ErrScanIsNotDeadLetter, http.StatusConflict},
+		{`When scheduler returns any other error, should return 500 status code`, errors.New("something went wrong"), http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			gotID := ""
+
+			scheduler = &schd.MockScheduler{
+				MockRedrive: func(_ context.Context, id string) (scan.Scan, error) {
+					gotID = id
+
+					return scan.Scan{ID: id, Status: scan.StatusScheduled}, c.err
+				},
+			}
+
+			e := echo.New()
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
+			recorder := httptest.NewRecorder()
+			ctx := e.NewContext(request, recorder)
+
+			ctx.SetPath("/v1/dead-letters/:id/redrive")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("2c5a5f48-9801-40ef-8d81-cd4a0f9c0ee2")
+
+			err := redriveDeadLetter(ctx)
+
+			if err != nil {
+				e.HTTPErrorHandler(err, ctx)
+			}
+
+			assert.Equal(t, "2c5a5f48-9801-40ef-8d81-cd4a0f9c0ee2", gotID)
+			assert.Equal(t, c.expected, recorder.Code)
+		})
+	}
+}
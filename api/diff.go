@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// showScanDiff returns what changed from a scan (otherId param or, when
+// omitted, the previous completed scan of the same image) to another one (id
+// param).
+func showScanDiff(ctx echo.Context) error {
+
+	storage := db.GetStorage()
+
+	current, err := storage.GetScanByID(ctx.Param("id"))
+
+	if err != nil {
+		return scanLookupError(err)
+	}
+
+	var previous scan.Scan
+
+	if otherID := ctx.Param("otherId"); otherID != "" {
+		previous, err = storage.GetScanByID(otherID)
+
+		if err != nil {
+			return scanLookupError(err)
+		}
+
+		if previous.Image != current.Image {
+			return echo.NewHTTPError(http.StatusBadRequest, "scans must be of the same image")
+		}
+	} else {
+		scans, err := storage.GetScansByImage(current.Image)
+
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		var found bool
+
+		if previous, found = previousCompletedScan(current, scans); !found {
+			return echo.NewHTTPError(http.StatusNotFound, "there is no previous scan of this image")
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, scan.DiffScans(previous, current))
+}
+
+// previousCompletedScan returns the latest scan, among scans, created before
+// current whose analysis has completed (finished or partial).
+func previousCompletedScan(current scan.Scan, scans []scan.Scan) (scan.Scan, bool) {
+
+	var previous scan.Scan
+	found := false
+
+	for _, s := range scans {
+		if s.ID == current.ID || !s.CreatedAt.Before(current.CreatedAt) {
+			continue
+		}
+
+		if s.Status != scan.StatusFinished && s.Status != scan.StatusPartial {
+			continue
+		}
+
+		if !found || s.CreatedAt.After(previous.CreatedAt) {
+			previous = s
+			found = true
+		}
+	}
+
+	return previous, found
+}
+
+func scanLookupError(err error) error {
+
+	if err == db.ErrScanNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	return echo.NewHTTPError(http.StatusInternalServerError)
+}
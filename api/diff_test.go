@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowScanDiff(t *testing.T) {
+
+	now := time.Now()
+
+	scans := map[string]scan.Scan{
+		"old": scan.Scan{
+			ID:        "old",
+			Image:     "tsuru/cst:latest",
+			Status:    scan.StatusFinished,
+			CreatedAt: now.Add(-2 * time.Hour),
+			Result: []scan.Result{
+				{Scanner: "clair", Findings: []scan.Finding{{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"}}},
+			},
+		},
+		"failed": scan.Scan{
+			ID:        "failed",
+			Image:     "tsuru/cst:latest",
+			Status:    scan.StatusFailed,
+			CreatedAt: now.Add(-time.Hour),
+		},
+		"new": scan.Scan{
+			ID:        "new",
+			Image:     "tsuru/cst:latest",
+			Status:    scan.StatusFinished,
+			CreatedAt: now,
+			Result: []scan.Result{
+				{Scanner: "clair", Findings: []scan.Finding{{CVE: "CVE-2018-14618", Scanner: "clair", Package: "curl"}}},
+			},
+		},
+		"another-image": scan.Scan{
+			ID:    "another-image",
+			Image: "tsuru/api:latest",
+		},
+	}
+
+	db.SetStorage(&db.MockStorage{
+		MockGetScanByID: func(id string) (scan.Scan, error) {
+			if s, ok := scans[id]; ok {
+				return s, nil
+			}
+
+			return scan.Scan{}, db.ErrScanNotFound
+		},
+		MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+			return []scan.Scan{scans["new"], scans["failed"], scans["old"]}, nil
+		},
+	})
+
+	request := func(params ...string) *httptest.ResponseRecorder {
+		e := echo.New()
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+
+		names := []string{"id", "otherId"}
+
+		ctx.SetParamNames(names[:len(params)]...)
+		ctx.SetParamValues(params...)
+
+		if err := showScanDiff(ctx); err != nil {
+			e.HTTPErrorHandler(err, ctx)
+		}
+
+		return recorder
+	}
+
+	t.Run(`When other scan is omitted, should diff against the previous completed scan of the image`, func(t *testing.T) {
+		recorder := request("new")
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var diff scan.Diff
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &diff))
+
+		assert.Equal(t, "old", diff.From)
+		assert.Equal(t, "new", diff.To)
+		require.Len(t, diff.Packages, 2)
+		assert.Equal(t, "curl", diff.Packages[0].Package)
+		assert.Len(t, diff.Packages[0].Added, 1)
+		assert.Equal(t, "openssl", diff.Packages[1].Package)
+		assert.Len(t, diff.Packages[1].Removed, 1)
+	})
+
+	t.Run(`When other scan is assigned, should diff against it`, func(t *testing.T) {
+		recorder := request("new", "failed")
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var diff scan.Diff
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &diff))
+
+		assert.Equal(t, "failed", diff.From)
+	})
+
+	t.Run(`When there is no previous scan, should return 404 status code`, func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request("old").Code)
+	})
+
+	t.Run(`When any scan does not exist, should return 404 status code`, func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request("unknown").Code)
+		assert.Equal(t, http.StatusNotFound, request("new", "unknown").Code)
+	})
+
+	t.Run(`When scans are of different images, should return 400 status code`, func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, request("new", "another-image").Code)
+	})
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// eventStreamHeartbeat is how often a comment is sent on idle event streams,
+// so proxies don't close them.
+var eventStreamHeartbeat = 15 * time.Second
+
+// showScanEvents streams the changes on a scan as Server-Sent Events. The
+// current status of the scan is sent first, and the stream ends when the scan
+// reaches a final status.
+func showScanEvents(ctx echo.Context) error {
+
+	id := ctx.Param("id")
+
+	storage := db.GetStorage()
+
+	// watching before reading the scan, so no change in between is lost
+	events, err := storage.WatchScanEvents(ctx.Request().Context(), db.EventFilter{ScanID: id})
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	s, err := storage.GetScanByID(id)
+
+	if err == db.ErrScanNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, "scan not found")
+	}
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	current := scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: s.ID,
+		Image:  s.Image,
+		Status: s.Status,
+		Time:   time.Now(),
+	}
+
+	return streamEvents(ctx, current, events)
+}
+
+// showEvents streams the changes on all scans (or only on the scans of the
+// image given by query param) as Server-Sent Events.
+func showEvents(ctx echo.Context) error {
+
+	filter := db.EventFilter{Image: ctx.QueryParam("image")}
+
+	events, err := db.GetStorage().WatchScanEvents(ctx.Request().Context(), filter)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	return streamEvents(ctx, scan.Event{}, events)
+}
+
+// streamEvents writes the first event (if any) and the ones received from
+// events until the client goes away, events is closed or, when streaming a
+// single scan, it reaches a final status.
+func streamEvents(ctx echo.Context, first scan.Event, events <-chan scan.Event) error {
+
+	response := ctx.Response()
+
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	response.WriteHeader(http.StatusOK)
+
+	singleScan := first.ScanID != ""
+
+	if singleScan {
+		if err := writeEvent(response, first); err != nil || first.IsFinal() {
+			return err
+		}
+	}
+
+	response.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	done := ctx.Request().Context().Done()
+
+	for {
+		select {
+		case <-done:
+			return nil
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(response, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+
+			response.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			if err := writeEvent(response, event); err != nil {
+				return err
+			}
+
+			if singleScan && event.IsFinal() {
+				return nil
+			}
+		}
+	}
+}
+
+// writeEvent writes event on Server-Sent Events format, named by its type.
+func writeEvent(response *echo.Response, event scan.Event) error {
+
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+
+	response.Flush()
+
+	return nil
+}
+
+// untilShutdown cancels the requests' context when done is, so long-lived
+// responses (e.g. event streams) don't hold the server's graceful shutdown.
+func untilShutdown(done context.Context) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+
+			requestCtx, cancel := context.WithCancel(ctx.Request().Context())
+			defer cancel()
+
+			go func() {
+				select {
+				case <-done.Done():
+					cancel()
+				case <-requestCtx.Done():
+				}
+			}()
+
+			ctx.SetRequest(ctx.Request().WithContext(requestCtx))
+
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowScanEvents(t *testing.T) {
+	t.Run(`Ensure the current status and the following changes are streamed until the scan is finished`, func(t *testing.T) {
+		var gotFilter db.EventFilter
+
+		events := make(chan scan.Event, 3)
+		events <- scan.Event{Type: scan.EventResultAppended, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Scanner: "clair"}
+		events <- scan.Event{Type: scan.EventStatusChanged, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: scan.StatusFinished}
+		events <- scan.Event{Type: scan.EventStatusChanged, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: scan.StatusScheduled}
+
+		db.SetStorage(&db.MockStorage{
+			MockWatchScanEvents: func(ctx context.Context, filter db.EventFilter) (<-chan scan.Event, error) {
+				gotFilter = filter
+
+				return events, nil
+			},
+
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{ID: id, Image: "tsuru/cst:latest", Status: scan.StatusRunning}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("2b935a8f-4241-49f0-a1a2-e3c8ba347b95")
+
+		require.NoError(t, showScanEvents(ctx))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "text/event-stream", recorder.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", gotFilter.ScanID)
+
+		body := recorder.Body.String()
+
+		assert.Equal(t, 3, strings.Count(body, "data: "))
+		assert.Equal(t, 2, strings.Count(body, "event: status\n"))
+		assert.Equal(t, 1, strings.Count(body, "event: result\n"))
+		assert.Contains(t, body, `"status":"running"`)
+		assert.Contains(t, body, `"status":"finished"`)
+		assert.NotContains(t, body, `"status":"scheduled"`)
+	})
+
+	t.Run(`When scan is already finished, should stream only its current status`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockWatchScanEvents: func(context.Context, db.EventFilter) (<-chan scan.Event, error) {
+				return make(chan scan.Event), nil
+			},
+
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{ID: id, Status: scan.StatusFailed}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("2b935a8f-4241-49f0-a1a2-e3c8ba347b95")
+
+		require.NoError(t, showScanEvents(ctx))
+		assert.Equal(t, 1, strings.Count(recorder.Body.String(), "data: "))
+		assert.Contains(t, recorder.Body.String(), `"status":"failed"`)
+	})
+
+	t.Run(`When scan does not exist, should return 404 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetScanByID: func(string) (scan.Scan, error) {
+				return scan.Scan{}, db.ErrScanNotFound
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("2b935a8f-4241-49f0-a1a2-e3c8ba347b95")
+
+		err := showScanEvents(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run(`When storage can't watch events, should return 500 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockWatchScanEvents: func(context.Context, db.EventFilter) (<-chan scan.Event, error) {
+				return nil, errors.New("just another error on storage")
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues("2b935a8f-4241-49f0-a1a2-e3c8ba347b95")
+
+		err := showScanEvents(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
+
+func TestShowEvents(t *testing.T) {
+	t.Run(`Ensure events of the given image are streamed until the client goes away`, func(t *testing.T) {
+		var gotFilter db.EventFilter
+
+		events := make(chan scan.Event, 2)
+		events <- scan.Event{Type: scan.EventStatusChanged, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: scan.StatusFinished}
+		events <- scan.Event{Type: scan.EventStatusChanged, ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Status: scan.StatusScheduled}
+
+		db.SetStorage(&db.MockStorage{
+			MockWatchScanEvents: func(ctx context.Context, filter db.EventFilter) (<-chan scan.Event, error) {
+				gotFilter = filter
+
+				return events, nil
+			},
+		})
+
+		requestCtx, cancel := context.WithCancel(context.Background())
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/?image=tsuru%2Fcst%3Alatest", nil).WithContext(requestCtx)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		require.NoError(t, showEvents(ctx))
+		assert.Equal(t, "tsuru/cst:latest", gotFilter.Image)
+		assert.Equal(t, 2, strings.Count(recorder.Body.String(), "data: "))
+	})
+
+	t.Run(`Ensure heartbeats are sent on idle streams`, func(t *testing.T) {
+		defer func(heartbeat time.Duration) {
+			eventStreamHeartbeat = heartbeat
+		}(eventStreamHeartbeat)
+
+		eventStreamHeartbeat = 10 * time.Millisecond
+
+		events := make(chan scan.Event)
+
+		db.SetStorage(&db.MockStorage{
+			MockWatchScanEvents: func(context.Context, db.EventFilter) (<-chan scan.Event, error) {
+				return events, nil
+			},
+		})
+
+		time.AfterFunc(50*time.Millisecond, func() { close(events) })
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, showEvents(ctx))
+		assert.Contains(t, recorder.Body.String(), ": heartbeat\n\n")
+	})
+}
+
+func TestUntilShutdown(t *testing.T) {
+	t.Run(`Ensure request's context is canceled on shutdown`, func(t *testing.T) {
+		shutdown, cancel := context.WithCancel(context.Background())
+
+		handler := untilShutdown(shutdown)(func(ctx echo.Context) error {
+			cancel()
+
+			select {
+			case <-ctx.Request().Context().Done():
+				return nil
+			case <-time.After(time.Second):
+				return errors.New("request's context was not canceled")
+			}
+		})
+
+		e := echo.New()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+		assert.NoError(t, handler(ctx))
+	})
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/tsuru/cst/db"
+	hc "github.com/tsuru/cst/health"
 )
 
 func health(ctx echo.Context) error {
@@ -15,3 +16,17 @@ func health(ctx echo.Context) error {
 
 	return ctx.String(http.StatusInternalServerError, "DOWN")
 }
+
+func liveness(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, hc.Report{Status: hc.StatusUp})
+}
+
+func readiness(ctx echo.Context) error {
+
+	report := hc.Run([]hc.Check{
+		hc.StorageCheck(),
+		hc.QueueCheck(),
+	}, hc.DefaultTimeout)
+
+	return ctx.JSON(report.StatusCode(), report)
+}
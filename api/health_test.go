@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tsuru/cst/db"
+	hc "github.com/tsuru/cst/health"
+	"github.com/tsuru/cst/queue"
 
 	"github.com/labstack/echo"
 	"github.com/stretchr/testify/require"
@@ -55,3 +58,72 @@ func TestHealth(t *testing.T) {
 		assert.Equal(t, "WORKING", recorder.Body.String())
 	})
 }
+
+func TestLiveness(t *testing.T) {
+
+	t.Run(`Ensure it always reports the process is up`, func(t *testing.T) {
+
+		e := echo.New()
+
+		request := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+		recorder := httptest.NewRecorder()
+
+		require.NoError(t, liveness(e.NewContext(request, recorder)))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"status":"up"}`, recorder.Body.String())
+	})
+}
+
+func TestReadiness(t *testing.T) {
+
+	t.Run(`When storage and queue are reachable, should return 200 code with each check`, func(t *testing.T) {
+
+		db.SetStorage(&db.MockStorage{
+			MockPing: func() bool {
+				return true
+			},
+		})
+
+		queue.SetQueue(queue.NewMemoryQueue())
+
+		e := echo.New()
+
+		request := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		recorder := httptest.NewRecorder()
+
+		require.NoError(t, readiness(e.NewContext(request, recorder)))
+
+		var report hc.Report
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, hc.StatusUp, report.Status)
+		require.Len(t, report.Checks, 2)
+		assert.Equal(t, "storage", report.Checks[0].Name)
+		assert.Equal(t, "queue", report.Checks[1].Name)
+	})
+
+	t.Run(`When storage is unreachable, should return 503 code`, func(t *testing.T) {
+
+		db.SetStorage(&db.MockStorage{})
+		queue.SetQueue(queue.NewMemoryQueue())
+
+		e := echo.New()
+
+		request := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		recorder := httptest.NewRecorder()
+
+		require.NoError(t, readiness(e.NewContext(request, recorder)))
+
+		var report hc.Report
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(t, hc.StatusDown, report.Status)
+		assert.Equal(t, hc.StatusDown, report.Checks[0].Status)
+		assert.Equal(t, hc.StatusUp, report.Checks[1].Status)
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+const defaultImagesLimit = 100
+
+func showImages(ctx echo.Context) error {
+
+	query, err := imageQueryFromRequest(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	images, err := db.GetStorage().GetImages(query)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if len(images) == 0 {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	return ctx.JSON(http.StatusOK, images)
+}
+
+// imageQueryFromRequest reads the image inventory filters from query params,
+// e.g. "?repository=tsuru/&severity=critical&sort=-critical&limit=20".
+func imageQueryFromRequest(ctx echo.Context) (db.ImageQuery, error) {
+
+	query := db.ImageQuery{
+		Repository: ctx.QueryParam("repository"),
+		Tag:        ctx.QueryParam("tag"),
+		Severity:   strings.ToLower(ctx.QueryParam("severity")),
+		Limit:      defaultImagesLimit,
+	}
+
+	if query.Severity != "" && !contains(scan.Severities, query.Severity) {
+		return query, echo.NewHTTPError(http.StatusBadRequest, "severity must be one of: "+strings.Join(scan.Severities, ", "))
+	}
+
+	if sort := ctx.QueryParam("sort"); sort != "" {
+		query.Descending = strings.HasPrefix(sort, "-")
+		query.SortBy = strings.TrimPrefix(sort, "-")
+
+		if !contains(db.ImageSortFields, query.SortBy) {
+			return query, echo.NewHTTPError(http.StatusBadRequest, "sort must be one of: "+strings.Join(db.ImageSortFields, ", "))
+		}
+	}
+
+	var err error
+
+	if query.Limit, err = intQueryParam(ctx, "limit", defaultImagesLimit); err != nil {
+		return query, err
+	}
+
+	if query.Skip, err = intQueryParam(ctx, "offset", 0); err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+func intQueryParam(ctx echo.Context, name string, defaultValue int) (int, error) {
+
+	raw := ctx.QueryParam(name)
+
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+
+	if err != nil || value < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, name+" must be a non-negative integer")
+	}
+
+	return value, nil
+}
+
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowImages(t *testing.T) {
+	t.Run(`Ensure query params are given to storage as filters and sorting`, func(t *testing.T) {
+		var gotQuery db.ImageQuery
+
+		db.SetStorage(&db.MockStorage{
+			MockGetImages: func(query db.ImageQuery) ([]scan.Image, error) {
+				gotQuery = query
+
+				return []scan.Image{
+					scan.Image{Name: "tsuru/cst:latest", Repository: "tsuru/cst", Tag: "latest", Severities: scan.SeverityCounts{Critical: 2}},
+				}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/?repository=tsuru/&tag=latest&severity=Critical&sort=-critical&limit=20&offset=40", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, showImages(ctx))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		expected := db.ImageQuery{
+			Repository: "tsuru/",
+			Tag:        "latest",
+			Severity:   "critical",
+			SortBy:     "critical",
+			Descending: true,
+			Limit:      20,
+			Skip:       40,
+		}
+
+		assert.Equal(t, expected, gotQuery)
+
+		var images []scan.Image
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &images))
+		require.Len(t, images, 1)
+		assert.Equal(t, 2, images[0].Severities.Critical)
+	})
+
+	t.Run(`When there are no images, should return 204 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, showImages(ctx))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	})
+
+	t.Run(`When query params are invalid, should return 400 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{})
+
+		for _, rawQuery := range []string{"sort=digest", "severity=catastrophic", "limit=-1", "offset=ten"} {
+			e := echo.New()
+			request := httptest.NewRequest(http.MethodGet, "/?"+rawQuery, nil)
+			recorder := httptest.NewRecorder()
+			ctx := e.NewContext(request, recorder)
+
+			err := showImages(ctx)
+
+			require.Error(t, err, rawQuery)
+			e.HTTPErrorHandler(err, ctx)
+			assert.Equal(t, http.StatusBadRequest, recorder.Code, rawQuery)
+		}
+	})
+
+	t.Run(`When storage returns any error, should return 500 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetImages: func(db.ImageQuery) ([]scan.Image, error) {
+				return nil, errors.New("just another error on storage")
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := showImages(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// showScanLayers returns, per scanner's result of a scan (id param), how many
+// findings per severity come from the base image's layers and from the app's
+// ones, as well as the counts of each layer.
+func showScanLayers(ctx echo.Context) error {
+
+	s, err := db.GetStorage().GetScanByID(ctx.Param("id"))
+
+	if err != nil {
+		return scanLookupError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, scan.BreakdownScan(s))
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowScanLayers(t *testing.T) {
+
+	db.SetStorage(&db.MockStorage{
+		MockGetScanByID: func(id string) (scan.Scan, error) {
+			if id != "scan" {
+				return scan.Scan{}, db.ErrScanNotFound
+			}
+
+			return scan.Scan{
+				ID:     "scan",
+				Image:  "tsuru/cst:latest",
+				Status: scan.StatusFinished,
+				Result: []scan.Result{
+					{
+						Scanner: "clair",
+						Layers:  []scan.Layer{{Digest: "sha256:base", Base: true}, {Digest: "sha256:app"}},
+						Findings: []scan.Finding{
+							{CVE: "CVE-2018-1000001", Package: "glibc", Severity: "High", Layer: "sha256:base"},
+							{CVE: "CVE-2018-1000120", Package: "curl", Severity: "Critical", Layer: "sha256:app"},
+						},
+					},
+				},
+			}, nil
+		},
+	})
+
+	request := func(id string) *httptest.ResponseRecorder {
+		e := echo.New()
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(id)
+
+		if err := showScanLayers(ctx); err != nil {
+			e.HTTPErrorHandler(err, ctx)
+		}
+
+		return recorder
+	}
+
+	t.Run(`When scan exists, should return the severities of base and app layers`, func(t *testing.T) {
+		recorder := request("scan")
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		var breakdowns []scan.Breakdown
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &breakdowns))
+		require.Len(t, breakdowns, 1)
+
+		assert.Equal(t, scan.SeverityCounts{High: 1}, breakdowns[0].Base)
+		assert.Equal(t, scan.SeverityCounts{Critical: 1}, breakdowns[0].App)
+		assert.Equal(t, "sha256:app", breakdowns[0].Layers[1].Digest)
+	})
+
+	t.Run(`When scan does not exist, should return 404 status code`, func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request("unknown").Code)
+	})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/metrics"
+)
+
+// metricsMiddleware records how many requests were handled and how long they
+// took, labeled by method and route (not the raw path, to keep the number of
+// label values bounded).
+func metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+
+		startedAt := time.Now()
+
+		err := next(ctx)
+
+		if err != nil {
+			ctx.Error(err)
+		}
+
+		method := ctx.Request().Method
+		path := ctx.Path()
+
+		metrics.HTTPRequests.Inc(method, path, strconv.Itoa(ctx.Response().Status))
+		metrics.HTTPRequestDuration.Observe(time.Since(startedAt).Seconds(), method, path)
+
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/metrics"
+)
+
+// counterValue scrapes the default registry and returns the value of the
+// counter named name whose labels are labels.
+func counterValue(t *testing.T, name string, labels map[string]string) float64 {
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	var parser expfmt.TextParser
+
+	families, err := parser.TextToMetricFamilies(recorder.Body)
+	require.NoError(t, err)
+
+	family, ok := families[name]
+
+	if !ok {
+		return 0
+	}
+
+	for _, metric := range family.GetMetric() {
+		matches := len(metric.GetLabel()) == len(labels)
+
+		for _, label := range metric.GetLabel() {
+			if labels[label.GetName()] != label.GetValue() {
+				matches = false
+			}
+		}
+
+		if matches {
+			return metric.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+
+	t.Run(`Ensure requests are counted by method, route and status code`, func(t *testing.T) {
+
+		e := echo.New()
+		e.Use(metricsMiddleware)
+
+		e.GET("/v1/scan/:image", func(ctx echo.Context) error {
+			return echo.NewHTTPError(http.StatusNotFound)
+		})
+
+		labels := map[string]string{
+			"method": http.MethodGet,
+			"path":   "/v1/scan/:image",
+			"code":   "404",
+		}
+
+		before := counterValue(t, "cst_http_requests_total", labels)
+
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/scan/tsuru%2Fcst", nil))
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, before+1, counterValue(t, "cst_http_requests_total", labels))
+	})
+}
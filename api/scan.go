@@ -9,7 +9,10 @@ import (
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/labstack/echo"
+	"github.com/tsuru/cst/archive"
 	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/reference"
+	"github.com/tsuru/cst/scan"
 	schd "github.com/tsuru/cst/scan/scheduler"
 )
 
@@ -36,19 +39,62 @@ func showScans(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	scans, err := db.GetStorage().GetScansByImage(image)
+	names := []string{image}
 
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError)
+	// uploaded images are named by their archive, not by a reference
+	if _, ok := archive.ParseImageName(image); !ok {
+		canonical, err := reference.Canonicalize(image)
+
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid image reference: "+err.Error())
+		}
+
+		// scans submitted before canonicalization are stored by the raw name
+		if canonical != image {
+			names = []string{canonical, image}
+		}
+	}
+
+	var scans []scan.Scan
+
+	for _, name := range names {
+		found, err := db.GetStorage().GetScansByImage(name)
+
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		scans = append(scans, found...)
 	}
 
 	if len(scans) == 0 {
 		return ctx.NoContent(http.StatusNoContent)
 	}
 
+	if platform := ctx.QueryParam("platform"); platform != "" {
+		filterResultsByPlatform(scans, platform)
+	}
+
 	return ctx.JSON(http.StatusOK, scans)
 }
 
+// filterResultsByPlatform keeps only the scans' results of a given platform
+// (e.g. "linux/arm64" matches "linux/arm64/v8").
+func filterResultsByPlatform(scans []scan.Scan, platform string) {
+
+	for index := range scans {
+		var results []scan.Result
+
+		for _, result := range scans[index].Result {
+			if scan.MatchPlatform(result.Platform, []string{platform}) {
+				results = append(results, result)
+			}
+		}
+
+		scans[index].Result = results
+	}
+}
+
 func createScan(ctx echo.Context) error {
 	scanRequest, err := loadScanRequestFromContext(ctx)
 	if err != nil {
@@ -59,9 +105,15 @@ func createScan(ctx echo.Context) error {
 	if imageWithoutSpaces == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "the image key is required")
 	}
-	scanRequest.Image = imageWithoutSpaces
 
-	scan, err := scheduler.Schedule(scanRequest.Image)
+	// stores the canonical form, so "nginx" and "docker.io/library/nginx:latest"
+	// are the same image
+	scanRequest.Image, err = reference.Canonicalize(imageWithoutSpaces)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid image reference: "+err.Error())
+	}
+
+	scan, err := scheduler.Schedule(ctx.Request().Context(), scanRequest.Image)
 	switch err {
 	case nil:
 		return ctx.JSON(http.StatusCreated, scan)
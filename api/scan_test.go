@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -23,11 +24,11 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(``))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
-		err := createScan(context)
+		ctx := e.NewContext(request, recorder)
+		err := createScan(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 	})
 
@@ -36,14 +37,52 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "image" : "    " }`))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
-		err := createScan(context)
+		ctx := e.NewContext(request, recorder)
+		err := createScan(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 	})
 
+	t.Run(`When image reference is malformed, should return bad request`, func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "image" : "Tsuru/CST:latest" }`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
+				require.Fail(t, "shouldn't schedule a malformed image reference")
+				return scan.Scan{}, nil
+			},
+		}
+		err := createScan(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "repository name must be lowercase")
+	})
+
+	t.Run(`Ensure image is scheduled on its canonical form`, func(t *testing.T) {
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "image" : "nginx" }`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		gotImage := ""
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
+				gotImage = image
+				return scan.Scan{}, nil
+			},
+		}
+
+		require.NoError(t, createScan(ctx))
+		assert.Equal(t, "docker.io/library/nginx:latest", gotImage)
+	})
+
 	t.Run(`When payload is OK, should return created status code`, func(t *testing.T) {
 		requestBody := `{ "image": "tsuru/cst:latest" }`
 
@@ -51,9 +90,9 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
 				return scan.Scan{
 					ID:     `2c5a5f48-9801-40ef-8d81-cd4a0f9c0ee2`,
 					Status: scan.StatusScheduled,
@@ -62,7 +101,7 @@ func TestCreateScan(t *testing.T) {
 			},
 		}
 
-		require.NoError(t, createScan(context))
+		require.NoError(t, createScan(ctx))
 		assert.Equal(t, http.StatusCreated, recorder.Code)
 	})
 
@@ -73,16 +112,16 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
 				return scan.Scan{}, schd.ErrImageHasAlreadyBeenScheduled
 			},
 		}
-		err := createScan(context)
+		err := createScan(ctx)
 
 		require.NoError(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusNoContent, recorder.Code)
 	})
 
@@ -93,16 +132,16 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
 				return scan.Scan{}, errors.New("something went wrong")
 			},
 		}
-		err := createScan(context)
+		err := createScan(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
 	})
 
@@ -113,17 +152,17 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
-				require.Equal(t, "tsuru/cst:latest", image)
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
+				require.Equal(t, "docker.io/tsuru/cst:latest", image)
 				return scan.Scan{}, nil
 			},
 		}
-		err := createScan(context)
+		err := createScan(ctx)
 
 		require.Nil(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		require.Equal(t, http.StatusCreated, recorder.Code)
 	})
 
@@ -134,17 +173,17 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
 				require.Fail(t, "shouldn't schedule for invalid data")
 				return scan.Scan{}, errors.New("invalid metadata")
 			},
 		}
-		err := createScan(context)
+		err := createScan(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		require.Equal(t, http.StatusBadRequest, recorder.Code)
 	})
 
@@ -155,17 +194,17 @@ func TestCreateScan(t *testing.T) {
 		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(requestBody))
 		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		recorder := httptest.NewRecorder()
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 		scheduler = &schd.MockScheduler{
-			MockSchedule: func(image string) (scan.Scan, error) {
-				require.Equal(t, "tsuru/cst:latest", image)
+			MockSchedule: func(_ context.Context, image string) (scan.Scan, error) {
+				require.Equal(t, "docker.io/tsuru/cst:latest", image)
 				return scan.Scan{}, nil
 			},
 		}
-		err := createScan(context)
+		err := createScan(ctx)
 
 		require.Nil(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		require.Equal(t, http.StatusCreated, recorder.Code)
 	})
 }
@@ -186,16 +225,16 @@ func TestShowScans(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
 		recorder := httptest.NewRecorder()
 
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 
-		context.SetPath("/v1/scan/:image")
-		context.SetParamNames("image")
-		context.SetParamValues(url.PathEscape("tsuru/cst:latest"))
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("tsuru/cst:latest"))
 
-		err := showScans(context)
+		err := showScans(ctx)
 
 		require.NoError(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusNoContent, recorder.Code)
 
 		assert.Equal(t, ``, recorder.Body.String())
@@ -207,19 +246,71 @@ func TestShowScans(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
 		recorder := httptest.NewRecorder()
 
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 
-		context.SetPath("/v1/scan/:image")
-		context.SetParamNames("image")
-		context.SetParamValues("badUrlEncoded%ss")
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues("badUrlEncoded%ss")
 
-		err := showScans(context)
+		err := showScans(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusBadRequest, recorder.Code)
 	})
 
+	t.Run(`Ensure scans are listed by the image's canonical form and raw name`, func(t *testing.T) {
+		var gotImages []string
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+				gotImages = append(gotImages, image)
+
+				return []scan.Scan{{ID: image, Image: image}}, nil
+			},
+		})
+
+		e := echo.New()
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("nginx"))
+
+		require.NoError(t, showScans(ctx))
+		assert.Equal(t, []string{"docker.io/library/nginx:latest", "nginx"}, gotImages)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var scans []scan.Scan
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &scans))
+		require.Len(t, scans, 2)
+		assert.Equal(t, "docker.io/library/nginx:latest", scans[0].ID)
+		assert.Equal(t, "nginx", scans[1].ID)
+	})
+
+	t.Run(`When image is already on its canonical form, should list scans once`, func(t *testing.T) {
+		var gotImages []string
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+				gotImages = append(gotImages, image)
+
+				return nil, nil
+			},
+		})
+
+		e := echo.New()
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("docker.io/library/nginx:latest"))
+
+		require.NoError(t, showScans(ctx))
+		assert.Equal(t, []string{"docker.io/library/nginx:latest"}, gotImages)
+	})
+
 	t.Run(`When storage returns any error, should return 500 status code`, func(t *testing.T) {
 		storage := &db.MockStorage{
 			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
@@ -234,16 +325,16 @@ func TestShowScans(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
 		recorder := httptest.NewRecorder()
 
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 
-		context.SetPath("/v1/scan/:image")
-		context.SetParamNames("image")
-		context.SetParamValues(url.PathEscape("tsuru/cst:latest"))
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("tsuru/cst:latest"))
 
-		err := showScans(context)
+		err := showScans(ctx)
 
 		require.Error(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
 	})
 
@@ -258,6 +349,10 @@ func TestShowScans(t *testing.T) {
 
 		storage := &db.MockStorage{
 			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+				if image != "tsuru/cst:latest" {
+					return nil, nil
+				}
+
 				return expectedScans, nil
 			},
 		}
@@ -269,20 +364,60 @@ func TestShowScans(t *testing.T) {
 		request := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(``))
 		recorder := httptest.NewRecorder()
 
-		context := e.NewContext(request, recorder)
+		ctx := e.NewContext(request, recorder)
 
-		context.SetPath("/v1/scan/:image")
-		context.SetParamNames("image")
-		context.SetParamValues(url.PathEscape("tsuru/cst:latest"))
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("tsuru/cst:latest"))
 
-		err := showScans(context)
+		err := showScans(ctx)
 
 		require.NoError(t, err)
-		e.HTTPErrorHandler(err, context)
+		e.HTTPErrorHandler(err, ctx)
 		assert.Equal(t, http.StatusOK, recorder.Code)
 
 		expectedScansJSON, _ := json.Marshal(expectedScans)
 
 		assert.JSONEq(t, string(expectedScansJSON), recorder.Body.String())
 	})
+	t.Run(`When platform param is assigned, should return only the results of that platform`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+				if image != "docker.io/tsuru/cst:latest" {
+					return nil, nil
+				}
+
+				return []scan.Scan{
+					{
+						ID:    "1",
+						Image: "docker.io/tsuru/cst:latest",
+						Result: []scan.Result{
+							{Scanner: "clair", Platform: "linux/amd64"},
+							{Scanner: "clair", Platform: "linux/arm64/v8"},
+						},
+					},
+				}, nil
+			},
+		})
+
+		e := echo.New()
+
+		request := httptest.NewRequest(http.MethodGet, "/?platform=linux/arm64", nil)
+		recorder := httptest.NewRecorder()
+
+		ctx := e.NewContext(request, recorder)
+
+		ctx.SetPath("/v1/scan/:image")
+		ctx.SetParamNames("image")
+		ctx.SetParamValues(url.PathEscape("tsuru/cst:latest"))
+
+		require.NoError(t, showScans(ctx))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+		var scans []scan.Scan
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &scans))
+		require.Len(t, scans, 1)
+		assert.Equal(t, []scan.Result{{Scanner: "clair", Platform: "linux/arm64/v8"}}, scans[0].Result)
+	})
 }
@@ -7,6 +7,9 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/tsuru/cst/archive"
+	"github.com/tsuru/cst/metrics"
+	"github.com/tsuru/cst/registry"
 )
 
 // WebServer defines actions about an usual web server. Despite this name, it
@@ -23,6 +26,20 @@ type SecureWebServer struct {
 	Port     int
 	UseTLS   bool
 
+	// RegistryCredentials authenticate the requests to registries (e.g.
+	// when crawling repositories).
+	RegistryCredentials registry.Credentials
+
+	// UploadDir is where uploaded image tarballs are stored (uploads are
+	// disabled when empty), up to UploadMaxSize bytes each.
+	UploadDir     string
+	UploadMaxSize int64
+
+	// BaseImages are the repositories of known base images (e.g.
+	// "docker.io/library/debian"), whose scans are matched against the
+	// images' layers to detect their base images.
+	BaseImages []string
+
 	echo *echo.Echo
 }
 
@@ -34,14 +51,48 @@ func (ws *SecureWebServer) Start() error {
 
 	ws.echo.HideBanner = true
 
+	crawler.Credentials = ws.RegistryCredentials
+
+	if ws.UploadDir != "" {
+		archives = &archive.Store{Dir: ws.UploadDir}
+		maxUploadSize = ws.UploadMaxSize
+	}
+
+	baseImages = ws.BaseImages
+
+	shutdown, cancel := context.WithCancel(context.Background())
+	ws.echo.Server.RegisterOnShutdown(cancel)
+	ws.echo.TLSServer.RegisterOnShutdown(cancel)
+
+	ws.echo.Pre(batchRewrite)
+
 	ws.echo.Use(middleware.Recover())
 	ws.echo.Use(middleware.Logger())
+	ws.echo.Use(metricsMiddleware)
+	ws.echo.Use(tracingMiddleware)
 
 	ws.echo.GET("/health", health)
+	ws.echo.GET("/health/live", liveness)
+	ws.echo.GET("/health/ready", readiness)
+	ws.echo.GET("/metrics", echo.WrapHandler(metrics.Handler()))
 
 	v1 := ws.echo.Group("/v1")
 	v1.POST("/scan", createScan)
 	v1.GET("/scan/:image", showScans)
+	v1.GET("/dead-letters", showDeadLetters)
+	v1.POST("/dead-letters/:id/redrive", redriveDeadLetter)
+	v1.POST("/scans/batch", createBatch)
+	v1.POST("/scans/upload", createUpload)
+	v1.GET("/batches/:id", showBatch)
+	v1.POST("/crawls", createCrawl)
+	v1.GET("/scans/:id/diff", showScanDiff)
+	v1.GET("/scans/:id/diff/:otherId", showScanDiff)
+	v1.GET("/scans/:id/layers", showScanLayers)
+	v1.GET("/scans/:id/base-image", showScanBaseImage)
+	v1.GET("/scans/:id/events", showScanEvents, untilShutdown(shutdown))
+	v1.GET("/events", showEvents, untilShutdown(shutdown))
+	v1.GET("/images", showImages)
+	v1.GET("/vulnerabilities/:cve", showVulnerability)
 
 	address := fmt.Sprintf(":%d", ws.Port)
 
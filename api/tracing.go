@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/tracing"
+)
+
+// tracingMiddleware starts a server span for each request, continuing the
+// trace from traceparent header (when sent by client). Handlers find that span
+// on request's context.
+func tracingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+
+		request := ctx.Request()
+
+		carrier := map[string]string{
+			tracing.TraceParentKey: request.Header.Get(tracing.TraceParentKey),
+		}
+
+		spanCtx, span := tracing.Start(
+			tracing.Extract(request.Context(), carrier),
+			fmt.Sprintf("%s %s", request.Method, ctx.Path()),
+			tracing.SpanKindServer,
+		)
+
+		defer span.End()
+
+		span.SetAttribute("http.method", request.Method)
+		span.SetAttribute("http.route", ctx.Path())
+
+		ctx.SetRequest(request.WithContext(spanCtx))
+
+		err := next(ctx)
+
+		if err != nil {
+			ctx.Error(err)
+			span.SetError(err)
+		}
+
+		span.SetAttribute("http.status_code", ctx.Response().Status)
+
+		return nil
+	}
+}
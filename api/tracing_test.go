@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/tsuru/cst/tracing"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+
+	t.Run(`When client sends a traceparent header, handlers should continue its trace`, func(t *testing.T) {
+
+		carrier := map[string]string{}
+
+		e := echo.New()
+		e.Use(tracingMiddleware)
+
+		e.POST("/v1/scan", func(ctx echo.Context) error {
+			tracing.Inject(ctx.Request().Context(), carrier)
+
+			return ctx.NoContent(http.StatusNoContent)
+		})
+
+		request := httptest.NewRequest(http.MethodPost, "/v1/scan", nil)
+		request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+		assert.Contains(t, carrier[tracing.TraceParentKey], "-4bf92f3577b34da6a3ce929d0e0e4736-")
+		assert.NotContains(t, carrier[tracing.TraceParentKey], "-00f067aa0ba902b7-")
+	})
+}
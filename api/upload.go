@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/archive"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+var (
+	// archives stores the uploaded image tarballs; uploads are disabled when
+	// it's nil.
+	archives *archive.Store
+
+	maxUploadSize int64
+)
+
+func createUpload(ctx echo.Context) error {
+
+	if archives == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "image uploads are disabled")
+	}
+
+	request := ctx.Request()
+
+	if request.ContentLength > maxUploadSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, archive.ErrArchiveTooLarge.Error())
+	}
+
+	id, err := archives.Save(request.Body, maxUploadSize)
+
+	switch err {
+	case nil:
+	case archive.ErrArchiveTooLarge:
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case archive.ErrInvalidArchive:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	scan, err := scheduler.Schedule(request.Context(), archive.ImageName(id))
+
+	switch err {
+	case nil:
+		return ctx.JSON(http.StatusCreated, scan)
+	case schd.ErrImageHasAlreadyBeenScheduled:
+		return ctx.NoContent(http.StatusNoContent)
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+}
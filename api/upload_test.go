@@ -0,0 +1,152 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/archive"
+	"github.com/tsuru/cst/scan"
+	schd "github.com/tsuru/cst/scan/scheduler"
+)
+
+func imageTarball(t *testing.T) []byte {
+
+	files := []struct{ name, content string }{
+		{"manifest.json", `[{"Config": "config.json", "RepoTags": ["tsuru/cst:latest"], "Layers": ["layer.tar"]}]`},
+		{"config.json", `{"rootfs": {"diff_ids": ["sha256:5bef08742407efd622d243692b79ba0055383bbce12900324f75e56f589aedb0"]}}`},
+		{"layer.tar", "layer"},
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+
+	for _, file := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))}))
+
+		_, err := writer.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+
+	return buffer.Bytes()
+}
+
+func TestCreateUpload(t *testing.T) {
+	defer func(original schd.Scheduler) {
+		scheduler = original
+		archives = nil
+		maxUploadSize = 0
+	}(scheduler)
+
+	dir, err := ioutil.TempDir("", "cst-uploads")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run(`When uploads are disabled, should return 501 status code`, func(t *testing.T) {
+		archives = nil
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(imageTarball(t)))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createUpload(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+	})
+
+	t.Run(`Ensure the uploaded tarball is stored and its image is scheduled`, func(t *testing.T) {
+		archives = &archive.Store{Dir: dir}
+		maxUploadSize = 1 << 20
+
+		var scheduled string
+
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+				scheduled = image
+
+				return scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: image}, nil
+			},
+		}
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(imageTarball(t)))
+		request.Header.Set(echo.HeaderContentType, "application/x-tar")
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, createUpload(ctx))
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+
+		id, ok := archive.ParseImageName(scheduled)
+
+		require.True(t, ok, scheduled)
+
+		_, err := archives.Open(id)
+		assert.NoError(t, err)
+	})
+
+	t.Run(`When tarball is larger than max size, should return 413 status code`, func(t *testing.T) {
+		archives = &archive.Store{Dir: dir}
+		maxUploadSize = 16
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(imageTarball(t)))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createUpload(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+
+	t.Run(`When body isn't an image tarball, should return 400 status code`, func(t *testing.T) {
+		archives = &archive.Store{Dir: dir}
+		maxUploadSize = 1 << 20
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not a tarball"))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		err := createUpload(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run(`When the image has already been scheduled, should return 204 status code`, func(t *testing.T) {
+		archives = &archive.Store{Dir: dir}
+		maxUploadSize = 1 << 20
+
+		scheduler = &schd.MockScheduler{
+			MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+				return scan.Scan{}, schd.ErrImageHasAlreadyBeenScheduled
+			},
+		}
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(imageTarball(t)))
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+
+		require.NoError(t, createUpload(ctx))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	})
+}
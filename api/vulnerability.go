@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/tsuru/cst/db"
+)
+
+func showVulnerability(ctx echo.Context) error {
+
+	cve := strings.ToUpper(strings.TrimSpace(ctx.Param("cve")))
+
+	if cve == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "the cve param is required")
+	}
+
+	findings, err := db.GetStorage().GetFindingsByCVE(cve)
+
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+
+	if len(findings) == 0 {
+		return ctx.NoContent(http.StatusNoContent)
+	}
+
+	return ctx.JSON(http.StatusOK, findings)
+}
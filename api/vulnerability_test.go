@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestShowVulnerability(t *testing.T) {
+	t.Run(`When some images are affected, should return their findings`, func(t *testing.T) {
+		gotCVE := ""
+
+		db.SetStorage(&db.MockStorage{
+			MockGetFindingsByCVE: func(cve string) ([]scan.Finding, error) {
+				gotCVE = cve
+
+				return []scan.Finding{
+					scan.Finding{CVE: cve, Image: "tsuru/cst:latest", Package: "openssl", Version: "1.1.0h-r0", FixedBy: "1.1.0i-r0"},
+				}, nil
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("cve")
+		ctx.SetParamValues("cve-2018-0732")
+
+		require.NoError(t, showVulnerability(ctx))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "CVE-2018-0732", gotCVE)
+
+		var findings []scan.Finding
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &findings))
+		require.Len(t, findings, 1)
+		assert.Equal(t, "tsuru/cst:latest", findings[0].Image)
+		assert.Equal(t, "1.1.0i-r0", findings[0].FixedBy)
+	})
+
+	t.Run(`When no images are affected, should return 204 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("cve")
+		ctx.SetParamValues("CVE-2018-0732")
+
+		require.NoError(t, showVulnerability(ctx))
+		assert.Equal(t, http.StatusNoContent, recorder.Code)
+	})
+
+	t.Run(`When storage returns any error, should return 500 status code`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetFindingsByCVE: func(string) ([]scan.Finding, error) {
+				return nil, errors.New("just another error on storage")
+			},
+		})
+
+		e := echo.New()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		recorder := httptest.NewRecorder()
+		ctx := e.NewContext(request, recorder)
+		ctx.SetParamNames("cve")
+		ctx.SetParamValues("CVE-2018-0732")
+
+		err := showVulnerability(ctx)
+
+		require.Error(t, err)
+		e.HTTPErrorHandler(err, ctx)
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	})
+}
@@ -0,0 +1,362 @@
+// Package archive stores image tarballs uploaded for scanning, as written by
+// `docker save` or on OCI image layout, and reads their manifests and layers.
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tsuru/cst/scan"
+)
+
+const (
+	// maxMetadataSize is the largest manifest (or image config) read from an
+	// archive.
+	maxMetadataSize = 4 << 20
+
+	// maxIndexDepth is how many nested image indexes are followed.
+	maxIndexDepth = 3
+
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+
+	ociImageIndexMediaType      = "application/vnd.oci.image.index.v1+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+var (
+	// ErrInvalidArchive indicates a file which isn't an image tarball written
+	// by `docker save` nor on OCI image layout.
+	ErrInvalidArchive = errors.New(`not a docker save or OCI image layout archive`)
+
+	// ErrLayerNotFound indicates the archive has no layer with a given digest.
+	ErrLayerNotFound = errors.New(`layer not found on archive`)
+
+	errFileNotFound = errors.New(`file not found on archive`)
+)
+
+// descriptor references a blob on OCI image layout.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+// Layer is a filesystem layer of an archived image. Digest is the layer's
+// digest as referenced by the image (e.g. "sha256:<hex>").
+type Layer struct {
+	Digest string
+	file   string
+}
+
+// Archive is an image tarball. Its layers are listed from the base one and
+// Digest identifies the image (the manifest's digest on OCI image layout or
+// the image's config digest on `docker save` format). Config is the image's
+// config blob. Platform is only known for images picked from a
+// multi-platform OCI image index.
+type Archive struct {
+	Digest   string
+	Platform string
+	RepoTags []string
+	Config   Layer
+	Layers   []Layer
+
+	path  string
+	files map[string]fileEntry
+}
+
+// fileEntry locates the content of a regular file on the tarball.
+type fileEntry struct {
+	offset int64
+	size   int64
+}
+
+// Open reads the manifest of the image tarball at path. When the archive
+// holds several images (or platforms), only the first one is considered.
+// Files are indexed once, so reading them later doesn't walk the tarball.
+func Open(path string) (*Archive, error) {
+
+	archive := &Archive{path: path}
+
+	if err := archive.index(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := archive.readFile("manifest.json")
+
+	switch err {
+	case nil:
+		err = archive.loadDockerManifest(manifest)
+	case errFileNotFound:
+		err = archive.loadOCILayout()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(archive.Layers) == 0 {
+		return nil, ErrInvalidArchive
+	}
+
+	return archive, nil
+}
+
+// OpenLayer returns the content of the layer (or the image's config) with a
+// given digest. Callers must close it.
+func (a *Archive) OpenLayer(digest string) (io.ReadCloser, error) {
+
+	for _, layer := range append([]Layer{a.Config}, a.Layers...) {
+		if layer.Digest == "" || layer.Digest != digest {
+			continue
+		}
+
+		file, reader, err := a.openFile(layer.file)
+
+		if err == errFileNotFound {
+			return nil, ErrLayerNotFound
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+
+	return nil, ErrLayerNotFound
+}
+
+// loadDockerManifest reads a `docker save` manifest, taking the layers'
+// digests from the image's config (rootfs.diff_ids).
+func (a *Archive) loadDockerManifest(data []byte) error {
+
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil || len(manifest) == 0 {
+		return ErrInvalidArchive
+	}
+
+	config, err := a.readFile(manifest[0].Config)
+
+	if err == errFileNotFound {
+		return ErrInvalidArchive
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var image struct {
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+
+	if err := json.Unmarshal(config, &image); err != nil {
+		return ErrInvalidArchive
+	}
+
+	if len(image.RootFS.DiffIDs) != len(manifest[0].Layers) {
+		return ErrInvalidArchive
+	}
+
+	a.Digest = digestOf(config)
+	a.RepoTags = manifest[0].RepoTags
+	a.Config = Layer{Digest: a.Digest, file: manifest[0].Config}
+
+	for index, file := range manifest[0].Layers {
+		a.Layers = append(a.Layers, Layer{
+			Digest: image.RootFS.DiffIDs[index],
+			file:   file,
+		})
+	}
+
+	return nil
+}
+
+// loadOCILayout reads the image index of an OCI image layout, following
+// nested indexes (e.g. multi-platform images) until an image manifest.
+func (a *Archive) loadOCILayout() error {
+
+	data, err := a.readFile("index.json")
+
+	if err == errFileNotFound {
+		return ErrInvalidArchive
+	}
+
+	for depth := 0; err == nil && depth < maxIndexDepth; depth++ {
+		var index struct {
+			Manifests []descriptor `json:"manifests"`
+		}
+
+		if err := json.Unmarshal(data, &index); err != nil || len(index.Manifests) == 0 {
+			return ErrInvalidArchive
+		}
+
+		manifest := index.Manifests[0]
+
+		if name := manifest.Annotations[refNameAnnotation]; name != "" {
+			a.RepoTags = append(a.RepoTags, name)
+		}
+
+		if platform := manifest.Platform; platform != nil {
+			a.Platform = scan.FormatPlatform(platform.OS, platform.Architecture, platform.Variant)
+		}
+
+		data, err = a.readFile(blobFile(manifest.Digest))
+
+		if err == errFileNotFound {
+			return ErrInvalidArchive
+		}
+
+		if err != nil || manifest.MediaType == ociImageIndexMediaType || manifest.MediaType == dockerManifestListMediaType {
+			continue
+		}
+
+		var image struct {
+			Config descriptor   `json:"config"`
+			Layers []descriptor `json:"layers"`
+		}
+
+		if err := json.Unmarshal(data, &image); err != nil {
+			return ErrInvalidArchive
+		}
+
+		a.Digest = manifest.Digest
+
+		if image.Config.Digest != "" {
+			a.Config = Layer{
+				Digest: image.Config.Digest,
+				file:   blobFile(image.Config.Digest),
+			}
+		}
+
+		for _, layer := range image.Layers {
+			a.Layers = append(a.Layers, Layer{
+				Digest: layer.Digest,
+				file:   blobFile(layer.Digest),
+			})
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ErrInvalidArchive
+}
+
+// readFile returns the content of a (small) file on archive.
+func (a *Archive) readFile(name string) ([]byte, error) {
+
+	file, reader, err := a.openFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ioutil.ReadAll(io.LimitReader(reader, maxMetadataSize))
+}
+
+// index walks the tarball, recording where each regular file's content is.
+func (a *Archive) index() error {
+
+	file, err := os.Open(a.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	a.files = make(map[string]fileEntry)
+
+	reader := tar.NewReader(file)
+
+	for {
+		header, err := reader.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return ErrInvalidArchive
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		// the tar reader doesn't buffer, so the file is positioned on the
+		// entry's content
+		offset, err := file.Seek(0, io.SeekCurrent)
+
+		if err != nil {
+			return err
+		}
+
+		// like extracting the tarball would do, later entries replace the
+		// former ones with the same name
+		a.files[cleanPath(header.Name)] = fileEntry{offset: offset, size: header.Size}
+	}
+}
+
+// openFile looks for a file on archive's index, returning the archive's file
+// (which callers must close) and a reader of that file's content.
+func (a *Archive) openFile(name string) (*os.File, io.Reader, error) {
+
+	entry, ok := a.files[cleanPath(name)]
+
+	if !ok {
+		return nil, nil, errFileNotFound
+	}
+
+	file, err := os.Open(a.path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, io.NewSectionReader(file, entry.offset, entry.size), nil
+}
+
+// blobFile returns where a blob is stored on OCI image layout.
+func blobFile(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func digestOf(data []byte) string {
+
+	sum := sha256.Sum256(data)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
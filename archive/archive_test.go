@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	baseLayerDigest = "sha256:5bef08742407efd622d243692b79ba0055383bbce12900324f75e56f589aedb0"
+	appLayerDigest  = "sha256:8e0e4a6c8e1d9d3a41b2bf7e8b3d3ffcd2d2f7f62c1b8c31dd7bd26b2a7a0d3b"
+	ociManifest     = "sha256:9b2f2c3a1f6d8a0e4b5c7d9e1f3a5b7c9d1e3f5a7b9c1d3e5f7a9b1c3d5e7f9a"
+	ociConfig       = "sha256:1a3c5e7a9c1e3a5c7e9a1c3e5a7c9e1a3c5e7a9c1e3a5c7e9a1c3e5a7c9e1a3c"
+)
+
+type tarEntry struct {
+	name    string
+	content string
+}
+
+func makeTarball(t *testing.T, entries ...tarEntry) []byte {
+
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+
+	for _, entry := range entries {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+			Typeflag: tar.TypeReg,
+		}))
+
+		_, err := writer.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, writer.Close())
+
+	return buffer.Bytes()
+}
+
+func dockerSaveTarball(t *testing.T) []byte {
+	return makeTarball(t,
+		tarEntry{"manifest.json", `[{"Config": "config.json", "RepoTags": ["tsuru/cst:latest"], "Layers": ["base/layer.tar", "./app/layer.tar"]}]`},
+		tarEntry{"config.json", `{"rootfs": {"type": "layers", "diff_ids": ["` + baseLayerDigest + `", "` + appLayerDigest + `"]}}`},
+		tarEntry{"base/layer.tar", "base layer"},
+		tarEntry{"app/layer.tar", "app layer"},
+	)
+}
+
+func ociLayoutTarball(t *testing.T) []byte {
+	return makeTarball(t,
+		tarEntry{"oci-layout", `{"imageLayoutVersion": "1.0.0"}`},
+		tarEntry{"blobs/sha256/" + baseLayerDigest[7:], "base layer"},
+		tarEntry{"blobs/sha256/" + appLayerDigest[7:], "app layer"},
+		tarEntry{"blobs/sha256/" + ociConfig[7:], `{"config": {"User": "app"}}`},
+		tarEntry{"blobs/sha256/" + ociManifest[7:], `{"schemaVersion": 2, "config": {"digest": "` + ociConfig + `"}, "layers": [{"digest": "` + baseLayerDigest + `"}, {"digest": "` + appLayerDigest + `"}]}`},
+		tarEntry{"index.json", `{"schemaVersion": 2, "manifests": [{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + ociManifest + `", "platform": {"os": "linux", "architecture": "arm64", "variant": "v8"}, "annotations": {"org.opencontainers.image.ref.name": "v1.0"}}]}`},
+	)
+}
+
+func writeTempFile(t *testing.T, data []byte) string {
+
+	dir, err := ioutil.TempDir("", "cst-archive")
+	require.NoError(t, err)
+
+	name := filepath.Join(dir, "image.tar")
+	require.NoError(t, ioutil.WriteFile(name, data, 0644))
+
+	return name
+}
+
+func TestOpen(t *testing.T) {
+	t.Run(`Ensure layers of a docker save tarball are read from its config`, func(t *testing.T) {
+		name := writeTempFile(t, dockerSaveTarball(t))
+		defer os.RemoveAll(filepath.Dir(name))
+
+		archive, err := Open(name)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"tsuru/cst:latest"}, archive.RepoTags)
+		assert.Equal(t, []Layer{{baseLayerDigest, "base/layer.tar"}, {appLayerDigest, "./app/layer.tar"}}, archive.Layers)
+		assert.Regexp(t, `^sha256:[a-f0-9]{64}$`, archive.Digest)
+		assert.Equal(t, Layer{archive.Digest, "config.json"}, archive.Config)
+
+		layer, err := archive.OpenLayer(appLayerDigest)
+		require.NoError(t, err)
+		defer layer.Close()
+
+		content, err := ioutil.ReadAll(layer)
+		require.NoError(t, err)
+		assert.Equal(t, "app layer", string(content))
+	})
+
+	t.Run(`Ensure layers of an OCI image layout are read from its manifest`, func(t *testing.T) {
+		name := writeTempFile(t, ociLayoutTarball(t))
+		defer os.RemoveAll(filepath.Dir(name))
+
+		archive, err := Open(name)
+
+		require.NoError(t, err)
+		assert.Equal(t, ociManifest, archive.Digest)
+		assert.Equal(t, "linux/arm64/v8", archive.Platform)
+		assert.Equal(t, []string{"v1.0"}, archive.RepoTags)
+		assert.Len(t, archive.Layers, 2)
+
+		layer, err := archive.OpenLayer(baseLayerDigest)
+		require.NoError(t, err)
+		defer layer.Close()
+
+		content, err := ioutil.ReadAll(layer)
+		require.NoError(t, err)
+		assert.Equal(t, "base layer", string(content))
+
+		_, err = archive.OpenLayer("sha256:unknown")
+		assert.Equal(t, ErrLayerNotFound, err)
+	})
+
+	t.Run(`Ensure files are indexed once, so layers are read without walking the tarball`, func(t *testing.T) {
+		name := writeTempFile(t, dockerSaveTarball(t))
+		defer os.RemoveAll(filepath.Dir(name))
+
+		archive, err := Open(name)
+		require.NoError(t, err)
+
+		file, err := os.OpenFile(name, os.O_WRONLY, 0)
+		require.NoError(t, err)
+
+		// wipes out the first tar header
+		_, err = file.Write(make([]byte, 512))
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		layer, err := archive.OpenLayer(baseLayerDigest)
+		require.NoError(t, err)
+		defer layer.Close()
+
+		content, err := ioutil.ReadAll(layer)
+		require.NoError(t, err)
+		assert.Equal(t, "base layer", string(content))
+	})
+
+	t.Run(`When file isn't an image tarball, should return ErrInvalidArchive`, func(t *testing.T) {
+		cases := [][]byte{
+			[]byte("definitely not a tarball"),
+			makeTarball(t, tarEntry{"README", "hello"}),
+			makeTarball(t, tarEntry{"manifest.json", `[{"Config": "missing.json", "Layers": ["layer.tar"]}]`}),
+			makeTarball(t, tarEntry{"index.json", `{"manifests": []}`}),
+		}
+
+		for _, data := range cases {
+			name := writeTempFile(t, data)
+
+			_, err := Open(name)
+			assert.Equal(t, ErrInvalidArchive, err)
+
+			os.RemoveAll(filepath.Dir(name))
+		}
+	})
+}
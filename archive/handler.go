@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	manifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	configMediaType   = "application/vnd.docker.container.image.v1+json"
+	layerMediaType    = "application/vnd.docker.image.rootfs.diff.tar"
+)
+
+// Handler serves the archived images through the (read-only) subset of the
+// Docker Registry API used by scanners to fetch layers:
+//
+//	HEAD /v2/<id>/manifests/<reference> - replies the image's digest;
+//	GET  /v2/<id>/manifests/<reference> - replies a schema 2 manifest;
+//	GET  /v2/<id>/blobs/<digest>        - streams a layer (or the config).
+//
+// Requests must be authorized by the store's Token, as a bearer token (see
+// Source, which hands it to the security engines).
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !s.authorized(r) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/")
+
+		if len(parts) != 3 || (parts[1] != "manifests" && parts[1] != "blobs") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if parts[1] == "manifests" && r.Method != http.MethodHead && r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		archive, err := s.Open(parts[0])
+
+		if err == ErrArchiveNotFound {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err != nil {
+			logrus.WithError(err).Error("could not open image archive")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if parts[1] == "manifests" {
+			w.Header().Set("Content-Type", manifestMediaType)
+			w.Header().Set("Docker-Content-Digest", archive.Digest)
+
+			if r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(archive.manifest())
+			}
+
+			return
+		}
+
+		layer, err := archive.OpenLayer(parts[2])
+
+		if err == ErrLayerNotFound {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err != nil {
+			logrus.WithError(err).Error("could not read layer from image archive")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		defer layer.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", parts[2])
+
+		io.Copy(w, layer)
+	})
+}
+
+func (s *Store) authorized(r *http.Request) bool {
+
+	if s.Token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.authorization())) == 1
+}
+
+// authorization returns the Authorization header of requests to Handler.
+func (s *Store) authorization() string {
+	return "Bearer " + s.Token
+}
+
+// manifest describes the archived image as a schema 2 manifest. Since layers
+// are served as stored on archive, they're referenced by their digests only.
+func (a *Archive) manifest() interface{} {
+
+	type blob struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	}
+
+	manifest := struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		MediaType     string `json:"mediaType"`
+		Config        blob   `json:"config"`
+		Layers        []blob `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		Config:        blob{MediaType: configMediaType, Digest: a.Config.Digest},
+		Layers:        make([]blob, len(a.Layers)),
+	}
+
+	for index, layer := range a.Layers {
+		manifest.Layers[index] = blob{MediaType: layerMediaType, Digest: layer.Digest}
+	}
+
+	return manifest
+}
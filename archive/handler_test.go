@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Handler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cst-handler")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &Store{Dir: dir, Token: "s3cr3t"}
+
+	data := ociLayoutTarball(t)
+	id, err := store.Save(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(store.Handler())
+	defer server.Close()
+
+	request := func(method, path string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+
+		response, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return response
+	}
+
+	t.Run(`Ensure layers are served by their digest`, func(t *testing.T) {
+		response := request(http.MethodGet, "/v2/"+id+"/blobs/"+appLayerDigest)
+		defer response.Body.Close()
+
+		body, _ := ioutil.ReadAll(response.Body)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "app layer", string(body))
+	})
+
+	t.Run(`Ensure the manifest's digest is replied on HEAD requests`, func(t *testing.T) {
+		response := request(http.MethodHead, "/v2/"+id+"/manifests/latest")
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, ociManifest, response.Header.Get("Docker-Content-Digest"))
+	})
+
+	t.Run(`Ensure a manifest referencing the config and layers is replied on GET requests`, func(t *testing.T) {
+		response := request(http.MethodGet, "/v2/"+id+"/manifests/latest")
+		defer response.Body.Close()
+
+		var manifest struct {
+			Config struct {
+				Digest string `json:"digest"`
+			} `json:"config"`
+			Layers []struct {
+				Digest string `json:"digest"`
+			} `json:"layers"`
+		}
+
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&manifest))
+
+		assert.Equal(t, ociConfig, manifest.Config.Digest)
+		require.Len(t, manifest.Layers, 2)
+		assert.Equal(t, appLayerDigest, manifest.Layers[1].Digest)
+
+		config := request(http.MethodGet, "/v2/"+id+"/blobs/"+ociConfig)
+		config.Body.Close()
+
+		assert.Equal(t, http.StatusOK, config.StatusCode)
+	})
+
+	t.Run(`When method isn't supported, should return 405`, func(t *testing.T) {
+		response := request(http.MethodPost, "/v2/"+id+"/manifests/latest")
+		response.Body.Close()
+
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	})
+
+	t.Run(`When archive or layer doesn't exist, should return 404`, func(t *testing.T) {
+		for _, path := range []string{
+			"/v2/" + id + "/blobs/sha256:unknown",
+			"/v2/unknown/blobs/" + appLayerDigest,
+			"/v2/" + id + "/tags/list",
+		} {
+			response := request(http.MethodGet, path)
+			response.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, response.StatusCode, path)
+		}
+	})
+
+	t.Run(`When request isn't authorized by the store's token, should return 401`, func(t *testing.T) {
+		for _, authorization := range []string{"", "Bearer wrong", "s3cr3t"} {
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/"+id+"/blobs/"+appLayerDigest, nil)
+			require.NoError(t, err)
+
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+
+			response, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			response.Body.Close()
+
+			assert.Equal(t, http.StatusUnauthorized, response.StatusCode, authorization)
+		}
+	})
+
+	t.Run(`When store has no token, should refuse every request`, func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v2/"+id+"/blobs/"+appLayerDigest, nil)
+		req.Header.Set("Authorization", "Bearer ")
+
+		(&Store{Dir: dir}).Handler().ServeHTTP(recorder, req)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
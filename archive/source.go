@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"strings"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/tsuru/cst/scan"
+)
+
+// Source implements the scan.Source interface, reading the archived images
+// (see ImageName) from Store. Their layers are served by Store's Handler on
+// URL, which must be reachable by the security engines, authorized by the
+// store's Token. Other images are fetched by Registry (or pulled from their
+// registries when it's nil).
+type Source struct {
+	Store    *Store
+	URL      string
+	Registry scan.Source
+}
+
+// Fetch reads the manifest of an archived image, pointing its layers to the
+// blobs served on URL.
+func (s *Source) Fetch(image string) ([]scan.PlatformImage, error) {
+
+	id, ok := ParseImageName(image)
+
+	if !ok {
+		if s.Registry == nil {
+			return (&scan.RegistrySource{}).Fetch(image)
+		}
+
+		return s.Registry.Fetch(image)
+	}
+
+	archive, err := s.Store.Open(id)
+
+	switch err {
+	case nil:
+	case ErrArchiveNotFound:
+		return nil, &scan.Error{Code: scan.ErrorCodeImageNotFound, Message: err.Error()}
+	case ErrInvalidArchive:
+		return nil, &scan.Error{Code: scan.ErrorCodeInvalidImage, Message: err.Error()}
+	default:
+		return nil, err
+	}
+
+	dockerImage := &docker.Image{
+		Registry: strings.TrimSuffix(s.URL, "/") + "/v2",
+		Name:     id,
+		Tag:      "latest",
+		Token:    s.Store.authorization(),
+	}
+
+	for _, layer := range archive.Layers {
+		dockerImage.FsLayers = append(dockerImage.FsLayers, docker.FsLayer{BlobSum: layer.Digest})
+	}
+
+	return []scan.PlatformImage{{Platform: archive.Platform, Image: dockerImage}}, nil
+}
@@ -0,0 +1,73 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestSource_Fetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cst-source")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &Store{Dir: dir, Token: "s3cr3t"}
+
+	data := dockerSaveTarball(t)
+	id, err := store.Save(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	t.Run(`Ensure archived images have their layers pointed to the blobs served on URL`, func(t *testing.T) {
+		source := &Source{Store: store, URL: "http://worker:9090/"}
+
+		images, err := source.Fetch(ImageName(id))
+
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+
+		image := images[0]
+
+		assert.Empty(t, image.Platform)
+		assert.Equal(t, "http://worker:9090/v2", image.Registry)
+		assert.Equal(t, id, image.Name)
+		assert.Equal(t, "Bearer s3cr3t", image.Token)
+		assert.Equal(t, []docker.FsLayer{{BlobSum: baseLayerDigest}, {BlobSum: appLayerDigest}}, image.FsLayers)
+	})
+
+	t.Run(`When archive doesn't exist, should return an image not found error`, func(t *testing.T) {
+		source := &Source{Store: store}
+
+		_, err := source.Fetch(ImageName("unknown"))
+
+		if assert.IsType(t, &scan.Error{}, err) {
+			assert.Equal(t, scan.ErrorCodeImageNotFound, err.(*scan.Error).Code)
+		}
+	})
+
+	t.Run(`Ensure other images are fetched by the registry source`, func(t *testing.T) {
+		called := false
+
+		source := &Source{
+			Store: store,
+			Registry: &scan.MockSource{
+				MockFetch: func(image string) ([]scan.PlatformImage, error) {
+					called = true
+					assert.Equal(t, "docker.io/tsuru/cst:latest", image)
+
+					return nil, nil
+				},
+			},
+		}
+
+		_, err := source.Fetch("docker.io/tsuru/cst:latest")
+
+		assert.NoError(t, err)
+		assert.True(t, called)
+	})
+}
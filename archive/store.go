@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ImagePrefix starts the names of archived images, followed by their
+	// archive's id (e.g. "archive://<sha256 of tarball>").
+	ImagePrefix = "archive://"
+
+	uploadPrefix = ".upload-"
+)
+
+var (
+	// ErrArchiveNotFound indicates there's no archive with a given id on
+	// store.
+	ErrArchiveNotFound = errors.New(`image archive not found`)
+
+	// ErrArchiveTooLarge indicates an archive bigger than the size allowed.
+	ErrArchiveTooLarge = errors.New(`image archive is too large`)
+
+	idRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// Store keeps image archives on a local directory (blob area), each one
+// named by the SHA-256 of its content. Web servers and workers share that
+// directory. Token authorizes the requests to Handler, which refuses all of
+// them when it's empty. Archives older than TTL (if any) are removed by Sweep,
+// so it must be longer than scans take to be analyzed.
+type Store struct {
+	Dir   string
+	Token string
+	TTL   time.Duration
+
+	mutex    sync.Mutex
+	archives map[string]*Archive
+}
+
+// NewToken generates a random token to authorize the requests to Handler.
+func NewToken() (string, error) {
+
+	token := make([]byte, 32)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// Save writes the archive streamed by reader on store, returning its id. The
+// archive is discarded when it's bigger than maxSize bytes or isn't a valid
+// image tarball.
+func (s *Store) Save(reader io.Reader, maxSize int64) (string, error) {
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	file, err := ioutil.TempFile(s.Dir, uploadPrefix)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(reader, maxSize+1))
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if written > maxSize {
+		return "", ErrArchiveTooLarge
+	}
+
+	// the upload was just written, so failing to read it (e.g. a truncated
+	// tarball) means it's malformed, not that the store is unavailable
+	if _, err := Open(file.Name()); err != nil {
+		logrus.WithError(err).Debug("uploaded file isn't a valid image archive")
+
+		return "", ErrInvalidArchive
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+
+	if err := os.Rename(file.Name(), s.path(id)); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Open reads the archive with a given id. Since archives are named by their
+// content, they're read once and kept on memory while their files exist.
+func (s *Store) Open(id string) (*Archive, error) {
+
+	if !idRegexp.MatchString(id) {
+		return nil, ErrArchiveNotFound
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(s.path(id)); os.IsNotExist(err) {
+		delete(s.archives, id)
+		return nil, ErrArchiveNotFound
+	}
+
+	if archive, ok := s.archives[id]; ok {
+		return archive, nil
+	}
+
+	archive, err := Open(s.path(id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.archives == nil {
+		s.archives = make(map[string]*Archive)
+	}
+
+	s.archives[id] = archive
+
+	return archive, nil
+}
+
+// Sweep removes the archives (and unfinished uploads) older than TTL. Nothing
+// is removed when TTL isn't assigned.
+func (s *Store) Sweep() error {
+
+	if s.TTL <= 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(s.Dir)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Now().Add(-s.TTL)
+
+	for _, file := range files {
+		if !file.Mode().IsRegular() || file.ModTime().After(expiration) {
+			continue
+		}
+
+		id := strings.TrimSuffix(file.Name(), ".tar")
+
+		if !strings.HasPrefix(file.Name(), uploadPrefix) && (id == file.Name() || !idRegexp.MatchString(id)) {
+			continue
+		}
+
+		s.mutex.Lock()
+
+		if err := os.Remove(filepath.Join(s.Dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("file", file.Name()).Error("could not remove expired image archive")
+		}
+
+		delete(s.archives, id)
+
+		s.mutex.Unlock()
+	}
+
+	return nil
+}
+
+// RunSweeper sweeps the store at each interval until stop is closed.
+func (s *Store) RunSweeper(interval time.Duration, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Sweep(); err != nil {
+				logrus.WithError(err).Error("could not sweep image archives")
+			}
+		}
+	}
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.Dir, id+".tar")
+}
+
+// ImageName returns the name of the image on archive with a given id.
+func ImageName(id string) string {
+	return ImagePrefix + id
+}
+
+// ParseImageName returns the archive's id of an archived image's name.
+// Returns false when image isn't archived (e.g. it's on a registry).
+func ParseImageName(image string) (string, bool) {
+
+	if !strings.HasPrefix(image, ImagePrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(image, ImagePrefix), true
+}
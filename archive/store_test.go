@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cst-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &Store{Dir: dir}
+
+	t.Run(`Ensure archives are saved named by their SHA-256`, func(t *testing.T) {
+		data := dockerSaveTarball(t)
+		sum := sha256.Sum256(data)
+
+		id, err := store.Save(bytes.NewReader(data), int64(len(data)))
+
+		require.NoError(t, err)
+		assert.Equal(t, hex.EncodeToString(sum[:]), id)
+
+		archive, err := store.Open(id)
+		require.NoError(t, err)
+		assert.Len(t, archive.Layers, 2)
+
+		again, err := store.Open(id)
+		require.NoError(t, err)
+		assert.True(t, archive == again, "archive should be read once")
+	})
+
+	t.Run(`When archive is larger than max size, should return ErrArchiveTooLarge`, func(t *testing.T) {
+		data := dockerSaveTarball(t)
+
+		_, err := store.Save(bytes.NewReader(data), int64(len(data)-1))
+
+		assert.Equal(t, ErrArchiveTooLarge, err)
+	})
+
+	t.Run(`When archive isn't an image tarball, should return ErrInvalidArchive and discard it`, func(t *testing.T) {
+		_, err := store.Save(strings.NewReader("not a tarball"), 1024)
+
+		assert.Equal(t, ErrInvalidArchive, err)
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run(`When archive is a truncated or compressed image tarball, should return ErrInvalidArchive`, func(t *testing.T) {
+		data := dockerSaveTarball(t)
+
+		var compressed bytes.Buffer
+
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(data)
+		require.NoError(t, writer.Close())
+
+		for _, malformed := range [][]byte{data[:len(data)/2], data[:600], compressed.Bytes()} {
+			_, err := store.Save(bytes.NewReader(malformed), int64(len(data)))
+
+			assert.Equal(t, ErrInvalidArchive, err)
+		}
+	})
+
+	t.Run(`When id is unknown or malformed, should return ErrArchiveNotFound`, func(t *testing.T) {
+		_, err := store.Open(strings.Repeat("a", 64))
+		assert.Equal(t, ErrArchiveNotFound, err)
+
+		_, err = store.Open("../../etc/passwd")
+		assert.Equal(t, ErrArchiveNotFound, err)
+	})
+}
+
+func TestStore_Sweep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cst-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data := dockerSaveTarball(t)
+
+	store := &Store{Dir: dir}
+
+	expired, err := store.Save(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	_, err = store.Open(expired)
+	require.NoError(t, err)
+
+	recent, err := store.Save(bytes.NewReader(ociLayoutTarball(t)), 1<<20)
+	require.NoError(t, err)
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	for _, name := range []string{".upload-123", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("partial"), 0644))
+	}
+
+	for _, name := range []string{expired + ".tar", ".upload-123", "notes.txt"} {
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), old, old))
+	}
+
+	t.Run(`When TTL isn't assigned, should keep every archive`, func(t *testing.T) {
+		require.NoError(t, store.Sweep())
+
+		files, _ := ioutil.ReadDir(dir)
+		assert.Len(t, files, 4)
+	})
+
+	t.Run(`Ensure archives and uploads older than TTL are removed`, func(t *testing.T) {
+		store.TTL = time.Hour
+
+		require.NoError(t, store.Sweep())
+
+		var names []string
+
+		files, _ := ioutil.ReadDir(dir)
+
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+
+		assert.ElementsMatch(t, []string{recent + ".tar", "notes.txt"}, names)
+
+		_, err := store.Open(expired)
+		assert.Equal(t, ErrArchiveNotFound, err)
+
+		_, err = store.Open(recent)
+		assert.NoError(t, err)
+	})
+}
+
+func TestParseImageName(t *testing.T) {
+	id, ok := ParseImageName(ImageName("3f2a"))
+
+	assert.True(t, ok)
+	assert.Equal(t, "3f2a", id)
+
+	_, ok = ParseImageName("docker.io/tsuru/cst:latest")
+	assert.False(t, ok)
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -11,11 +13,17 @@ import (
 	"github.com/tsuru/cst/api"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/db/mongodb"
+	"github.com/tsuru/cst/metrics"
 	"github.com/tsuru/cst/queue"
+	"github.com/tsuru/cst/reference"
+	"github.com/tsuru/cst/registry"
+	"github.com/tsuru/cst/scan/scheduler"
+	"github.com/tsuru/cst/tracing"
 )
 
 var (
 	webserver api.WebServer
+	crawlJob  *scheduler.CrawlJob
 
 	signalChan = make(chan os.Signal, 1)
 
@@ -39,6 +47,11 @@ func New() *cobra.Command {
 					return errors.New("key-file is required")
 				}
 			}
+			// the server only enqueues jobs, which are consumed by workers
+			// running on other processes
+			if strings.HasPrefix(viper.GetString("server.queue"), "memory:") {
+				return errors.New("memory queue isn't supported by server, use mongodb:// or redis://")
+			}
 			return nil
 		},
 	}
@@ -55,21 +68,84 @@ func New() *cobra.Command {
 	serverCmd.Flags().
 		String("database", "", "database URL connection (required)")
 
+	serverCmd.Flags().
+		String("queue", "", "queue URL connection: mongodb:// or redis:// (defaults to database URL)")
+
 	serverCmd.Flags().
 		Bool("insecure", false, "start server without TLS")
 
+	serverCmd.Flags().
+		String("tracing-exporter", "none", "tracing exporter: otlp, stdout or none")
+
+	serverCmd.Flags().
+		String("tracing-endpoint", tracing.DefaultOTLPEndpoint, "OTLP/HTTP traces endpoint (used by otlp exporter)")
+
+	serverCmd.Flags().
+		String("registry-auth-file", "", "Docker config file (e.g. ~/.docker/config.json) holding the registry credentials")
+
+	serverCmd.Flags().
+		StringSlice("crawl-target", nil, "repository (or pattern, e.g. registry.tld/team/*) to crawl for images periodically (can be repeated)")
+
+	serverCmd.Flags().
+		StringSlice("crawl-include", nil, "only crawl images matching this repository:tag pattern (can be repeated)")
+
+	serverCmd.Flags().
+		StringSlice("crawl-exclude", nil, "skip crawled images matching this repository:tag pattern (can be repeated)")
+
+	serverCmd.Flags().
+		Int("crawl-latest-tags", 0, "only crawl the N latest tags of each repository (all tags when zero)")
+
+	serverCmd.Flags().
+		Duration("crawl-interval", 24*time.Hour, "interval to crawl the targets")
+
+	serverCmd.Flags().
+		String("upload-dir", "", "directory to store the uploaded image tarballs, shared with workers (uploads are disabled when empty)")
+
+	serverCmd.Flags().
+		Int64("upload-max-size", 2<<30, "maximum size (in bytes) of an uploaded image tarball")
+
+	serverCmd.Flags().
+		StringSlice("base-image", nil, "repository of a known base image (e.g. debian) to crawl and detect as images' base image (can be repeated)")
+
+	serverCmd.Flags().
+		Int("base-image-tags", 10, "crawl the N latest tags of each base image's repository (all tags when zero)")
+
 	serverCmd.MarkFlagRequired("database")
 
 	viper.BindPFlag("server.cert-file", serverCmd.Flags().Lookup("cert-file"))
 	viper.BindPFlag("server.key-file", serverCmd.Flags().Lookup("key-file"))
 	viper.BindPFlag("server.port", serverCmd.Flags().Lookup("port"))
 	viper.BindPFlag("server.database", serverCmd.Flags().Lookup("database"))
+	viper.BindPFlag("server.queue", serverCmd.Flags().Lookup("queue"))
 	viper.BindPFlag("server.insecure", serverCmd.Flags().Lookup("insecure"))
+	viper.BindPFlag("server.tracing.exporter", serverCmd.Flags().Lookup("tracing-exporter"))
+	viper.BindPFlag("server.tracing.endpoint", serverCmd.Flags().Lookup("tracing-endpoint"))
+	viper.BindPFlag("server.registry.auth-file", serverCmd.Flags().Lookup("registry-auth-file"))
+	viper.BindPFlag("server.crawl.targets", serverCmd.Flags().Lookup("crawl-target"))
+	viper.BindPFlag("server.crawl.include", serverCmd.Flags().Lookup("crawl-include"))
+	viper.BindPFlag("server.crawl.exclude", serverCmd.Flags().Lookup("crawl-exclude"))
+	viper.BindPFlag("server.crawl.latest-tags", serverCmd.Flags().Lookup("crawl-latest-tags"))
+	viper.BindPFlag("server.crawl.interval", serverCmd.Flags().Lookup("crawl-interval"))
+	viper.BindPFlag("server.upload.dir", serverCmd.Flags().Lookup("upload-dir"))
+	viper.BindPFlag("server.upload.max-size", serverCmd.Flags().Lookup("upload-max-size"))
+	viper.BindPFlag("server.base-images.repositories", serverCmd.Flags().Lookup("base-image"))
+	viper.BindPFlag("server.base-images.tags", serverCmd.Flags().Lookup("base-image-tags"))
 
 	return serverCmd
 }
 
 func serverCommandPreRun(cmd *cobra.Command, args []string) {
+	exporter, err := tracing.NewExporter(
+		viper.GetString("server.tracing.exporter"),
+		viper.GetString("server.tracing.endpoint"),
+	)
+
+	if err != nil {
+		logrus.WithError(err).Fatal("problem to configure tracing exporter")
+	}
+
+	tracing.SetTracer(tracing.NewTracer("cst-server", exporter))
+
 	databaseURL := viper.GetString("server.database")
 
 	database, err := newStorage(databaseURL)
@@ -80,7 +156,13 @@ func serverCommandPreRun(cmd *cobra.Command, args []string) {
 
 	db.SetStorage(database)
 
-	q, err := newQueue(databaseURL)
+	queueURL := viper.GetString("server.queue")
+
+	if queueURL == "" {
+		queueURL = databaseURL
+	}
+
+	q, err := newQueue(queueURL)
 
 	if err != nil {
 		logrus.WithError(err).Fatal("problem to connect on queue service")
@@ -88,11 +170,71 @@ func serverCommandPreRun(cmd *cobra.Command, args []string) {
 
 	queue.SetQueue(q)
 
+	if sizer, ok := q.(queue.Sizer); ok {
+		metrics.SetQueueDepthFunc(sizer.Size)
+	}
+
+	var credentials registry.Credentials
+
+	if authFile := viper.GetString("server.registry.auth-file"); authFile != "" {
+		credentials, err = registry.LoadDockerConfig(authFile)
+
+		if err != nil {
+			logrus.WithError(err).Fatal("problem to load registry credentials")
+		}
+	}
+
+	var baseImages []string
+
+	for _, repository := range viper.GetStringSlice("server.base-images.repositories") {
+		ref, err := reference.Parse(repository)
+
+		if err != nil || ref.Tag != "" || ref.Digest != "" {
+			logrus.WithField("repository", repository).Fatal("base image must be a repository, without tag or digest")
+		}
+
+		ref, _ = reference.ParseNormalized(repository)
+
+		baseImages = append(baseImages, ref.Name())
+	}
+
 	webserver = &api.SecureWebServer{
-		CertFile: viper.GetString("server.cert-file"),
-		KeyFile:  viper.GetString("server.key-file"),
-		Port:     viper.GetInt("server.port"),
-		UseTLS:   !viper.GetBool("server.insecure"),
+		CertFile:            viper.GetString("server.cert-file"),
+		KeyFile:             viper.GetString("server.key-file"),
+		Port:                viper.GetInt("server.port"),
+		UseTLS:              !viper.GetBool("server.insecure"),
+		RegistryCredentials: credentials,
+		UploadDir:           viper.GetString("server.upload.dir"),
+		UploadMaxSize:       viper.GetInt64("server.upload.max-size"),
+		BaseImages:          baseImages,
+	}
+
+	targets := viper.GetStringSlice("server.crawl.targets")
+
+	if interval := viper.GetDuration("server.crawl.interval"); len(targets)+len(baseImages) > 0 && interval > 0 {
+		crawlJob = &scheduler.CrawlJob{
+			Crawler:   &registry.Crawler{Credentials: credentials},
+			Scheduler: &scheduler.DefaultScheduler{},
+			Interval:  interval,
+		}
+
+		for _, target := range targets {
+			crawlJob.Specs = append(crawlJob.Specs, registry.CrawlSpec{
+				Target:     target,
+				Include:    viper.GetStringSlice("server.crawl.include"),
+				Exclude:    viper.GetStringSlice("server.crawl.exclude"),
+				LatestTags: viper.GetInt("server.crawl.latest-tags"),
+			})
+		}
+
+		// base images are scanned by CST itself to be matched against the
+		// images' layers
+		for _, repository := range baseImages {
+			crawlJob.Specs = append(crawlJob.Specs, registry.CrawlSpec{
+				Target:     repository,
+				LatestTags: viper.GetInt("server.base-images.tags"),
+			})
+		}
 	}
 }
 
@@ -108,11 +250,20 @@ func serverCommandRun(cmd *cobra.Command, args []string) {
 		signalChan <- os.Interrupt
 	}()
 
+	stopCrawlJob := make(chan struct{})
+
+	if crawlJob != nil {
+		go crawlJob.Run(stopCrawlJob)
+	}
+
 	signal.Notify(signalChan, os.Interrupt)
 
 	<-signalChan
 	signal.Stop(signalChan)
 
+	close(stopCrawlJob)
+
 	webserver.Shutdown()
 	db.GetStorage().Close()
+	tracing.GetTracer().Shutdown()
 }
@@ -10,10 +10,12 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tsuru/cst/api"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/db/mongodb"
-	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/cst/queue"
+	"github.com/tsuru/cst/registry"
 )
 
 func TestServerCommandPreRun(t *testing.T) {
@@ -29,7 +31,7 @@ func TestServerCommandPreRun(t *testing.T) {
 	}()
 
 	t.Run(`Ensure WebServer is created with expected params`, func(t *testing.T) {
-		newQueue = func(url string) (monsterqueue.Queue, error) {
+		newQueue = func(url string) (queue.Queue, error) {
 			return nil, nil
 		}
 
@@ -59,7 +61,7 @@ func TestServerCommandPreRun(t *testing.T) {
 		gotStorageURL := ""
 		gotQueueURL := ""
 
-		newQueue = func(url string) (monsterqueue.Queue, error) {
+		newQueue = func(url string) (queue.Queue, error) {
 			gotQueueURL = url
 
 			return nil, nil
@@ -78,6 +80,91 @@ func TestServerCommandPreRun(t *testing.T) {
 		assert.Equal(t, gotQueueURL, viper.Get("server.database"))
 		assert.Equal(t, gotStorageURL, viper.Get("server.database"))
 	})
+
+	t.Run(`When queue URL is assigned, should call newQueue with it instead of database URL`, func(t *testing.T) {
+		gotQueueURL := ""
+
+		newQueue = func(url string) (queue.Queue, error) {
+			gotQueueURL = url
+
+			return nil, nil
+		}
+
+		newStorage = func(url string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("server.database", "mongodb://localhost/")
+		viper.Set("server.queue", "redis://localhost:6379/0")
+
+		serverCommandPreRun(nil, []string{})
+
+		assert.Equal(t, "redis://localhost:6379/0", gotQueueURL)
+	})
+
+	t.Run(`When crawl targets are assigned, should create a crawl job for each one`, func(t *testing.T) {
+		defer func() {
+			crawlJob = nil
+		}()
+
+		newQueue = func(url string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(url string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("server.crawl.targets", []string{"registry.tld/team/*", "registry.tld/other/app"})
+		viper.Set("server.crawl.exclude", []string{"team/legacy:*"})
+		viper.Set("server.crawl.latest-tags", 3)
+		viper.Set("server.crawl.interval", time.Hour)
+
+		serverCommandPreRun(nil, []string{})
+
+		require.NotNil(t, crawlJob)
+		assert.Equal(t, time.Hour, crawlJob.Interval)
+
+		expected := []registry.CrawlSpec{
+			{Target: "registry.tld/team/*", Exclude: []string{"team/legacy:*"}, LatestTags: 3},
+			{Target: "registry.tld/other/app", Exclude: []string{"team/legacy:*"}, LatestTags: 3},
+		}
+
+		assert.Equal(t, expected, crawlJob.Specs)
+	})
+
+	t.Run(`When base images are assigned, should crawl their repositories and detect them on web server`, func(t *testing.T) {
+		defer func() {
+			crawlJob = nil
+			viper.Set("server.crawl.targets", nil)
+			viper.Set("server.base-images.repositories", nil)
+		}()
+
+		newQueue = func(url string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(url string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("server.crawl.targets", nil)
+		viper.Set("server.crawl.interval", time.Hour)
+		viper.Set("server.base-images.repositories", []string{"debian", "registry.tld/base/alpine"})
+		viper.Set("server.base-images.tags", 5)
+
+		serverCommandPreRun(nil, []string{})
+
+		expected := []string{"docker.io/library/debian", "registry.tld/base/alpine"}
+
+		require.NotNil(t, crawlJob)
+		assert.Equal(t, []registry.CrawlSpec{
+			{Target: expected[0], LatestTags: 5},
+			{Target: expected[1], LatestTags: 5},
+		}, crawlJob.Specs)
+
+		assert.Equal(t, expected, webserver.(*api.SecureWebServer).BaseImages)
+	})
 }
 
 func TestServerCommandRun(t *testing.T) {
@@ -211,6 +298,11 @@ func TestNew(t *testing.T) {
 			[]string{
 				"--database", "dbhost",
 			},
+			[]string{
+				"--database", "mongodb://127.0.0.1:27017/",
+				"--queue", "memory://",
+				"--insecure",
+			},
 		}
 
 		for _, args := range errorArgs {
@@ -1,24 +1,45 @@
 package worker
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
-	"github.com/tsuru/monsterqueue"
-
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/tsuru/cst/archive"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/db/mongodb"
+	"github.com/tsuru/cst/health"
+	"github.com/tsuru/cst/metrics"
 	"github.com/tsuru/cst/queue"
 	"github.com/tsuru/cst/scan"
 	"github.com/tsuru/cst/scan/worker"
+	"github.com/tsuru/cst/tracing"
+)
+
+const (
+	// layersTimeout is how long scanners analyzing the images' layers can
+	// take to download each layer.
+	layersTimeout = 10 * time.Minute
+
+	// uploadSweepInterval is how often the expired image tarballs are removed
+	// from upload dir.
+	uploadSweepInterval = 10 * time.Minute
 )
 
 var (
-	scanTask monsterqueue.Task
+	scanTask   queue.Task
+	reaper     *worker.Reaper
+	archives   *archive.Store
+	httpServer *http.Server
 
 	signalChan = make(chan os.Signal, 1)
 
@@ -39,20 +60,144 @@ func New() *cobra.Command {
 	workerCmd.Flags().
 		String("database", "", "database URL connection (required)")
 
+	workerCmd.Flags().
+		String("queue", "", "queue URL connection: mongodb://, redis:// or memory:// (defaults to database URL)")
+
 	workerCmd.Flags().
 		String("clair-address", "", "CoresOS Clair address (required)")
 
+	workerCmd.Flags().
+		Bool("clair-v4", false, "use Clair v4 (ClairCore) indexer and matcher APIs on clair-address")
+
+	workerCmd.Flags().
+		String("clair-matcher-address", "", "Clair v4 matcher address (defaults to clair-address, i.e. Clair on combo mode)")
+
+	workerCmd.Flags().
+		String("clair-psk-file", "", "file holding the base64 encoded pre-shared key signing the JWTs which authenticate Clair v4 requests (defaults to CST_CLAIR_PSK env, disabled when both are empty)")
+
+	workerCmd.Flags().
+		String("clair-psk-issuer", "cst", "issuer of the JWTs signed with the Clair v4 PSK (must be on Clair's auth.psk.iss)")
+
+	workerCmd.Flags().
+		Int("max-attempts", 5, "maximum attempts to analyze an image when scanners have transient failures")
+
+	workerCmd.Flags().
+		Duration("retry-backoff", 30*time.Second, "time to wait before the second attempt (it doubles at each new attempt)")
+
+	workerCmd.Flags().
+		Duration("retry-max-backoff", 10*time.Minute, "maximum time to wait between attempts")
+
+	workerCmd.Flags().
+		Duration("lease-duration", time.Minute, "time a running scan is kept leased to this worker without heartbeats")
+
+	workerCmd.Flags().
+		Duration("reaper-interval", time.Minute, "interval to look for running scans whose worker has gone away")
+
+	workerCmd.Flags().
+		String("http-address", "", "address to serve metrics and health checks, e.g. :9090 (disabled when empty)")
+
+	workerCmd.Flags().
+		String("tracing-exporter", "none", "tracing exporter: otlp, stdout or none")
+
+	workerCmd.Flags().
+		String("tracing-endpoint", tracing.DefaultOTLPEndpoint, "OTLP/HTTP traces endpoint (used by otlp exporter)")
+
+	workerCmd.Flags().
+		StringSlice("platforms", nil, "platforms of multi-platform images to analyze, e.g. linux/amd64,linux/arm64 (all platforms when empty)")
+
+	workerCmd.Flags().
+		StringSlice("insecure-registry", nil, "registries pulled over plain HTTP, e.g. registry.local:5000")
+
+	workerCmd.Flags().
+		String("upload-dir", "", "directory where the web server stores the uploaded image tarballs (uploads aren't scanned when empty)")
+
+	workerCmd.Flags().
+		String("upload-url", "", "URL of this worker's HTTP address reachable by the security engines, which fetch the uploaded images' layers from it")
+
+	workerCmd.Flags().
+		Duration("upload-ttl", 24*time.Hour, "time the uploaded image tarballs are kept on upload-dir before being removed (kept forever when zero)")
+
+	workerCmd.Flags().
+		Duration("layer-cache-ttl", 12*time.Hour, "time the findings of analyzed layers are reused by other images sharing them, on Clair v1 API (disabled when zero)")
+
+	workerCmd.Flags().
+		String("clair-db-version", "", "version of Clair's vulnerability database (e.g. the date of its last update), cached layers analyzed on other versions aren't reused")
+
+	workerCmd.Flags().
+		Bool("secret-scanner", false, "search for secrets (private keys, access keys, tokens...) on the images' layers")
+
+	workerCmd.Flags().
+		String("secret-rules", "", "YAML file with rules and allowlists added to the secret scanner's default ones")
+
+	workerCmd.Flags().
+		Bool("config-audit", false, "check the images' configs and files for misconfigurations (e.g. running as root, setuid files)")
+
+	workerCmd.Flags().
+		StringSlice("config-audit-disable", []string{}, "IDs of config audit rules to skip (e.g. healthcheck-missing)")
+
+	workerCmd.Flags().
+		Bool("license-scanner", false, "report the licenses of the packages (OS and language ones) installed on images")
+
+	workerCmd.Flags().
+		StringSlice("license-deny", []string{}, "licenses reported as findings (SPDX identifiers or families, e.g. AGPL)")
+
+	workerCmd.Flags().
+		String("osv-database", "", "directory of OSV advisories (JSON files or ZIP archives) matched against the images' language dependencies")
+
+	workerCmd.Flags().
+		String("scanner-plugins", "", "YAML file declaring external scanners (plugins) run on the images, see scan.LoadExecScanners")
+
 	workerCmd.MarkFlagRequired("database")
 	workerCmd.MarkFlagRequired("clair-address")
 
 	viper.BindPFlag("worker.database", workerCmd.Flags().Lookup("database"))
+	viper.BindPFlag("worker.queue", workerCmd.Flags().Lookup("queue"))
 	viper.BindPFlag("worker.clair.address", workerCmd.Flags().Lookup("clair-address"))
+	viper.BindPFlag("worker.clair.v4", workerCmd.Flags().Lookup("clair-v4"))
+	viper.BindPFlag("worker.clair.matcher-address", workerCmd.Flags().Lookup("clair-matcher-address"))
+	viper.BindPFlag("worker.clair.psk-file", workerCmd.Flags().Lookup("clair-psk-file"))
+	viper.BindEnv("worker.clair.psk", "CST_CLAIR_PSK")
+	viper.BindPFlag("worker.clair.psk-issuer", workerCmd.Flags().Lookup("clair-psk-issuer"))
+	viper.BindPFlag("worker.retry.max-attempts", workerCmd.Flags().Lookup("max-attempts"))
+	viper.BindPFlag("worker.retry.backoff", workerCmd.Flags().Lookup("retry-backoff"))
+	viper.BindPFlag("worker.retry.max-backoff", workerCmd.Flags().Lookup("retry-max-backoff"))
+	viper.BindPFlag("worker.lease.duration", workerCmd.Flags().Lookup("lease-duration"))
+	viper.BindPFlag("worker.reaper.interval", workerCmd.Flags().Lookup("reaper-interval"))
+	viper.BindPFlag("worker.http.address", workerCmd.Flags().Lookup("http-address"))
+	viper.BindPFlag("worker.tracing.exporter", workerCmd.Flags().Lookup("tracing-exporter"))
+	viper.BindPFlag("worker.tracing.endpoint", workerCmd.Flags().Lookup("tracing-endpoint"))
+	viper.BindPFlag("worker.platforms", workerCmd.Flags().Lookup("platforms"))
+	viper.BindPFlag("worker.insecure-registries", workerCmd.Flags().Lookup("insecure-registry"))
+	viper.BindPFlag("worker.upload.dir", workerCmd.Flags().Lookup("upload-dir"))
+	viper.BindPFlag("worker.upload.url", workerCmd.Flags().Lookup("upload-url"))
+	viper.BindPFlag("worker.upload.ttl", workerCmd.Flags().Lookup("upload-ttl"))
+	viper.BindPFlag("worker.layer-cache.ttl", workerCmd.Flags().Lookup("layer-cache-ttl"))
+	viper.BindPFlag("worker.clair.db-version", workerCmd.Flags().Lookup("clair-db-version"))
+	viper.BindPFlag("worker.secrets.enabled", workerCmd.Flags().Lookup("secret-scanner"))
+	viper.BindPFlag("worker.secrets.rules", workerCmd.Flags().Lookup("secret-rules"))
+	viper.BindPFlag("worker.config-audit.enabled", workerCmd.Flags().Lookup("config-audit"))
+	viper.BindPFlag("worker.config-audit.disabled", workerCmd.Flags().Lookup("config-audit-disable"))
+	viper.BindPFlag("worker.licenses.enabled", workerCmd.Flags().Lookup("license-scanner"))
+	viper.BindPFlag("worker.licenses.deny", workerCmd.Flags().Lookup("license-deny"))
+	viper.BindPFlag("worker.osv.database", workerCmd.Flags().Lookup("osv-database"))
+	viper.BindPFlag("worker.plugins.config", workerCmd.Flags().Lookup("scanner-plugins"))
 
 	return workerCmd
 }
 
 func workerCommandPreRun(cmd *cobra.Command, args []string) {
 
+	exporter, err := tracing.NewExporter(
+		viper.GetString("worker.tracing.exporter"),
+		viper.GetString("worker.tracing.endpoint"),
+	)
+
+	if err != nil {
+		logrus.WithError(err).Fatal("problem to configure tracing exporter")
+	}
+
+	tracing.SetTracer(tracing.NewTracer("cst-worker", exporter))
+
 	databaseURL := viper.GetString("worker.database")
 
 	storage, err := newStorage(databaseURL)
@@ -63,7 +208,13 @@ func workerCommandPreRun(cmd *cobra.Command, args []string) {
 
 	db.SetStorage(storage)
 
-	q, err := newQueue(databaseURL)
+	queueURL := viper.GetString("worker.queue")
+
+	if queueURL == "" {
+		queueURL = databaseURL
+	}
+
+	q, err := newQueue(queueURL)
 
 	if err != nil {
 		logrus.WithError(err).Fatal("problem to connect on queue service")
@@ -71,16 +222,205 @@ func workerCommandPreRun(cmd *cobra.Command, args []string) {
 
 	queue.SetQueue(q)
 
-	clair := &scan.Clair{
-		Address: viper.GetString("worker.clair.address"),
-		Name:    "clair",
-		Timeout: time.Minute,
+	if sizer, ok := q.(queue.Sizer); ok {
+		metrics.SetQueueDepthFunc(sizer.Size)
+	}
+
+	registrySource := &scan.RegistrySource{
+		Platforms:          viper.GetStringSlice("worker.platforms"),
+		InsecureRegistries: viper.GetStringSlice("worker.insecure-registries"),
 	}
 
+	var source scan.Source = registrySource
+
+	archives = nil
+
+	if dir := viper.GetString("worker.upload.dir"); dir != "" {
+		if viper.GetString("worker.http.address") == "" || viper.GetString("worker.upload.url") == "" {
+			logrus.Fatal("scanning uploaded images requires both http-address and upload-url")
+		}
+
+		// only security engines asked by this worker may fetch the layers
+		token, err := archive.NewToken()
+
+		if err != nil {
+			logrus.WithError(err).Fatal("problem to generate the token of uploaded images' layers")
+		}
+
+		archives = &archive.Store{
+			Dir:   dir,
+			Token: token,
+			TTL:   viper.GetDuration("worker.upload.ttl"),
+		}
+
+		source = &archive.Source{
+			Store:    archives,
+			URL:      viper.GetString("worker.upload.url"),
+			Registry: registrySource,
+		}
+	}
+
+	var clair scan.Scanner
+
+	if viper.GetBool("worker.clair.v4") {
+		psk, err := clairPSK()
+
+		if err != nil {
+			logrus.WithError(err).Fatal("problem to read Clair v4 PSK")
+		}
+
+		clair = &scan.ClairV4{
+			Address:        viper.GetString("worker.clair.address"),
+			MatcherAddress: viper.GetString("worker.clair.matcher-address"),
+			PSK:            psk,
+			Issuer:         viper.GetString("worker.clair.psk-issuer"),
+			Name:           "clair",
+			Timeout:        time.Minute,
+			Source:         source,
+		}
+
+		// Clair v4 indexer reuses the layers it has indexed by itself
+		if viper.GetDuration("worker.layer-cache.ttl") > 0 {
+			logrus.Warn("layer cache is only supported on Clair v1 API, ignoring layer-cache-ttl (set it to 0 to silence this warning)")
+		}
+	} else {
+		clairV1 := &scan.Clair{
+			Address:   viper.GetString("worker.clair.address"),
+			Name:      "clair",
+			Timeout:   time.Minute,
+			Source:    source,
+			DBVersion: viper.GetString("worker.clair.db-version"),
+		}
+
+		if ttl := viper.GetDuration("worker.layer-cache.ttl"); ttl > 0 {
+			clairV1.Cache = &worker.LayerCache{TTL: ttl}
+
+			if clairV1.DBVersion == "" {
+				logrus.WithField("ttl", ttl).Info("Clair database version isn't assigned, cached layers are only refreshed when they expire")
+			}
+		}
+
+		clair = clairV1
+	}
+
+	scanners := []scan.Scanner{clair}
+
+	// built-in scanners run on the worker itself, only Clair and plugins
+	// depend on something able to go away (see scan.Pinger)
+	pingers := map[string]scan.Scanner{"clair": clair}
+
+	if viper.GetBool("worker.secrets.enabled") {
+		secretScanner := &scan.SecretScanner{
+			Name:    "secrets",
+			Source:  source,
+			Timeout: layersTimeout,
+		}
+
+		if rules := viper.GetString("worker.secrets.rules"); rules != "" {
+			if secretScanner.Config, err = scan.LoadSecretConfig(rules); err != nil {
+				logrus.WithError(err).Fatal("problem to load secret scanner's rules")
+			}
+		}
+
+		scanners = append(scanners, secretScanner)
+	}
+
+	if viper.GetBool("worker.config-audit.enabled") {
+		disabled := viper.GetStringSlice("worker.config-audit.disabled")
+
+		if err := scan.ValidateAuditRules(disabled); err != nil {
+			logrus.WithError(err).Fatal("problem to disable config audit rules")
+		}
+
+		scanners = append(scanners, &scan.ConfigAuditScanner{
+			Name:     "config-audit",
+			Source:   source,
+			Disabled: disabled,
+			Timeout:  layersTimeout,
+		})
+	}
+
+	if viper.GetBool("worker.licenses.enabled") {
+		scanners = append(scanners, &scan.LicenseScanner{
+			Name:    "licenses",
+			Source:  source,
+			Deny:    viper.GetStringSlice("worker.licenses.deny"),
+			Timeout: layersTimeout,
+		})
+	}
+
+	if dir := viper.GetString("worker.osv.database"); dir != "" {
+		database, err := scan.LoadOSVDatabase(dir)
+
+		if err != nil {
+			logrus.WithError(err).Fatal("problem to load OSV database")
+		}
+
+		logrus.WithField("advisories", database.Len()).Info("OSV database loaded")
+
+		scanners = append(scanners, &scan.DependencyScanner{
+			Name:     "osv",
+			Source:   source,
+			Database: database,
+			Timeout:  layersTimeout,
+		})
+	}
+
+	if file := viper.GetString("worker.plugins.config"); file != "" {
+		plugins, err := scan.LoadExecScanners(file)
+
+		if err != nil {
+			logrus.WithError(err).Fatal("problem to load scanner plugins")
+		}
+
+		for _, plugin := range plugins {
+			plugin.Source = source
+			scanners = append(scanners, plugin)
+			pingers[plugin.Name] = plugin
+		}
+	}
+
+	retryPolicy := worker.RetryPolicy{
+		MaxAttempts:    viper.GetInt("worker.retry.max-attempts"),
+		InitialBackoff: viper.GetDuration("worker.retry.backoff"),
+		MaxBackoff:     viper.GetDuration("worker.retry.max-backoff"),
+	}
+
+	hostname, _ := os.Hostname()
+
 	scanTask = &worker.ScanTask{
-		Scanners: []scan.Scanner{
-			clair,
-		},
+		Scanners:      scanners,
+		Retry:         retryPolicy,
+		WorkerID:      fmt.Sprintf("%s_%d", hostname, os.Getpid()),
+		LeaseDuration: viper.GetDuration("worker.lease.duration"),
+	}
+
+	if interval := viper.GetDuration("worker.reaper.interval"); interval > 0 {
+		reaper = &worker.Reaper{
+			Interval: interval,
+			Retry:    retryPolicy,
+		}
+	}
+
+	if address := viper.GetString("worker.http.address"); address != "" {
+		readinessChecks := append([]health.Check{
+			health.StorageCheck(),
+			health.QueueCheck(),
+		}, health.ScannerChecks(pingers)...)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/health/live", health.LiveHandler())
+		mux.Handle("/health/ready", health.ReadyHandler(readinessChecks))
+
+		if archives != nil {
+			mux.Handle("/v2/", archives.Handler())
+		}
+
+		httpServer = &http.Server{
+			Addr:    address,
+			Handler: mux,
+		}
 	}
 }
 
@@ -88,16 +428,67 @@ func workerCommandRun(cmd *cobra.Command, args []string) {
 
 	q := queue.GetQueue()
 
-	q.RegisterTask(scanTask)
-
 	// process the jobs in another thread to be able to handle signals
-	go q.ProcessLoop()
+	go q.Consume(scanTask)
+
+	stop := make(chan struct{})
+
+	if reaper != nil {
+		go reaper.Run(stop)
+	}
+
+	if archives != nil {
+		go archives.RunSweeper(uploadSweepInterval, stop)
+	}
+
+	if httpServer != nil {
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Error("could not serve worker's HTTP endpoints")
+			}
+		}()
+	}
 
 	signal.Notify(signalChan, os.Interrupt)
 
 	<-signalChan
 	signal.Stop(signalChan)
 
+	close(stop)
+
+	if httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		httpServer.Shutdown(ctx)
+		cancel()
+	}
+
 	q.Stop()
 	db.GetStorage().Close()
+	tracing.GetTracer().Shutdown()
+}
+
+// clairPSK reads the base64 encoded Clair v4 PSK from the file on
+// clair-psk-file or, when it's not assigned, from CST_CLAIR_PSK env. The key
+// isn't accepted as a flag since it'd be visible on the process list.
+func clairPSK() ([]byte, error) {
+
+	encoded := viper.GetString("worker.clair.psk")
+
+	if file := viper.GetString("worker.clair.psk-file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = string(data)
+	}
+
+	psk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+
+	if err != nil {
+		return nil, fmt.Errorf("PSK must be base64 encoded: %v", err)
+	}
+
+	return psk, nil
 }
@@ -2,17 +2,23 @@ package worker
 
 import (
 	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/db/mongodb"
 	"github.com/tsuru/cst/queue"
-	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/cst/scan"
+	"github.com/tsuru/cst/scan/worker"
 )
 
 func TestWorkerCommandPreRun(t *testing.T) {
@@ -20,7 +26,7 @@ func TestWorkerCommandPreRun(t *testing.T) {
 		gotStorageURL := ""
 		gotQueueURL := ""
 
-		newQueue = func(url string) (monsterqueue.Queue, error) {
+		newQueue = func(url string) (queue.Queue, error) {
 			gotQueueURL = url
 
 			return nil, nil
@@ -39,16 +45,283 @@ func TestWorkerCommandPreRun(t *testing.T) {
 		assert.Equal(t, gotQueueURL, viper.Get("worker.database"))
 		assert.Equal(t, gotStorageURL, viper.Get("worker.database"))
 	})
+
+	t.Run(`When HTTP address is assigned, should create a server to expose metrics and health checks`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.http.address", "")
+			httpServer = nil
+		}()
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.http.address", ":9090")
+
+		workerCommandPreRun(nil, []string{})
+
+		require.NotNil(t, httpServer)
+		assert.Equal(t, ":9090", httpServer.Addr)
+
+		recorder := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run(`When upload dir is assigned, should serve the uploaded images' layers`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.http.address", "")
+			viper.Set("worker.upload.dir", "")
+			viper.Set("worker.upload.url", "")
+			httpServer = nil
+		}()
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.http.address", ":9090")
+		viper.Set("worker.upload.dir", os.TempDir())
+		viper.Set("worker.upload.url", "http://worker:9090")
+
+		workerCommandPreRun(nil, []string{})
+
+		require.NotNil(t, httpServer)
+		require.NotNil(t, archives)
+		require.NotEmpty(t, archives.Token)
+
+		recorder := httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v2/unknown/manifests/latest", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+		request := httptest.NewRequest(http.MethodPost, "/v2/unknown/manifests/latest", nil)
+		request.Header.Set("Authorization", "Bearer "+archives.Token)
+
+		recorder = httptest.NewRecorder()
+		httpServer.Handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+
+	t.Run(`When Clair v4 is enabled, should scan images with its indexer and matcher APIs`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.clair.v4", false)
+			viper.Set("worker.clair.matcher-address", "")
+			viper.Set("worker.clair.psk-file", "")
+			viper.Set("worker.clair.psk-issuer", "")
+		}()
+
+		pskFile := filepath.Join(t.TempDir(), "psk")
+
+		require.NoError(t, ioutil.WriteFile(pskFile, []byte("c2VjcmV0\n"), 0600))
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.clair.address", "http://clair-indexer:6060")
+		viper.Set("worker.clair.v4", true)
+		viper.Set("worker.clair.matcher-address", "http://clair-matcher:6060")
+		viper.Set("worker.clair.psk-file", pskFile)
+		viper.Set("worker.clair.psk-issuer", "cst")
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 1)
+
+		if assert.IsType(t, &scan.ClairV4{}, scanners[0]) {
+			clair := scanners[0].(*scan.ClairV4)
+
+			assert.Equal(t, "http://clair-indexer:6060", clair.Address)
+			assert.Equal(t, "http://clair-matcher:6060", clair.MatcherAddress)
+			assert.Equal(t, []byte("secret"), clair.PSK)
+			assert.Equal(t, "cst", clair.Issuer)
+		}
+	})
+
+	t.Run(`When secret scanner is enabled, should scan images with it too`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.secrets.enabled", false)
+		}()
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.secrets.enabled", true)
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 2)
+		assert.IsType(t, &scan.SecretScanner{}, scanners[1])
+	})
+
+	t.Run(`When config audit is enabled, should scan images with it too, skipping the disabled rules`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.config-audit.enabled", false)
+			viper.Set("worker.config-audit.disabled", []string{})
+		}()
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.config-audit.enabled", true)
+		viper.Set("worker.config-audit.disabled", []string{"healthcheck-missing"})
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 2)
+
+		if assert.IsType(t, &scan.ConfigAuditScanner{}, scanners[1]) {
+			assert.Equal(t, []string{"healthcheck-missing"}, scanners[1].(*scan.ConfigAuditScanner).Disabled)
+		}
+	})
+
+	t.Run(`When license scanner is enabled, should scan images with it too, denying the given licenses`, func(t *testing.T) {
+		defer func() {
+			viper.Set("worker.licenses.enabled", false)
+			viper.Set("worker.licenses.deny", []string{})
+		}()
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.licenses.enabled", true)
+		viper.Set("worker.licenses.deny", []string{"AGPL", "SSPL-1.0"})
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 2)
+
+		if assert.IsType(t, &scan.LicenseScanner{}, scanners[1]) {
+			assert.Equal(t, []string{"AGPL", "SSPL-1.0"}, scanners[1].(*scan.LicenseScanner).Deny)
+		}
+	})
+
+	t.Run(`When OSV database is assigned, should scan images' dependencies against it too`, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "osv")
+		require.NoError(t, err)
+
+		defer func() {
+			os.RemoveAll(dir)
+			viper.Set("worker.osv.database", "")
+		}()
+
+		advisory := `{"id":"GHSA-jf85-cpcp-j695","affected":[{"package":{"ecosystem":"npm","name":"lodash"},"versions":["4.17.11"]}]}`
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "GHSA-jf85-cpcp-j695.json"), []byte(advisory), 0644))
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.osv.database", dir)
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 2)
+
+		if assert.IsType(t, &scan.DependencyScanner{}, scanners[1]) {
+			assert.Equal(t, 1, scanners[1].(*scan.DependencyScanner).Database.Len())
+		}
+	})
+
+	t.Run(`When scanner plugins are declared, should scan images with them too`, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "plugins")
+		require.NoError(t, err)
+
+		defer func() {
+			os.RemoveAll(dir)
+			viper.Set("worker.plugins.config", "")
+		}()
+
+		config := "scanners:\n- name: acme\n  command: acme-scanner\n- name: in-house\n  command: in-house-scanner\n"
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plugins.yml"), []byte(config), 0644))
+
+		newQueue = func(string) (queue.Queue, error) {
+			return nil, nil
+		}
+
+		newStorage = func(string) (*mongodb.MongoDB, error) {
+			return nil, nil
+		}
+
+		viper.Set("worker.database", "mongodb://localhost/")
+		viper.Set("worker.plugins.config", filepath.Join(dir, "plugins.yml"))
+
+		workerCommandPreRun(nil, []string{})
+
+		scanners := scanTask.(*worker.ScanTask).Scanners
+
+		require.Len(t, scanners, 3)
+
+		for index, name := range []string{"acme", "in-house"} {
+			if assert.IsType(t, &scan.ExecScanner{}, scanners[index+1]) {
+				plugin := scanners[index+1].(*scan.ExecScanner)
+
+				assert.Equal(t, name, plugin.Name)
+				assert.NotNil(t, plugin.Source)
+			}
+		}
+	})
 }
 
 func TestWorkerCommandRun(t *testing.T) {
-	t.Run(`Ensure queue.ProcessLoop is called, when receive a INT singnal should calls storage.Close`, func(t *testing.T) {
-		hasCalledProcessLoop := false
+	t.Run(`Ensure queue.Consume is called, when receive a INT singnal should calls storage.Close`, func(t *testing.T) {
+		hasCalledConsume := false
 		hasCalledStorageClose := false
 
 		q := queue.MockQueue{
-			MockProcessLoop: func() {
-				hasCalledProcessLoop = true
+			MockConsume: func(queue.Task) {
+				hasCalledConsume = true
 			},
 		}
 
@@ -66,7 +339,7 @@ func TestWorkerCommandRun(t *testing.T) {
 
 		time.Sleep(time.Second)
 
-		assert.True(t, hasCalledProcessLoop)
+		assert.True(t, hasCalledConsume)
 		assert.False(t, hasCalledStorageClose)
 
 		signalChan <- os.Interrupt
@@ -77,6 +350,58 @@ func TestWorkerCommandRun(t *testing.T) {
 	})
 }
 
+func TestClairPSK(t *testing.T) {
+	t.Run(`When PSK file is assigned, should read the base64 encoded key from it`, func(t *testing.T) {
+		defer viper.Set("worker.clair.psk-file", "")
+
+		pskFile := filepath.Join(t.TempDir(), "psk")
+
+		require.NoError(t, ioutil.WriteFile(pskFile, []byte("c2VjcmV0\n"), 0600))
+
+		viper.Set("worker.clair.psk-file", pskFile)
+
+		psk, err := clairPSK()
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), psk)
+	})
+
+	t.Run(`When PSK file isn't assigned, should read the key from CST_CLAIR_PSK env`, func(t *testing.T) {
+		New()
+
+		t.Setenv("CST_CLAIR_PSK", "c2VjcmV0")
+
+		psk, err := clairPSK()
+
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret"), psk)
+	})
+
+	t.Run(`When neither PSK file nor env are assigned, should return an empty key`, func(t *testing.T) {
+		psk, err := clairPSK()
+
+		require.NoError(t, err)
+		assert.Empty(t, psk)
+	})
+
+	t.Run(`When PSK file can't be read or isn't base64 encoded, should return an error`, func(t *testing.T) {
+		defer viper.Set("worker.clair.psk-file", "")
+
+		dir := t.TempDir()
+		pskFile := filepath.Join(dir, "psk")
+
+		require.NoError(t, ioutil.WriteFile(pskFile, []byte("not base64!"), 0600))
+
+		for _, file := range []string{filepath.Join(dir, "missing"), pskFile} {
+			viper.Set("worker.clair.psk-file", file)
+
+			_, err := clairPSK()
+
+			assert.Error(t, err, file)
+		}
+	})
+}
+
 func TestNew(t *testing.T) {
 	t.Run(`When required args are not assigned, should retuns a error`, func(t *testing.T) {
 		errorArgs := [][]string{
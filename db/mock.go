@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"time"
 
 	"github.com/tsuru/cst/scan"
@@ -8,20 +9,36 @@ import (
 
 // MockStorage implements a Storage interface for testing purposes.
 type MockStorage struct {
-	MockAppendResultToScanByID  func(string, scan.Result) error
-	MockClose                   func()
-	MockGetScansByImage         func(string) ([]scan.Scan, error)
-	MockHasScheduledScanByImage func(string) bool
-	MockSave                    func(scan.Scan) error
-	MockUpdateScanByID          func(string, scan.Status, *time.Time) error
-	MockPing                    func() bool
+	MockAppendResultToScanByID       func(string, string, scan.Result) error
+	MockClose                        func()
+	MockExpireScanLeaseByID          func(string, time.Time) error
+	MockGetBatchByID                 func(string) (scan.Batch, error)
+	MockGetFindingsByCVE             func(string) ([]scan.Finding, error)
+	MockGetImages                    func(ImageQuery) ([]scan.Image, error)
+	MockGetLatestScansByRepositories func([]string) ([]scan.Scan, error)
+	MockGetLayerFindings             func(string, string, []string, time.Time) ([]scan.LayerFindings, error)
+	MockGetScanByID                  func(string) (scan.Scan, error)
+	MockGetScansByIDs                func([]string) ([]scan.Scan, error)
+	MockGetScansByImage              func(string) ([]scan.Scan, error)
+	MockGetScansByStatus             func(scan.Status) ([]scan.Scan, error)
+	MockGetScansWithExpiredLease     func(time.Time) ([]scan.Scan, error)
+	MockHasScheduledScanByImage      func(string) bool
+	MockLeaseScanByID                func(string, int, scan.Lease) error
+	MockRescheduleScanByID           func(string, int) error
+	MockSave                         func(scan.Scan) error
+	MockSaveBatch                    func(scan.Batch) error
+	MockSaveLayerFindings            func([]scan.LayerFindings) error
+	MockStartScanByID                func(string, int, *scan.Lease) error
+	MockUpdateScanByID               func(string, scan.Status, *time.Time) error
+	MockPing                         func() bool
+	MockWatchScanEvents              func(context.Context, EventFilter) (<-chan scan.Event, error)
 }
 
 // AppendResultToScanByID is a mock implementation for testing purposes.
-func (ms *MockStorage) AppendResultToScanByID(id string, result scan.Result) error {
+func (ms *MockStorage) AppendResultToScanByID(id, worker string, result scan.Result) error {
 
 	if ms.MockAppendResultToScanByID != nil {
-		return ms.MockAppendResultToScanByID(id, result)
+		return ms.MockAppendResultToScanByID(id, worker, result)
 	}
 
 	return nil
@@ -35,6 +52,86 @@ func (ms *MockStorage) Close() {
 	}
 }
 
+// ExpireScanLeaseByID is a mock implementation for testing purposes.
+func (ms *MockStorage) ExpireScanLeaseByID(id string, now time.Time) error {
+
+	if ms.MockExpireScanLeaseByID != nil {
+		return ms.MockExpireScanLeaseByID(id, now)
+	}
+
+	return nil
+}
+
+// GetBatchByID is a mock implementation for testing purposes.
+func (ms *MockStorage) GetBatchByID(id string) (scan.Batch, error) {
+
+	if ms.MockGetBatchByID != nil {
+		return ms.MockGetBatchByID(id)
+	}
+
+	return scan.Batch{}, nil
+}
+
+// GetFindingsByCVE is a mock implementation for testing purposes.
+func (ms *MockStorage) GetFindingsByCVE(cve string) ([]scan.Finding, error) {
+
+	if ms.MockGetFindingsByCVE != nil {
+		return ms.MockGetFindingsByCVE(cve)
+	}
+
+	return nil, nil
+}
+
+// GetImages is a mock implementation for testing purposes.
+func (ms *MockStorage) GetImages(query ImageQuery) ([]scan.Image, error) {
+
+	if ms.MockGetImages != nil {
+		return ms.MockGetImages(query)
+	}
+
+	return nil, nil
+}
+
+// GetLatestScansByRepositories is a mock implementation for testing purposes.
+func (ms *MockStorage) GetLatestScansByRepositories(repositories []string) ([]scan.Scan, error) {
+
+	if ms.MockGetLatestScansByRepositories != nil {
+		return ms.MockGetLatestScansByRepositories(repositories)
+	}
+
+	return nil, nil
+}
+
+// GetLayerFindings is a mock implementation for testing purposes.
+func (ms *MockStorage) GetLayerFindings(scanner, dbVersion string, names []string, now time.Time) ([]scan.LayerFindings, error) {
+
+	if ms.MockGetLayerFindings != nil {
+		return ms.MockGetLayerFindings(scanner, dbVersion, names, now)
+	}
+
+	return nil, nil
+}
+
+// GetScanByID is a mock implementation for testing purposes.
+func (ms *MockStorage) GetScanByID(id string) (scan.Scan, error) {
+
+	if ms.MockGetScanByID != nil {
+		return ms.MockGetScanByID(id)
+	}
+
+	return scan.Scan{}, nil
+}
+
+// GetScansByIDs is a mock implementation for testing purposes.
+func (ms *MockStorage) GetScansByIDs(ids []string) ([]scan.Scan, error) {
+
+	if ms.MockGetScansByIDs != nil {
+		return ms.MockGetScansByIDs(ids)
+	}
+
+	return nil, nil
+}
+
 // GetScansByImage is a mock implementation for testing purposes.
 func (ms *MockStorage) GetScansByImage(image string) ([]scan.Scan, error) {
 
@@ -45,6 +142,26 @@ func (ms *MockStorage) GetScansByImage(image string) ([]scan.Scan, error) {
 	return []scan.Scan{}, nil
 }
 
+// GetScansByStatus is a mock implementation for testing purposes.
+func (ms *MockStorage) GetScansByStatus(status scan.Status) ([]scan.Scan, error) {
+
+	if ms.MockGetScansByStatus != nil {
+		return ms.MockGetScansByStatus(status)
+	}
+
+	return []scan.Scan{}, nil
+}
+
+// GetScansWithExpiredLease is a mock implementation for testing purposes.
+func (ms *MockStorage) GetScansWithExpiredLease(now time.Time) ([]scan.Scan, error) {
+
+	if ms.MockGetScansWithExpiredLease != nil {
+		return ms.MockGetScansWithExpiredLease(now)
+	}
+
+	return []scan.Scan{}, nil
+}
+
 // HasScheduledScanByImage is a mock implementation for testing purposes.
 func (ms *MockStorage) HasScheduledScanByImage(image string) bool {
 
@@ -55,6 +172,26 @@ func (ms *MockStorage) HasScheduledScanByImage(image string) bool {
 	return false
 }
 
+// LeaseScanByID is a mock implementation for testing purposes.
+func (ms *MockStorage) LeaseScanByID(id string, attempt int, lease scan.Lease) error {
+
+	if ms.MockLeaseScanByID != nil {
+		return ms.MockLeaseScanByID(id, attempt, lease)
+	}
+
+	return nil
+}
+
+// RescheduleScanByID is a mock implementation for testing purposes.
+func (ms *MockStorage) RescheduleScanByID(id string, attempts int) error {
+
+	if ms.MockRescheduleScanByID != nil {
+		return ms.MockRescheduleScanByID(id, attempts)
+	}
+
+	return nil
+}
+
 // Save is a mock implementation for testing purposes.
 func (ms *MockStorage) Save(s scan.Scan) error {
 
@@ -65,6 +202,36 @@ func (ms *MockStorage) Save(s scan.Scan) error {
 	return nil
 }
 
+// SaveBatch is a mock implementation for testing purposes.
+func (ms *MockStorage) SaveBatch(batch scan.Batch) error {
+
+	if ms.MockSaveBatch != nil {
+		return ms.MockSaveBatch(batch)
+	}
+
+	return nil
+}
+
+// SaveLayerFindings is a mock implementation for testing purposes.
+func (ms *MockStorage) SaveLayerFindings(layers []scan.LayerFindings) error {
+
+	if ms.MockSaveLayerFindings != nil {
+		return ms.MockSaveLayerFindings(layers)
+	}
+
+	return nil
+}
+
+// StartScanByID is a mock implementation for testing purposes.
+func (ms *MockStorage) StartScanByID(id string, attempt int, lease *scan.Lease) error {
+
+	if ms.MockStartScanByID != nil {
+		return ms.MockStartScanByID(id, attempt, lease)
+	}
+
+	return nil
+}
+
 // UpdateScanByID is a mock implementation for testing purposes.
 func (ms *MockStorage) UpdateScanByID(id string, status scan.Status, finishedAt *time.Time) error {
 	if ms.MockUpdateScanByID != nil {
@@ -83,3 +250,13 @@ func (ms *MockStorage) Ping() bool {
 
 	return false
 }
+
+// WatchScanEvents is a mock implementation for testing purposes.
+func (ms *MockStorage) WatchScanEvents(ctx context.Context, filter EventFilter) (<-chan scan.Event, error) {
+
+	if ms.MockWatchScanEvents != nil {
+		return ms.MockWatchScanEvents(ctx, filter)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,179 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// eventCollectionSize is the size (in bytes) of the capped collection
+	// which holds scan events. The oldest events are discarded when it's full.
+	eventCollectionSize = 16 * 1024 * 1024
+
+	// eventTailTimeout is how long a watcher waits for new events before
+	// checking whether it was stopped.
+	eventTailTimeout = time.Second
+
+	// errCodeNamespaceExists is the MongoDB error code returned when creating
+	// a collection that already exists.
+	errCodeNamespaceExists = 48
+)
+
+// WatchScanEvents streams the scan events matching filter that happen from
+// now on, tailing the capped events collection. The returned channel is
+// closed when ctx is done or the events collection can't be read anymore.
+func (mongo *MongoDB) WatchScanEvents(ctx context.Context, filter db.EventFilter) (<-chan scan.Event, error) {
+
+	collection := mongo.getEventCollection()
+
+	// events published from now on are the ones after the last event stored.
+	// Their identifiers are generated by MongoDB on insertion, so (unlike
+	// the times set by publishers) they follow the collection's order.
+	var last eventDocument
+
+	err := collection.Find(nil).Sort("-$natural").Select(bson.M{"_id": 1}).One(&last)
+
+	if err != nil && err != mgo.ErrNotFound {
+		collection.Database.Session.Close()
+
+		return nil, err
+	}
+
+	selector := bson.M{}
+
+	if filter.ScanID != "" {
+		selector["scanID"] = filter.ScanID
+	}
+
+	if filter.Image != "" {
+		selector["image"] = filter.Image
+	}
+
+	events := make(chan scan.Event)
+
+	go func() {
+		defer collection.Database.Session.Close()
+		defer close(events)
+
+		lastID := last.ID
+
+		for ctx.Err() == nil {
+			if lastID != nil {
+				selector["_id"] = bson.M{"$gt": lastID}
+			}
+
+			iter := collection.Find(selector).Sort("$natural").Tail(eventTailTimeout)
+
+			lastID = tailEvents(ctx, iter, events, lastID)
+
+			if err := iter.Close(); err != nil {
+				return
+			}
+
+			// the cursor dies when there's no event matching the selector
+			// yet, so wait a bit before querying again
+			select {
+			case <-ctx.Done():
+			case <-time.After(eventTailTimeout):
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// tailEvents sends the events read from iter until ctx is done or the cursor
+// dies, returning the identifier of the last event read.
+func tailEvents(ctx context.Context, iter *mgo.Iter, events chan<- scan.Event, lastID interface{}) interface{} {
+
+	for {
+		var document eventDocument
+
+		for iter.Next(&document) {
+			lastID = document.ID
+
+			select {
+			case events <- document.Event:
+			case <-ctx.Done():
+				return lastID
+			}
+
+			document = eventDocument{}
+		}
+
+		if !iter.Timeout() || ctx.Err() != nil {
+			return lastID
+		}
+	}
+}
+
+// publishScanEvent records a scan event on the events collection, looking up
+// the scan's image when the event doesn't have it. Errors are only logged:
+// events are published after the scan was written, which mustn't fail because
+// watchers couldn't be notified.
+func publishScanEvent(database *mgo.Database, event scan.Event) {
+
+	log := logrus.
+		WithField("scan.id", event.ScanID).
+		WithField("event.type", event.Type)
+
+	if event.Image == "" {
+		var s scan.Scan
+
+		err := database.C("scans").FindId(event.ScanID).Select(bson.M{"image": 1}).One(&s)
+
+		if err != nil {
+			log.WithError(err).Error("could not find scan's image to publish its event")
+
+			return
+		}
+
+		event.Image = s.Image
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if err := database.C("events").Insert(event); err != nil {
+		log.WithError(err).Error("could not publish scan event")
+	}
+}
+
+// eventDocument adds the identifier generated by MongoDB to the stored events.
+type eventDocument struct {
+	ID         interface{} `bson:"_id"`
+	scan.Event `bson:",inline"`
+}
+
+func (mongo *MongoDB) getEventCollection() *mgo.Collection {
+
+	session := mongo.session.Copy()
+
+	return session.DB("").C("events")
+}
+
+// ensureEventCollection creates the events collection as a capped one, so it
+// can be tailed by watchers.
+func (mongo *MongoDB) ensureEventCollection() error {
+
+	collection := mongo.getEventCollection()
+	defer collection.Database.Session.Close()
+
+	err := collection.Create(&mgo.CollectionInfo{
+		Capped:   true,
+		MaxBytes: eventCollectionSize,
+	})
+
+	if queryErr, ok := err.(*mgo.QueryError); ok && queryErr.Code == errCodeNamespaceExists {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,124 @@
+package mongodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestMongoDB_WatchScanEvents(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure changes on the watched scan are streamed in order`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		imageColl := mongo.getImageCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			imageColl.DropCollection()
+			scanColl.Database.Session.Close()
+			imageColl.Database.Session.Close()
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		events, err := mongo.WatchScanEvents(ctx, db.EventFilter{ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"})
+
+		require.NoError(t, err)
+
+		now := time.Now()
+
+		require.NoError(t, mongo.Save(scan.Scan{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Image: "tsuru/api:latest", Status: scan.StatusScheduled}))
+		require.NoError(t, mongo.Save(scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: "tsuru/cst:latest", Status: scan.StatusScheduled}))
+		require.NoError(t, mongo.UpdateScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", scan.StatusRunning, nil))
+		require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{Scanner: "clair"}))
+		require.NoError(t, mongo.UpdateScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", scan.StatusFinished, &now))
+
+		var got []scan.Event
+
+		for len(got) < 4 {
+			select {
+			case event := <-events:
+				got = append(got, event)
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "timed out waiting for scan events")
+			}
+		}
+
+		assert.Equal(t, scan.StatusScheduled, got[0].Status)
+		assert.Equal(t, "tsuru/cst:latest", got[0].Image)
+		assert.Equal(t, scan.StatusRunning, got[1].Status)
+		assert.Equal(t, "tsuru/cst:latest", got[1].Image)
+		assert.Equal(t, scan.EventResultAppended, got[2].Type)
+		assert.Equal(t, "clair", got[2].Scanner)
+		assert.Equal(t, scan.StatusFinished, got[3].Status)
+		assert.True(t, got[3].IsFinal())
+
+		cancel()
+
+		for range events {
+		}
+	})
+
+	t.Run(`When events were published before watching, should not stream them even if their time is ahead`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		imageColl := mongo.getImageCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			imageColl.DropCollection()
+			scanColl.Database.Session.Close()
+			imageColl.Database.Session.Close()
+		}()
+
+		// a publisher whose clock is ahead of this one
+		publishScanEvent(scanColl.Database, scan.Event{
+			Type:   scan.EventStatusChanged,
+			ScanID: "a4e5bd6c-0aa1-4c5b-9a46-3d1f1f5b2f8e",
+			Image:  "tsuru/cst:latest",
+			Status: scan.StatusScheduled,
+			Time:   time.Now().Add(time.Hour),
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		events, err := mongo.WatchScanEvents(ctx, db.EventFilter{ScanID: "a4e5bd6c-0aa1-4c5b-9a46-3d1f1f5b2f8e"})
+
+		require.NoError(t, err)
+
+		require.NoError(t, mongo.Save(scan.Scan{ID: "a4e5bd6c-0aa1-4c5b-9a46-3d1f1f5b2f8e", Image: "tsuru/cst:latest", Status: scan.StatusScheduled}))
+		require.NoError(t, mongo.UpdateScanByID("a4e5bd6c-0aa1-4c5b-9a46-3d1f1f5b2f8e", scan.StatusRunning, nil))
+
+		var got []scan.Event
+
+		for len(got) < 2 {
+			select {
+			case event := <-events:
+				got = append(got, event)
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "timed out waiting for scan events")
+			}
+		}
+
+		assert.Equal(t, scan.StatusScheduled, got[0].Status)
+		assert.WithinDuration(t, time.Now(), got[0].Time, time.Minute)
+		assert.Equal(t, scan.StatusRunning, got[1].Status)
+
+		cancel()
+
+		for range events {
+		}
+	})
+}
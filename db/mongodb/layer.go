@@ -0,0 +1,71 @@
+package mongodb
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/tsuru/cst/scan"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GetLayerFindings returns the cached findings of layers (by their chain
+// names) analyzed by scanner on a given vulnerability database version, which
+// haven't expired by now.
+func (mongo *MongoDB) GetLayerFindings(scanner, dbVersion string, names []string, now time.Time) ([]scan.LayerFindings, error) {
+
+	collection := mongo.getLayerCollection()
+	defer release(collection, "get_layer_findings", time.Now())
+
+	var layers []scan.LayerFindings
+
+	err := collection.Find(bson.M{
+		"scanner":   scanner,
+		"dbVersion": dbVersion,
+		"name":      bson.M{"$in": names},
+		"expiresAt": bson.M{"$gt": now},
+	}).All(&layers)
+
+	return layers, err
+}
+
+// SaveLayerFindings inserts or replaces the cached findings of layers, one
+// entry per scanner and layer chain name.
+func (mongo *MongoDB) SaveLayerFindings(layers []scan.LayerFindings) error {
+
+	if len(layers) == 0 {
+		return nil
+	}
+
+	collection := mongo.getLayerCollection()
+	defer release(collection, "save_layer_findings", time.Now())
+
+	bulk := collection.Bulk()
+	bulk.Unordered()
+
+	for _, layer := range layers {
+		bulk.Upsert(bson.M{"_id": layer.Scanner + "|" + layer.Name}, layer)
+	}
+
+	_, err := bulk.Run()
+
+	return err
+}
+
+// ensureLayerIndexes lets MongoDB discard the expired layers.
+func (mongo *MongoDB) ensureLayerIndexes() error {
+
+	collection := mongo.getLayerCollection()
+	defer collection.Database.Session.Close()
+
+	return collection.EnsureIndex(mgo.Index{
+		Key:         []string{"expiresAt"},
+		ExpireAfter: time.Second,
+	})
+}
+
+func (mongo *MongoDB) getLayerCollection() *mgo.Collection {
+
+	session := mongo.session.Copy()
+
+	return session.DB("").C("layers")
+}
@@ -0,0 +1,60 @@
+package mongodb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestMongoDB_LayerFindings(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	collection := mongo.getLayerCollection()
+
+	defer func() {
+		collection.DropCollection()
+		collection.Database.Session.Close()
+	}()
+
+	now := time.Now()
+
+	layers := []scan.LayerFindings{
+		{Name: "sha256:base", Scanner: "clair", Findings: []scan.Finding{{CVE: "CVE-2018-1000001", Package: "glibc"}}, ExpiresAt: now.Add(time.Hour)},
+		{Name: "sha256:app", Scanner: "clair", Findings: []scan.Finding{}, ExpiresAt: now.Add(time.Hour)},
+		{Name: "sha256:old", Scanner: "clair", ExpiresAt: now.Add(-time.Hour)},
+	}
+
+	require.NoError(t, mongo.SaveLayerFindings(layers))
+
+	t.Run(`Ensure only the unexpired layers of that scanner and database version are returned`, func(t *testing.T) {
+		got, err := mongo.GetLayerFindings("clair", "", []string{"sha256:base", "sha256:app", "sha256:old", "sha256:unknown"}, now)
+
+		require.NoError(t, err)
+		assert.Len(t, got, 2)
+
+		got, err = mongo.GetLayerFindings("clair", "v2", []string{"sha256:base"}, now)
+
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run(`Ensure layers are replaced when saved again`, func(t *testing.T) {
+		require.NoError(t, mongo.SaveLayerFindings([]scan.LayerFindings{
+			{Name: "sha256:base", Scanner: "clair", Findings: []scan.Finding{}, ExpiresAt: now.Add(time.Hour)},
+		}))
+
+		got, err := mongo.GetLayerFindings("clair", "", []string{"sha256:base"}, now)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Empty(t, got[0].Findings)
+	})
+}
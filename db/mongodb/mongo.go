@@ -1,9 +1,14 @@
 package mongodb
 
 import (
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/metrics"
 	"github.com/tsuru/cst/scan"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -14,15 +19,43 @@ type MongoDB struct {
 }
 
 // Save inserts or updates (if scan.ID already exists on current collection)
-// a scan document on MongoDB service.
-func (mongo *MongoDB) Save(scan scan.Scan) error {
+// a scan document on MongoDB service. The scan's image is added to the image
+// inventory, if it isn't there yet.
+//
+// Like the other methods changing a scan's status or results, it publishes a
+// scan event to the watchers (see WatchScanEvents). Failing to publish it is
+// logged, not returned, since the scan was written anyway.
+func (mongo *MongoDB) Save(s scan.Scan) error {
 
 	collection := mongo.getScanCollection()
-	defer collection.Database.Session.Close()
+	defer release(collection, "save", time.Now())
 
-	_, err := collection.UpsertId(scan.ID, scan)
+	if _, err := collection.UpsertId(s.ID, s); err != nil {
+		return err
+	}
 
-	return err
+	repository, tag := scan.SplitImageName(s.Image)
+
+	_, err := collection.Database.C("images").UpsertId(s.Image, bson.M{
+		"$setOnInsert": bson.M{
+			"repository": repository,
+			"tag":        tag,
+			"severities": scan.SeverityCounts{},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	publishScanEvent(collection.Database, scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: s.ID,
+		Image:  s.Image,
+		Status: s.Status,
+	})
+
+	return nil
 }
 
 // HasScheduledScanByImage checks if exists scan documents given image (by
@@ -31,7 +64,7 @@ func (mongo *MongoDB) Save(scan scan.Scan) error {
 func (mongo *MongoDB) HasScheduledScanByImage(image string) bool {
 
 	collection := mongo.getScanCollection()
-	defer collection.Database.Session.Close()
+	defer release(collection, "has_scheduled_scan_by_image", time.Now())
 
 	scanFilter := scan.Scan{
 		Image:  image,
@@ -48,32 +81,189 @@ func (mongo *MongoDB) Close() {
 	mongo.session.Close()
 }
 
-// AppendResultToScanByID append the result on scan on MongoDB service.
-func (mongo *MongoDB) AppendResultToScanByID(id string, result scan.Result) error {
+// AppendResultToScanByID append the result on scan on MongoDB service. The
+// findings of a successful result replace the ones previously indexed for the
+// same image, scanner and platform, so the findings index always reflects the
+// latest analysis of each image. Returns db.ErrLeaseLost when the scan is leased by
+// another worker, so results of a reaped attempt don't mix with the next one.
+func (mongo *MongoDB) AppendResultToScanByID(id, worker string, result scan.Result) error {
 
 	collection := mongo.getScanCollection()
-	defer collection.Database.Session.Close()
+	defer release(collection, "append_result_to_scan_by_id", time.Now())
+
+	selector := bson.M{
+		"_id": id,
+		"$or": []bson.M{
+			bson.M{"lease": bson.M{"$exists": false}},
+			bson.M{"lease.worker": worker},
+		},
+	}
 
-	return collection.UpdateId(id, bson.M{"$push": bson.M{"result": result}})
+	err := collection.Update(selector, bson.M{"$push": bson.M{"result": result}})
+
+	if err == mgo.ErrNotFound {
+		return db.ErrLeaseLost
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var s scan.Scan
+
+	// the result is already stored, so failing to index its findings or to
+	// publish its event doesn't fail the append
+	if err := collection.FindId(id).Select(bson.M{"image": 1}).One(&s); err != nil {
+		logrus.WithError(err).
+			WithField("scan.id", id).
+			Error("could not find scan's image to index its findings")
+
+		return nil
+	}
+
+	if result.Error == nil {
+		if err := indexFindings(collection.Database.C("findings"), s.Image, id, result); err != nil {
+			logrus.WithError(err).
+				WithField("scan.id", id).
+				WithField("scanner", result.Scanner).
+				Error("could not index findings of scan")
+		}
+	}
+
+	publishScanEvent(collection.Database, scan.Event{
+		Type:    scan.EventResultAppended,
+		ScanID:  id,
+		Image:   s.Image,
+		Scanner: result.Scanner,
+		Error:   result.Error,
+	})
+
+	return nil
+}
+
+// GetFindingsByCVE returns the latest findings of a given CVE (e.g.
+// CVE-2018-0732) across all images, ordered by image and package.
+func (mongo *MongoDB) GetFindingsByCVE(cve string) ([]scan.Finding, error) {
+
+	collection := mongo.getFindingCollection()
+	defer release(collection, "get_findings_by_cve", time.Now())
+
+	var findings []scan.Finding
+
+	err := collection.Find(bson.M{"cve": cve}).Sort("image", "package").All(&findings)
+
+	return findings, err
 }
 
 // UpdateScanByID updates status and finishedAt scan fields on MongoDB service.
+// When the scan completes (finished or partial status), it also becomes the
+// summary of its image on the image inventory.
 func (mongo *MongoDB) UpdateScanByID(id string, status scan.Status, finishedAt *time.Time) error {
 	collection := mongo.getScanCollection()
-	defer collection.Database.Session.Close()
+	defer release(collection, "update_scan_by_id", time.Now())
 
 	data := bson.M{"status": status}
 	if finishedAt != nil {
 		data["finishedAt"] = *finishedAt
 	}
-	return collection.UpdateId(id, bson.M{"$set": data})
+
+	if err := collection.UpdateId(id, bson.M{"$set": data}); err != nil {
+		return err
+	}
+
+	event := scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: id,
+		Status: status,
+	}
+
+	if status == scan.StatusFinished || status == scan.StatusPartial {
+		var s scan.Scan
+
+		if err := collection.FindId(id).One(&s); err != nil {
+			return err
+		}
+
+		image := scan.NewImageSummary(s)
+
+		if _, err := collection.Database.C("images").UpsertId(image.Name, image); err != nil {
+			return err
+		}
+
+		event.Image = s.Image
+	}
+
+	publishScanEvent(collection.Database, event)
+
+	return nil
+}
+
+// GetImages returns the image inventory, filtered and sorted by query.
+func (mongo *MongoDB) GetImages(query db.ImageQuery) ([]scan.Image, error) {
+
+	collection := mongo.getImageCollection()
+	defer release(collection, "get_images", time.Now())
+
+	selector := imageSelector(query)
+
+	sortField := imageSortField(query.SortBy)
+
+	if query.Descending {
+		sortField = "-" + sortField
+	}
+
+	var images []scan.Image
+
+	err := collection.Find(selector).
+		Sort(sortField, "_id").
+		Skip(query.Skip).
+		Limit(query.Limit).
+		All(&images)
+
+	return images, err
+}
+
+// GetLatestScansByRepositories returns the latest scan, with its results, of
+// each image (i.e. tag) of the given repositories (e.g.
+// "docker.io/library/debian").
+func (mongo *MongoDB) GetLatestScansByRepositories(repositories []string) ([]scan.Scan, error) {
+
+	collection := mongo.getImageCollection()
+	defer release(collection, "get_latest_scans_by_repositories", time.Now())
+
+	var images []scan.Image
+
+	err := collection.Find(bson.M{
+		"repository": bson.M{"$in": repositories},
+		"lastScanID": bson.M{"$exists": true},
+	}).Select(bson.M{"lastScanID": 1}).All(&images)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(images))
+
+	for _, image := range images {
+		ids = append(ids, image.LastScanID)
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var scans []scan.Scan
+
+	err = collection.Database.C("scans").Find(bson.M{"_id": bson.M{"$in": ids}}).All(&scans)
+
+	return scans, err
 }
 
 // GetScansByImage returns the list of scans that match a given image name.
 func (mongo *MongoDB) GetScansByImage(image string) ([]scan.Scan, error) {
 
 	collection := mongo.getScanCollection()
-	defer collection.Database.Session.Close()
+	defer release(collection, "get_scans_by_image", time.Now())
 
 	var scans []scan.Scan
 
@@ -82,13 +272,369 @@ func (mongo *MongoDB) GetScansByImage(image string) ([]scan.Scan, error) {
 	return scans, err
 }
 
+// GetScanByID returns the scan identified by id. Returns db.ErrScanNotFound
+// when there is no such scan.
+func (mongo *MongoDB) GetScanByID(id string) (scan.Scan, error) {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "get_scan_by_id", time.Now())
+
+	var s scan.Scan
+
+	err := collection.FindId(id).One(&s)
+
+	if err == mgo.ErrNotFound {
+		return scan.Scan{}, db.ErrScanNotFound
+	}
+
+	return s, err
+}
+
+// GetScansByIDs returns the scans identified by ids, without their results.
+func (mongo *MongoDB) GetScansByIDs(ids []string) ([]scan.Scan, error) {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "get_scans_by_ids", time.Now())
+
+	var scans []scan.Scan
+
+	err := collection.Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"result": 0}).All(&scans)
+
+	return scans, err
+}
+
+// SaveBatch inserts or updates a batch of scans on MongoDB service.
+func (mongo *MongoDB) SaveBatch(batch scan.Batch) error {
+
+	collection := mongo.getBatchCollection()
+	defer release(collection, "save_batch", time.Now())
+
+	_, err := collection.UpsertId(batch.ID, batch)
+
+	return err
+}
+
+// GetBatchByID returns the batch identified by id. Returns db.ErrBatchNotFound
+// when there is no such batch.
+func (mongo *MongoDB) GetBatchByID(id string) (scan.Batch, error) {
+
+	collection := mongo.getBatchCollection()
+	defer release(collection, "get_batch_by_id", time.Now())
+
+	var batch scan.Batch
+
+	err := collection.FindId(id).One(&batch)
+
+	if err == mgo.ErrNotFound {
+		return scan.Batch{}, db.ErrBatchNotFound
+	}
+
+	return batch, err
+}
+
+// GetScansByStatus returns the list of scans that are in a given status.
+func (mongo *MongoDB) GetScansByStatus(status scan.Status) ([]scan.Scan, error) {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "get_scans_by_status", time.Now())
+
+	var scans []scan.Scan
+
+	err := collection.Find(bson.M{"status": status}).All(&scans)
+
+	return scans, err
+}
+
+// RescheduleScanByID brings a scan back to the scheduled status, discarding
+// its previous results and recording how many attempts were made.
+func (mongo *MongoDB) RescheduleScanByID(id string, attempts int) error {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "reschedule_scan_by_id", time.Now())
+
+	err := collection.UpdateId(id, bson.M{
+		"$set": bson.M{
+			"status":   scan.StatusScheduled,
+			"attempts": attempts,
+			"result":   []scan.Result{},
+		},
+		"$unset": bson.M{"finishedAt": "", "lease": ""},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	publishScanEvent(collection.Database, scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: id,
+		Status: scan.StatusScheduled,
+	})
+
+	return nil
+}
+
+// StartScanByID atomically takes a scheduled scan to the running status,
+// recording the current attempt and leasing it to a worker (when lease is
+// assigned). Returns db.ErrScanNotScheduled when the scan isn't scheduled
+// anymore, e.g. it's run by another job or it's done.
+func (mongo *MongoDB) StartScanByID(id string, attempt int, lease *scan.Lease) error {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "start_scan_by_id", time.Now())
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":   scan.StatusRunning,
+			"attempts": attempt,
+		},
+	}
+
+	if lease != nil {
+		update["$set"].(bson.M)["lease"] = *lease
+	} else {
+		update["$unset"] = bson.M{"lease": ""}
+	}
+
+	err := collection.Update(bson.M{"_id": id, "status": scan.StatusScheduled}, update)
+
+	if err == mgo.ErrNotFound {
+		return db.ErrScanNotScheduled
+	}
+
+	if err != nil {
+		return err
+	}
+
+	publishScanEvent(collection.Database, scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: id,
+		Status: scan.StatusRunning,
+	})
+
+	return nil
+}
+
+// LeaseScanByID assigns (or renews) the lease of a running scan to a worker,
+// recording the current attempt. Returns db.ErrLeaseLost when the scan isn't
+// running anymore or it's leased by another worker.
+func (mongo *MongoDB) LeaseScanByID(id string, attempt int, lease scan.Lease) error {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "lease_scan_by_id", time.Now())
+
+	selector := bson.M{
+		"_id":    id,
+		"status": scan.StatusRunning,
+		"$or": []bson.M{
+			bson.M{"lease": bson.M{"$exists": false}},
+			bson.M{"lease.worker": lease.Worker},
+		},
+	}
+
+	err := collection.Update(selector, bson.M{
+		"$set": bson.M{
+			"attempts": attempt,
+			"lease":    lease,
+		},
+	})
+
+	if err == mgo.ErrNotFound {
+		return db.ErrLeaseLost
+	}
+
+	return err
+}
+
+// GetScansWithExpiredLease returns the running scans whose lease expired
+// before a given time.
+func (mongo *MongoDB) GetScansWithExpiredLease(now time.Time) ([]scan.Scan, error) {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "get_scans_with_expired_lease", time.Now())
+
+	var scans []scan.Scan
+
+	err := collection.Find(bson.M{
+		"status":          scan.StatusRunning,
+		"lease.expiresAt": bson.M{"$lt": now},
+	}).All(&scans)
+
+	return scans, err
+}
+
+// ExpireScanLeaseByID atomically takes a running scan whose lease expired
+// before a given time back to the scheduled status, dropping its lease.
+// Returns db.ErrLeaseLost when the lease was renewed or already expired by
+// someone else.
+func (mongo *MongoDB) ExpireScanLeaseByID(id string, now time.Time) error {
+
+	collection := mongo.getScanCollection()
+	defer release(collection, "expire_scan_lease_by_id", time.Now())
+
+	selector := bson.M{
+		"_id":             id,
+		"status":          scan.StatusRunning,
+		"lease.expiresAt": bson.M{"$lt": now},
+	}
+
+	err := collection.Update(selector, bson.M{
+		"$set":   bson.M{"status": scan.StatusScheduled},
+		"$unset": bson.M{"lease": ""},
+	})
+
+	if err == mgo.ErrNotFound {
+		return db.ErrLeaseLost
+	}
+
+	if err != nil {
+		return err
+	}
+
+	publishScanEvent(collection.Database, scan.Event{
+		Type:   scan.EventStatusChanged,
+		ScanID: id,
+		Status: scan.StatusScheduled,
+	})
+
+	return nil
+}
+
 // Ping is a wrapper to the mgo.session.Ping method. It returns true when the
 // ping command was correctly executed on the storage service, otherwise returns
 // false.
 func (mongo *MongoDB) Ping() bool {
+
+	defer observe("ping", time.Now())
+
 	return mongo.session.Ping() == nil
 }
 
+// release closes the session copied to collection and records how long the
+// storage operation took.
+func release(collection *mgo.Collection, operation string, startedAt time.Time) {
+
+	collection.Database.Session.Close()
+
+	observe(operation, startedAt)
+}
+
+func observe(operation string, startedAt time.Time) {
+	metrics.StorageOperationDuration.Observe(time.Since(startedAt).Seconds(), operation)
+}
+
+// indexFindings replaces the findings of image reported by result's scanner on
+// result's platform.
+func indexFindings(collection *mgo.Collection, image, scanID string, result scan.Result) error {
+
+	selector := bson.M{"image": image, "scanner": result.Scanner, "platform": result.Platform}
+
+	// platform is omitted from the findings of images which aren't
+	// multi-platform
+	if result.Platform == "" {
+		selector["platform"] = bson.M{"$in": []interface{}{"", nil}}
+	}
+
+	_, err := collection.RemoveAll(selector)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	documents := make(map[string]interface{})
+
+	for _, finding := range result.Findings {
+		finding.Image = image
+		finding.ScanID = scanID
+		finding.Platform = result.Platform
+		finding.DetectedAt = now
+
+		id := strings.Join([]string{image, result.Platform, finding.Scanner, finding.Key()}, "|")
+
+		documents[id] = findingDocument{ID: id, Finding: finding}
+	}
+
+	if len(documents) == 0 {
+		return nil
+	}
+
+	bulk := collection.Bulk()
+	bulk.Unordered()
+
+	for id, document := range documents {
+		bulk.Upsert(bson.M{"_id": id}, document)
+	}
+
+	_, err = bulk.Run()
+
+	return err
+}
+
+// imageSelector builds the selector of images matching query. Operators are
+// used instead of special types (e.g. bson.RegEx), since sessions don't
+// marshal the types of gopkg.in/mgo.v2/bson package.
+func imageSelector(query db.ImageQuery) bson.M {
+
+	selector := bson.M{}
+
+	if query.Repository != "" {
+		selector["repository"] = bson.M{"$regex": "^" + regexp.QuoteMeta(query.Repository)}
+	}
+
+	if query.Tag != "" {
+		selector["tag"] = query.Tag
+	}
+
+	if query.Severity != "" {
+		selector["severities."+query.Severity] = bson.M{"$gt": 0}
+	}
+
+	return selector
+}
+
+// imageSortField maps the fields on db.ImageSortFields to the image document
+// fields.
+func imageSortField(field string) string {
+
+	switch field {
+	case "", "name":
+		return "_id"
+	case "lastScannedAt":
+		return field
+	default:
+		return "severities." + field
+	}
+}
+
+// findingDocument adds an identifier, unique by image, platform, scanner and
+// finding's key (see scan.Finding.Key), to the stored findings.
+type findingDocument struct {
+	ID           string `bson:"_id"`
+	scan.Finding `bson:",inline"`
+}
+
+func (mongo *MongoDB) getBatchCollection() *mgo.Collection {
+
+	session := mongo.session.Copy()
+
+	return session.DB("").C("batches")
+}
+
+func (mongo *MongoDB) getFindingCollection() *mgo.Collection {
+
+	session := mongo.session.Copy()
+
+	return session.DB("").C("findings")
+}
+
+func (mongo *MongoDB) getImageCollection() *mgo.Collection {
+
+	session := mongo.session.Copy()
+
+	return session.DB("").C("images")
+}
+
 func (mongo *MongoDB) getScanCollection() *mgo.Collection {
 
 	session := mongo.session.Copy()
@@ -106,7 +652,40 @@ func NewMongoDB(rawURL string) (*MongoDB, error) {
 		return nil, err
 	}
 
-	return &MongoDB{
+	mongo := &MongoDB{
 		session: session,
-	}, nil
+	}
+
+	if err := mongo.ensureEventCollection(); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := mongo.ensureIndexes(); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	if err := mongo.ensureLayerIndexes(); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return mongo, nil
+}
+
+func (mongo *MongoDB) ensureIndexes() error {
+
+	collection := mongo.getFindingCollection()
+	defer collection.Database.Session.Close()
+
+	if err := collection.EnsureIndexKey("cve", "image"); err != nil {
+		return err
+	}
+
+	if err := collection.EnsureIndexKey("image", "scanner", "platform"); err != nil {
+		return err
+	}
+
+	return collection.Database.C("images").EnsureIndexKey("repository", "tag")
 }
@@ -2,13 +2,17 @@ package mongodb
 
 import (
 	"os"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/globalsign/mgo"
+	mgobson "github.com/globalsign/mgo/bson"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/scan"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestMongoDB_Save(t *testing.T) {
@@ -167,7 +171,7 @@ func TestMongoDB_AppendResultToScanByID(t *testing.T) {
 
 		assert.Equal(t, 0, len(scanOnStorage.Result))
 
-		err := mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", scan.Result{
+		err := mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{
 			Scanner:         "scanner-example",
 			Vulnerabilities: "all-vulns-described-here",
 		})
@@ -176,6 +180,163 @@ func TestMongoDB_AppendResultToScanByID(t *testing.T) {
 		scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
 		assert.Equal(t, 1, len(scanOnStorage.Result))
 	})
+
+	t.Run(`When scan is leased by another worker, should return db.ErrLeaseLost and keep its results`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{
+			ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Image:  "tsuru/cst:latest",
+			Status: scan.StatusRunning,
+			Lease: &scan.Lease{
+				Worker:    "worker-02_43",
+				ExpiresAt: time.Now().Add(time.Minute),
+			},
+			Result: []scan.Result{},
+		})
+
+		err := mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "worker-01_42", scan.Result{
+			Scanner: "scanner-example",
+		})
+
+		assert.Equal(t, db.ErrLeaseLost, err)
+
+		err = mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "worker-02_43", scan.Result{
+			Scanner: "scanner-example",
+		})
+
+		require.NoError(t, err)
+
+		var scanOnStorage scan.Scan
+
+		scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
+		assert.Equal(t, 1, len(scanOnStorage.Result))
+	})
+}
+
+func TestMongoDB_GetFindingsByCVE(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure only the findings of the latest scan of each image are returned`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		findingColl := mongo.getFindingCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			findingColl.DropCollection()
+			scanColl.Database.Session.Close()
+			findingColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(
+			scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: "tsuru/cst:latest"},
+			scan.Scan{ID: "83633447-353f-4e87-aa95-2a44205eb89e", Image: "tsuru/cst:latest"},
+			scan.Scan{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Image: "tsuru/api:latest"},
+		)
+
+		vulnerable := scan.Result{
+			Scanner: "clair",
+			Findings: []scan.Finding{
+				scan.Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Version: "1.1.0h-r0", FixedBy: "1.1.0i-r0"},
+			},
+		}
+
+		fixed := scan.Result{
+			Scanner: "clair",
+		}
+
+		require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", vulnerable))
+		require.NoError(t, mongo.AppendResultToScanByID("d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", "", vulnerable))
+		require.NoError(t, mongo.AppendResultToScanByID("83633447-353f-4e87-aa95-2a44205eb89e", "", fixed))
+
+		findings, err := mongo.GetFindingsByCVE("CVE-2018-0732")
+
+		require.NoError(t, err)
+		require.Len(t, findings, 1)
+		assert.Equal(t, "tsuru/api:latest", findings[0].Image)
+		assert.Equal(t, "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", findings[0].ScanID)
+		assert.Equal(t, "openssl", findings[0].Package)
+		assert.Equal(t, "1.1.0i-r0", findings[0].FixedBy)
+	})
+
+	t.Run(`Ensure findings of multi-platform images are indexed by platform`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		findingColl := mongo.getFindingCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			findingColl.DropCollection()
+			scanColl.Database.Session.Close()
+			findingColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: "tsuru/cst:latest"})
+
+		for _, platform := range []string{"linux/amd64", "linux/arm64/v8"} {
+			require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{
+				Scanner:  "clair",
+				Platform: platform,
+				Findings: []scan.Finding{
+					scan.Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"},
+				},
+			}))
+		}
+
+		findings, err := mongo.GetFindingsByCVE("CVE-2018-0732")
+
+		require.NoError(t, err)
+		require.Len(t, findings, 2)
+		assert.ElementsMatch(t, []string{"linux/amd64", "linux/arm64/v8"}, []string{findings[0].Platform, findings[1].Platform})
+
+		require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{
+			Scanner:  "clair",
+			Platform: "linux/amd64",
+		}))
+
+		findings, err = mongo.GetFindingsByCVE("CVE-2018-0732")
+
+		require.NoError(t, err)
+		require.Len(t, findings, 1)
+		assert.Equal(t, "linux/arm64/v8", findings[0].Platform)
+	})
+
+	t.Run(`Ensure findings without CVE are indexed by their rule and path`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		findingColl := mongo.getFindingCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			findingColl.DropCollection()
+			scanColl.Database.Session.Close()
+			findingColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: "tsuru/cst:latest"})
+
+		require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{
+			Scanner: "secrets",
+			Findings: []scan.Finding{
+				scan.Finding{Scanner: "secrets", Rule: "aws-access-key", Path: "/app/.env"},
+				scan.Finding{Scanner: "secrets", Rule: "aws-access-key", Path: "/root/.aws/credentials"},
+				scan.Finding{Scanner: "secrets", Rule: "private-key", Path: "/app/.env"},
+			},
+		}))
+
+		count, err := findingColl.Find(bson.M{"image": "tsuru/cst:latest", "scanner": "secrets"}).Count()
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
 }
 
 func TestMongoDB_UpdateScanByID(t *testing.T) {
@@ -240,6 +401,160 @@ func TestMongoDB_UpdateScanByID(t *testing.T) {
 	})
 }
 
+func TestMongoDB_GetImages(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure completed scans summarize their images, filtered and sorted by query`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		imageColl := mongo.getImageCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			imageColl.DropCollection()
+			scanColl.Database.Session.Close()
+			imageColl.Database.Session.Close()
+		}()
+
+		require.NoError(t, mongo.Save(scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: "tsuru/cst:latest"}))
+		require.NoError(t, mongo.Save(scan.Scan{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Image: "tsuru/api:v1"}))
+		require.NoError(t, mongo.Save(scan.Scan{ID: "83633447-353f-4e87-aa95-2a44205eb89e", Image: "nginx:latest"}))
+
+		require.NoError(t, mongo.AppendResultToScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "", scan.Result{
+			Scanner: "clair",
+			Findings: []scan.Finding{
+				scan.Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Severity: "High"},
+			},
+		}))
+
+		require.NoError(t, mongo.AppendResultToScanByID("d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", "", scan.Result{
+			Scanner: "clair",
+			Findings: []scan.Finding{
+				scan.Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Severity: "High"},
+				scan.Finding{CVE: "CVE-2018-14618", Scanner: "clair", Package: "curl", Severity: "Defcon1"},
+			},
+		}))
+
+		now := time.Now()
+
+		require.NoError(t, mongo.UpdateScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", scan.StatusFinished, &now))
+		require.NoError(t, mongo.UpdateScanByID("d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", scan.StatusFinished, &now))
+
+		images, err := mongo.GetImages(db.ImageQuery{Repository: "tsuru/", SortBy: "high", Descending: true})
+
+		require.NoError(t, err)
+		require.Len(t, images, 2)
+		assert.Equal(t, "tsuru/api:v1", images[0].Name)
+		assert.Equal(t, "tsuru/api", images[0].Repository)
+		assert.Equal(t, "v1", images[0].Tag)
+		assert.Equal(t, scan.SeverityCounts{Critical: 1, High: 1}, images[0].Severities)
+		assert.Equal(t, "tsuru/cst:latest", images[1].Name)
+
+		images, err = mongo.GetImages(db.ImageQuery{Tag: "latest"})
+
+		require.NoError(t, err)
+		require.Len(t, images, 2)
+		assert.Equal(t, "nginx:latest", images[0].Name)
+		assert.Empty(t, images[0].LastScanID)
+
+		images, err = mongo.GetImages(db.ImageQuery{Severity: scan.SeverityCritical})
+
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		assert.Equal(t, "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", images[0].LastScanID)
+	})
+}
+
+func TestMongoDB_GetLatestScansByRepositories(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure the latest completed scans of the repositories' images are returned with their results`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+		imageColl := mongo.getImageCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			imageColl.DropCollection()
+			scanColl.Database.Session.Close()
+			imageColl.Database.Session.Close()
+		}()
+
+		ids := map[string]string{
+			"2b935a8f-4241-49f0-a1a2-e3c8ba347b95": "docker.io/library/debian:9",
+			"d29b39eb-a5e5-4237-acb4-e7203cd6e2cf": "docker.io/library/debian:10",
+			"83633447-353f-4e87-aa95-2a44205eb89e": "docker.io/library/debian-extra:10",
+		}
+
+		now := time.Now()
+
+		for id, image := range ids {
+			require.NoError(t, mongo.Save(scan.Scan{ID: id, Image: image}))
+			require.NoError(t, mongo.AppendResultToScanByID(id, "", scan.Result{
+				Scanner: "clair",
+				Layers:  []scan.Layer{{Digest: "sha256:" + id}},
+			}))
+			require.NoError(t, mongo.UpdateScanByID(id, scan.StatusFinished, &now))
+		}
+
+		// running scans aren't the latest completed one yet
+		require.NoError(t, mongo.Save(scan.Scan{ID: "5f8e2a1c-9d4b-4c3e-8a7f-6b1d0e2c3a4f", Image: "docker.io/library/debian:11"}))
+
+		scans, err := mongo.GetLatestScansByRepositories([]string{"docker.io/library/debian"})
+
+		require.NoError(t, err)
+		require.Len(t, scans, 2)
+
+		for _, s := range scans {
+			assert.Contains(t, []string{"docker.io/library/debian:9", "docker.io/library/debian:10"}, s.Image)
+			require.Len(t, s.Result, 1)
+			assert.Equal(t, []scan.Layer{{Digest: "sha256:" + s.ID}}, s.Result[0].Layers)
+		}
+
+		scans, err = mongo.GetLatestScansByRepositories([]string{"docker.io/library/alpine"})
+
+		require.NoError(t, err)
+		assert.Empty(t, scans)
+	})
+}
+
+func TestImageSelector(t *testing.T) {
+
+	t.Run(`Ensure selector is marshaled as a repository prefix match by sessions`, func(t *testing.T) {
+		data, err := mgobson.Marshal(imageSelector(db.ImageQuery{
+			Repository: "tsuru/c.t",
+			Tag:        "latest",
+			Severity:   scan.SeverityHigh,
+		}))
+
+		require.NoError(t, err)
+
+		var selector mgobson.M
+
+		require.NoError(t, mgobson.Unmarshal(data, &selector))
+
+		assert.Equal(t, mgobson.M{
+			"repository":      mgobson.M{"$regex": `^tsuru/c\.t`},
+			"tag":             "latest",
+			"severities.high": mgobson.M{"$gt": 0},
+		}, selector)
+
+		pattern := regexp.MustCompile(selector["repository"].(mgobson.M)["$regex"].(string))
+
+		assert.True(t, pattern.MatchString("tsuru/c.t-api"))
+		assert.False(t, pattern.MatchString("tsuru/cst"))
+		assert.False(t, pattern.MatchString("library/tsuru/c.t"))
+	})
+}
+
 func TestMongoDB_GetScansByImage(t *testing.T) {
 
 	mongo := getMongoDBTestingInstance(t)
@@ -294,6 +609,366 @@ func TestMongoDB_GetScansByImage(t *testing.T) {
 	})
 }
 
+func TestMongoDB_GetScanByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`When scan document does not exist, should return db.ErrScanNotFound`, func(t *testing.T) {
+		_, err := mongo.GetScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95")
+
+		assert.Equal(t, db.ErrScanNotFound, err)
+	})
+
+	t.Run(`Ensure expected scan document is returned`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		expected := scan.Scan{
+			ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Image:  "tsuru/cst:latest",
+			Status: scan.StatusDeadLetter,
+		}
+
+		scanColl.Insert(expected)
+
+		got, err := mongo.GetScanByID(expected.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, expected, got)
+	})
+}
+
+func TestMongoDB_GetScansByIDs(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure only the scans with given ids are returned, without results`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(
+			scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: scan.StatusFinished, Result: []scan.Result{{Scanner: "clair"}}},
+			scan.Scan{ID: "83633447-353f-4e87-aa95-2a44205eb89e", Status: scan.StatusRunning},
+			scan.Scan{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Status: scan.StatusScheduled},
+		)
+
+		scans, err := mongo.GetScansByIDs([]string{"2b935a8f-4241-49f0-a1a2-e3c8ba347b95", "83633447-353f-4e87-aa95-2a44205eb89e"})
+
+		require.NoError(t, err)
+		require.Len(t, scans, 2)
+
+		for _, s := range scans {
+			assert.NotEqual(t, "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", s.ID)
+			assert.Empty(t, s.Result)
+		}
+	})
+}
+
+func TestMongoDB_GetBatchByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`When batch exists, should return the batch saved`, func(t *testing.T) {
+		batchColl := mongo.getBatchCollection()
+
+		defer func() {
+			batchColl.DropCollection()
+			batchColl.Database.Session.Close()
+		}()
+
+		batch := scan.Batch{
+			ID: "f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11",
+			Items: []scan.BatchItem{
+				{Image: "tsuru/cst:latest", Status: scan.BatchItemCreated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+				{Image: "tsuru/cst latest", Status: scan.BatchItemInvalid, Error: "invalid reference format"},
+			},
+		}
+
+		require.NoError(t, mongo.SaveBatch(batch))
+
+		got, err := mongo.GetBatchByID(batch.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, batch.Items, got.Items)
+	})
+
+	t.Run(`When batch does not exist, should return db.ErrBatchNotFound error`, func(t *testing.T) {
+		_, err := mongo.GetBatchByID("f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11")
+
+		assert.Equal(t, db.ErrBatchNotFound, err)
+	})
+}
+
+func TestMongoDB_GetScansByStatus(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure only scan documents with given status are returned`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scansOnStorage := []scan.Scan{
+			scan.Scan{ID: "1", Status: scan.StatusDeadLetter},
+			scan.Scan{ID: "2", Status: scan.StatusFinished},
+		}
+
+		scanColl.Insert(scansOnStorage[0], scansOnStorage[1])
+
+		gotScans, err := mongo.GetScansByStatus(scan.StatusDeadLetter)
+
+		require.NoError(t, err)
+		assert.Equal(t, []scan.Scan{scansOnStorage[0]}, gotScans)
+	})
+}
+
+func TestMongoDB_RescheduleScanByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure scan gets scheduled status, without results and with given attempts`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{
+			ID:         "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Status:     scan.StatusDeadLetter,
+			FinishedAt: time.Now(),
+			Result: []scan.Result{
+				scan.Result{Scanner: "clair", Error: &scan.Error{Code: scan.ErrorCodeUnavailable, Message: "service unavailable", Transient: true}},
+			},
+		})
+
+		err := mongo.RescheduleScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", 2)
+
+		require.NoError(t, err)
+
+		var scanOnStorage scan.Scan
+
+		scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
+
+		assert.Equal(t, scan.StatusScheduled, scanOnStorage.Status)
+		assert.Equal(t, 2, scanOnStorage.Attempts)
+		assert.Empty(t, scanOnStorage.Result)
+		assert.True(t, scanOnStorage.FinishedAt.IsZero())
+	})
+}
+
+func TestMongoDB_StartScanByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`When scan is scheduled, should run it under the worker's lease`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{
+			ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Status: scan.StatusScheduled,
+		})
+
+		err := mongo.StartScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", 2, &scan.Lease{
+			Worker:    "worker-01_42",
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+
+		require.NoError(t, err)
+
+		var scanOnStorage scan.Scan
+
+		scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
+
+		assert.Equal(t, scan.StatusRunning, scanOnStorage.Status)
+		assert.Equal(t, 2, scanOnStorage.Attempts)
+		require.NotNil(t, scanOnStorage.Lease)
+		assert.Equal(t, "worker-01_42", scanOnStorage.Lease.Worker)
+	})
+
+	t.Run(`When scan is running or done, should return db.ErrScanNotScheduled and keep it untouched`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		for _, status := range []scan.Status{scan.StatusRunning, scan.StatusFinished} {
+			scanColl.UpsertId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", scan.Scan{
+				ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+				Status: status,
+			})
+
+			err := mongo.StartScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", 1, nil)
+
+			assert.Equal(t, db.ErrScanNotScheduled, err)
+
+			var scanOnStorage scan.Scan
+
+			scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
+
+			assert.Equal(t, status, scanOnStorage.Status)
+		}
+	})
+}
+
+func TestMongoDB_LeaseScanByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`When running scan has no lease, should lease it to worker and record the attempt`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{
+			ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Status: scan.StatusRunning,
+		})
+
+		lease := scan.Lease{
+			Worker:    "worker-01_42",
+			ExpiresAt: time.Now().Add(time.Minute),
+		}
+
+		err := mongo.LeaseScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", 2, lease)
+
+		require.NoError(t, err)
+
+		var scanOnStorage scan.Scan
+
+		scanColl.FindId("2b935a8f-4241-49f0-a1a2-e3c8ba347b95").One(&scanOnStorage)
+
+		require.NotNil(t, scanOnStorage.Lease)
+		assert.Equal(t, "worker-01_42", scanOnStorage.Lease.Worker)
+		assert.Equal(t, 2, scanOnStorage.Attempts)
+	})
+
+	t.Run(`When scan is leased by another worker, should return db.ErrLeaseLost`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		scanColl.Insert(scan.Scan{
+			ID:     "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Status: scan.StatusRunning,
+			Lease: &scan.Lease{
+				Worker:    "worker-02_42",
+				ExpiresAt: time.Now().Add(time.Minute),
+			},
+		})
+
+		err := mongo.LeaseScanByID("2b935a8f-4241-49f0-a1a2-e3c8ba347b95", 1, scan.Lease{
+			Worker:    "worker-01_42",
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+
+		assert.Equal(t, db.ErrLeaseLost, err)
+	})
+}
+
+func TestMongoDB_ExpireScanLeaseByID(t *testing.T) {
+
+	mongo := getMongoDBTestingInstance(t)
+
+	defer func() {
+		mongo.session.Close()
+	}()
+
+	t.Run(`Ensure only scans with expired lease are taken back to scheduled status`, func(t *testing.T) {
+		scanColl := mongo.getScanCollection()
+
+		defer func() {
+			scanColl.DropCollection()
+			scanColl.Database.Session.Close()
+		}()
+
+		now := time.Now()
+
+		scanColl.Insert(
+			scan.Scan{
+				ID:     "1",
+				Status: scan.StatusRunning,
+				Lease:  &scan.Lease{Worker: "worker-01_42", ExpiresAt: now.Add(-time.Minute)},
+			},
+			scan.Scan{
+				ID:     "2",
+				Status: scan.StatusRunning,
+				Lease:  &scan.Lease{Worker: "worker-02_42", ExpiresAt: now.Add(time.Minute)},
+			},
+		)
+
+		expired, err := mongo.GetScansWithExpiredLease(now)
+
+		require.NoError(t, err)
+		require.Equal(t, 1, len(expired))
+		assert.Equal(t, "1", expired[0].ID)
+
+		require.NoError(t, mongo.ExpireScanLeaseByID("1", now))
+		assert.Equal(t, db.ErrLeaseLost, mongo.ExpireScanLeaseByID("1", now))
+		assert.Equal(t, db.ErrLeaseLost, mongo.ExpireScanLeaseByID("2", now))
+
+		var scanOnStorage scan.Scan
+
+		scanColl.FindId("1").One(&scanOnStorage)
+
+		assert.Equal(t, scan.StatusScheduled, scanOnStorage.Status)
+		assert.Nil(t, scanOnStorage.Lease)
+	})
+}
+
 func TestMongoDB_Ping(t *testing.T) {
 
 	mongo := getMongoDBTestingInstance(t)
@@ -1,20 +1,92 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/tsuru/cst/scan"
 )
 
+var (
+	// ErrScanNotFound indicates that no scan matches the given identifier.
+	ErrScanNotFound = errors.New(`scan not found`)
+
+	// ErrBatchNotFound indicates that no batch matches the given identifier.
+	ErrBatchNotFound = errors.New(`batch not found`)
+
+	// ErrLeaseLost indicates that a scan is no longer running under the
+	// lease of a given worker (e.g. its lease expired and it was reaped).
+	ErrLeaseLost = errors.New(`scan lease was lost`)
+
+	// ErrScanNotScheduled indicates that a scan couldn't be started because
+	// it isn't scheduled anymore (e.g. it's already running or done).
+	ErrScanNotScheduled = errors.New(`scan is not scheduled`)
+)
+
+// ImageQuery filters and sorts the image inventory.
+type ImageQuery struct {
+	// Repository matches images whose repository starts with it.
+	Repository string
+
+	// Tag matches images with exactly that tag.
+	Tag string
+
+	// Severity matches images with at least one finding of that severity
+	// (one of scan.Severities).
+	Severity string
+
+	// SortBy is one of ImageSortFields. Images are sorted by name when empty.
+	SortBy     string
+	Descending bool
+
+	Limit int
+	Skip  int
+}
+
+// EventFilter selects the scan events to watch. Empty fields match any scan.
+type EventFilter struct {
+	ScanID string
+	Image  string
+}
+
+// ImageSortFields lists the fields the image inventory can be sorted by.
+var ImageSortFields = []string{
+	"name",
+	"lastScannedAt",
+	"critical",
+	"high",
+	"medium",
+	"low",
+	"negligible",
+	"unknown",
+}
+
 // Storage represents a persistent data store.
 type Storage interface {
-	AppendResultToScanByID(string, scan.Result) error
+	AppendResultToScanByID(string, string, scan.Result) error
 	Close()
+	ExpireScanLeaseByID(string, time.Time) error
+	GetBatchByID(string) (scan.Batch, error)
+	GetFindingsByCVE(string) ([]scan.Finding, error)
+	GetImages(ImageQuery) ([]scan.Image, error)
+	GetLatestScansByRepositories([]string) ([]scan.Scan, error)
+	GetLayerFindings(string, string, []string, time.Time) ([]scan.LayerFindings, error)
+	GetScanByID(string) (scan.Scan, error)
+	GetScansByIDs([]string) ([]scan.Scan, error)
 	GetScansByImage(image string) ([]scan.Scan, error)
+	GetScansByStatus(scan.Status) ([]scan.Scan, error)
+	GetScansWithExpiredLease(time.Time) ([]scan.Scan, error)
 	HasScheduledScanByImage(string) bool
+	LeaseScanByID(string, int, scan.Lease) error
+	RescheduleScanByID(string, int) error
+	SaveBatch(scan.Batch) error
+	SaveLayerFindings([]scan.LayerFindings) error
+	StartScanByID(string, int, *scan.Lease) error
 	UpdateScanByID(string, scan.Status, *time.Time) error
 	Ping() bool
 	Save(scan.Scan) error
+	WatchScanEvents(context.Context, EventFilter) (<-chan scan.Event, error)
 }
 
 var storageInstance Storage
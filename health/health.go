@@ -0,0 +1,206 @@
+// Package health reports whether a CST process is alive and ready to work,
+// checking the services it depends on (storage, queue and scanners).
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/queue"
+	"github.com/tsuru/cst/scan"
+)
+
+// DefaultTimeout is how long a check can take before being considered down.
+const DefaultTimeout = 5 * time.Second
+
+// ErrTimeout indicates a check took longer than allowed.
+var ErrTimeout = errors.New(`check timed out`)
+
+// Status indicates whether a check (or the whole report) succeeded.
+type Status string
+
+const (
+	// StatusDown indicates a dependency is unreachable.
+	StatusDown = Status("down")
+
+	// StatusUp indicates a dependency is reachable.
+	StatusUp = Status("up")
+)
+
+// Check is a named function which returns an error when a dependency is
+// unreachable.
+type Check struct {
+	Name string
+	Func func() error
+}
+
+// CheckResult holds the outcome of a single check.
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report holds the outcome of all checks. Its status is up only when every
+// check is up.
+type Report struct {
+	Status Status        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+// Run executes all checks concurrently, waiting at most timeout for each one.
+func Run(checks []Check, timeout time.Duration) Report {
+
+	report := Report{
+		Status: StatusUp,
+		Checks: make([]CheckResult, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+
+	for index, check := range checks {
+		wg.Add(1)
+
+		go func(index int, check Check) {
+			defer wg.Done()
+
+			report.Checks[index] = runCheck(check, timeout)
+		}(index, check)
+	}
+
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+
+	return report
+}
+
+func runCheck(check Check, timeout time.Duration) CheckResult {
+
+	startedAt := time.Now()
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- check.Func()
+	}()
+
+	var err error
+
+	select {
+	case err = <-errChan:
+	case <-time.After(timeout):
+		err = ErrTimeout
+	}
+
+	result := CheckResult{
+		Name:      check.Name,
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(startedAt)) / float64(time.Millisecond),
+	}
+
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// StorageCheck returns a check which pings the storage service.
+func StorageCheck() Check {
+	return Check{
+		Name: "storage",
+		Func: func() error {
+			if !db.GetStorage().Ping() {
+				return errors.New("storage service is unreachable")
+			}
+
+			return nil
+		},
+	}
+}
+
+// QueueCheck returns a check which pings the queue service, when the queue
+// supports it (see queue.Pinger).
+func QueueCheck() Check {
+	return Check{
+		Name: "queue",
+		Func: func() error {
+			if pinger, ok := queue.GetQueue().(queue.Pinger); ok {
+				return pinger.Ping()
+			}
+
+			return nil
+		},
+	}
+}
+
+// ScannerChecks returns a check for each scanner able to ping the services
+// it depends on (see scan.Pinger).
+func ScannerChecks(scanners map[string]scan.Scanner) []Check {
+
+	names := make([]string, 0, len(scanners))
+
+	for name := range scanners {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var checks []Check
+
+	for _, name := range names {
+		if pinger, ok := scanners[name].(scan.Pinger); ok {
+			checks = append(checks, Check{
+				Name: "scanner:" + name,
+				Func: pinger.Ping,
+			})
+		}
+	}
+
+	return checks
+}
+
+// LiveHandler returns an http.Handler which always reports the process is up.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Report{Status: StatusUp})
+	})
+}
+
+// ReadyHandler returns an http.Handler which runs checks on each request.
+// It replies with 503 status code when any check is down.
+func ReadyHandler(checks []Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Run(checks, DefaultTimeout))
+	})
+}
+
+// StatusCode returns the HTTP status code matching the report's status.
+func (r Report) StatusCode() int {
+
+	if r.Status == StatusUp {
+		return http.StatusOK
+	}
+
+	return http.StatusServiceUnavailable
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(report.StatusCode())
+
+	json.NewEncoder(w).Encode(report)
+}
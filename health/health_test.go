@@ -0,0 +1,86 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestRun(t *testing.T) {
+
+	t.Run(`When all checks succeed, should report up`, func(t *testing.T) {
+		report := Run([]Check{
+			{Name: "storage", Func: func() error { return nil }},
+			{Name: "queue", Func: func() error { return nil }},
+		}, time.Second)
+
+		assert.Equal(t, StatusUp, report.Status)
+		assert.Equal(t, "storage", report.Checks[0].Name)
+		assert.Equal(t, "queue", report.Checks[1].Name)
+	})
+
+	t.Run(`When any check fails or times out, should report down with its error`, func(t *testing.T) {
+		report := Run([]Check{
+			{Name: "storage", Func: func() error { return nil }},
+			{Name: "queue", Func: func() error { return errors.New("connection refused") }},
+			{Name: "scanner:clair", Func: func() error {
+				time.Sleep(time.Second)
+				return nil
+			}},
+		}, 50*time.Millisecond)
+
+		assert.Equal(t, StatusDown, report.Status)
+		assert.Equal(t, StatusUp, report.Checks[0].Status)
+		assert.Equal(t, CheckResult{Name: "queue", Status: StatusDown, LatencyMs: report.Checks[1].LatencyMs, Error: "connection refused"}, report.Checks[1])
+		assert.Equal(t, ErrTimeout.Error(), report.Checks[2].Error)
+	})
+}
+
+type pingerScanner struct {
+	scan.MockScanner
+	err error
+}
+
+func (ps *pingerScanner) Ping() error {
+	return ps.err
+}
+
+func TestScannerChecks(t *testing.T) {
+
+	t.Run(`Ensure only scanners able to ping are checked`, func(t *testing.T) {
+		checks := ScannerChecks(map[string]scan.Scanner{
+			"clair":   &pingerScanner{err: errors.New("connection refused")},
+			"another": &scan.MockScanner{},
+		})
+
+		require.Len(t, checks, 1)
+		assert.Equal(t, "scanner:clair", checks[0].Name)
+		assert.EqualError(t, checks[0].Func(), "connection refused")
+	})
+}
+
+func TestReadyHandler(t *testing.T) {
+
+	t.Run(`When any check is down, should reply 503 status code with JSON report`, func(t *testing.T) {
+		handler := ReadyHandler([]Check{
+			{Name: "scanner:clair", Func: func() error { return errors.New("connection refused") }},
+		})
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+		var report Report
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Equal(t, StatusDown, report.Status)
+	})
+}
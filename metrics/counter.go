@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CounterVec is a set of monotonically increasing counters partitioned by
+// label values.
+type CounterVec struct {
+	*prometheus.CounterVec
+
+	name string
+}
+
+// Name returns the metric name.
+func (cv *CounterVec) Name() string {
+	return cv.name
+}
+
+// Inc increments by one the counter identified by label values.
+func (cv *CounterVec) Inc(labelValues ...string) {
+	cv.Add(1, labelValues...)
+}
+
+// Add increments by delta the counter identified by label values. Negative
+// deltas are ignored.
+func (cv *CounterVec) Add(delta float64, labelValues ...string) {
+
+	if delta < 0 {
+		return
+	}
+
+	cv.WithLabelValues(labelValues...).Add(delta)
+}
+
+// NewCounterVec creates a new CounterVec and registers it on registry.
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+
+	cv := &CounterVec{
+		CounterVec: prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames),
+		name:       name,
+	}
+
+	r.Register(cv)
+
+	return cv
+}
@@ -0,0 +1,89 @@
+package metrics
+
+var (
+	// ScansScheduled counts the scans (including re-driven and retried ones)
+	// workers handed over to each scanner.
+	ScansScheduled = DefaultRegistry.NewCounterVec(
+		"cst_scans_scheduled_total",
+		"Total of scans scheduled by scanner.",
+		"scanner",
+	)
+
+	// ScansFinished counts the scans finished by their final status.
+	ScansFinished = DefaultRegistry.NewCounterVec(
+		"cst_scans_finished_total",
+		"Total of scans finished by final status.",
+		"status",
+	)
+
+	// ScannerResults counts the analysis results by scanner and status
+	// ("finished" or "failed").
+	ScannerResults = DefaultRegistry.NewCounterVec(
+		"cst_scanner_results_total",
+		"Total of analysis results reported by scanner and status.",
+		"scanner", "status",
+	)
+
+	// ScannerDuration measures how long each scanner takes to analyze an
+	// image.
+	ScannerDuration = DefaultRegistry.NewHistogramVec(
+		"cst_scanner_duration_seconds",
+		"Time spent by scanner to analyze an image.",
+		ScanBuckets,
+		"scanner",
+	)
+
+	// ImagePullDuration measures how long scanners take to fetch the image's
+	// manifest and layers from registry.
+	ImagePullDuration = DefaultRegistry.NewHistogramVec(
+		"cst_image_pull_duration_seconds",
+		"Time spent by scanner to fetch image from registry.",
+		ScanBuckets,
+		"scanner",
+	)
+
+	// LayerCacheLookups counts the image layers looked up on the layer cache
+	// by scanner and result ("hit" or "miss").
+	LayerCacheLookups = DefaultRegistry.NewCounterVec(
+		"cst_layer_cache_lookups_total",
+		"Total of image layers looked up on cache by scanner and result.",
+		"scanner", "result",
+	)
+
+	// HTTPRequests counts the requests handled by API server.
+	HTTPRequests = DefaultRegistry.NewCounterVec(
+		"cst_http_requests_total",
+		"Total of HTTP requests by method, route and status code.",
+		"method", "path", "code",
+	)
+
+	// HTTPRequestDuration measures how long API server takes to respond.
+	HTTPRequestDuration = DefaultRegistry.NewHistogramVec(
+		"cst_http_request_duration_seconds",
+		"Time spent to handle HTTP requests by method and route.",
+		DefaultBuckets,
+		"method", "path",
+	)
+
+	// StorageOperationDuration measures the latency of storage operations.
+	StorageOperationDuration = DefaultRegistry.NewHistogramVec(
+		"cst_storage_operation_duration_seconds",
+		"Time spent on storage operations.",
+		DefaultBuckets,
+		"operation",
+	)
+)
+
+// SetQueueDepthFunc registers the function used to get how many jobs are
+// waiting on queue.
+func SetQueueDepthFunc(depth func() (int, error)) {
+	DefaultRegistry.NewGaugeFunc(
+		"cst_queue_depth",
+		"Number of jobs waiting on queue.",
+		func() (float64, error) {
+			size, err := depth()
+
+			return float64(size), err
+		},
+	)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// GaugeFunc is a gauge whose value is taken from a function on each scrape.
+type GaugeFunc struct {
+	name     string
+	desc     *prometheus.Desc
+	function func() (float64, error)
+}
+
+// Name returns the metric name.
+func (gf *GaugeFunc) Name() string {
+	return gf.name
+}
+
+// Describe implements the prometheus.Collector interface.
+func (gf *GaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gf.desc
+}
+
+// Collect implements the prometheus.Collector interface calling the gauge
+// function. When the function fails, the sample is omitted.
+func (gf *GaugeFunc) Collect(ch chan<- prometheus.Metric) {
+
+	value, err := gf.function()
+
+	if err != nil {
+		logrus.
+			WithField("metric", gf.name).
+			WithError(err).
+			Warn("could not collect gauge value")
+
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(gf.desc, prometheus.GaugeValue, value)
+}
+
+// NewGaugeFunc creates a new GaugeFunc and registers it on registry.
+func (r *Registry) NewGaugeFunc(name, help string, function func() (float64, error)) *GaugeFunc {
+
+	gf := &GaugeFunc{
+		name:     name,
+		desc:     prometheus.NewDesc(name, help, nil, nil),
+		function: function,
+	}
+
+	r.Register(gf)
+
+	return gf
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DefaultBuckets are suited to measure network operations (in seconds).
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// ScanBuckets are suited to measure image analysis (in seconds).
+	ScanBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+)
+
+// HistogramVec is a set of histograms partitioned by label values. Each
+// histogram counts the observations on cumulative buckets.
+type HistogramVec struct {
+	*prometheus.HistogramVec
+
+	name string
+}
+
+// Name returns the metric name.
+func (hv *HistogramVec) Name() string {
+	return hv.name
+}
+
+// Observe adds a value on the histogram identified by label values.
+func (hv *HistogramVec) Observe(value float64, labelValues ...string) {
+	hv.WithLabelValues(labelValues...).Observe(value)
+}
+
+// NewHistogramVec creates a new HistogramVec and registers it on registry.
+// When buckets are omitted, DefaultBuckets are used.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	opts := prometheus.HistogramOpts{
+		Name:    name,
+		Help:    help,
+		Buckets: buckets,
+	}
+
+	hv := &HistogramVec{
+		HistogramVec: prometheus.NewHistogramVec(opts, labelNames),
+		name:         name,
+	}
+
+	r.Register(hv)
+
+	return hv
+}
@@ -0,0 +1,63 @@
+// Package metrics exposes CST's internal measurements on Prometheus text
+// exposition format, backed by the Prometheus client library.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultRegistry holds the metrics exposed by Handler.
+var DefaultRegistry = NewRegistry()
+
+// Collector is implemented by metrics registered on a Registry.
+type Collector interface {
+	prometheus.Collector
+
+	Name() string
+}
+
+// Registry holds a set of collectors identified by their names.
+type Registry struct {
+	registry *prometheus.Registry
+
+	mutex      sync.Mutex
+	collectors map[string]Collector
+}
+
+// Register adds a collector on registry. A collector already registered with
+// the same name is replaced.
+func (r *Registry) Register(collector Collector) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if previous, ok := r.collectors[collector.Name()]; ok {
+		r.registry.Unregister(previous)
+	}
+
+	r.registry.MustRegister(collector)
+	r.collectors[collector.Name()] = collector
+}
+
+// ServeHTTP implements the http.Handler interface writing all registered
+// collectors on response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+}
+
+// NewRegistry creates a new empty instance of Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		registry:   prometheus.NewRegistry(),
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Handler returns an http.Handler serving the metrics on DefaultRegistry.
+func Handler() http.Handler {
+	return DefaultRegistry
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	return recorder.Body.String()
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+
+	t.Run(`Ensure metrics are written on Prometheus text exposition format`, func(t *testing.T) {
+
+		r := NewRegistry()
+
+		counter := r.NewCounterVec("cst_scanner_results_total", "Total of results.", "scanner", "status")
+		counter.Inc("clair", "finished")
+		counter.Add(2, "clair", "failed")
+
+		histogram := r.NewHistogramVec("cst_scanner_duration_seconds", "Time spent.", []float64{1, 5}, "scanner")
+		histogram.Observe(0.5, "clair")
+		histogram.Observe(3, "clair")
+
+		r.NewGaugeFunc("cst_queue_depth", "Jobs waiting.", func() (float64, error) {
+			return 42, nil
+		})
+
+		expected := `# HELP cst_queue_depth Jobs waiting.
+# TYPE cst_queue_depth gauge
+cst_queue_depth 42
+# HELP cst_scanner_duration_seconds Time spent.
+# TYPE cst_scanner_duration_seconds histogram
+cst_scanner_duration_seconds_bucket{scanner="clair",le="1"} 1
+cst_scanner_duration_seconds_bucket{scanner="clair",le="5"} 2
+cst_scanner_duration_seconds_bucket{scanner="clair",le="+Inf"} 2
+cst_scanner_duration_seconds_sum{scanner="clair"} 3.5
+cst_scanner_duration_seconds_count{scanner="clair"} 2
+# HELP cst_scanner_results_total Total of results.
+# TYPE cst_scanner_results_total counter
+cst_scanner_results_total{scanner="clair",status="failed"} 2
+cst_scanner_results_total{scanner="clair",status="finished"} 1
+`
+
+		assert.Equal(t, expected, scrape(t, r))
+	})
+
+	t.Run(`When gauge function fails, should omit its sample`, func(t *testing.T) {
+
+		r := NewRegistry()
+
+		r.NewGaugeFunc("cst_queue_depth", "Jobs waiting.", func() (float64, error) {
+			return 0, errors.New("queue unavailable")
+		})
+
+		assert.NotContains(t, scrape(t, r), "cst_queue_depth")
+	})
+
+	t.Run(`Ensure label values are escaped`, func(t *testing.T) {
+
+		r := NewRegistry()
+
+		r.NewCounterVec("cst_http_requests_total", "Total of requests.", "path").Inc("/a\"b\\c")
+
+		assert.Contains(t, scrape(t, r), `cst_http_requests_total{path="/a\"b\\c"} 1`)
+	})
+
+	t.Run(`When a collector is registered again with the same name, should replace it`, func(t *testing.T) {
+
+		r := NewRegistry()
+
+		r.NewGaugeFunc("cst_queue_depth", "Jobs waiting.", func() (float64, error) {
+			return 1, nil
+		})
+
+		r.NewGaugeFunc("cst_queue_depth", "Jobs waiting.", func() (float64, error) {
+			return 2, nil
+		})
+
+		body := scrape(t, r)
+
+		assert.Contains(t, body, "cst_queue_depth 2\n")
+		assert.NotContains(t, body, "cst_queue_depth 1\n")
+	})
+}
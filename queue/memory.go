@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryQueue implements a Queue interface keeping jobs in process memory.
+// It's only useful when jobs are enqueued and consumed by the same process
+// (e.g. on testing), since jobs aren't shared with other processes and are
+// lost when the process finishes.
+type MemoryQueue struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []Job
+	running map[string]Job
+	lastID  int
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Enqueue appends a new job at the end of queue.
+func (mq *MemoryQueue) Enqueue(job Job) (string, error) {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	mq.lastID++
+	job.ID = strconv.Itoa(mq.lastID)
+
+	mq.pending = append(mq.pending, job)
+	mq.cond.Broadcast()
+
+	if delay := time.Until(job.NotBefore); delay > 0 {
+		time.AfterFunc(delay, mq.wakeUp)
+	}
+
+	return job.ID, nil
+}
+
+// Consume delivers each pending job to task until Stop is called.
+func (mq *MemoryQueue) Consume(task Task) {
+
+	for {
+		job, ok := mq.next()
+
+		if !ok {
+			return
+		}
+
+		mq.wg.Add(1)
+
+		go func() {
+			defer mq.wg.Done()
+
+			task.Run(job)
+		}()
+	}
+}
+
+// Ack removes a delivered job from queue.
+func (mq *MemoryQueue) Ack(job Job) error {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	if _, ok := mq.running[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+
+	delete(mq.running, job.ID)
+
+	return nil
+}
+
+// Fail removes a delivered job from queue, logging the reason of its failure
+// (failed jobs aren't kept, as nothing would ever read them).
+func (mq *MemoryQueue) Fail(job Job, reason error) error {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	if _, ok := mq.running[job.ID]; !ok {
+		return ErrJobNotFound
+	}
+
+	delete(mq.running, job.ID)
+
+	logrus.
+		WithField("job.id", job.ID).
+		WithField("scan.id", job.ScanID).
+		WithError(reason).
+		Warn("job has failed on memory queue")
+
+	return nil
+}
+
+// Cancel removes a pending job from queue.
+func (mq *MemoryQueue) Cancel(id string) error {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	for index, job := range mq.pending {
+		if job.ID == id {
+			mq.pending = append(mq.pending[:index], mq.pending[index+1:]...)
+
+			return nil
+		}
+	}
+
+	return ErrJobNotFound
+}
+
+// Ping always succeeds, since jobs are kept in process memory.
+func (mq *MemoryQueue) Ping() error {
+	return nil
+}
+
+// Size returns how many jobs are pending on queue.
+func (mq *MemoryQueue) Size() (int, error) {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	return len(mq.pending), nil
+}
+
+// Stop finishes the consuming loop and waits for running jobs.
+func (mq *MemoryQueue) Stop() {
+
+	mq.mutex.Lock()
+	mq.stopped = true
+	mq.cond.Broadcast()
+	mq.mutex.Unlock()
+
+	mq.wg.Wait()
+}
+
+func (mq *MemoryQueue) next() (Job, bool) {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	for !mq.stopped {
+		now := time.Now()
+
+		for index, job := range mq.pending {
+			if !job.Due(now) {
+				continue
+			}
+
+			mq.pending = append(mq.pending[:index], mq.pending[index+1:]...)
+			mq.running[job.ID] = job
+
+			return job, true
+		}
+
+		mq.cond.Wait()
+	}
+
+	return Job{}, false
+}
+
+func (mq *MemoryQueue) wakeUp() {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	mq.cond.Broadcast()
+}
+
+// NewMemoryQueue creates a new empty instance of MemoryQueue.
+func NewMemoryQueue() *MemoryQueue {
+
+	mq := &MemoryQueue{
+		running: make(map[string]Job),
+	}
+
+	mq.cond = sync.NewCond(&mq.mutex)
+
+	return mq
+}
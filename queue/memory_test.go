@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueue_Consume(t *testing.T) {
+	t.Run(`Ensure enqueued jobs are delivered to task and acknowledged`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		delivered := make(chan Job, 2)
+
+		task := &MockTask{
+			MockRun: func(job Job) {
+				require.NoError(t, mq.Ack(job))
+
+				delivered <- job
+			},
+		}
+
+		firstID, err := mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+		require.NoError(t, err)
+
+		secondID, err := mq.Enqueue(Job{ScanID: "2", Image: "tsuru/cst:v10"})
+		require.NoError(t, err)
+
+		assert.NotEqual(t, firstID, secondID)
+
+		go mq.Consume(task)
+
+		gotScanIDs := []string{}
+
+		for range []int{1, 2} {
+			select {
+			case job := <-delivered:
+				gotScanIDs = append(gotScanIDs, job.ScanID)
+			case <-time.After(time.Second):
+				require.Fail(t, "job was not delivered")
+			}
+		}
+
+		mq.Stop()
+
+		assert.ElementsMatch(t, []string{"1", "2"}, gotScanIDs)
+		assert.Empty(t, mq.running)
+	})
+}
+
+func TestMemoryQueue_Enqueue(t *testing.T) {
+	t.Run(`When job has NotBefore in the future, should not deliver it before that time`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		notBefore := time.Now().Add(200 * time.Millisecond)
+
+		mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest", NotBefore: notBefore})
+
+		delivered := make(chan time.Time, 1)
+
+		go mq.Consume(&MockTask{
+			MockRun: func(job Job) {
+				mq.Ack(job)
+
+				delivered <- time.Now()
+			},
+		})
+
+		defer mq.Stop()
+
+		select {
+		case deliveredAt := <-delivered:
+			assert.False(t, deliveredAt.Before(notBefore))
+		case <-time.After(2 * time.Second):
+			require.Fail(t, "delayed job was not delivered")
+		}
+	})
+}
+
+func TestMemoryQueue_Fail(t *testing.T) {
+	t.Run(`When job was not delivered yet, should return ErrJobNotFound`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		id, _ := mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+
+		assert.Equal(t, ErrJobNotFound, mq.Fail(Job{ID: id}, errors.New("just another error")))
+	})
+
+	t.Run(`When job is running, should remove it from running jobs`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+
+		job, ok := mq.next()
+		require.True(t, ok)
+
+		reason := errors.New("just another error")
+
+		require.NoError(t, mq.Fail(job, reason))
+		assert.Empty(t, mq.running)
+		assert.Equal(t, ErrJobNotFound, mq.Fail(job, reason))
+	})
+}
+
+func TestMemoryQueue_Cancel(t *testing.T) {
+	t.Run(`When job is pending, should remove it from queue`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		id, _ := mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+
+		require.NoError(t, mq.Cancel(id))
+		assert.Empty(t, mq.pending)
+	})
+
+	t.Run(`When job does not exist, should return ErrJobNotFound`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		assert.Equal(t, ErrJobNotFound, mq.Cancel("42"))
+	})
+}
+
+func TestMemoryQueue_Size(t *testing.T) {
+	t.Run(`Ensure pending and delayed jobs are counted`, func(t *testing.T) {
+		mq := NewMemoryQueue()
+
+		mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+		mq.Enqueue(Job{ScanID: "2", Image: "tsuru/cst:latest", NotBefore: time.Now().Add(time.Hour)})
+
+		size, err := mq.Size()
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, size)
+	})
+}
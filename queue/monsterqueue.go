@@ -0,0 +1,454 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/monsterqueue/mongodb"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ScanTaskName holds the task name to which jobs are assigned on monsterqueue.
+const ScanTaskName = `scan`
+
+const (
+	// monsterJobsCollection is where monsterqueue keeps its jobs.
+	monsterJobsCollection = `queue_tasks`
+
+	// delayedJobsCollection holds the delayed jobs until they're due.
+	delayedJobsCollection = `queue_delayed`
+
+	monsterPromoteInterval = time.Second
+)
+
+// MonsterQueue implements a Queue interface backed by monsterqueue on MongoDB.
+// Since monsterqueue has no support to delayed jobs, they wait on a MongoDB
+// collection (by their due time) until a consumer moves them to monsterqueue.
+type MonsterQueue struct {
+	queue   monsterqueue.Queue
+	session *mgo.Session
+
+	mutex   sync.Mutex
+	running map[string]monsterqueue.Job
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// delayedJob is a job waiting on MongoDB until NotBefore.
+type delayedJob struct {
+	ID        string    `bson:"_id"`
+	NotBefore time.Time `bson:"notBefore"`
+	Job       Job       `bson:"job"`
+}
+
+// Enqueue publishes a new job on monsterqueue. Jobs with NotBefore in the
+// future are kept on MongoDB until they're due.
+func (mq *MonsterQueue) Enqueue(job Job) (string, error) {
+
+	if !job.Due(time.Now()) {
+		return mq.delay(job)
+	}
+
+	enqueued, err := mq.queue.Enqueue(ScanTaskName, paramsOf(job))
+
+	if err != nil {
+		return "", err
+	}
+
+	return enqueued.ID(), nil
+}
+
+func paramsOf(job Job) monsterqueue.JobParams {
+
+	params := monsterqueue.JobParams{
+		"id":    job.ScanID,
+		"image": job.Image,
+	}
+
+	if job.Attempt > 0 {
+		params["attempt"] = job.Attempt
+	}
+
+	if !job.NotBefore.IsZero() {
+		params["notBefore"] = job.NotBefore
+	}
+
+	if len(job.Metadata) > 0 {
+		metadata := make(map[string]interface{}, len(job.Metadata))
+
+		for key, value := range job.Metadata {
+			metadata[key] = value
+		}
+
+		params["metadata"] = metadata
+	}
+
+	return params
+}
+
+// Consume registers task on monsterqueue and processes its jobs until Stop is
+// called, moving the delayed jobs to monsterqueue once they're due.
+func (mq *MonsterQueue) Consume(task Task) {
+
+	mq.wg.Add(1)
+
+	go func() {
+		defer mq.wg.Done()
+
+		ticker := time.NewTicker(monsterPromoteInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := mq.promoteDelayedJobs(); err != nil {
+				logrus.WithError(err).Warn("could not move delayed jobs to monsterqueue")
+			}
+
+			select {
+			case <-mq.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	mq.queue.RegisterTask(&monsterTask{
+		queue: mq,
+		task:  task,
+	})
+
+	mq.queue.ProcessLoop()
+}
+
+// Ack notifies monsterqueue the job was successfully processed.
+func (mq *MonsterQueue) Ack(job Job) error {
+
+	mJob, err := mq.release(job.ID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = mJob.Success(nil)
+
+	return err
+}
+
+// Fail notifies monsterqueue the job was processed with errors.
+func (mq *MonsterQueue) Fail(job Job, reason error) error {
+
+	mJob, err := mq.release(job.ID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = mJob.Error(reason)
+
+	return err
+}
+
+// Cancel deletes a job from monsterqueue when it's still enqueued (or
+// delayed).
+func (mq *MonsterQueue) Cancel(id string) error {
+
+	if mq.session != nil {
+		collection := mq.getDelayedCollection()
+		err := collection.RemoveId(id)
+		collection.Database.Session.Close()
+
+		if err == nil {
+			return nil
+		}
+
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+
+	mJob, err := mq.queue.RetrieveJob(id)
+
+	if err == monsterqueue.ErrNoSuchJob {
+		return ErrJobNotFound
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if mJob.Status().State != monsterqueue.JobStateEnqueued {
+		return ErrJobNotFound
+	}
+
+	return mq.queue.DeleteJob(id)
+}
+
+// Ping checks the connection with MongoDB service.
+func (mq *MonsterQueue) Ping() error {
+
+	if mq.session == nil {
+		return errors.New("monsterqueue has no MongoDB session to check")
+	}
+
+	return mq.session.Ping()
+}
+
+// Size returns how many jobs are enqueued (or delayed) on monsterqueue,
+// counted by MongoDB rather than listing every job.
+func (mq *MonsterQueue) Size() (int, error) {
+
+	if mq.session == nil {
+		return 0, errors.New("monsterqueue has no MongoDB session to count jobs")
+	}
+
+	session := mq.session.Copy()
+	defer session.Close()
+
+	// same selector monsterqueue uses to take the enqueued jobs
+	enqueued, err := session.DB("").C(monsterJobsCollection).Find(bson.M{
+		"task":               ScanTaskName,
+		"owner.owned":        false,
+		"resultmessage.done": false,
+	}).Count()
+
+	if err != nil {
+		return 0, err
+	}
+
+	delayed, err := session.DB("").C(delayedJobsCollection).Count()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return enqueued + delayed, nil
+}
+
+// Stop finishes the processing loop and waits for running jobs.
+func (mq *MonsterQueue) Stop() {
+	close(mq.done)
+	mq.queue.Stop()
+	mq.wg.Wait()
+
+	if mq.session != nil {
+		mq.session.Close()
+	}
+}
+
+// delay keeps job on MongoDB until it's due.
+func (mq *MonsterQueue) delay(job Job) (string, error) {
+
+	if mq.session == nil {
+		return "", errors.New("monsterqueue has no MongoDB session to delay jobs")
+	}
+
+	collection := mq.getDelayedCollection()
+	defer collection.Database.Session.Close()
+
+	job.ID = ""
+
+	delayed := delayedJob{
+		ID:        bson.NewObjectId().Hex(),
+		NotBefore: job.NotBefore,
+		Job:       job,
+	}
+
+	if err := collection.Insert(delayed); err != nil {
+		return "", err
+	}
+
+	return delayed.ID, nil
+}
+
+// promoteDelayedJobs moves the due jobs from MongoDB to monsterqueue. Only the
+// consumer which succeeds removing a job from collection enqueues it.
+func (mq *MonsterQueue) promoteDelayedJobs() error {
+
+	if mq.session == nil {
+		return nil
+	}
+
+	collection := mq.getDelayedCollection()
+	defer collection.Database.Session.Close()
+
+	var delayed []delayedJob
+
+	err := collection.
+		Find(bson.M{"notBefore": bson.M{"$lte": time.Now()}}).
+		Sort("notBefore").
+		Limit(100).
+		All(&delayed)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range delayed {
+		err := collection.RemoveId(entry.ID)
+
+		if err == mgo.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := mq.queue.Enqueue(ScanTaskName, paramsOf(entry.Job)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mq *MonsterQueue) getDelayedCollection() *mgo.Collection {
+
+	session := mq.session.Copy()
+
+	return session.DB("").C(delayedJobsCollection)
+}
+
+func (mq *MonsterQueue) hold(mJob monsterqueue.Job) {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	mq.running[mJob.ID()] = mJob
+}
+
+func (mq *MonsterQueue) release(id string) (monsterqueue.Job, error) {
+
+	mq.mutex.Lock()
+	defer mq.mutex.Unlock()
+
+	mJob, ok := mq.running[id]
+
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	delete(mq.running, id)
+
+	return mJob, nil
+}
+
+// NewMonsterQueue creates a new instance of MonsterQueue. Returns an error if
+// MongoDB service is unavailable.
+func NewMonsterQueue(rawURL string) (*MonsterQueue, error) {
+
+	q, err := mongodb.NewQueue(mongodb.QueueConfig{
+		Url: rawURL,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// monsterqueue doesn't expose its session, so another one is kept to
+	// check the connectivity with MongoDB
+	session, err := mgo.Dial(rawURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mq := newMonsterQueue(q)
+	mq.session = session
+
+	return mq, nil
+}
+
+func newMonsterQueue(q monsterqueue.Queue) *MonsterQueue {
+
+	return &MonsterQueue{
+		queue:   q,
+		running: make(map[string]monsterqueue.Job),
+		done:    make(chan struct{}),
+	}
+}
+
+// monsterTask adapts a Task to the monsterqueue.Task interface.
+type monsterTask struct {
+	queue *MonsterQueue
+	task  Task
+}
+
+func (mt *monsterTask) Run(mJob monsterqueue.Job) {
+
+	job, err := jobFromParams(mJob.ID(), mJob.Parameters())
+
+	if err != nil {
+		mJob.Error(err)
+
+		return
+	}
+
+	// jobs published on monsterqueue before they're due (e.g. by older
+	// versions) go back to the delayed ones
+	if !job.Due(time.Now()) {
+		if _, err := mt.queue.delay(job); err != nil {
+			mJob.Error(err)
+
+			return
+		}
+
+		mJob.Success(nil)
+
+		return
+	}
+
+	mt.queue.hold(mJob)
+
+	mt.task.Run(job)
+}
+
+func (mt *monsterTask) Name() string {
+	return ScanTaskName
+}
+
+func jobFromParams(id string, params monsterqueue.JobParams) (Job, error) {
+
+	scanID, _ := params["id"].(string)
+	image, _ := params["image"].(string)
+
+	if scanID == "" || image == "" {
+		return Job{}, errors.New(`job parameters are missing scan's id or image`)
+	}
+
+	job := Job{
+		ID:     id,
+		ScanID: scanID,
+		Image:  image,
+	}
+
+	if attempt, ok := params["attempt"].(int); ok {
+		job.Attempt = attempt
+	}
+
+	if notBefore, ok := params["notBefore"].(time.Time); ok {
+		job.NotBefore = notBefore
+	}
+
+	metadata, ok := params["metadata"].(map[string]interface{})
+
+	// nested documents are decoded by monsterqueue as bson.M values
+	if doc, isDoc := params["metadata"].(bson.M); isDoc {
+		metadata, ok = doc, true
+	}
+
+	if ok {
+		job.Metadata = make(map[string]string, len(metadata))
+
+		for key, value := range metadata {
+			if str, ok := value.(string); ok {
+				job.Metadata[key] = str
+			}
+		}
+	}
+
+	return job, nil
+}
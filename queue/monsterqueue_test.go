@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/monsterqueue"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestJobFromParams(t *testing.T) {
+	t.Run(`When params are missing scan's id, should return an error`, func(t *testing.T) {
+		_, err := jobFromParams("5b9f9c1e0c9d440001a1b2c3", monsterqueue.JobParams{
+			"image": "tsuru/cst:latest",
+		})
+
+		assert.Error(t, err)
+	})
+
+	t.Run(`Ensure metadata decoded as bson.M is loaded on job`, func(t *testing.T) {
+		job, err := jobFromParams("5b9f9c1e0c9d440001a1b2c3", monsterqueue.JobParams{
+			"id":       "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			"image":    "tsuru/cst:latest",
+			"metadata": bson.M{"key": "value"},
+		})
+
+		require.NoError(t, err)
+
+		expected := Job{
+			ID:       "5b9f9c1e0c9d440001a1b2c3",
+			ScanID:   "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:    "tsuru/cst:latest",
+			Metadata: map[string]string{"key": "value"},
+		}
+
+		assert.Equal(t, expected, job)
+	})
+}
+
+func TestParamsOf(t *testing.T) {
+	t.Run(`Ensure jobs are loaded back from their params`, func(t *testing.T) {
+		job := Job{
+			ScanID:    "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:     "tsuru/cst:latest",
+			Attempt:   2,
+			NotBefore: time.Date(2018, 9, 17, 12, 0, 0, 0, time.UTC),
+			Metadata:  map[string]string{"key": "value"},
+		}
+
+		loaded, err := jobFromParams("5b9f9c1e0c9d440001a1b2c3", paramsOf(job))
+
+		require.NoError(t, err)
+
+		job.ID = "5b9f9c1e0c9d440001a1b2c3"
+
+		assert.Equal(t, job, loaded)
+	})
+}
+
+func TestMonsterQueue_Delayed(t *testing.T) {
+
+	mq := getMonsterQueueTestingInstance(t)
+
+	defer func() {
+		mq.session.DB("").C(delayedJobsCollection).DropCollection()
+		mq.queue.ResetStorage()
+		mq.Stop()
+	}()
+
+	t.Run(`Ensure delayed jobs are kept on MongoDB and can be canceled`, func(t *testing.T) {
+		id, err := mq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest", NotBefore: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		count, err := mq.session.DB("").C(delayedJobsCollection).FindId(id).Count()
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		size, err := mq.Size()
+		require.NoError(t, err)
+		assert.Equal(t, 1, size)
+
+		assert.NoError(t, mq.Cancel(id))
+		assert.Equal(t, ErrJobNotFound, mq.Cancel(id))
+	})
+
+	t.Run(`Ensure size counts only the enqueued jobs`, func(t *testing.T) {
+		_, err := mq.Enqueue(Job{ScanID: "3", Image: "tsuru/cst:latest"})
+		require.NoError(t, err)
+
+		size, err := mq.Size()
+		require.NoError(t, err)
+		assert.Equal(t, 1, size)
+
+		require.NoError(t, mq.queue.ResetStorage())
+	})
+
+	t.Run(`Ensure delayed jobs are delivered once they're due`, func(t *testing.T) {
+		delivered := make(chan Job, 1)
+
+		task := &MockTask{
+			MockRun: func(job Job) {
+				require.NoError(t, mq.Ack(job))
+
+				delivered <- job
+			},
+		}
+
+		notBefore := time.Now().Add(500 * time.Millisecond)
+
+		_, err := mq.Enqueue(Job{ScanID: "2", Image: "tsuru/cst:latest", NotBefore: notBefore})
+		require.NoError(t, err)
+
+		go mq.Consume(task)
+
+		select {
+		case job := <-delivered:
+			assert.Equal(t, "2", job.ScanID)
+			assert.False(t, time.Now().Before(notBefore))
+		case <-time.After(10 * time.Second):
+			require.Fail(t, "delayed job was not delivered")
+		}
+	})
+}
+
+func getMonsterQueueTestingInstance(t *testing.T) *MonsterQueue {
+
+	storageURL := os.Getenv("STORAGE_URL")
+
+	if storageURL == "" {
+		t.Skip("mongodb connection url are not assigned, skipping integration tests")
+	}
+
+	mq, err := NewMonsterQueue(storageURL)
+
+	require.NoError(t, err, "could not connect with mongodb service")
+
+	return mq
+}
@@ -1,31 +1,116 @@
 package queue
 
 import (
-	"github.com/tsuru/monsterqueue"
-	"github.com/tsuru/monsterqueue/mongodb"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
 )
 
-// ScanTaskName holds the task name to which jobs are assigned.
-const ScanTaskName = `scan`
+var (
+	// ErrJobNotFound indicates that a job could not be found on queue (e.g.
+	// it was already delivered to a consumer or it never existed).
+	ErrJobNotFound = errors.New(`job not found on queue`)
 
-var queueInstance monsterqueue.Queue
+	// ErrUnsupportedBackend indicates that no queue backend is able to handle
+	// the scheme of a given queue URL.
+	ErrUnsupportedBackend = errors.New(`unsupported queue backend`)
+)
+
+// Job represents a scan waiting to be analyzed by a worker. Attempt holds how
+// many times the scan has been tried (starting at 1) and NotBefore, when
+// assigned, prevents the job from being delivered before that time.
+type Job struct {
+	ID        string            `json:"id,omitempty"`
+	ScanID    string            `json:"scanID"`
+	Image     string            `json:"image"`
+	Attempt   int               `json:"attempt,omitempty"`
+	NotBefore time.Time         `json:"notBefore,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Due returns true when job can be delivered at a given time.
+func (j Job) Due(now time.Time) bool {
+	return !now.Before(j.NotBefore)
+}
+
+// Task processes jobs delivered by a Queue. Implementations must either
+// acknowledge (calling Queue.Ack) or fail (calling Queue.Fail) every job they
+// receive.
+type Task interface {
+	Run(Job)
+}
+
+// Queue defines the actions about a scan job queue, regardless of the
+// service used to store its jobs.
+type Queue interface {
+	// Enqueue publishes a new job on queue and returns its identifier. Jobs
+	// with NotBefore in the future are delayed until that time.
+	Enqueue(Job) (string, error)
+
+	// Consume delivers the enqueued jobs to task, each one in its own
+	// goroutine. It blocks until Stop is called.
+	Consume(Task)
+
+	// Ack marks a delivered job as successfully processed.
+	Ack(Job) error
+
+	// Fail marks a delivered job as failed with a given reason.
+	Fail(Job, error) error
+
+	// Cancel removes a job (by its identifier) that wasn't delivered yet.
+	Cancel(string) error
+
+	// Stop finishes the consuming loop, waits for running jobs and releases
+	// any resource held by queue.
+	Stop()
+}
+
+// Pinger is implemented by queues able to check their connectivity with the
+// service which stores jobs.
+type Pinger interface {
+	Ping() error
+}
+
+// Sizer is implemented by queues able to report how many jobs are waiting to
+// be delivered (including the delayed ones).
+type Sizer interface {
+	Size() (int, error)
+}
+
+var queueInstance Queue
+
+// NewQueue creates a new instance of Queue whose backend is chosen by the
+// scheme of rawURL: "mongodb" (backed by monsterqueue), "redis" (backed by
+// Redis streams) or "memory" (an in-process queue).
+func NewQueue(rawURL string) (Queue, error) {
+
+	queueURL, err := url.Parse(rawURL)
 
-// NewQueue creates a new instance of Queue (backed by monsterqueue.MongoDB).
-func NewQueue(rawURL string) (monsterqueue.Queue, error) {
+	if err != nil {
+		return nil, err
+	}
 
-	return mongodb.NewQueue(mongodb.QueueConfig{
-		Url: rawURL,
-	})
+	switch queueURL.Scheme {
+	case "mongodb":
+		return NewMonsterQueue(rawURL)
+	case "redis":
+		return NewRedisQueue(queueURL)
+	case "memory":
+		return NewMemoryQueue(), nil
+	default:
+		return nil, fmt.Errorf("%s: %q", ErrUnsupportedBackend, queueURL.Scheme)
+	}
 }
 
 // SetQueue sets the queue parameter to be used globally by any that calls
 // GetQueue function.
-func SetQueue(queue monsterqueue.Queue) {
+func SetQueue(queue Queue) {
 	queueInstance = queue
 }
 
 // GetQueue returns the current queue instance. Make sure you set a queue
 // instance (calling the SetQueue function) before.
-func GetQueue() monsterqueue.Queue {
+func GetQueue() Queue {
 	return queueInstance
 }
@@ -0,0 +1,24 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQueue(t *testing.T) {
+	t.Run(`When URL scheme is memory, should return a MemoryQueue instance`, func(t *testing.T) {
+		q, err := NewQueue("memory://")
+
+		require.NoError(t, err)
+		assert.IsType(t, &MemoryQueue{}, q)
+	})
+
+	t.Run(`When URL scheme is not supported, should return an error`, func(t *testing.T) {
+		_, err := NewQueue("amqp://localhost:5672/")
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), ErrUnsupportedBackend.Error())
+	})
+}
@@ -0,0 +1,697 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRedisStream    = `cst:scans`
+	defaultRedisGroup     = `cst-workers`
+	defaultRedisClaimIdle = 5 * time.Minute
+
+	redisBlockTimeout = time.Second
+	redisMaxBackoff   = 30 * time.Second
+
+	// redisPendingCount is how many pending jobs are inspected on each
+	// XPENDING page.
+	redisPendingCount = 100
+
+	// redisDelayedPrefix distinguishes the identifiers of delayed jobs from
+	// the stream entries' ones.
+	redisDelayedPrefix = `delayed-`
+)
+
+// RedisQueue implements a Queue interface backed by Redis streams. Jobs are
+// appended on a stream and consumed through a consumer group, so each job is
+// delivered to only one worker. Delayed jobs wait on a sorted set (scored by
+// their due time) until a consumer moves them to stream.
+//
+// Jobs delivered to a consumer which hasn't acknowledged them for claimIdle
+// (e.g. its worker has crashed) are removed by the other consumers, rather
+// than delivered again: their scans are recovered by the worker's Reaper, once
+// their leases expire. Running jobs are claimed again by their own consumer
+// periodically, so they don't look idle.
+type RedisQueue struct {
+	url       *url.URL
+	stream    string
+	group     string
+	consumer  string
+	claimIdle time.Duration
+
+	conn *redisConn
+
+	mutex   sync.Mutex
+	running map[string]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Enqueue appends a new job on stream. Delayed jobs are kept on a hash (by
+// their identifiers) and their identifiers on the delayed set.
+func (rq *RedisQueue) Enqueue(job Job) (string, error) {
+
+	job.ID = ""
+
+	data, err := json.Marshal(job)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !job.Due(time.Now()) {
+		reply, err := rq.conn.do("INCR", rq.delayedKey()+":seq")
+
+		if err != nil {
+			return "", err
+		}
+
+		sequence, _ := reply.(int64)
+		id := redisDelayedPrefix + strconv.FormatInt(sequence, 10)
+
+		if _, err := rq.conn.do("HSET", rq.delayedJobsKey(), id, string(data)); err != nil {
+			return "", err
+		}
+
+		score := strconv.FormatInt(job.NotBefore.UnixNano()/int64(time.Millisecond), 10)
+
+		if _, err := rq.conn.do("ZADD", rq.delayedKey(), score, id); err != nil {
+			return "", err
+		}
+
+		return id, nil
+	}
+
+	reply, err := rq.conn.do("XADD", rq.stream, "*", "job", string(data))
+
+	if err != nil {
+		return "", err
+	}
+
+	id, _ := reply.(string)
+
+	return id, nil
+}
+
+// Consume reads new jobs from stream (as a member of consumer group) and
+// delivers them to task until Stop is called. Connection failures are retried
+// with exponential backoff.
+func (rq *RedisQueue) Consume(task Task) {
+
+	conn := &redisConn{url: rq.url}
+
+	defer conn.close()
+
+	rq.wg.Add(1)
+
+	go func() {
+		defer rq.wg.Done()
+
+		rq.claimLoop()
+	}()
+
+	blockTimeout := fmt.Sprintf("%d", redisBlockTimeout/time.Millisecond)
+
+	backoff := redisBlockTimeout
+
+	for {
+		select {
+		case <-rq.done:
+			return
+		default:
+		}
+
+		if err := rq.promoteDelayedJobs(); err != nil {
+			logrus.WithError(err).Warn("could not move delayed jobs to redis stream")
+		}
+
+		reply, err := conn.do("XREADGROUP", "GROUP", rq.group, rq.consumer,
+			"COUNT", "1", "BLOCK", blockTimeout, "STREAMS", rq.stream, ">")
+
+		if err == errNilReply {
+			backoff = redisBlockTimeout
+			continue
+		}
+
+		if err != nil {
+			logrus.WithError(err).WithField("backoff", backoff).Warn("could not read jobs from redis stream")
+
+			// the stream (and its group) is gone when Redis has restarted
+			// without persistence
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				if err := rq.createGroup(conn); err != nil {
+					logrus.WithError(err).Warn("could not create redis consumer group")
+				}
+			}
+
+			select {
+			case <-rq.done:
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > redisMaxBackoff {
+				backoff = redisMaxBackoff
+			}
+
+			continue
+		}
+
+		backoff = redisBlockTimeout
+
+		rq.deliver(task, parseRedisStreamJobs(reply))
+	}
+}
+
+// claimLoop claims, every half of claimIdle, the running jobs of this
+// consumer and removes the idle jobs of other ones, until Stop is called.
+func (rq *RedisQueue) claimLoop() {
+
+	conn := &redisConn{url: rq.url}
+
+	defer conn.close()
+
+	ticker := time.NewTicker(rq.claimIdle / 2)
+	defer ticker.Stop()
+
+	for {
+		if err := rq.claimRunningJobs(conn); err != nil {
+			logrus.WithError(err).Warn("could not refresh running jobs on redis stream")
+		}
+
+		if err := rq.removeIdleJobs(conn); err != nil {
+			logrus.WithError(err).Warn("could not remove idle jobs from redis stream")
+		}
+
+		select {
+		case <-rq.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimRunningJobs claims the jobs this consumer is running to itself,
+// resetting their idle time.
+func (rq *RedisQueue) claimRunningJobs(conn *redisConn) error {
+
+	rq.mutex.Lock()
+
+	ids := make([]string, 0, len(rq.running))
+
+	for id := range rq.running {
+		ids = append(ids, id)
+	}
+
+	rq.mutex.Unlock()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := append([]string{"XCLAIM", rq.stream, rq.group, rq.consumer, "0"}, ids...)
+
+	_, err := conn.do(append(args, "JUSTID")...)
+
+	return err
+}
+
+// removeIdleJobs removes the jobs delivered to other consumers which haven't
+// been acknowledged for claimIdle. Their ownership is taken (by XCLAIM) before
+// removing them, so a job acknowledged or removed meanwhile is left alone.
+func (rq *RedisQueue) removeIdleJobs(conn *redisConn) error {
+
+	minIdle := strconv.FormatInt(int64(rq.claimIdle/time.Millisecond), 10)
+
+	for start := "-"; ; {
+		reply, err := conn.do("XPENDING", rq.stream, rq.group, start, "+", strconv.Itoa(redisPendingCount))
+
+		if err != nil {
+			return err
+		}
+
+		entries := parseRedisPendingEntries(reply)
+
+		var ids []string
+
+		for _, entry := range entries {
+			if entry.consumer != rq.consumer && entry.idle >= rq.claimIdle {
+				ids = append(ids, entry.id)
+			}
+		}
+
+		if len(ids) > 0 {
+			reply, err := conn.do(append([]string{"XCLAIM", rq.stream, rq.group, rq.consumer, minIdle}, ids...)...)
+
+			if err != nil {
+				return err
+			}
+
+			for _, job := range parseRedisStreamJobs([]interface{}{[]interface{}{rq.stream, reply}}) {
+				logrus.
+					WithField("job.id", job.ID).
+					WithField("scan.id", job.ScanID).
+					Warn("removing job idle on another consumer, its scan is left to the reaper")
+			}
+
+			for _, id := range ids {
+				if _, err := conn.do("XACK", rq.stream, rq.group, id); err != nil {
+					return err
+				}
+
+				if _, err := conn.do("XDEL", rq.stream, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(entries) < redisPendingCount {
+			return nil
+		}
+
+		start = nextRedisStreamID(entries[len(entries)-1].id)
+	}
+}
+
+// deliver runs task over each job on its own goroutine, keeping them as
+// running until they're acknowledged (or failed).
+func (rq *RedisQueue) deliver(task Task, jobs []Job) {
+
+	for _, job := range jobs {
+		rq.mutex.Lock()
+		rq.running[job.ID] = true
+		rq.mutex.Unlock()
+
+		rq.wg.Add(1)
+
+		go func(job Job) {
+			defer rq.wg.Done()
+
+			task.Run(job)
+		}(job)
+	}
+}
+
+// Ack acknowledges the job on consumer group and removes it from stream.
+func (rq *RedisQueue) Ack(job Job) error {
+	return rq.remove(job.ID)
+}
+
+// Fail removes the job from stream and appends it, with the failure reason,
+// on the failed jobs stream.
+func (rq *RedisQueue) Fail(job Job, reason error) error {
+
+	if err := rq.remove(job.ID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(job)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = rq.conn.do("XADD", rq.stream+":failed", "*", "job", string(data), "error", reason.Error())
+
+	return err
+}
+
+// Cancel removes a job from stream, or from the delayed ones when it isn't
+// due yet.
+func (rq *RedisQueue) Cancel(id string) error {
+
+	if strings.HasPrefix(id, redisDelayedPrefix) {
+		reply, err := rq.conn.do("ZREM", rq.delayedKey(), id)
+
+		if err != nil {
+			return err
+		}
+
+		if removed, _ := reply.(int64); removed == 0 {
+			return ErrJobNotFound
+		}
+
+		_, err = rq.conn.do("HDEL", rq.delayedJobsKey(), id)
+
+		return err
+	}
+
+	reply, err := rq.conn.do("XDEL", rq.stream, id)
+
+	if err != nil {
+		return err
+	}
+
+	if deleted, _ := reply.(int64); deleted == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// Ping checks the connection with Redis service.
+func (rq *RedisQueue) Ping() error {
+
+	_, err := rq.conn.do("PING")
+
+	return err
+}
+
+// Size returns how many jobs weren't delivered to any consumer yet, adding
+// the delayed ones.
+func (rq *RedisQueue) Size() (int, error) {
+
+	reply, err := rq.conn.do("XINFO", "GROUPS", rq.stream)
+
+	if err != nil {
+		return 0, err
+	}
+
+	length, err := rq.conn.do("XLEN", rq.stream)
+
+	if err != nil {
+		return 0, err
+	}
+
+	delayed, err := rq.conn.do("ZCARD", rq.delayedKey())
+
+	if err != nil {
+		return 0, err
+	}
+
+	streamLength, _ := length.(int64)
+	delayedLength, _ := delayed.(int64)
+
+	return int(streamLength-redisGroupPending(reply, rq.group)) + int(delayedLength), nil
+}
+
+// Stop finishes the consuming loop, waits for running jobs and closes the
+// connection with Redis service.
+func (rq *RedisQueue) Stop() {
+
+	close(rq.done)
+	rq.wg.Wait()
+
+	rq.conn.close()
+}
+
+// promoteDelayedJobs moves the due jobs from delayed set (and hash) to stream.
+// Only the consumer which succeeds removing a job from set appends it on
+// stream.
+func (rq *RedisQueue) promoteDelayedJobs() error {
+
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+
+	reply, err := rq.conn.do("ZRANGEBYSCORE", rq.delayedKey(), "-inf", now, "LIMIT", "0", "100")
+
+	if err != nil {
+		return err
+	}
+
+	members, _ := reply.([]interface{})
+
+	for _, member := range members {
+		id, _ := member.(string)
+
+		reply, err := rq.conn.do("ZREM", rq.delayedKey(), id)
+
+		if err != nil {
+			return err
+		}
+
+		if removed, _ := reply.(int64); removed == 0 {
+			continue
+		}
+
+		data := id
+
+		// jobs delayed by former versions are kept on the set itself
+		if !strings.HasPrefix(id, "{") {
+			reply, err = rq.conn.do("HGET", rq.delayedJobsKey(), id)
+
+			if err != nil {
+				return err
+			}
+
+			data, _ = reply.(string)
+		}
+
+		if _, err := rq.conn.do("XADD", rq.stream, "*", "job", data); err != nil {
+			return err
+		}
+
+		if _, err := rq.conn.do("HDEL", rq.delayedJobsKey(), id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rq *RedisQueue) delayedKey() string {
+	return rq.stream + ":delayed"
+}
+
+func (rq *RedisQueue) delayedJobsKey() string {
+	return rq.stream + ":delayed:jobs"
+}
+
+func (rq *RedisQueue) remove(id string) error {
+
+	rq.mutex.Lock()
+	delete(rq.running, id)
+	rq.mutex.Unlock()
+
+	reply, err := rq.conn.do("XACK", rq.stream, rq.group, id)
+
+	if err != nil {
+		return err
+	}
+
+	if acked, _ := reply.(int64); acked == 0 {
+		return ErrJobNotFound
+	}
+
+	_, err = rq.conn.do("XDEL", rq.stream, id)
+
+	return err
+}
+
+// createGroup creates the consumer group (and the stream, when missing).
+func (rq *RedisQueue) createGroup(conn *redisConn) error {
+
+	_, err := conn.do("XGROUP", "CREATE", rq.stream, rq.group, "0", "MKSTREAM")
+
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// redisPendingEntry is a job delivered to a consumer and not acknowledged
+// yet, idle since its last delivery.
+type redisPendingEntry struct {
+	id       string
+	consumer string
+	idle     time.Duration
+}
+
+// parseRedisPendingEntries extracts the pending entries from a XPENDING
+// reply (extended form), in the form: [[id, consumer, idle, deliveries], ...].
+func parseRedisPendingEntries(reply interface{}) []redisPendingEntry {
+
+	var entries []redisPendingEntry
+
+	items, _ := reply.([]interface{})
+
+	for _, item := range items {
+		fields, _ := item.([]interface{})
+
+		if len(fields) < 3 {
+			continue
+		}
+
+		id, _ := fields[0].(string)
+		consumer, _ := fields[1].(string)
+		idle, _ := fields[2].(int64)
+
+		entries = append(entries, redisPendingEntry{
+			id:       id,
+			consumer: consumer,
+			idle:     time.Duration(idle) * time.Millisecond,
+		})
+	}
+
+	return entries
+}
+
+// nextRedisStreamID returns the smallest stream entry identifier greater than
+// id (e.g. "1536612345678-1" for "1536612345678-0"), so pages of entries can
+// be read without the exclusive ranges of Redis 6.2.
+func nextRedisStreamID(id string) string {
+
+	milliseconds, sequence := id, "0"
+
+	if index := strings.IndexByte(id, '-'); index >= 0 {
+		milliseconds, sequence = id[:index], id[index+1:]
+	}
+
+	next, err := strconv.ParseUint(sequence, 10, 64)
+
+	if err != nil || next == math.MaxUint64 {
+		value, _ := strconv.ParseUint(milliseconds, 10, 64)
+
+		return strconv.FormatUint(value+1, 10) + "-0"
+	}
+
+	return milliseconds + "-" + strconv.FormatUint(next+1, 10)
+}
+
+// redisGroupPending extracts how many jobs were delivered and not acknowledged
+// yet on a consumer group from a XINFO GROUPS reply, in the form:
+// [[name, group, consumers, n, pending, n, ...], ...].
+func redisGroupPending(reply interface{}, group string) int64 {
+
+	groups, _ := reply.([]interface{})
+
+	for _, groupInfo := range groups {
+		fields, _ := groupInfo.([]interface{})
+
+		info := make(map[string]interface{}, len(fields)/2)
+
+		for index := 0; index+1 < len(fields); index += 2 {
+			name, _ := fields[index].(string)
+			info[name] = fields[index+1]
+		}
+
+		if name, _ := info["name"].(string); name != group {
+			continue
+		}
+
+		pending, _ := info["pending"].(int64)
+
+		return pending
+	}
+
+	return 0
+}
+
+// parseRedisStreamJobs extracts the jobs from a XREADGROUP reply, in the form:
+// [[stream, [[id, [field, value, ...]], ...]], ...].
+func parseRedisStreamJobs(reply interface{}) []Job {
+
+	var jobs []Job
+
+	streams, _ := reply.([]interface{})
+
+	for _, stream := range streams {
+		streamData, _ := stream.([]interface{})
+
+		if len(streamData) != 2 {
+			continue
+		}
+
+		entries, _ := streamData[1].([]interface{})
+
+		for _, entry := range entries {
+			entryData, _ := entry.([]interface{})
+
+			if len(entryData) != 2 {
+				continue
+			}
+
+			id, _ := entryData[0].(string)
+			fields, _ := entryData[1].([]interface{})
+
+			for index := 0; index+1 < len(fields); index += 2 {
+				if name, _ := fields[index].(string); name != "job" {
+					continue
+				}
+
+				value, _ := fields[index+1].(string)
+
+				var job Job
+
+				if err := json.Unmarshal([]byte(value), &job); err != nil {
+					logrus.
+						WithField("job.id", id).
+						WithError(err).
+						Warn("ignoring malformed job from redis stream")
+
+					continue
+				}
+
+				job.ID = id
+				jobs = append(jobs, job)
+			}
+		}
+	}
+
+	return jobs
+}
+
+// NewRedisQueue creates a new instance of RedisQueue, ensuring the stream and
+// its consumer group exist. Stream and group names can be assigned by the
+// "stream" and "group" query params on redisURL (e.g.
+// redis://localhost:6379/0?stream=cst:scans&group=cst-workers), and how long
+// jobs are idle before being claimed by other consumers by "claim-idle"
+// (e.g. claim-idle=10m). Returns an error if Redis service is unavailable.
+func NewRedisQueue(redisURL *url.URL) (*RedisQueue, error) {
+
+	claimIdle := defaultRedisClaimIdle
+
+	if value := redisURL.Query().Get("claim-idle"); value != "" {
+		duration, err := time.ParseDuration(value)
+
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid claim-idle on redis URL: %q", value)
+		}
+
+		claimIdle = duration
+	}
+
+	conn, err := dialRedis(redisURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+
+	rq := &RedisQueue{
+		url:       redisURL,
+		stream:    redisURL.Query().Get("stream"),
+		group:     redisURL.Query().Get("group"),
+		consumer:  fmt.Sprintf("%s_%d", hostname, os.Getpid()),
+		claimIdle: claimIdle,
+		conn:      conn,
+		running:   make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+
+	if rq.stream == "" {
+		rq.stream = defaultRedisStream
+	}
+
+	if rq.group == "" {
+		rq.group = defaultRedisGroup
+	}
+
+	if err := rq.createGroup(conn); err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	return rq, nil
+}
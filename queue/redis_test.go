@@ -0,0 +1,354 @@
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRedisReply(t *testing.T) {
+	t.Run(`Ensure nested arrays and bulk strings are decoded`, func(t *testing.T) {
+		raw := "*2\r\n$9\r\ncst:scans\r\n*2\r\n:42\r\n$-1\r\n"
+
+		reply, err := readRedisReply(bufio.NewReader(strings.NewReader(raw)))
+
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{"cst:scans", []interface{}{int64(42), nil}}, reply)
+	})
+
+	t.Run(`When Redis replies an error, should return it`, func(t *testing.T) {
+		_, err := readRedisReply(bufio.NewReader(strings.NewReader("-BUSYGROUP Consumer Group name already exists\r\n")))
+
+		require.Error(t, err)
+		assert.True(t, strings.HasPrefix(err.Error(), "BUSYGROUP"))
+	})
+}
+
+func TestParseRedisStreamJobs(t *testing.T) {
+	t.Run(`Ensure jobs are extracted from XREADGROUP reply`, func(t *testing.T) {
+		reply := []interface{}{
+			[]interface{}{
+				"cst:scans",
+				[]interface{}{
+					[]interface{}{
+						"1536612345678-0",
+						[]interface{}{"job", `{"scanID":"1","image":"tsuru/cst:latest"}`},
+					},
+					[]interface{}{
+						"1536612345679-0",
+						[]interface{}{"job", `malformed`},
+					},
+				},
+			},
+		}
+
+		jobs := parseRedisStreamJobs(reply)
+
+		expected := []Job{
+			Job{
+				ID:     "1536612345678-0",
+				ScanID: "1",
+				Image:  "tsuru/cst:latest",
+			},
+		}
+
+		assert.Equal(t, expected, jobs)
+	})
+}
+
+func TestNextRedisStreamID(t *testing.T) {
+	t.Run(`Ensure the next identifier of a stream entry is returned`, func(t *testing.T) {
+		assert.Equal(t, "1536612345678-1", nextRedisStreamID("1536612345678-0"))
+		assert.Equal(t, "1536612345678-43", nextRedisStreamID("1536612345678-42"))
+		assert.Equal(t, "1536612345679-0", nextRedisStreamID("1536612345678-18446744073709551615"))
+	})
+}
+
+func TestRedisGroupPending(t *testing.T) {
+	t.Run(`Ensure pending count is extracted from XINFO GROUPS reply`, func(t *testing.T) {
+		reply := []interface{}{
+			[]interface{}{"name", "another-group", "consumers", int64(1), "pending", int64(7), "last-delivered-id", "0-0"},
+			[]interface{}{"name", "cst-workers", "consumers", int64(2), "pending", int64(3), "last-delivered-id", "0-0"},
+		}
+
+		assert.Equal(t, int64(3), redisGroupPending(reply, "cst-workers"))
+		assert.Equal(t, int64(0), redisGroupPending(reply, "unknown-group"))
+	})
+}
+
+func TestParseRedisPendingEntries(t *testing.T) {
+	t.Run(`Ensure pending entries are extracted from XPENDING reply`, func(t *testing.T) {
+		reply := []interface{}{
+			[]interface{}{"1536612345678-0", "worker-1_42", int64(360000), int64(1)},
+			[]interface{}{"1536612345679-0", "worker-2_43", int64(1500), int64(3)},
+			[]interface{}{"malformed"},
+		}
+
+		expected := []redisPendingEntry{
+			{id: "1536612345678-0", consumer: "worker-1_42", idle: 6 * time.Minute},
+			{id: "1536612345679-0", consumer: "worker-2_43", idle: 1500 * time.Millisecond},
+		}
+
+		assert.Equal(t, expected, parseRedisPendingEntries(reply))
+	})
+}
+
+func TestRedisConn(t *testing.T) {
+	t.Run(`When connection is lost, should dial Redis again on next command`, func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		defer listener.Close()
+
+		connections := make(chan int, 2)
+
+		go func() {
+			for count := 1; ; count++ {
+				conn, err := listener.Accept()
+
+				if err != nil {
+					return
+				}
+
+				connections <- count
+
+				reader := bufio.NewReader(conn)
+
+				// replies a PING on each connection, then drops it
+				if _, err := readRedisReply(reader); err == nil {
+					fmt.Fprintf(conn, "+PONG\r\n")
+				}
+
+				reader.ReadByte()
+				conn.Close()
+			}
+		}()
+
+		conn := &redisConn{url: &url.URL{Scheme: "redis", Host: listener.Addr().String()}}
+		defer conn.close()
+
+		reply, err := conn.do("PING")
+		require.NoError(t, err)
+		assert.Equal(t, "PONG", reply)
+
+		// first connection is dropped after the server reads the next command
+		_, err = conn.do("PING")
+		assert.Error(t, err)
+
+		reply, err = conn.do("PING")
+		require.NoError(t, err)
+		assert.Equal(t, "PONG", reply)
+
+		assert.Equal(t, 1, <-connections)
+		assert.Equal(t, 2, <-connections)
+	})
+}
+
+func TestRedisQueue(t *testing.T) {
+
+	rq := getRedisQueueTestingInstance(t)
+
+	defer func() {
+		rq.conn.do("DEL", rq.stream, rq.stream+":failed", rq.delayedKey())
+		rq.Stop()
+	}()
+
+	t.Run(`Ensure enqueued jobs are delivered to task and can be failed`, func(t *testing.T) {
+		delivered := make(chan Job, 1)
+
+		task := &MockTask{
+			MockRun: func(job Job) {
+				require.NoError(t, rq.Fail(job, errors.New("just another error")))
+
+				delivered <- job
+			},
+		}
+
+		id, err := rq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest"})
+		require.NoError(t, err)
+
+		go rq.Consume(task)
+
+		select {
+		case job := <-delivered:
+			assert.Equal(t, id, job.ID)
+			assert.Equal(t, "tsuru/cst:latest", job.Image)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "job was not delivered")
+		}
+
+		assert.Equal(t, ErrJobNotFound, rq.Cancel(id))
+	})
+}
+
+func TestRedisQueue_Cancel(t *testing.T) {
+
+	rq := getRedisQueueTestingInstance(t)
+
+	defer func() {
+		rq.conn.do("DEL", rq.stream, rq.delayedKey(), rq.delayedJobsKey())
+		rq.Stop()
+	}()
+
+	t.Run(`When job is delayed, should remove it from delayed jobs`, func(t *testing.T) {
+		id, err := rq.Enqueue(Job{ScanID: "1", Image: "tsuru/cst:latest", NotBefore: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(id, redisDelayedPrefix))
+
+		size, err := rq.Size()
+		require.NoError(t, err)
+		assert.Equal(t, 1, size)
+
+		require.NoError(t, rq.Cancel(id))
+		assert.Equal(t, ErrJobNotFound, rq.Cancel(id))
+
+		size, err = rq.Size()
+		require.NoError(t, err)
+		assert.Equal(t, 0, size)
+
+		reply, err := rq.conn.do("HLEN", rq.delayedJobsKey())
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), reply)
+	})
+
+	t.Run(`Ensure due delayed jobs are moved to stream`, func(t *testing.T) {
+		_, err := rq.Enqueue(Job{ScanID: "2", Image: "tsuru/cst:latest", NotBefore: time.Now().Add(50 * time.Millisecond)})
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+
+		require.NoError(t, rq.promoteDelayedJobs())
+
+		reply, err := rq.conn.do("XRANGE", rq.stream, "-", "+")
+		require.NoError(t, err)
+
+		jobs := parseRedisStreamJobs([]interface{}{[]interface{}{rq.stream, reply}})
+
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "2", jobs[0].ScanID)
+
+		reply, err = rq.conn.do("HLEN", rq.delayedJobsKey())
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), reply)
+	})
+}
+
+func TestRedisQueue_Claim(t *testing.T) {
+
+	rq := getRedisQueueTestingInstance(t)
+	rq.claimIdle = 200 * time.Millisecond
+
+	defer func() {
+		rq.conn.do("DEL", rq.stream, rq.stream+":failed", rq.delayedKey())
+		rq.Stop()
+	}()
+
+	// jobs read by another worker which has crashed, more than a page of
+	// pending entries
+	for index := 0; index < redisPendingCount+10; index++ {
+		_, err := rq.Enqueue(Job{ScanID: fmt.Sprint(index), Image: "tsuru/cst:latest"})
+		require.NoError(t, err)
+	}
+
+	reply, err := rq.conn.do("XREADGROUP", "GROUP", rq.group, "crashed-worker_1", "COUNT", "1000", "STREAMS", rq.stream, ">")
+	require.NoError(t, err)
+	require.Len(t, parseRedisStreamJobs(reply), redisPendingCount+10)
+
+	delivered := make(chan Job, 1)
+	release := make(chan struct{})
+
+	task := &MockTask{
+		MockRun: func(job Job) {
+			delivered <- job
+			<-release
+
+			require.NoError(t, rq.Ack(job))
+		},
+	}
+
+	t.Run(`Ensure jobs idle on other consumers are removed rather than delivered to task`, func(t *testing.T) {
+		time.Sleep(rq.claimIdle)
+
+		require.NoError(t, rq.removeIdleJobs(rq.conn))
+
+		length, err := rq.conn.do("XLEN", rq.stream)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), length)
+
+		reply, err := rq.conn.do("XPENDING", rq.stream, rq.group, "-", "+", "1000")
+		require.NoError(t, err)
+		assert.Empty(t, parseRedisPendingEntries(reply))
+	})
+
+	go rq.Consume(task)
+
+	t.Run(`Ensure running jobs aren't removed by other consumers`, func(t *testing.T) {
+		id, err := rq.Enqueue(Job{ScanID: "running", Image: "tsuru/cst:latest"})
+		require.NoError(t, err)
+
+		select {
+		case job := <-delivered:
+			assert.Equal(t, id, job.ID)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "job was not delivered")
+		}
+
+		other := getRedisQueueTestingInstance(t)
+		other.consumer = "other-worker_2"
+		other.claimIdle = rq.claimIdle
+
+		defer other.conn.close()
+
+		for index := 0; index < 5; index++ {
+			time.Sleep(rq.claimIdle / 2)
+
+			require.NoError(t, other.removeIdleJobs(other.conn))
+
+			reply, err := rq.conn.do("XPENDING", rq.stream, rq.group, "-", "+", "10")
+			require.NoError(t, err)
+			assert.Len(t, parseRedisPendingEntries(reply), 1)
+		}
+
+		release <- struct{}{}
+	})
+
+	t.Run(`Ensure running jobs are acknowledged`, func(t *testing.T) {
+		time.Sleep(100 * time.Millisecond)
+
+		reply, err := rq.conn.do("XPENDING", rq.stream, rq.group, "-", "+", "10")
+		require.NoError(t, err)
+		assert.Empty(t, parseRedisPendingEntries(reply))
+	})
+}
+
+func getRedisQueueTestingInstance(t *testing.T) *RedisQueue {
+
+	rawURL := os.Getenv("REDIS_URL")
+
+	if rawURL == "" {
+		t.Skip("redis connection url are not assigned, skipping integration tests")
+	}
+
+	redisURL, err := url.Parse(rawURL)
+
+	require.NoError(t, err)
+
+	query := redisURL.Query()
+	query.Set("stream", "cst:testing")
+	redisURL.RawQuery = query.Encode()
+
+	rq, err := NewRedisQueue(redisURL)
+
+	require.NoError(t, err, "could not connect with redis service")
+
+	return rq
+}
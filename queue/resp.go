@@ -0,0 +1,219 @@
+package queue
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNilReply indicates Redis replied a null bulk string or array.
+var errNilReply = errors.New(`redis: nil reply`)
+
+// redisError holds an error reply sent by Redis service.
+type redisError string
+
+func (re redisError) Error() string {
+	return string(re)
+}
+
+// redisIOTimeout bounds how long a command (including blocking ones) waits
+// for Redis' reply, so half-open connections are detected.
+const redisIOTimeout = 10 * time.Second
+
+// redisConn is a minimal client of the Redis serialization protocol (RESP)
+// able to issue one command at a time over a single connection. Broken
+// connections (e.g. after Redis restarts) are dropped and dialed again on
+// the next command.
+type redisConn struct {
+	mutex  sync.Mutex
+	url    *url.URL
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command to Redis and returns its decoded reply. Replies are
+// decoded as: string (simple and bulk strings), int64 (integers) and
+// []interface{} (arrays).
+func (rc *redisConn) do(args ...string) (interface{}, error) {
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.conn == nil {
+		if err := rc.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.exchange(args...)
+
+	if _, ok := err.(redisError); err != nil && !ok && err != errNilReply {
+		rc.conn.Close()
+		rc.conn = nil
+	}
+
+	return reply, err
+}
+
+func (rc *redisConn) close() error {
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if rc.conn == nil {
+		return nil
+	}
+
+	err := rc.conn.Close()
+	rc.conn = nil
+
+	return err
+}
+
+// connect dials Redis service described by url (e.g.
+// redis://:password@localhost:6379/0), authenticating and selecting the
+// database when required.
+func (rc *redisConn) connect() error {
+
+	address := rc.url.Host
+
+	if rc.url.Port() == "" {
+		address = net.JoinHostPort(rc.url.Hostname(), "6379")
+	}
+
+	conn, err := net.DialTimeout("tcp", address, redisIOTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	rc.conn = conn
+	rc.reader = bufio.NewReader(conn)
+
+	if password, ok := rc.url.User.Password(); ok {
+		if _, err := rc.exchange("AUTH", password); err != nil {
+			rc.conn.Close()
+			rc.conn = nil
+			return err
+		}
+	}
+
+	if database := strings.Trim(rc.url.Path, "/"); database != "" {
+		if _, err := rc.exchange("SELECT", database); err != nil {
+			rc.conn.Close()
+			rc.conn = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exchange writes a command on connection and reads its reply.
+func (rc *redisConn) exchange(args ...string) (interface{}, error) {
+
+	var command strings.Builder
+
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	rc.conn.SetDeadline(time.Now().Add(redisIOTimeout))
+
+	if _, err := io.WriteString(rc.conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(rc.reader)
+}
+
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+
+	if len(line) == 0 {
+		return nil, errors.New(`redis: empty reply`)
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 {
+			return nil, errNilReply
+		}
+
+		data := make([]byte, size+2)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 {
+			return nil, errNilReply
+		}
+
+		items := make([]interface{}, size)
+
+		for index := range items {
+			item, err := readRedisReply(reader)
+
+			if err != nil && err != errNilReply {
+				return nil, err
+			}
+
+			items[index] = item
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+// dialRedis connects on Redis service described by redisURL (e.g.
+// redis://:password@localhost:6379/0), authenticating and selecting the
+// database when required.
+func dialRedis(redisURL *url.URL) (*redisConn, error) {
+
+	rc := &redisConn{url: redisURL}
+
+	if err := rc.connect(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
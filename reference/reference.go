@@ -0,0 +1,191 @@
+// Package reference parses and normalizes container image references (e.g.
+// "nginx" or "registry.tld:5000/tsuru/cst:latest"), following the grammar of
+// Docker's distribution project:
+//
+//	reference := name [ ":" tag ] [ "@" digest ]
+//	name      := [ domain "/" ] path-component [ "/" path-component ]*
+//	domain    := domain-component [ "." domain-component ]* [ ":" port ]
+//	tag       := [\w][\w.-]{0,127}
+//	digest    := algorithm ":" hex
+package reference
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain is the registry assumed for references without domain.
+	DefaultDomain = "docker.io"
+
+	// DefaultTag is the tag assumed for references without tag and digest.
+	DefaultTag = "latest"
+
+	officialRepositoryPrefix = "library/"
+
+	nameMaxLength = 255
+)
+
+var (
+	// ErrReferenceInvalidFormat indicates the reference doesn't follow the
+	// grammar.
+	ErrReferenceInvalidFormat = errors.New("invalid reference format")
+
+	// ErrNameEmpty indicates the reference has no repository name.
+	ErrNameEmpty = errors.New("repository name must have at least one component")
+
+	// ErrNameContainsUppercase indicates the repository name has uppercase
+	// letters, which aren't allowed.
+	ErrNameContainsUppercase = errors.New("repository name must be lowercase")
+
+	// ErrNameTooLong indicates the repository name is longer than 255
+	// characters.
+	ErrNameTooLong = errors.New("repository name must not be more than 255 characters")
+
+	// ErrDigestInvalidFormat indicates the reference's digest is malformed.
+	ErrDigestInvalidFormat = errors.New("invalid digest format")
+)
+
+var (
+	domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domain          = domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+	pathComponent   = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+	name            = `(?:` + domain + `/)?` + pathComponent + `(?:/` + pathComponent + `)*`
+	tag             = `[\w][\w.-]{0,127}`
+	digest          = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+
+	referenceRegexp = regexp.MustCompile(`^(` + name + `)(?::(` + tag + `))?(?:@(` + digest + `))?$`)
+	domainRegexp    = regexp.MustCompile(`^` + domain + `$`)
+	sha256Regexp    = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference is a parsed container image reference.
+type Reference struct {
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// Name returns the repository name, e.g. "docker.io/library/nginx".
+func (r Reference) Name() string {
+
+	if r.Domain == "" {
+		return r.Path
+	}
+
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the reference on its textual form, e.g.
+// "docker.io/library/nginx:latest".
+func (r Reference) String() string {
+
+	s := r.Name()
+
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+
+	return s
+}
+
+// Parse parses a reference as is, without assuming domain or tag.
+func Parse(s string) (Reference, error) {
+
+	matches := referenceRegexp.FindStringSubmatch(s)
+
+	if matches == nil {
+		switch {
+		case s == "":
+			return Reference{}, ErrNameEmpty
+		case referenceRegexp.MatchString(strings.ToLower(s)):
+			return Reference{}, ErrNameContainsUppercase
+		default:
+			return Reference{}, ErrReferenceInvalidFormat
+		}
+	}
+
+	if len(matches[1]) > nameMaxLength {
+		return Reference{}, ErrNameTooLong
+	}
+
+	ref := Reference{Tag: matches[2], Digest: matches[3]}
+
+	if ref.Digest != "" && strings.HasPrefix(ref.Digest, "sha256:") && !sha256Regexp.MatchString(ref.Digest) {
+		return Reference{}, ErrDigestInvalidFormat
+	}
+
+	ref.Domain, ref.Path = splitDomain(matches[1])
+
+	return ref, nil
+}
+
+// ParseNormalized parses a reference the way Docker does, assuming the
+// Docker Hub domain, the "library/" namespace of official images and the
+// "latest" tag when they're missing, e.g. "nginx" is parsed as
+// "docker.io/library/nginx:latest".
+func ParseNormalized(s string) (Reference, error) {
+
+	ref, err := Parse(s)
+
+	if err != nil {
+		return Reference{}, err
+	}
+
+	switch ref.Domain {
+	case "", "index.docker.io":
+		ref.Domain = DefaultDomain
+	}
+
+	if ref.Domain == DefaultDomain && !strings.Contains(ref.Path, "/") {
+		ref.Path = officialRepositoryPrefix + ref.Path
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+
+	return ref, nil
+}
+
+// Canonicalize returns the normalized form of a reference (see
+// ParseNormalized), e.g. "nginx" returns "docker.io/library/nginx:latest".
+func Canonicalize(s string) (string, error) {
+
+	ref, err := ParseNormalized(s)
+
+	if err != nil {
+		return "", err
+	}
+
+	return ref.String(), nil
+}
+
+// splitDomain takes the first component of name as domain when it looks like
+// a host (i.e. has dots, a port or it's "localhost").
+func splitDomain(name string) (string, string) {
+
+	index := strings.Index(name, "/")
+
+	if index < 0 {
+		return "", name
+	}
+
+	first := name[:index]
+
+	if first != "localhost" && !strings.ContainsAny(first, ".:") && strings.ToLower(first) == first {
+		return "", name
+	}
+
+	if !domainRegexp.MatchString(first) {
+		return "", name
+	}
+
+	return first, name[index+1:]
+}
@@ -0,0 +1,71 @@
+package reference
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	digest := "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"
+
+	cases := []struct {
+		reference string
+		expected  string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"nginx:1.15", "docker.io/library/nginx:1.15"},
+		{"library/nginx", "docker.io/library/nginx:latest"},
+		{"docker.io/nginx", "docker.io/library/nginx:latest"},
+		{"index.docker.io/tsuru/cst:v1", "docker.io/tsuru/cst:v1"},
+		{"tsuru/cst", "docker.io/tsuru/cst:latest"},
+		{"registry.tld:5000/tsuru/cst", "registry.tld:5000/tsuru/cst:latest"},
+		{"localhost/cst:dev", "localhost/cst:dev"},
+		{"Registry.TLD/tsuru/cst:V1", "Registry.TLD/tsuru/cst:V1"},
+		{"nginx@" + digest, "docker.io/library/nginx@" + digest},
+		{"nginx:1.15@" + digest, "docker.io/library/nginx:1.15@" + digest},
+	}
+
+	for _, c := range cases {
+		got, err := Canonicalize(c.reference)
+
+		require.NoError(t, err, c.reference)
+		assert.Equal(t, c.expected, got, c.reference)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Run(`Ensure components of a complete reference are parsed`, func(t *testing.T) {
+		ref, err := Parse("registry.tld:5000/tsuru/cst:v1.0")
+
+		require.NoError(t, err)
+		assert.Equal(t, Reference{Domain: "registry.tld:5000", Path: "tsuru/cst", Tag: "v1.0"}, ref)
+		assert.Equal(t, "registry.tld:5000/tsuru/cst", ref.Name())
+	})
+
+	t.Run(`When reference is malformed, should return a descriptive error`, func(t *testing.T) {
+		cases := []struct {
+			reference string
+			err       error
+		}{
+			{"", ErrNameEmpty},
+			{"Tsuru/CST", ErrNameContainsUppercase},
+			{"tsuru/cst latest", ErrReferenceInvalidFormat},
+			{"tsuru/cst:", ErrReferenceInvalidFormat},
+			{"tsuru//cst", ErrReferenceInvalidFormat},
+			{":latest", ErrReferenceInvalidFormat},
+			{"tsuru/cst@sha256:abc", ErrReferenceInvalidFormat},
+			{"tsuru/cst@sha256:" + strings.Repeat("g", 64), ErrReferenceInvalidFormat},
+			{"tsuru/cst@sha256:" + strings.Repeat("a", 32), ErrDigestInvalidFormat},
+			{strings.Repeat("a", 256), ErrNameTooLong},
+		}
+
+		for _, c := range cases {
+			_, err := Parse(c.reference)
+
+			assert.Equal(t, c.err, err, c.reference)
+		}
+	})
+}
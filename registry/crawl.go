@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidPattern indicates a malformed glob pattern on a crawl spec.
+var ErrInvalidPattern = errors.New("invalid pattern on crawl spec")
+
+// CrawlSpec describes which images of a registry should be scanned.
+type CrawlSpec struct {
+	// Target is a repository (e.g. "registry.tld/team/app") or a glob
+	// pattern of repositories (e.g. "registry.tld/team/*") listed from the
+	// registry's catalog. Like in path.Match, "*" doesn't match "/", but a
+	// "**" component matches any number of components (e.g.
+	// "registry.tld/team/**" matches "team/app" and "team/sub/app").
+	//
+	// Plain HTTP registries are crawled when given with the "http://"
+	// prefix. The crawled images are named without it, so workers pull them
+	// over plain HTTP only when the registry is among their insecure ones.
+	Target string `json:"target"`
+
+	// Include and Exclude are glob patterns (like Target's) matched against
+	// the images' "repository:tag" (without registry host), e.g.
+	// "team/*:v*" or "team/**/*:v*". Images must match some Include pattern
+	// (when given) and no Exclude pattern.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	// LatestTags keeps only the N latest tags of each repository, by version
+	// order (e.g. "v1.10" is newer than "v1.9"). All tags are kept when zero.
+	LatestTags int `json:"latestTags,omitempty"`
+}
+
+// Crawler expands crawl specs into images, using the Registry's catalog and
+// tags APIs.
+type Crawler struct {
+	Credentials Credentials
+}
+
+// Crawl returns the images (e.g. "registry.tld/team/app:v1") matching spec.
+func (c *Crawler) Crawl(spec CrawlSpec) ([]string, error) {
+
+	if err := validatePatterns(spec); err != nil {
+		return nil, err
+	}
+
+	address, prefix, pattern := parseTarget(spec.Target)
+
+	client := c.Credentials.Client(address)
+
+	repositories := []string{pattern}
+
+	listed := strings.ContainsAny(pattern, "*?[")
+
+	if listed {
+		catalog, err := client.Catalog()
+
+		if err != nil {
+			return nil, err
+		}
+
+		repositories = repositories[:0]
+
+		for _, repository := range catalog {
+			if match(pattern, repository) {
+				repositories = append(repositories, repository)
+			}
+		}
+	}
+
+	var images []string
+
+	for _, repository := range repositories {
+		tags, err := client.Tags(repository)
+
+		// a repository listed on the catalog may be gone or forbidden,
+		// which shouldn't keep the others from being crawled
+		if err != nil && listed {
+			logrus.
+				WithError(err).
+				WithField("crawl.target", spec.Target).
+				WithField("repository", repository).
+				Warn("could not list repository's tags, skipping it")
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(repository, "library/")
+
+		if prefix != "" {
+			name = prefix + "/" + repository
+		}
+
+		for _, tag := range spec.filterTags(repository, tags) {
+			images = append(images, name+":"+tag)
+		}
+	}
+
+	return images, nil
+}
+
+// filterTags applies the spec's patterns and latest tags limit to the tags
+// of a repository, returning them from the newest to the oldest.
+func (spec CrawlSpec) filterTags(repository string, tags []string) []string {
+
+	var filtered []string
+
+	for _, tag := range tags {
+		if spec.matches(repository + ":" + tag) {
+			filtered = append(filtered, tag)
+		}
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return CompareVersions(filtered[i], filtered[j]) > 0
+	})
+
+	if spec.LatestTags > 0 && len(filtered) > spec.LatestTags {
+		filtered = filtered[:spec.LatestTags]
+	}
+
+	return filtered
+}
+
+func (spec CrawlSpec) matches(image string) bool {
+
+	for _, pattern := range spec.Exclude {
+		if match(pattern, image) {
+			return false
+		}
+	}
+
+	if len(spec.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range spec.Include {
+		if match(pattern, image) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether name matches the glob pattern, component by
+// component (split by "/"). Components are matched by path.Match, except
+// "**" which matches any number of components (even none).
+func match(pattern, name string) bool {
+	return matchComponents(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchComponents(patterns, names []string) bool {
+
+	if len(patterns) == 0 {
+		return len(names) == 0
+	}
+
+	if patterns[0] == "**" {
+		for index := 0; index <= len(names); index++ {
+			if matchComponents(patterns[1:], names[index:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+
+	if matched, _ := path.Match(patterns[0], names[0]); !matched {
+		return false
+	}
+
+	return matchComponents(patterns[1:], names[1:])
+}
+
+func validatePatterns(spec CrawlSpec) error {
+
+	if spec.Target == "" {
+		return ErrInvalidPattern
+	}
+
+	patterns := append([]string{spec.Target}, spec.Include...)
+
+	for _, pattern := range append(patterns, spec.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ErrInvalidPattern
+		}
+	}
+
+	return nil
+}
+
+// parseTarget splits a crawl target into the registry's address, the host
+// prefixing the images' names (empty for Docker Hub) and the repository
+// pattern.
+func parseTarget(target string) (string, string, string) {
+
+	scheme := "https://"
+
+	if strings.HasPrefix(target, "http://") {
+		scheme = "http://"
+	}
+
+	target = strings.TrimPrefix(strings.TrimPrefix(target, "https://"), "http://")
+
+	address, repository := ParseRepository(target)
+
+	if address == DockerHub {
+		return address, "", repository
+	}
+
+	host := strings.TrimPrefix(address, "https://")
+
+	return scheme + host, host, repository
+}
+
+// CompareVersions compares tags by their numeric and non-numeric parts, so
+// "v1.10" is greater than "v1.9". Returns a negative number when a is lower
+// than b, zero when equal, otherwise a positive number.
+func CompareVersions(a, b string) int {
+
+	partsA, partsB := splitVersion(a), splitVersion(b)
+
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numberA, errA := strconv.Atoi(partsA[i])
+		numberB, errB := strconv.Atoi(partsB[i])
+
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			return numberA - numberB
+		case errA == nil && errB != nil:
+			return 1
+		case errA != nil && errB == nil:
+			return -1
+		case partsA[i] != partsB[i]:
+			return strings.Compare(partsA[i], partsB[i])
+		}
+	}
+
+	return len(partsA) - len(partsB)
+}
+
+// splitVersion splits a tag into runs of digits and non-digits.
+func splitVersion(version string) []string {
+
+	var parts []string
+
+	start := 0
+
+	for i := 1; i <= len(version); i++ {
+		if i == len(version) || isDigit(version[i]) != isDigit(version[i-1]) {
+			parts = append(parts, version[start:i])
+			start = i
+		}
+	}
+
+	return parts
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
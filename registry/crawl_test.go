@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrawler_Crawl(t *testing.T) {
+	server := fakeRegistry(t, map[string][]string{
+		"team/api":      []string{"latest", "v1.9", "v1.10", "v2.0"},
+		"team/web":      []string{"v1.0", "v1.1", "dev"},
+		"team/legacy":   []string{"v0.1"},
+		"team/sub/app":  []string{"v1.0"},
+		"team/gone":     nil,
+		"other/service": []string{"v1.0"},
+	})
+
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	crawler := &Crawler{
+		Credentials: Credentials{
+			host: Credential{Username: "cst", Password: "secret"},
+		},
+	}
+
+	t.Run(`Ensure repositories matching the target are expanded to their tags`, func(t *testing.T) {
+		images, err := crawler.Crawl(CrawlSpec{
+			Target:     "http://" + host + "/team/*",
+			Exclude:    []string{"team/legacy:*", "team/*:dev"},
+			LatestTags: 2,
+		})
+
+		require.NoError(t, err)
+
+		expected := []string{
+			host + "/team/api:v2.0",
+			host + "/team/api:v1.10",
+			host + "/team/web:v1.1",
+			host + "/team/web:v1.0",
+		}
+
+		assert.Equal(t, expected, images)
+	})
+
+	t.Run(`Ensure "**" matches repositories nested at any depth`, func(t *testing.T) {
+		images, err := crawler.Crawl(CrawlSpec{
+			Target:  "http://" + host + "/team/**",
+			Include: []string{"team/**/*:v1.*"},
+			Exclude: []string{"team/legacy:*"},
+		})
+
+		require.NoError(t, err)
+
+		expected := []string{
+			host + "/team/api:v1.10",
+			host + "/team/api:v1.9",
+			host + "/team/sub/app:v1.0",
+			host + "/team/web:v1.1",
+			host + "/team/web:v1.0",
+		}
+
+		assert.Equal(t, expected, images)
+	})
+
+	t.Run(`When tags of a repository listed on catalog can't be listed, should skip it`, func(t *testing.T) {
+		images, err := crawler.Crawl(CrawlSpec{Target: "http://" + host + "/team/g*"})
+
+		require.NoError(t, err)
+		assert.Empty(t, images)
+	})
+
+	t.Run(`When tags of a single repository can't be listed, should return the error`, func(t *testing.T) {
+		_, err := crawler.Crawl(CrawlSpec{Target: "http://" + host + "/team/gone"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run(`Ensure a single repository is crawled without the catalog`, func(t *testing.T) {
+		images, err := crawler.Crawl(CrawlSpec{
+			Target:  "http://" + host + "/team/api",
+			Include: []string{"team/*:v1.*"},
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{host + "/team/api:v1.10", host + "/team/api:v1.9"}, images)
+	})
+
+	t.Run(`When credentials are missing, should return ErrUnauthorized error`, func(t *testing.T) {
+		_, err := (&Crawler{}).Crawl(CrawlSpec{Target: "http://" + host + "/team/*"})
+
+		assert.Equal(t, ErrUnauthorized, err)
+	})
+
+	t.Run(`When some pattern is malformed, should return ErrInvalidPattern error`, func(t *testing.T) {
+		_, err := crawler.Crawl(CrawlSpec{Target: "http://" + host + "/team/*", Include: []string{"team/[api"}})
+
+		assert.Equal(t, ErrInvalidPattern, err)
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.True(t, CompareVersions("v1.10", "v1.9") > 0)
+	assert.True(t, CompareVersions("1.0.0", "1.0") > 0)
+	assert.True(t, CompareVersions("v2.0-rc1", "v2.0-rc2") < 0)
+	assert.True(t, CompareVersions("v1.0", "latest") > 0)
+	assert.Equal(t, 0, CompareVersions("v1.0", "v1.0"))
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Credential holds the username and password used to authenticate on a
+// registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Credentials maps registry hosts (e.g. "registry.tld:5000") to their
+// credentials.
+type Credentials map[string]Credential
+
+// LoadDockerConfig reads the credentials stored on a Docker config file
+// (e.g. "~/.docker/config.json", written by "docker login").
+func LoadDockerConfig(path string) (Credentials, error) {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	credentials := make(Credentials)
+
+	for host, auth := range config.Auths {
+		credential := Credential{Username: auth.Username, Password: auth.Password}
+
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+
+			if err != nil {
+				return nil, fmt.Errorf("could not decode the credentials of %s: %v", host, err)
+			}
+
+			parts := strings.SplitN(string(decoded), ":", 2)
+
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("could not decode the credentials of %s", host)
+			}
+
+			credential = Credential{Username: parts[0], Password: parts[1]}
+		}
+
+		credentials[normalizeHost(host)] = credential
+	}
+
+	return credentials, nil
+}
+
+// Client creates a client for the registry on address, authenticated with
+// the credentials of its host (if any).
+func (c Credentials) Client(address string) *Client {
+
+	client := NewClient(address)
+
+	if credential, ok := c[normalizeHost(address)]; ok {
+		client.Username = credential.Username
+		client.Password = credential.Password
+	}
+
+	return client
+}
+
+// normalizeHost strips scheme and path from a registry address, mapping the
+// Docker Hub aliases to its registry host.
+func normalizeHost(address string) string {
+
+	host := address
+
+	if index := strings.Index(host, "://"); index >= 0 {
+		host = host[index+3:]
+	}
+
+	host = strings.SplitN(host, "/", 2)[0]
+
+	switch host {
+	case "index.docker.io", "docker.io":
+		return "registry-1.docker.io"
+	}
+
+	return host
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cst-registry")
+
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+
+	// "Y3N0OnNlY3JldA==" is base64 of "cst:secret"
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "Y3N0OnNlY3JldA=="},
+			"registry.tld:5000": {"username": "robot", "password": "token"}
+		}
+	}`
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(config), 0600))
+
+	credentials, err := LoadDockerConfig(path)
+
+	require.NoError(t, err)
+
+	hub := credentials.Client(DockerHub)
+
+	assert.Equal(t, "cst", hub.Username)
+	assert.Equal(t, "secret", hub.Password)
+
+	private := credentials.Client("https://registry.tld:5000")
+
+	assert.Equal(t, "robot", private.Username)
+	assert.Equal(t, "token", private.Password)
+
+	anonymous := credentials.Client("https://quay.io")
+
+	assert.Empty(t, anonymous.Username)
+}
@@ -0,0 +1,352 @@
+// Package registry implements a client of the Docker Registry HTTP API V2,
+// used to discover the repositories and tags to be scanned.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DockerHub is the address of the Docker Hub registry, used for repositories
+// without a registry host (e.g. "tsuru/cst").
+const DockerHub = "https://registry-1.docker.io"
+
+const defaultTimeout = 30 * time.Second
+
+var (
+	// ErrUnauthorized indicates the registry refused the client's credentials
+	// (or the lack of them).
+	ErrUnauthorized = errors.New("unauthorized to access the registry")
+
+	// ErrNotFound indicates the registry has no such repository.
+	ErrNotFound = errors.New("repository not found on registry")
+
+	bearerChallengeRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	nextLinkRegexp        = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+// Client is a Docker Registry HTTP API V2 client. It authenticates with
+// Username and Password (when given) using either basic or bearer token
+// authentication, as requested by the registry.
+type Client struct {
+	// Address is the registry's base URL, e.g. "https://registry.tld:5000".
+	Address  string
+	Username string
+	Password string
+
+	HTTPClient *http.Client
+
+	tokens map[string]string
+}
+
+// NewClient creates a client for the registry on address.
+func NewClient(address string) *Client {
+	return &Client{
+		Address:    strings.TrimSuffix(address, "/"),
+		HTTPClient: &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// ParseRepository splits a repository name (e.g. "registry.tld:5000/tsuru/cst"
+// or "nginx") into the registry's address and the repository name on that
+// registry, following the Docker conventions. Docker Hub may be named as
+// "docker.io" (e.g. "docker.io/library/nginx").
+func ParseRepository(repository string) (string, string) {
+
+	parts := strings.SplitN(repository, "/", 2)
+
+	if len(parts) == 2 && (parts[0] == "docker.io" || parts[0] == "index.docker.io") {
+		return ParseRepository(parts[1])
+	}
+
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return "https://" + parts[0], parts[1]
+	}
+
+	if len(parts) == 1 {
+		return DockerHub, "library/" + repository
+	}
+
+	return DockerHub, repository
+}
+
+// Catalog lists all repositories of the registry. Note that some registries
+// (e.g. Docker Hub) don't serve the catalog.
+func (c *Client) Catalog() ([]string, error) {
+
+	var repositories []string
+
+	next := c.Address + "/v2/_catalog"
+
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+
+		link, err := c.getJSON(next, "registry:catalog:*", &page)
+
+		if err != nil {
+			return nil, err
+		}
+
+		repositories = append(repositories, page.Repositories...)
+		next = link
+	}
+
+	return repositories, nil
+}
+
+// Tags lists all tags of a repository.
+func (c *Client) Tags(repository string) ([]string, error) {
+
+	var tags []string
+
+	next := fmt.Sprintf("%s/v2/%s/tags/list", c.Address, repository)
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+
+	for next != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		link, err := c.getJSON(next, scope, &page)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, page.Tags...)
+		next = link
+	}
+
+	return tags, nil
+}
+
+// getJSON decodes the JSON document on rawURL into v, returning the URL of
+// its next page (if any).
+func (c *Client) getJSON(rawURL, scope string, v interface{}) (string, error) {
+
+	response, err := c.get(rawURL, scope)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return "", err
+	}
+
+	return c.nextPage(rawURL, response.Header.Get("Link"))
+}
+
+func (c *Client) nextPage(current, link string) (string, error) {
+
+	matches := nextLinkRegexp.FindStringSubmatch(link)
+
+	if matches == nil {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(matches[1])
+
+	if err != nil {
+		return "", err
+	}
+
+	return next.String(), nil
+}
+
+// get requests rawURL, authenticating when the registry challenges the
+// client.
+func (c *Client) get(rawURL, scope string) (*http.Response, error) {
+
+	response, err := c.do(rawURL, c.authorization(scope))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		discard(response)
+
+		authorization, err := c.authenticate(response.Header.Get("Www-Authenticate"), scope)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if response, err = c.do(rawURL, authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return response, nil
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		discard(response)
+		return nil, ErrUnauthorized
+	case response.StatusCode == http.StatusNotFound:
+		discard(response)
+		return nil, ErrNotFound
+	default:
+		discard(response)
+		return nil, fmt.Errorf("registry replied with status code %d", response.StatusCode)
+	}
+}
+
+func (c *Client) do(rawURL, authorization string) (*http.Response, error) {
+
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	return c.httpClient().Do(request)
+}
+
+// authenticate answers the registry's challenge, caching the bearer token
+// granted for scope.
+func (c *Client) authenticate(challenge, scope string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(challenge, "Basic"):
+		if c.Username == "" {
+			return "", ErrUnauthorized
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, c.Address, nil)
+		request.SetBasicAuth(c.Username, c.Password)
+
+		return request.Header.Get("Authorization"), nil
+
+	case strings.HasPrefix(challenge, "Bearer"):
+		token, err := c.requestToken(challenge, scope)
+
+		if err != nil {
+			return "", err
+		}
+
+		if c.tokens == nil {
+			c.tokens = make(map[string]string)
+		}
+
+		c.tokens[scope] = "Bearer " + token
+
+		return c.tokens[scope], nil
+
+	default:
+		return "", ErrUnauthorized
+	}
+}
+
+func (c *Client) authorization(scope string) string {
+	return c.tokens[scope]
+}
+
+// requestToken asks the authorization service pointed by a bearer challenge
+// for a token granting scope.
+func (c *Client) requestToken(challenge, scope string) (string, error) {
+
+	params := make(map[string]string)
+
+	for _, match := range bearerChallengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("could not parse the registry's challenge: %s", challenge)
+	}
+
+	query := realm.Query()
+
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+
+	query.Set("scope", scope)
+
+	if c.Username != "" {
+		query.Set("account", c.Username)
+	}
+
+	realm.RawQuery = query.Encode()
+
+	request, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	if c.Username != "" {
+		request.SetBasicAuth(c.Username, c.Password)
+	}
+
+	response, err := c.httpClient().Do(request)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", ErrUnauthorized
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.Token == "" {
+		return body.AccessToken, nil
+	}
+
+	return body.Token, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+
+	return c.HTTPClient
+}
+
+func discard(response *http.Response) {
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry serves the catalog and the tags of repositories, two per page,
+// requiring a bearer token granted (for user "cst") by its own authorization
+// service.
+func fakeRegistry(t *testing.T, tags map[string][]string) *httptest.Server {
+
+	mux := http.NewServeMux()
+
+	var server *httptest.Server
+
+	authorized := func(w http.ResponseWriter, r *http.Request, scope string) bool {
+		if r.Header.Get("Authorization") == "Bearer token-for-"+scope {
+			return true
+		}
+
+		w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="%s"`, server.URL, scope))
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return false
+	}
+
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, "registry:catalog:*") {
+			return
+		}
+
+		var repositories []string
+
+		for repository := range tags {
+			repositories = append(repositories, repository)
+		}
+
+		sort.Strings(repositories)
+
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": repositories})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+
+		if username != "cst" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.Equal(t, "fake-registry", r.URL.Query().Get("service"))
+
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-for-" + r.URL.Query().Get("scope")})
+	})
+
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		var repository string
+
+		fmt.Sscanf(r.URL.Path, "/v2/%s", &repository)
+
+		repository = repository[:len(repository)-len("/tags/list")]
+
+		if !authorized(w, r, fmt.Sprintf("repository:%s:pull", repository)) {
+			return
+		}
+
+		all, ok := tags[repository]
+
+		// repositories without tags are listed on catalog, but missing
+		if !ok || all == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		start := 0
+		fmt.Sscanf(r.URL.Query().Get("last"), "%d", &start)
+
+		end := start + 2
+
+		if end < len(all) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=2&last=%d>; rel="next"`, repository, end))
+		} else {
+			end = len(all)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repository, "tags": all[start:end]})
+	})
+
+	server = httptest.NewServer(mux)
+
+	return server
+}
+
+func TestClient_Tags(t *testing.T) {
+	server := fakeRegistry(t, map[string][]string{
+		"tsuru/cst": []string{"latest", "v1.0", "v1.1", "v2.0", "v2.1"},
+	})
+
+	defer server.Close()
+
+	t.Run(`Ensure all pages of tags are listed, authenticating with a bearer token`, func(t *testing.T) {
+		client := NewClient(server.URL)
+		client.Username = "cst"
+		client.Password = "secret"
+
+		tags, err := client.Tags("tsuru/cst")
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"latest", "v1.0", "v1.1", "v2.0", "v2.1"}, tags)
+	})
+
+	t.Run(`When credentials are wrong, should return ErrUnauthorized error`, func(t *testing.T) {
+		client := NewClient(server.URL)
+		client.Username = "cst"
+		client.Password = "wrong"
+
+		_, err := client.Tags("tsuru/cst")
+
+		assert.Equal(t, ErrUnauthorized, err)
+	})
+
+	t.Run(`When repository does not exist, should return ErrNotFound error`, func(t *testing.T) {
+		client := NewClient(server.URL)
+		client.Username = "cst"
+		client.Password = "secret"
+
+		_, err := client.Tags("tsuru/nothing")
+
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestParseRepository(t *testing.T) {
+	cases := []struct {
+		repository string
+		address    string
+		name       string
+	}{
+		{"nginx", DockerHub, "library/nginx"},
+		{"tsuru/cst", DockerHub, "tsuru/cst"},
+		{"registry.tld/tsuru/cst", "https://registry.tld", "tsuru/cst"},
+		{"localhost:5000/cst", "https://localhost:5000", "cst"},
+		{"docker.io/library/nginx", DockerHub, "library/nginx"},
+		{"index.docker.io/tsuru/cst", DockerHub, "tsuru/cst"},
+	}
+
+	for _, c := range cases {
+		address, name := ParseRepository(c.repository)
+
+		assert.Equal(t, c.address, address, c.repository)
+		assert.Equal(t, c.name, name, c.repository)
+	}
+}
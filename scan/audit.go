@@ -0,0 +1,232 @@
+package scan
+
+import (
+	"archive/tar"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/docker"
+)
+
+// AuditRule is a check of the config audit scanner over the image's config
+// or files, in the style of CIS Docker Benchmark's image recommendations.
+type AuditRule struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Severity string `json:"severity"`
+}
+
+// AuditRules lists the checks of the config audit scanner.
+var AuditRules = []AuditRule{
+	{"user-missing", "No USER instruction, container runs as root (CIS 4.1)", SeverityMedium},
+	{"user-root", "USER is root (CIS 4.1)", SeverityHigh},
+	{"healthcheck-missing", "No HEALTHCHECK instruction (CIS 4.6)", SeverityLow},
+	{"port-privileged", "Privileged port exposed (CIS 5.7)", SeverityLow},
+	{"file-setuid", "Setuid or setgid file (CIS 4.8)", SeverityLow},
+	{"dir-world-writable", "World-writable directory without sticky bit", SeverityMedium},
+	{"add-remote-url", "ADD of a remote URL instead of COPY (CIS 4.9)", SeverityMedium},
+	{"env-secret", "Secret stored in ENV (CIS 4.10)", SeverityHigh},
+}
+
+var (
+	remoteAddRegexp = regexp.MustCompile(`(^|\s)ADD\s+(--\S+\s+)*https?://\S+`)
+	secretEnvRegexp = regexp.MustCompile(`(?i)(passw(or)?d|secret|token|api_?key|access_?key|private_?key|credential)`)
+	fileEnvRegexp   = regexp.MustCompile(`(?i)_(file|path|url|dir)$`)
+)
+
+// ConfigAuditScanner implements the Scanner and PlatformScanner interfaces.
+// It checks the image's config and files for misconfigurations (see
+// AuditRules), skipping the rules whose IDs are on Disabled.
+type ConfigAuditScanner struct {
+	Name     string
+	Source   Source
+	Disabled []string
+	Timeout  time.Duration
+}
+
+// ValidateAuditRules returns an error when some of ids isn't an audit rule.
+func ValidateAuditRules(ids []string) error {
+
+	for _, id := range ids {
+		if _, ok := findAuditRule(id); !ok {
+			return fmt.Errorf("unknown audit rule: %s", id)
+		}
+	}
+
+	return nil
+}
+
+// Scan audits a container image. Only the first platform of multi-platform
+// images is analyzed (see ScanPlatforms).
+func (cas *ConfigAuditScanner) Scan(image string) Result {
+	return cas.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms audits every platform of a container image, returning a
+// result per platform.
+func (cas *ConfigAuditScanner) ScanPlatforms(image string) []Result {
+	return scanLayers(cas.Name, cas.Source, image, cas.analyze)
+}
+
+func (cas *ConfigAuditScanner) analyze(dockerImage *docker.Image) (Result, error) {
+
+	config, err := fetchConfig(dockerImage)
+
+	if err == errNoImageConfig {
+		return Result{}, &Error{Code: ErrorCodeInvalidImage, Phase: PhasePull, Message: err.Error()}
+	}
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	findings := cas.auditConfig(config)
+
+	if !cas.enabled("file-setuid") && !cas.enabled("dir-world-writable") {
+		return Result{Findings: findings}, nil
+	}
+
+	files, err := flattenLayers(&http.Client{Timeout: cas.Timeout}, dockerImage, nil)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Findings: append(findings, cas.auditFiles(files)...)}, nil
+}
+
+func (cas *ConfigAuditScanner) auditConfig(config imageConfig) []Finding {
+
+	var findings []Finding
+
+	user := strings.SplitN(config.Config.User, ":", 2)[0]
+
+	switch user {
+	case "":
+		findings = cas.report(findings, "user-missing", Finding{})
+	case "root", "0":
+		findings = cas.report(findings, "user-root", Finding{Evidence: "USER " + config.Config.User})
+	}
+
+	if healthcheck := config.Config.Healthcheck; healthcheck == nil || len(healthcheck.Test) == 0 || healthcheck.Test[0] == "NONE" {
+		findings = cas.report(findings, "healthcheck-missing", Finding{})
+	}
+
+	var ports []string
+
+	for port := range config.Config.ExposedPorts {
+		ports = append(ports, port)
+	}
+
+	sort.Strings(ports)
+
+	for _, port := range ports {
+		number, err := strconv.Atoi(strings.SplitN(port, "/", 2)[0])
+
+		if err == nil && number < 1024 {
+			findings = cas.report(findings, "port-privileged", Finding{Evidence: "EXPOSE " + port})
+		}
+	}
+
+	for _, entry := range config.History {
+		if match := remoteAddRegexp.FindString(entry.CreatedBy); match != "" {
+			findings = cas.report(findings, "add-remote-url", Finding{
+				Evidence:  strings.TrimSpace(match),
+				CreatedBy: entry.CreatedBy,
+			})
+		}
+	}
+
+	for _, variable := range config.Config.Env {
+		parts := strings.SplitN(variable, "=", 2)
+
+		if len(parts) != 2 || parts[1] == "" || strings.HasPrefix(parts[1], "/") {
+			continue
+		}
+
+		if secretEnvRegexp.MatchString(parts[0]) && !fileEnvRegexp.MatchString(parts[0]) {
+			findings = cas.report(findings, "env-secret", Finding{Evidence: "ENV " + parts[0] + "=" + redact(parts[1])})
+		}
+	}
+
+	return findings
+}
+
+func (cas *ConfigAuditScanner) auditFiles(files map[string]layerFile) []Finding {
+
+	var names []string
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var findings []Finding
+
+	for _, name := range names {
+		file := files[name]
+
+		switch {
+		case file.typeflag == tar.TypeReg && file.mode&(04000|02000) != 0:
+			findings = cas.report(findings, "file-setuid", Finding{
+				Path:     name,
+				Layer:    file.layer,
+				Evidence: fmt.Sprintf("mode %04o", file.mode&07777),
+			})
+
+		case file.typeflag == tar.TypeDir && file.mode&0002 != 0 && file.mode&01000 == 0:
+			findings = cas.report(findings, "dir-world-writable", Finding{
+				Path:     name,
+				Layer:    file.layer,
+				Evidence: fmt.Sprintf("mode %04o", file.mode&07777),
+			})
+		}
+	}
+
+	return findings
+}
+
+// report appends a finding of an enabled rule to findings.
+func (cas *ConfigAuditScanner) report(findings []Finding, id string, finding Finding) []Finding {
+
+	if !cas.enabled(id) {
+		return findings
+	}
+
+	rule, _ := findAuditRule(id)
+
+	finding.Scanner = cas.Name
+	finding.Rule = rule.ID
+	finding.Title = rule.Title
+	finding.Severity = rule.Severity
+
+	return append(findings, finding)
+}
+
+func (cas *ConfigAuditScanner) enabled(id string) bool {
+
+	for _, disabled := range cas.Disabled {
+		if disabled == id {
+			return false
+		}
+	}
+
+	return true
+}
+
+func findAuditRule(id string) (AuditRule, bool) {
+
+	for _, rule := range AuditRules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+
+	return AuditRule{}, false
+}
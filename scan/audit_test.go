@@ -0,0 +1,195 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuditRegistry serves an image with the given config (through its
+// manifest) and layers.
+func newTestAuditRegistry(t *testing.T, config map[string]interface{}, layers ...[]testFile) (*httptest.Server, *docker.Image) {
+
+	blobs, image := newTestRegistry(t, layers...)
+
+	blobsURL, err := url.Parse(blobs.URL)
+	require.NoError(t, err)
+
+	proxy := httputil.NewSingleHostReverseProxy(blobsURL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/tsuru/cst/manifests/latest":
+			w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:config"}}`))
+
+		case "/v2/tsuru/cst/blobs/sha256:config":
+			json.NewEncoder(w).Encode(config)
+
+		default:
+			proxy.ServeHTTP(w, r)
+		}
+	}))
+
+	t.Cleanup(blobs.Close)
+
+	image.Registry = server.URL + "/v2"
+
+	return server, image
+}
+
+func TestConfigAuditScanner_Scan(t *testing.T) {
+	config := map[string]interface{}{
+		"config": map[string]interface{}{
+			"User":         "root:root",
+			"Env":          []string{"PATH=/usr/bin", "DB_PASSWORD=s3cr3tP4ssw0rd", "API_TOKEN_FILE=/run/secrets/token", "SECRET_KEY="},
+			"ExposedPorts": map[string]interface{}{"80/tcp": struct{}{}, "8080/tcp": struct{}{}},
+		},
+		"history": []map[string]interface{}{
+			{"created_by": "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / "},
+			{"created_by": "/bin/sh -c #(nop) ADD https://example.com/app.tar.gz /app "},
+		},
+	}
+
+	server, image := newTestAuditRegistry(t, config,
+		[]testFile{
+			{name: "tmp/", mode: 01777},
+			{name: "usr/bin/passwd", mode: 04755},
+			{name: "usr/bin/su", mode: 04755},
+			{name: "var/cache/", mode: 0777},
+			{name: "var/cache/app/", mode: 0777},
+		},
+		[]testFile{
+			{name: "usr/bin/.wh.su"},
+			{name: "var/.wh..wh..opq"},
+			{name: "app/uploads/", mode: 0777},
+		},
+	)
+
+	defer server.Close()
+
+	source := &MockSource{
+		MockFetch: func(string) ([]PlatformImage, error) {
+			return []PlatformImage{{Image: image}}, nil
+		},
+	}
+
+	t.Run(`Ensure every enabled rule reports its findings`, func(t *testing.T) {
+		scanner := &ConfigAuditScanner{Name: "config-audit", Source: source}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+
+		var found []string
+
+		for _, finding := range result.Findings {
+			assert.Equal(t, "config-audit", finding.Scanner)
+			assert.NotEmpty(t, finding.Title)
+			assert.NotEmpty(t, finding.Severity)
+
+			found = append(found, finding.Rule+" "+finding.Path+" "+finding.Evidence)
+		}
+
+		expected := []string{
+			"user-root  USER root:root",
+			"healthcheck-missing  ",
+			"port-privileged  EXPOSE 80/tcp",
+			"add-remote-url  ADD https://example.com/app.tar.gz",
+			"env-secret  ENV DB_PASSWORD=s3cr****",
+			"dir-world-writable app/uploads mode 0777",
+			"file-setuid usr/bin/passwd mode 4755",
+		}
+
+		assert.Equal(t, expected, found)
+
+		for _, finding := range result.Findings {
+			switch finding.Rule {
+			case "add-remote-url":
+				assert.Equal(t, "/bin/sh -c #(nop) ADD https://example.com/app.tar.gz /app ", finding.CreatedBy)
+			case "file-setuid":
+				assert.Equal(t, "sha256:layer0", finding.Layer)
+			case "dir-world-writable":
+				assert.Equal(t, "sha256:layer1", finding.Layer)
+			}
+		}
+	})
+
+	t.Run(`When rules are disabled, should not report their findings`, func(t *testing.T) {
+		scanner := &ConfigAuditScanner{
+			Name:     "config-audit",
+			Source:   source,
+			Disabled: []string{"healthcheck-missing", "port-privileged", "add-remote-url", "env-secret", "file-setuid", "dir-world-writable"},
+		}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		require.Len(t, result.Findings, 1)
+		assert.Equal(t, "user-root", result.Findings[0].Rule)
+		assert.Equal(t, SeverityHigh, result.Findings[0].Severity)
+	})
+
+	t.Run(`When image has no USER, should report it runs as root`, func(t *testing.T) {
+		server, image := newTestAuditRegistry(t, map[string]interface{}{
+			"config": map[string]interface{}{
+				"Healthcheck": map[string]interface{}{"Test": []string{"CMD", "true"}},
+			},
+		}, []testFile{{name: "app/"}})
+
+		defer server.Close()
+
+		scanner := &ConfigAuditScanner{
+			Name: "config-audit",
+			Source: &MockSource{
+				MockFetch: func(string) ([]PlatformImage, error) {
+					return []PlatformImage{{Image: image}}, nil
+				},
+			},
+		}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		require.Len(t, result.Findings, 1)
+		assert.Equal(t, "user-missing", result.Findings[0].Rule)
+	})
+
+	t.Run(`When image's manifest has no config, should returns an invalid image error`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"schemaVersion":1}`))
+		}))
+
+		defer server.Close()
+
+		scanner := &ConfigAuditScanner{
+			Name: "config-audit",
+			Source: &MockSource{
+				MockFetch: func(string) ([]PlatformImage, error) {
+					return []PlatformImage{{Image: &docker.Image{Registry: server.URL + "/v2", Name: "tsuru/cst", Tag: "latest"}}}, nil
+				},
+			},
+		}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeInvalidImage, result.Error.Code)
+	})
+}
+
+func TestValidateAuditRules(t *testing.T) {
+	t.Run(`When every ID is an audit rule, should returns no error`, func(t *testing.T) {
+		assert.NoError(t, ValidateAuditRules([]string{"user-root", "env-secret"}))
+	})
+
+	t.Run(`When some ID isn't an audit rule, should returns an error`, func(t *testing.T) {
+		assert.EqualError(t, ValidateAuditRules([]string{"user-root", "unknown"}), "unknown audit rule: unknown")
+	})
+}
@@ -0,0 +1,195 @@
+package scan
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/tsuru/cst/registry"
+)
+
+// BaseImage is an image of the base images catalog, i.e. a known base image
+// scanned by CST itself. Layers is how many layers it has.
+type BaseImage struct {
+	Image      string         `json:"image"`
+	ScanID     string         `json:"scanID"`
+	Digest     string         `json:"digest,omitempty"`
+	Layers     int            `json:"layers"`
+	Severities SeverityCounts `json:"severities"`
+}
+
+// BaseImageReport holds the base image detected on a scanner's result (nil
+// when none of the catalog matches) and the newer tags of that base image
+// with fewer vulnerabilities, from the fewest to the most vulnerable.
+type BaseImageReport struct {
+	Scanner         string      `json:"scanner"`
+	Platform        string      `json:"platform,omitempty"`
+	Base            *BaseImage  `json:"base,omitempty"`
+	Recommendations []BaseImage `json:"recommendations"`
+}
+
+// DetectBaseImages looks for the base image of each successful result of a
+// scan among the latest scans of the catalog images. An image is built from
+// the catalog image whose layers are the longest match of its leading layers.
+func DetectBaseImages(s Scan, catalog []Scan) []BaseImageReport {
+
+	reports := make([]BaseImageReport, 0, len(s.Result))
+
+	for _, result := range s.Result {
+		if result.Error != nil {
+			continue
+		}
+
+		report := BaseImageReport{
+			Scanner:         result.Scanner,
+			Platform:        result.Platform,
+			Recommendations: []BaseImage{},
+		}
+
+		base, baseResult, found := detectBaseImage(s.Image, result, catalog)
+
+		if found {
+			report.Base = &base
+			report.Recommendations = recommendBaseImages(base, baseResult, catalog)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+func detectBaseImage(image string, result Result, catalog []Scan) (BaseImage, Result, bool) {
+
+	var base BaseImage
+	var baseResult Result
+
+	for _, candidate := range catalog {
+		if candidate.Image == image {
+			continue
+		}
+
+		for _, candidateResult := range candidate.Result {
+			if candidateResult.Error != nil || candidateResult.Scanner != result.Scanner {
+				continue
+			}
+
+			layers := len(candidateResult.Layers)
+
+			if layers <= base.Layers || !hasLeadingLayers(result.Layers, candidateResult.Layers) {
+				continue
+			}
+
+			base = newBaseImage(candidate, candidateResult)
+			baseResult = candidateResult
+		}
+	}
+
+	return base, baseResult, base.Layers > 0
+}
+
+// recommendBaseImages lists the newer tags of base (of the same variant, e.g.
+// "-slim") with fewer vulnerabilities for the base's platform.
+func recommendBaseImages(base BaseImage, baseResult Result, catalog []Scan) []BaseImage {
+
+	repository, tag := SplitImageName(base.Image)
+
+	recommendations := []BaseImage{}
+
+	for _, candidate := range catalog {
+		candidateRepository, candidateTag := SplitImageName(candidate.Image)
+
+		if candidateRepository != repository || !isNewerVariant(candidateTag, tag) {
+			continue
+		}
+
+		for _, candidateResult := range candidate.Result {
+			if candidateResult.Error != nil ||
+				candidateResult.Scanner != baseResult.Scanner ||
+				candidateResult.Platform != baseResult.Platform {
+				continue
+			}
+
+			recommendation := newBaseImage(candidate, candidateResult)
+
+			if compareSeverities(recommendation.Severities, base.Severities) < 0 {
+				recommendations = append(recommendations, recommendation)
+			}
+		}
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		if comparison := compareSeverities(recommendations[i].Severities, recommendations[j].Severities); comparison != 0 {
+			return comparison < 0
+		}
+
+		_, tagI := SplitImageName(recommendations[i].Image)
+		_, tagJ := SplitImageName(recommendations[j].Image)
+
+		return registry.CompareVersions(tagI, tagJ) > 0
+	})
+
+	return recommendations
+}
+
+func newBaseImage(s Scan, result Result) BaseImage {
+	return BaseImage{
+		Image:      s.Image,
+		ScanID:     s.ID,
+		Digest:     result.Digest,
+		Layers:     len(result.Layers),
+		Severities: CountSeverities(result.Findings),
+	}
+}
+
+// hasLeadingLayers returns true when the leading layers of an image are the
+// base ones.
+func hasLeadingLayers(layers, base []Layer) bool {
+
+	if len(base) == 0 || len(base) > len(layers) {
+		return false
+	}
+
+	for index := range base {
+		if layers[index].Digest != base[index].Digest {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isNewerVariant returns true when tag is newer than other and both are of
+// the same variant, that is, they differ only by their numbers (e.g.
+// "3.18-slim" and "3.17-slim").
+func isNewerVariant(tag, other string) bool {
+
+	variant := func(tag string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return -1
+			}
+
+			return r
+		}, tag)
+	}
+
+	return variant(tag) == variant(other) && registry.CompareVersions(tag, other) > 0
+}
+
+// compareSeverities compares severity counts from the most to the least
+// severe, so fewer critical vulnerabilities always come first. Returns a
+// negative number when a is less vulnerable than b, zero when equal,
+// otherwise a positive number.
+func compareSeverities(a, b SeverityCounts) int {
+
+	countsA := []int{a.Critical, a.High, a.Medium, a.Low, a.Negligible, a.Unknown}
+	countsB := []int{b.Critical, b.High, b.Medium, b.Low, b.Negligible, b.Unknown}
+
+	for index := range countsA {
+		if countsA[index] != countsB[index] {
+			return countsA[index] - countsB[index]
+		}
+	}
+
+	return 0
+}
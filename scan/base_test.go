@@ -0,0 +1,102 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectBaseImages(t *testing.T) {
+
+	layers := func(digests ...string) []Layer {
+		var layers []Layer
+
+		for _, digest := range digests {
+			layers = append(layers, Layer{Digest: digest})
+		}
+
+		return layers
+	}
+
+	critical := []Finding{{CVE: "CVE-2018-1000001", Package: "glibc", Severity: "Critical"}}
+	low := []Finding{{CVE: "CVE-2018-1000002", Package: "glibc", Severity: "Low"}}
+
+	catalog := []Scan{
+		{ID: "alpine", Image: "docker.io/library/alpine:3.7", Result: []Result{{Scanner: "clair", Layers: layers("sha256:alpine")}}},
+		{ID: "slim-9", Image: "docker.io/library/debian:9-slim", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian"), Findings: critical}}},
+		{ID: "9", Image: "docker.io/library/debian:9", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian", "sha256:debian-full"), Findings: critical}}},
+		{ID: "slim-10", Image: "docker.io/library/debian:10-slim", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian-10"), Findings: low}}},
+		{ID: "slim-11", Image: "docker.io/library/debian:11-slim", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian-11")}}},
+		{ID: "slim-8", Image: "docker.io/library/debian:8-slim", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian-8")}}},
+		{ID: "10", Image: "docker.io/library/debian:10", Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian-10-full")}}},
+	}
+
+	t.Run(`Ensure the longest matching catalog image is the base image`, func(t *testing.T) {
+		s := Scan{
+			Image: "docker.io/tsuru/cst:latest",
+			Result: []Result{
+				{Scanner: "clair", Layers: layers("sha256:debian", "sha256:debian-full", "sha256:app")},
+			},
+		}
+
+		reports := DetectBaseImages(s, catalog)
+
+		require.Len(t, reports, 1)
+		require.NotNil(t, reports[0].Base)
+		assert.Equal(t, "docker.io/library/debian:9", reports[0].Base.Image)
+		assert.Equal(t, 2, reports[0].Base.Layers)
+		assert.Equal(t, SeverityCounts{Critical: 1}, reports[0].Base.Severities)
+	})
+
+	t.Run(`Ensure only newer tags of the same variant with fewer vulnerabilities are recommended`, func(t *testing.T) {
+		s := Scan{
+			Image:  "docker.io/tsuru/cst:latest",
+			Result: []Result{{Scanner: "clair", Layers: layers("sha256:debian", "sha256:app")}},
+		}
+
+		reports := DetectBaseImages(s, catalog)
+
+		require.Len(t, reports, 1)
+		require.NotNil(t, reports[0].Base)
+		assert.Equal(t, "docker.io/library/debian:9-slim", reports[0].Base.Image)
+
+		var recommended []string
+
+		for _, recommendation := range reports[0].Recommendations {
+			recommended = append(recommended, recommendation.Image)
+		}
+
+		assert.Equal(t, []string{"docker.io/library/debian:11-slim", "docker.io/library/debian:10-slim"}, recommended)
+	})
+
+	t.Run(`When no catalog image matches, should report no base image`, func(t *testing.T) {
+		s := Scan{
+			Image: "docker.io/tsuru/cst:latest",
+			Result: []Result{
+				{Scanner: "clair", Layers: layers("sha256:scratch")},
+				{Scanner: "other", Error: &Error{Code: ErrorCodeUnknown}},
+			},
+		}
+
+		reports := DetectBaseImages(s, catalog)
+
+		require.Len(t, reports, 1)
+		assert.Nil(t, reports[0].Base)
+		assert.Empty(t, reports[0].Recommendations)
+	})
+}
+
+func TestCompareSeverities(t *testing.T) {
+	assert.True(t, compareSeverities(SeverityCounts{High: 10}, SeverityCounts{Critical: 1}) < 0)
+	assert.True(t, compareSeverities(SeverityCounts{Critical: 1, Low: 1}, SeverityCounts{Critical: 1}) > 0)
+	assert.Equal(t, 0, compareSeverities(SeverityCounts{Medium: 2}, SeverityCounts{Medium: 2}))
+}
+
+func TestIsNewerVariant(t *testing.T) {
+	assert.True(t, isNewerVariant("3.18", "3.17"))
+	assert.True(t, isNewerVariant("10-slim", "9-slim"))
+	assert.False(t, isNewerVariant("10", "9-slim"))
+	assert.False(t, isNewerVariant("3.17", "3.18"))
+	assert.False(t, isNewerVariant("latest", "latest"))
+}
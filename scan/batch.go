@@ -0,0 +1,95 @@
+package scan
+
+import "time"
+
+// BatchItemStatus indicates what happened to an image submitted on a batch.
+type BatchItemStatus string
+
+const (
+	// BatchItemCreated indicates a new scan was scheduled for the image.
+	BatchItemCreated = BatchItemStatus("created")
+
+	// BatchItemDeduplicated indicates the image already had a scheduled scan
+	// (or it was repeated on the batch), which is followed instead.
+	BatchItemDeduplicated = BatchItemStatus("deduplicated")
+
+	// BatchItemInvalid indicates the image name is malformed.
+	BatchItemInvalid = BatchItemStatus("invalid")
+
+	// BatchItemFailed indicates the scan couldn't be scheduled.
+	BatchItemFailed = BatchItemStatus("failed")
+)
+
+// Batch groups the scans of several images submitted at once.
+type Batch struct {
+	ID        string      `bson:"_id" json:"id"`
+	CreatedAt time.Time   `bson:"createdAt" json:"createdAt"`
+	Items     []BatchItem `bson:"items" json:"items"`
+}
+
+// BatchItem holds the scan of an image submitted on a batch.
+type BatchItem struct {
+	Image  string          `bson:"image" json:"image"`
+	Status BatchItemStatus `bson:"status" json:"status"`
+	ScanID string          `bson:"scanID,omitempty" json:"scanID,omitempty"`
+	Error  string          `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// BatchProgress aggregates the status of the scans of a batch.
+type BatchProgress struct {
+	Batch `bson:",inline"`
+
+	// Scans counts the batch's scans per status.
+	Scans map[Status]int `json:"scans"`
+
+	// Completed counts the scans which have reached a final status.
+	Completed int `json:"completed"`
+
+	// Total counts the scans of the batch (i.e. ignoring invalid or failed
+	// items).
+	Total int `json:"total"`
+
+	// Done indicates all scans of the batch have reached a final status.
+	Done bool `json:"done"`
+}
+
+// ScanIDs returns the identifiers of the scans followed by the batch.
+func (b Batch) ScanIDs() []string {
+
+	var ids []string
+
+	seen := make(map[string]bool)
+
+	for _, item := range b.Items {
+		if item.ScanID == "" || seen[item.ScanID] {
+			continue
+		}
+
+		seen[item.ScanID] = true
+		ids = append(ids, item.ScanID)
+	}
+
+	return ids
+}
+
+// Progress aggregates the status of the batch's scans.
+func (b Batch) Progress(scans []Scan) BatchProgress {
+
+	progress := BatchProgress{
+		Batch: b,
+		Scans: make(map[Status]int),
+		Total: len(b.ScanIDs()),
+	}
+
+	for _, s := range scans {
+		progress.Scans[s.Status]++
+
+		if (Event{Type: EventStatusChanged, Status: s.Status}).IsFinal() {
+			progress.Completed++
+		}
+	}
+
+	progress.Done = progress.Completed == progress.Total
+
+	return progress
+}
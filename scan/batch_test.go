@@ -0,0 +1,41 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch_Progress(t *testing.T) {
+	batch := Batch{
+		ID: "f5b0d1b4-3d6c-4c5e-9a43-3d7b2a1c7e11",
+		Items: []BatchItem{
+			{Image: "tsuru/cst:latest", Status: BatchItemCreated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+			{Image: "tsuru/api:latest", Status: BatchItemDeduplicated, ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf"},
+			{Image: "tsuru/cst:latest", Status: BatchItemDeduplicated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+			{Image: "Tsuru/CST", Status: BatchItemInvalid},
+		},
+	}
+
+	t.Run(`Ensure scans are counted once per status`, func(t *testing.T) {
+		progress := batch.Progress([]Scan{
+			{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: StatusPartial},
+			{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Status: StatusRunning},
+		})
+
+		assert.Equal(t, map[Status]int{StatusPartial: 1, StatusRunning: 1}, progress.Scans)
+		assert.Equal(t, 2, progress.Total)
+		assert.Equal(t, 1, progress.Completed)
+		assert.False(t, progress.Done)
+	})
+
+	t.Run(`When all scans have reached a final status, should be done`, func(t *testing.T) {
+		progress := batch.Progress([]Scan{
+			{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Status: StatusFinished},
+			{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Status: StatusDeadLetter},
+		})
+
+		assert.Equal(t, 2, progress.Completed)
+		assert.True(t, progress.Done)
+	})
+}
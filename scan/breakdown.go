@@ -0,0 +1,83 @@
+package scan
+
+// LayerSeverities holds how many findings were attributed to a layer per
+// severity.
+type LayerSeverities struct {
+	Layer
+	Severities SeverityCounts `json:"severities"`
+}
+
+// Breakdown splits the findings of a scanner's result between the layers of
+// the base image and the ones added by the image itself (app layers).
+// Unattributed counts the findings the scanner couldn't attribute to a layer.
+type Breakdown struct {
+	Scanner      string            `json:"scanner"`
+	Platform     string            `json:"platform,omitempty"`
+	Base         SeverityCounts    `json:"base"`
+	App          SeverityCounts    `json:"app"`
+	Unattributed SeverityCounts    `json:"unattributed"`
+	Layers       []LayerSeverities `json:"layers"`
+}
+
+// BreakdownScan breaks down the findings of each successful result of a scan.
+// When a result has no image history, every layer counts as an app layer.
+func BreakdownScan(s Scan) []Breakdown {
+
+	breakdowns := make([]Breakdown, 0, len(s.Result))
+
+	for _, result := range s.Result {
+		if result.Error != nil {
+			continue
+		}
+
+		breakdowns = append(breakdowns, breakdownResult(result))
+	}
+
+	return breakdowns
+}
+
+func breakdownResult(result Result) Breakdown {
+
+	byLayer := make(map[string][]Finding, len(result.Layers))
+
+	for _, finding := range result.Findings {
+		byLayer[finding.Layer] = append(byLayer[finding.Layer], finding)
+	}
+
+	breakdown := Breakdown{
+		Scanner:  result.Scanner,
+		Platform: result.Platform,
+		Layers:   make([]LayerSeverities, len(result.Layers)),
+	}
+
+	var base, app []Finding
+
+	for index, layer := range result.Layers {
+		findings := byLayer[layer.Digest]
+
+		breakdown.Layers[index] = LayerSeverities{
+			Layer:      layer,
+			Severities: CountSeverities(findings),
+		}
+
+		if layer.Base {
+			base = append(base, findings...)
+		} else {
+			app = append(app, findings...)
+		}
+
+		delete(byLayer, layer.Digest)
+	}
+
+	var unattributed []Finding
+
+	for _, findings := range byLayer {
+		unattributed = append(unattributed, findings...)
+	}
+
+	breakdown.Base = CountSeverities(base)
+	breakdown.App = CountSeverities(app)
+	breakdown.Unattributed = CountSeverities(unattributed)
+
+	return breakdown
+}
@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakdownScan(t *testing.T) {
+	s := Scan{
+		Result: []Result{
+			{
+				Scanner: "clair",
+				Layers: []Layer{
+					{Digest: "sha256:base", Base: true},
+					{Digest: "sha256:app", CreatedBy: "/bin/sh -c apt-get install -y curl"},
+				},
+				Findings: []Finding{
+					{CVE: "CVE-2018-1000001", Package: "glibc", Severity: "High", Layer: "sha256:base"},
+					{CVE: "CVE-2018-1000120", Package: "curl", Severity: "Critical", Layer: "sha256:app"},
+					{CVE: "CVE-2018-1000121", Package: "curl", Severity: "Low", Layer: "sha256:app"},
+					{CVE: "CVE-2018-0732", Package: "openssl", Severity: "Medium"},
+				},
+			},
+			{Scanner: "other", Error: &Error{Code: ErrorCodeUnknown}},
+		},
+	}
+
+	breakdowns := BreakdownScan(s)
+
+	if assert.Len(t, breakdowns, 1) {
+		breakdown := breakdowns[0]
+
+		assert.Equal(t, "clair", breakdown.Scanner)
+		assert.Equal(t, SeverityCounts{High: 1}, breakdown.Base)
+		assert.Equal(t, SeverityCounts{Critical: 1, Low: 1}, breakdown.App)
+		assert.Equal(t, SeverityCounts{Medium: 1}, breakdown.Unattributed)
+		assert.Equal(t, []LayerSeverities{
+			{Layer: Layer{Digest: "sha256:base", Base: true}, Severities: SeverityCounts{High: 1}},
+			{Layer: Layer{Digest: "sha256:app", CreatedBy: "/bin/sh -c apt-get install -y curl"}, Severities: SeverityCounts{Critical: 1, Low: 1}},
+		}, breakdown.Layers)
+	}
+}
@@ -1,22 +1,50 @@
 package scan
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/optiopay/klar/clair"
 	"github.com/optiopay/klar/docker"
 	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/metrics"
 )
 
-// Clair is a struct that implements a Scanner interface.
+const (
+	clairPingTimeout = 5 * time.Second
+	registryTimeout  = 10 * time.Second
+
+	manifestMediaTypes = "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// Clair is a struct that implements the Scanner and Pinger interfaces.
+// Source fetches the images' layers; when nil, they are pulled from
+// registries. When Cache is assigned, the findings of layers are reused
+// across images analyzed on the same DBVersion, the version of Clair's
+// vulnerability database (see analyzeLayers).
 type Clair struct {
-	Address string
-	Name    string
-	Timeout time.Duration
+	Address   string
+	Name      string
+	Timeout   time.Duration
+	Source    Source
+	Cache     LayerCache
+	DBVersion string
 }
 
-// Scan analyzes a container image on CoreOS Clair security engine.
+// Scan analyzes a container image on CoreOS Clair security engine. Only the
+// first platform of multi-platform images is analyzed (see ScanPlatforms).
 func (c *Clair) Scan(image string) Result {
+	return c.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms analyzes every platform of a container image on CoreOS Clair
+// security engine, returning a result per platform.
+func (c *Clair) ScanPlatforms(image string) []Result {
 
 	log := logrus.
 		WithField("clair.address", c.Address).
@@ -26,67 +54,256 @@ func (c *Clair) Scan(image string) Result {
 
 	defer log.Info("finishing scan on CoreOS Clair")
 
-	dockerImage, err := docker.NewImage(&docker.Config{
-		ImageName: image,
-	})
+	source := c.Source
+
+	if source == nil {
+		source = &RegistrySource{}
+	}
+
+	log.Info("fetching manifest and layers of the image")
+
+	pullStartedAt := time.Now()
+
+	images, err := source.Fetch(image)
+
+	metrics.ImagePullDuration.Observe(time.Since(pullStartedAt).Seconds(), c.Name)
 
 	if err != nil {
-		return c.makeErrorResult(err)
+		return []Result{c.makeErrorResult(PhasePull, err)}
+	}
+
+	results := make([]Result, 0, len(images))
+
+	for _, platformImage := range images {
+		result := c.analyze(platformImage.Image, log.WithField("platform", platformImage.Platform))
+		result.Platform = platformImage.Platform
+
+		results = append(results, result)
 	}
 
+	return results
+}
+
+func (c *Clair) analyze(dockerImage *docker.Image, log *logrus.Entry) Result {
+
 	log.
 		WithField("docker.registry", dockerImage.Registry).
 		WithField("docker.image", dockerImage.Name).
 		WithField("docker.tag", dockerImage.Tag).
-		Info("fetching manifest and layers from image's repository")
+		Info("image's layers fetched")
+
+	// klar drops the empty layers of the image while analyzing it
+	layers := fetchLayers(dockerImage)
 
-	err = dockerImage.Pull()
+	vulnerabilities, findings, err := c.analyzeLayers(dockerImage, log)
 
 	if err != nil {
-		return c.makeErrorResult(err)
-	}
+		log.
+			WithField("clair.api", 1).
+			WithError(err).
+			Warn("failed to analyze using that CoreOS Clair API version")
 
-	var vulns []*clair.Vulnerability
+		var vulns []*clair.Vulnerability
 
-	for _, apiVersion := range []int{1, 3} {
-		clairClient := clair.NewClair(c.Address, apiVersion, c.Timeout)
+		clairClient := clair.NewClair(c.Address, 3, c.Timeout)
 
 		vulns, err = clairClient.Analyse(dockerImage)
 
-		if err == nil {
-			break
+		if err != nil {
+			return c.makeErrorResult(PhaseAnalyze, err)
 		}
 
-		log.
-			WithField("clair.api", apiVersion).
-			WithError(err).
-			Warn("failed to analyze using that CoreOS Clair API version")
+		vulnerabilities = make([]clair.Vulnerability, len(vulns))
 
-		continue
-	}
+		for index, vulnerability := range vulns {
+			vulnerabilities[index] = *vulnerability
+		}
 
-	if err != nil {
-		return c.makeErrorResult(err)
+		findings = findingsFromClair(c.Name, vulnerabilities)
 	}
 
-	vulnerabilities := make([]clair.Vulnerability, len(vulns))
-
-	for index, vulnerability := range vulns {
-		vulnerabilities[index] = *vulnerability
-	}
+	attributeFindings(findings, layers)
 
 	log.Info("successful to get vulnerabilities on CoreOS Clair")
 
 	return Result{
 		Scanner:         c.Name,
+		Digest:          resolveDigest(dockerImage),
 		Vulnerabilities: vulnerabilities,
+		Findings:        findings,
+		Layers:          layers,
 	}
 }
 
-func (c *Clair) makeErrorResult(err error) Result {
+// Ping checks whether CoreOS Clair API is reachable, listing its namespaces.
+func (c *Clair) Ping() error {
+
+	client := &http.Client{Timeout: clairPingTimeout}
+
+	response, err := client.Get(strings.TrimSuffix(c.Address, "/") + "/v1/namespaces")
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("CoreOS Clair replied with status code %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// resolveDigest asks the registry for the digest of the image's manifest.
+// Returns an empty string when the registry doesn't report it.
+func resolveDigest(image *docker.Image) string {
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", image.Registry, image.Name, image.Tag)
+
+	request, err := http.NewRequest(http.MethodHead, url, nil)
+
+	if err != nil {
+		return ""
+	}
+
+	if image.Token != "" {
+		request.Header.Set("Authorization", image.Token)
+	}
+
+	request.Header.Set("Accept", manifestMediaTypes)
+
+	client := &http.Client{Timeout: registryTimeout}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		logrus.WithError(err).Warn("could not resolve the image digest")
+		return ""
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	return response.Header.Get("Docker-Content-Digest")
+}
+
+func (c *Clair) makeErrorResult(phase Phase, err error) Result {
+
+	scanErr := classifyClairError(phase, err)
+
+	logrus.
+		WithField("clair.address", c.Address).
+		WithField("error.code", scanErr.Code).
+		WithField("error.phase", scanErr.Phase).
+		WithError(err).
+		Error("could not analyze that image on CoreOS Clair")
 
 	return Result{
 		Scanner: c.Name,
-		Error:   "could not analyze that image on CoreOS Clair",
+		Error:   scanErr,
+	}
+}
+
+var (
+	// statusCodeRegexp matches the HTTP status codes on error messages
+	// returned by klar (e.g. "push error 500: ..." or "Token request
+	// returned 401").
+	statusCodeRegexp = regexp.MustCompile(`(push error|analyze error|Token request returned) (\d{3})`)
+
+	unavailableErrorMessages = []string{
+		"can't push layer to Clair",
+		"connection refused",
+		"connection reset",
+		"no such host",
+		"Unavailable",
+		"EOF",
+	}
+
+	timeoutErrorMessages = []string{
+		"i/o timeout",
+		"Client.Timeout exceeded",
+		"TLS handshake timeout",
+		"DeadlineExceeded",
+	}
+)
+
+// classifyClairError builds an Error describing why the analysis failed on a
+// given phase. Network failures, timeouts and server errors are transient,
+// that is, the analysis could succeed if retried. Errors like a missing image
+// or unauthorized access to registry are permanent.
+func classifyClairError(phase Phase, err error) *Error {
+
+	if scanErr, ok := err.(*Error); ok {
+		if scanErr.Phase == "" {
+			scanErr.Phase = phase
+		}
+
+		return scanErr
 	}
+
+	message := err.Error()
+
+	scanErr := &Error{
+		Code:    ErrorCodeUnknown,
+		Phase:   phase,
+		Message: message,
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		scanErr.Code = ErrorCodeTimeout
+		scanErr.Transient = true
+
+		return scanErr
+	}
+
+	if matches := statusCodeRegexp.FindStringSubmatch(message); matches != nil {
+		statusCode, _ := strconv.Atoi(matches[2])
+
+		if matches[1] == "Token request returned" {
+			scanErr.Phase = PhaseAuth
+		}
+
+		switch {
+		case statusCode == 401 || statusCode == 403:
+			scanErr.Code = ErrorCodeUnauthorized
+		case statusCode == 404:
+			scanErr.Code = ErrorCodeImageNotFound
+		case statusCode == 429 || statusCode >= 500:
+			scanErr.Code = ErrorCodeUnavailable
+			scanErr.Transient = true
+		}
+
+		return scanErr
+	}
+
+	if strings.Contains(message, "Www-Authenticate") {
+		scanErr.Code = ErrorCodeUnauthorized
+		scanErr.Phase = PhaseAuth
+
+		return scanErr
+	}
+
+	for _, timeoutMessage := range timeoutErrorMessages {
+		if strings.Contains(message, timeoutMessage) {
+			scanErr.Code = ErrorCodeTimeout
+			scanErr.Transient = true
+
+			return scanErr
+		}
+	}
+
+	for _, unavailableMessage := range unavailableErrorMessages {
+		if strings.Contains(message, unavailableMessage) {
+			scanErr.Code = ErrorCodeUnavailable
+			scanErr.Transient = true
+
+			return scanErr
+		}
+	}
+
+	return scanErr
 }
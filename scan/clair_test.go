@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "operation timed out" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyClairError(t *testing.T) {
+	cases := []struct {
+		phase     Phase
+		err       error
+		code      ErrorCode
+		expPhase  Phase
+		transient bool
+	}{
+		{PhasePull, timeoutError{}, ErrorCodeTimeout, PhasePull, true},
+		{PhasePull, &url.Error{Op: "Get", URL: "https://registry-1.docker.io/v2/", Err: errors.New("dial tcp: connection refused")}, ErrorCodeUnavailable, PhasePull, true},
+		{PhaseAnalyze, errors.New("push image registry/tsuru/cst:latest to Clair failed: can't push layer to Clair: EOF"), ErrorCodeUnavailable, PhaseAnalyze, true},
+		{PhaseAnalyze, errors.New("push image registry/tsuru/cst:latest to Clair failed: push error 503: service unavailable"), ErrorCodeUnavailable, PhaseAnalyze, true},
+		{PhaseAnalyze, errors.New("analyse image registry/tsuru/cst:latest failed: analyze error 500: internal error"), ErrorCodeUnavailable, PhaseAnalyze, true},
+		{PhasePull, errors.New("Token request returned 429"), ErrorCodeUnavailable, PhaseAuth, true},
+		{PhasePull, errors.New("Token request returned 401"), ErrorCodeUnauthorized, PhaseAuth, false},
+		{PhasePull, errors.New("Can't parse Www-Authenticate: Basic realm=registry"), ErrorCodeUnauthorized, PhaseAuth, false},
+		{PhaseAnalyze, errors.New("push image registry/tsuru/cst:latest to Clair failed: push error 400: could not find layer"), ErrorCodeUnknown, PhaseAnalyze, false},
+		{PhasePull, &Error{Code: ErrorCodeImageNotFound, Message: "image manifest not found on registry"}, ErrorCodeImageNotFound, PhasePull, false},
+	}
+
+	for _, c := range cases {
+		got := classifyClairError(c.phase, c.err)
+
+		assert.Equal(t, c.code, got.Code, "unexpected code for: %s", c.err)
+		assert.Equal(t, c.expPhase, got.Phase, "unexpected phase for: %s", c.err)
+		assert.Equal(t, c.transient, got.Transient, "unexpected transient for: %s", c.err)
+
+		if scanErr, ok := c.err.(*Error); ok {
+			assert.Equal(t, scanErr.Message, got.Message)
+		} else {
+			assert.Equal(t, c.err.Error(), got.Message)
+		}
+	}
+}
+
+func TestClair_Ping(t *testing.T) {
+	t.Run(`When Clair API replies without server errors, should return no error`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/namespaces", r.URL.Path)
+			w.Write([]byte(`{"Namespaces":[]}`))
+		}))
+
+		defer server.Close()
+
+		clair := &Clair{Address: server.URL + "/"}
+
+		assert.NoError(t, clair.Ping())
+	})
+
+	t.Run(`When Clair API replies with server errors, should return an error`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+
+		defer server.Close()
+
+		clair := &Clair{Address: server.URL}
+
+		assert.Error(t, clair.Ping())
+	})
+}
+
+func TestResolveDigest(t *testing.T) {
+	t.Run(`Ensure the digest is taken from the registry's manifest response`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodHead, r.Method)
+			assert.Equal(t, "/v2/tsuru/cst/manifests/latest", r.URL.Path)
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+			w.Header().Set("Docker-Content-Digest", "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b")
+		}))
+
+		defer server.Close()
+
+		image := &docker.Image{Registry: server.URL + "/v2", Name: "tsuru/cst", Tag: "latest", Token: "Bearer token"}
+
+		assert.Equal(t, "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b", resolveDigest(image))
+	})
+
+	t.Run(`When registry doesn't find the manifest, should return an empty digest`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+
+		defer server.Close()
+
+		image := &docker.Image{Registry: server.URL + "/v2", Name: "tsuru/cst", Tag: "latest"}
+
+		assert.Empty(t, resolveDigest(image))
+	})
+}
@@ -0,0 +1,468 @@
+package scan
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/optiopay/klar/docker"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/metrics"
+)
+
+const (
+	clairV4IndexFinished = "IndexFinished"
+	clairV4IndexError    = "IndexError"
+
+	// clairV4TokenTTL is how long the JWTs signed for Clair v4 requests are
+	// valid.
+	clairV4TokenTTL = 5 * time.Minute
+
+	defaultClairV4PollInterval = 2 * time.Second
+	defaultClairV4IndexTimeout = 10 * time.Minute
+)
+
+// clairV4Manifest is the image submitted to Clair v4 indexer: its manifest
+// digest and where the indexer fetches each layer from.
+type clairV4Manifest struct {
+	Hash   string         `json:"hash"`
+	Layers []clairV4Layer `json:"layers"`
+}
+
+type clairV4Layer struct {
+	Hash    string              `json:"hash"`
+	URI     string              `json:"uri"`
+	Headers map[string][]string `json:"headers"`
+}
+
+type clairV4IndexReport struct {
+	ManifestHash string `json:"manifest_hash"`
+	State        string `json:"state"`
+	Success      bool   `json:"success"`
+	Err          string `json:"err"`
+}
+
+type clairV4VulnerabilityReport struct {
+	ManifestHash string `json:"manifest_hash"`
+	Packages     map[string]struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"packages"`
+	Vulnerabilities        map[string]ClairV4Vulnerability `json:"vulnerabilities"`
+	PackageVulnerabilities map[string][]string             `json:"package_vulnerabilities"`
+	Environments           map[string][]struct {
+		IntroducedIn string `json:"introduced_in"`
+	} `json:"environments"`
+}
+
+// ClairV4Vulnerability is a vulnerability as reported by Clair v4 matcher,
+// kept on results' Vulnerabilities.
+type ClairV4Vulnerability struct {
+	ID                 string `bson:"id" json:"id"`
+	Name               string `bson:"name" json:"name"`
+	Description        string `bson:"description,omitempty" json:"description,omitempty"`
+	Links              string `bson:"links,omitempty" json:"links,omitempty"`
+	Severity           string `bson:"severity,omitempty" json:"severity,omitempty"`
+	NormalizedSeverity string `bson:"normalizedSeverity,omitempty" json:"normalized_severity,omitempty"`
+	FixedInVersion     string `bson:"fixedInVersion,omitempty" json:"fixed_in_version,omitempty"`
+}
+
+// ClairV4 implements the Scanner, PlatformScanner and Pinger interfaces over
+// Clair v4 (ClairCore) APIs: images' manifests are submitted to the indexer
+// at Address, which is polled (every PollInterval, up to IndexTimeout) until
+// the image is indexed, then the vulnerability report is fetched from the
+// matcher at MatcherAddress (Address when empty, i.e. Clair on combo mode).
+// When PSK is assigned, requests are authenticated by JWTs signed with it
+// (HS256), issued by Issuer.
+type ClairV4 struct {
+	Name           string
+	Address        string
+	MatcherAddress string
+	PSK            []byte
+	Issuer         string
+	Timeout        time.Duration
+	PollInterval   time.Duration
+	IndexTimeout   time.Duration
+	Source         Source
+}
+
+// Scan analyzes a container image on Clair v4. Only the first platform of
+// multi-platform images is analyzed (see ScanPlatforms).
+func (c *ClairV4) Scan(image string) Result {
+	return c.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms analyzes every platform of a container image on Clair v4,
+// returning a result per platform.
+func (c *ClairV4) ScanPlatforms(image string) []Result {
+
+	log := logrus.
+		WithField("clair.address", c.Address).
+		WithField("image", image)
+
+	log.Info("initializing scan on Clair v4")
+
+	defer log.Info("finishing scan on Clair v4")
+
+	source := c.Source
+
+	if source == nil {
+		source = &RegistrySource{}
+	}
+
+	pullStartedAt := time.Now()
+
+	images, err := source.Fetch(image)
+
+	metrics.ImagePullDuration.Observe(time.Since(pullStartedAt).Seconds(), c.Name)
+
+	if err != nil {
+		return []Result{errorResult(c.Name, PhasePull, err)}
+	}
+
+	results := make([]Result, 0, len(images))
+
+	for _, platformImage := range images {
+		result := c.analyze(platformImage.Image, log.WithField("platform", platformImage.Platform))
+		result.Platform = platformImage.Platform
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (c *ClairV4) analyze(dockerImage *docker.Image, log *logrus.Entry) Result {
+
+	layers := fetchLayers(dockerImage)
+	digest := resolveDigest(dockerImage)
+
+	manifest := clairV4ManifestOf(dockerImage, digest)
+
+	log = log.WithField("clair.manifest", manifest.Hash)
+
+	if err := c.index(manifest, log); err != nil {
+		return errorResult(c.Name, PhaseAnalyze, err)
+	}
+
+	var report clairV4VulnerabilityReport
+
+	url := fmt.Sprintf("%s/matcher/api/v1/vulnerability_report/%s", c.matcherAddress(), manifest.Hash)
+
+	if err := c.do(http.MethodGet, url, nil, &report); err != nil {
+		return errorResult(c.Name, PhaseAnalyze, err)
+	}
+
+	vulnerabilities, findings := report.findings(c.Name)
+
+	attributeFindings(findings, layers)
+
+	log.Info("successful to get vulnerabilities on Clair v4")
+
+	return Result{
+		Scanner:         c.Name,
+		Digest:          digest,
+		Vulnerabilities: vulnerabilities,
+		Findings:        findings,
+		Layers:          layers,
+	}
+}
+
+// index submits the manifest to the indexer, waiting until it's indexed.
+func (c *ClairV4) index(manifest clairV4Manifest, log *logrus.Entry) error {
+
+	pollInterval := c.PollInterval
+
+	if pollInterval <= 0 {
+		pollInterval = defaultClairV4PollInterval
+	}
+
+	indexTimeout := c.IndexTimeout
+
+	if indexTimeout <= 0 {
+		indexTimeout = defaultClairV4IndexTimeout
+	}
+
+	deadline := time.Now().Add(indexTimeout)
+
+	var report clairV4IndexReport
+
+	address := strings.TrimSuffix(c.Address, "/")
+
+	if err := c.do(http.MethodPost, address+"/indexer/api/v1/index_report", manifest, &report); err != nil {
+		return err
+	}
+
+	for report.State != clairV4IndexFinished {
+		if report.State == clairV4IndexError || report.Err != "" {
+			return &Error{
+				Code:    ErrorCodeUnknown,
+				Phase:   PhaseAnalyze,
+				Message: "Clair v4 could not index the image: " + report.Err,
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return &Error{
+				Code:      ErrorCodeTimeout,
+				Phase:     PhaseAnalyze,
+				Message:   fmt.Sprintf("Clair v4 has not indexed the image after %s (state: %s)", indexTimeout, report.State),
+				Transient: true,
+			}
+		}
+
+		log.WithField("clair.state", report.State).Debug("waiting for Clair v4 to index the image")
+
+		time.Sleep(pollInterval)
+
+		if err := c.do(http.MethodGet, address+"/indexer/api/v1/index_report/"+manifest.Hash, nil, &report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping checks whether Clair v4 indexer is reachable, getting its state.
+func (c *ClairV4) Ping() error {
+
+	client := &http.Client{Timeout: clairPingTimeout}
+
+	request, err := c.newRequest(http.MethodGet, strings.TrimSuffix(c.Address, "/")+"/indexer/api/v1/index_state", nil)
+
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("Clair v4 replied with status code %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// do sends a request (with body encoded as JSON, when not nil) to Clair v4,
+// decoding its JSON response to value.
+func (c *ClairV4) do(method, url string, body, value interface{}) error {
+
+	var reader io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := c.newRequest(method, url, reader)
+
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: c.Timeout}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+
+		return clairV4StatusError(response.StatusCode, fmt.Sprintf("%s %s: %s", method, url, bytes.TrimSpace(message)))
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+func (c *ClairV4) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+
+	request, err := http.NewRequest(method, url, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if len(c.PSK) > 0 {
+		token, err := c.signToken()
+
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return request, nil
+}
+
+// signToken signs a JWT with the pre-shared key, as expected by Clair v4's
+// PSK authentication.
+func (c *ClairV4) signToken() (string, error) {
+
+	now := time.Now()
+
+	claims := jwt.RegisteredClaims{
+		Issuer:    c.Issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clairV4TokenTTL)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.PSK)
+}
+
+func (c *ClairV4) matcherAddress() string {
+
+	if c.MatcherAddress != "" {
+		return strings.TrimSuffix(c.MatcherAddress, "/")
+	}
+
+	return strings.TrimSuffix(c.Address, "/")
+}
+
+// clairV4ManifestOf describes the image for Clair v4 indexer, which fetches
+// its (non-empty) layers from registry, reusing the credentials of the
+// image's pull. When the manifest digest is unknown, it's derived from the
+// layers' digests.
+func clairV4ManifestOf(image *docker.Image, digest string) clairV4Manifest {
+
+	layers := nonEmptyLayers(image)
+
+	if digest == "" {
+		sum := sha256.Sum256([]byte(strings.Join(layers, "\n")))
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	manifest := clairV4Manifest{Hash: digest, Layers: make([]clairV4Layer, len(layers))}
+
+	for index, layer := range layers {
+		manifest.Layers[index] = clairV4Layer{
+			Hash:    layer,
+			URI:     fmt.Sprintf("%s/%s/blobs/%s", image.Registry, image.Name, layer),
+			Headers: map[string][]string{},
+		}
+
+		if image.Token != "" {
+			manifest.Layers[index].Headers["Authorization"] = []string{image.Token}
+		}
+	}
+
+	return manifest
+}
+
+// findings normalizes the vulnerabilities affecting the image's packages,
+// attributed to the layers that have introduced those packages. Returns the
+// affecting vulnerabilities as well, sorted by name.
+func (r clairV4VulnerabilityReport) findings(scanner string) ([]ClairV4Vulnerability, []Finding) {
+
+	var findings []Finding
+
+	affecting := make(map[string]ClairV4Vulnerability)
+
+	for packageID, vulnerabilityIDs := range r.PackageVulnerabilities {
+		pkg := r.Packages[packageID]
+
+		layer := ""
+
+		if environments := r.Environments[packageID]; len(environments) > 0 {
+			layer = environments[0].IntroducedIn
+		}
+
+		for _, id := range vulnerabilityIDs {
+			vulnerability, ok := r.Vulnerabilities[id]
+
+			if !ok {
+				continue
+			}
+
+			affecting[id] = vulnerability
+
+			findings = append(findings, Finding{
+				CVE:      vulnerability.Name,
+				Scanner:  scanner,
+				Package:  pkg.Name,
+				Version:  pkg.Version,
+				FixedBy:  vulnerability.FixedInVersion,
+				Severity: NormalizeSeverity(vulnerability.NormalizedSeverity),
+				Link:     strings.SplitN(vulnerability.Links, " ", 2)[0],
+				Layer:    layer,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].CVE != findings[j].CVE {
+			return findings[i].CVE < findings[j].CVE
+		}
+
+		return findings[i].Package < findings[j].Package
+	})
+
+	vulnerabilities := make([]ClairV4Vulnerability, 0, len(affecting))
+
+	for _, vulnerability := range affecting {
+		vulnerabilities = append(vulnerabilities, vulnerability)
+	}
+
+	sort.Slice(vulnerabilities, func(i, j int) bool {
+		if vulnerabilities[i].Name != vulnerabilities[j].Name {
+			return vulnerabilities[i].Name < vulnerabilities[j].Name
+		}
+
+		return vulnerabilities[i].ID < vulnerabilities[j].ID
+	})
+
+	return vulnerabilities, findings
+}
+
+// clairV4StatusError classifies the status code replied by Clair v4.
+func clairV4StatusError(statusCode int, message string) *Error {
+
+	scanErr := &Error{
+		Code:    ErrorCodeUnknown,
+		Phase:   PhaseAnalyze,
+		Message: fmt.Sprintf("Clair v4 replied with status code %d: %s", statusCode, message),
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		scanErr.Code = ErrorCodeUnauthorized
+		scanErr.Phase = PhaseAuth
+	case statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError:
+		scanErr.Code = ErrorCodeUnavailable
+		scanErr.Transient = true
+	}
+
+	return scanErr
+}
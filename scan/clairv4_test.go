@@ -0,0 +1,343 @@
+package scan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const clairV4TestManifestHash = "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"
+
+// clairV4Replay replies Clair v4 requests with recorded responses (from
+// testdata/clairv4), keyed by method and path. When a route has several
+// responses, they're replied in order, repeating the last one.
+type clairV4Replay struct {
+	t         *testing.T
+	mutex     sync.Mutex
+	routes    map[string][]clairV4Response
+	requests  []*http.Request
+	manifests []clairV4Manifest
+}
+
+type clairV4Response struct {
+	status int
+	file   string
+}
+
+func newClairV4Replay(t *testing.T, routes map[string][]clairV4Response) (*httptest.Server, *clairV4Replay) {
+
+	replay := &clairV4Replay{t: t, routes: routes}
+
+	return httptest.NewServer(replay), replay
+}
+
+func (r *clairV4Replay) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests = append(r.requests, request)
+
+	if request.Method == http.MethodPost {
+		var manifest clairV4Manifest
+
+		require.NoError(r.t, json.NewDecoder(request.Body).Decode(&manifest))
+
+		r.manifests = append(r.manifests, manifest)
+	}
+
+	key := request.Method + " " + request.URL.Path
+	responses := r.routes[key]
+
+	if len(responses) == 0 {
+		http.NotFound(w, request)
+		return
+	}
+
+	response := responses[0]
+
+	if len(responses) > 1 {
+		r.routes[key] = responses[1:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.status)
+
+	if response.file != "" {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "clairv4", response.file))
+		require.NoError(r.t, err)
+
+		w.Write(data)
+	}
+}
+
+func TestClairV4_Scan(t *testing.T) {
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/v2/tsuru/cst/manifests/latest" {
+			w.Header().Set("Docker-Content-Digest", clairV4TestManifestHash)
+			return
+		}
+
+		http.NotFound(w, r)
+	}))
+
+	defer registry.Close()
+
+	source := &MockSource{
+		MockFetch: func(string) ([]PlatformImage, error) {
+			return []PlatformImage{{
+				Image: &docker.Image{
+					Registry: registry.URL + "/v2",
+					Name:     "tsuru/cst",
+					Tag:      "latest",
+					Token:    "Bearer registry-token",
+					FsLayers: []docker.FsLayer{{BlobSum: "sha256:layer0"}, {BlobSum: "sha256:layer1"}},
+				},
+			}}, nil
+		},
+	}
+
+	indexReportPath := "GET /indexer/api/v1/index_report/" + clairV4TestManifestHash
+	vulnerabilityReportPath := "GET /matcher/api/v1/vulnerability_report/" + clairV4TestManifestHash
+
+	t.Run(`Ensure the manifest is indexed and the vulnerabilities affecting its packages are reported`, func(t *testing.T) {
+		server, replay := newClairV4Replay(t, map[string][]clairV4Response{
+			"POST /indexer/api/v1/index_report": {{http.StatusCreated, "index_report_pending.json"}},
+			indexReportPath:                     {{http.StatusOK, "index_report_pending.json"}, {http.StatusOK, "index_report.json"}},
+			vulnerabilityReportPath:             {{http.StatusOK, "vulnerability_report.json"}},
+		})
+
+		defer server.Close()
+
+		clair := &ClairV4{
+			Name:         "clair-v4",
+			Address:      server.URL,
+			Timeout:      time.Second,
+			PollInterval: time.Millisecond,
+			Source:       source,
+		}
+
+		result := clair.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+
+		assert.Equal(t, "clair-v4", result.Scanner)
+		assert.Equal(t, clairV4TestManifestHash, result.Digest)
+
+		require.Len(t, replay.manifests, 1)
+		assert.Equal(t, clairV4TestManifestHash, replay.manifests[0].Hash)
+
+		if assert.Len(t, replay.manifests[0].Layers, 2) {
+			assert.Equal(t, "sha256:layer0", replay.manifests[0].Layers[0].Hash)
+			assert.Equal(t, registry.URL+"/v2/tsuru/cst/blobs/sha256:layer0", replay.manifests[0].Layers[0].URI)
+			assert.Equal(t, []string{"Bearer registry-token"}, replay.manifests[0].Layers[0].Headers["Authorization"])
+		}
+
+		assert.Len(t, replay.requests, 4)
+
+		assert.Equal(t, []Finding{
+			{
+				CVE:      "CVE-2022-37434",
+				Scanner:  "clair-v4",
+				Package:  "zlib1g",
+				Version:  "1:1.2.11.dfsg-2+deb11u1",
+				FixedBy:  "1:1.2.11.dfsg-2+deb11u2",
+				Severity: SeverityCritical,
+				Link:     "https://security-tracker.debian.org/tracker/CVE-2022-37434",
+				Layer:    "sha256:layer0",
+			},
+			{
+				CVE:      "CVE-2023-0286",
+				Scanner:  "clair-v4",
+				Package:  "openssl",
+				Version:  "1.1.1n-0+deb11u3",
+				FixedBy:  "1.1.1n-0+deb11u4",
+				Severity: SeverityHigh,
+				Link:     "https://security-tracker.debian.org/tracker/CVE-2023-0286",
+				Layer:    "sha256:layer1",
+			},
+			{
+				CVE:      "CVE-2023-2650",
+				Scanner:  "clair-v4",
+				Package:  "openssl",
+				Version:  "1.1.1n-0+deb11u3",
+				Severity: SeverityMedium,
+				Link:     "https://security-tracker.debian.org/tracker/CVE-2023-2650",
+				Layer:    "sha256:layer1",
+			},
+		}, result.Findings)
+
+		vulnerabilities, ok := result.Vulnerabilities.([]ClairV4Vulnerability)
+
+		if assert.True(t, ok) && assert.Len(t, vulnerabilities, 3) {
+			assert.Equal(t, "351278", vulnerabilities[0].ID)
+			assert.Equal(t, "Critical", vulnerabilities[0].Severity)
+		}
+	})
+
+	t.Run(`When matcher address is assigned, should get the vulnerability report from it`, func(t *testing.T) {
+		indexer, _ := newClairV4Replay(t, map[string][]clairV4Response{
+			"POST /indexer/api/v1/index_report": {{http.StatusCreated, "index_report.json"}},
+		})
+
+		defer indexer.Close()
+
+		matcher, replay := newClairV4Replay(t, map[string][]clairV4Response{
+			vulnerabilityReportPath: {{http.StatusOK, "vulnerability_report.json"}},
+		})
+
+		defer matcher.Close()
+
+		clair := &ClairV4{Address: indexer.URL, MatcherAddress: matcher.URL + "/", Source: source}
+
+		result := clair.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		assert.Len(t, result.Findings, 3)
+		assert.Len(t, replay.requests, 1)
+	})
+
+	t.Run(`When PSK is assigned, should authenticate requests with JWTs signed with it`, func(t *testing.T) {
+		server, replay := newClairV4Replay(t, map[string][]clairV4Response{
+			"POST /indexer/api/v1/index_report": {{http.StatusCreated, "index_report.json"}},
+			vulnerabilityReportPath:             {{http.StatusOK, "vulnerability_report.json"}},
+		})
+
+		defer server.Close()
+
+		psk := []byte("a pre-shared key")
+
+		clair := &ClairV4{Address: server.URL, PSK: psk, Issuer: "cst", Source: source}
+
+		result := clair.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		require.Len(t, replay.requests, 2)
+
+		for _, request := range replay.requests {
+			authorization := request.Header.Get("Authorization")
+
+			require.True(t, strings.HasPrefix(authorization, "Bearer "), authorization)
+
+			claims := &jwt.RegisteredClaims{}
+
+			token, err := jwt.ParseWithClaims(strings.TrimPrefix(authorization, "Bearer "), claims, func(token *jwt.Token) (interface{}, error) {
+				assert.Equal(t, jwt.SigningMethodHS256, token.Method)
+				return psk, nil
+			})
+
+			require.NoError(t, err)
+			assert.True(t, token.Valid)
+			assert.Equal(t, "cst", claims.Issuer)
+		}
+	})
+
+	t.Run(`When Clair v4 fails to index the image, should return an error`, func(t *testing.T) {
+		server, _ := newClairV4Replay(t, map[string][]clairV4Response{
+			"POST /indexer/api/v1/index_report": {{http.StatusCreated, "index_report_pending.json"}},
+			indexReportPath:                     {{http.StatusOK, "index_report_error.json"}},
+		})
+
+		defer server.Close()
+
+		clair := &ClairV4{Address: server.URL, PollInterval: time.Millisecond, Source: source}
+
+		result := clair.Scan("tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeUnknown, result.Error.Code)
+		assert.Equal(t, PhaseAnalyze, result.Error.Phase)
+		assert.False(t, result.Error.Transient)
+		assert.Contains(t, result.Error.Message, "fetcher: unexpected status code: 401 Unauthorized")
+	})
+
+	t.Run(`When the image isn't indexed until the index timeout, should return a transient timeout error`, func(t *testing.T) {
+		server, _ := newClairV4Replay(t, map[string][]clairV4Response{
+			"POST /indexer/api/v1/index_report": {{http.StatusCreated, "index_report_pending.json"}},
+			indexReportPath:                     {{http.StatusOK, "index_report_pending.json"}},
+		})
+
+		defer server.Close()
+
+		clair := &ClairV4{
+			Address:      server.URL,
+			PollInterval: time.Millisecond,
+			IndexTimeout: 20 * time.Millisecond,
+			Source:       source,
+		}
+
+		result := clair.Scan("tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeTimeout, result.Error.Code)
+		assert.True(t, result.Error.Transient)
+	})
+
+	t.Run(`When Clair v4 replies error status codes, should classify them`, func(t *testing.T) {
+		cases := []struct {
+			status    int
+			code      ErrorCode
+			phase     Phase
+			transient bool
+		}{
+			{http.StatusUnauthorized, ErrorCodeUnauthorized, PhaseAuth, false},
+			{http.StatusForbidden, ErrorCodeUnauthorized, PhaseAuth, false},
+			{http.StatusTooManyRequests, ErrorCodeUnavailable, PhaseAnalyze, true},
+			{http.StatusServiceUnavailable, ErrorCodeUnavailable, PhaseAnalyze, true},
+			{http.StatusBadRequest, ErrorCodeUnknown, PhaseAnalyze, false},
+		}
+
+		for _, c := range cases {
+			server, _ := newClairV4Replay(t, map[string][]clairV4Response{
+				"POST /indexer/api/v1/index_report": {{c.status, ""}},
+			})
+
+			clair := &ClairV4{Address: server.URL, Source: source}
+
+			result := clair.Scan("tsuru/cst:latest")
+
+			server.Close()
+
+			if assert.NotNil(t, result.Error, "status code %d", c.status) {
+				assert.Equal(t, c.code, result.Error.Code, "status code %d", c.status)
+				assert.Equal(t, c.phase, result.Error.Phase, "status code %d", c.status)
+				assert.Equal(t, c.transient, result.Error.Transient, "status code %d", c.status)
+			}
+		}
+	})
+}
+
+func TestClairV4_Ping(t *testing.T) {
+	t.Run(`When Clair v4 indexer replies without server errors, should return no error`, func(t *testing.T) {
+		server, replay := newClairV4Replay(t, map[string][]clairV4Response{
+			"GET /indexer/api/v1/index_state": {{http.StatusOK, ""}},
+		})
+
+		defer server.Close()
+
+		assert.NoError(t, (&ClairV4{Address: server.URL}).Ping())
+		assert.Len(t, replay.requests, 1)
+	})
+
+	t.Run(`When Clair v4 indexer replies server errors, should return an error`, func(t *testing.T) {
+		server, _ := newClairV4Replay(t, map[string][]clairV4Response{
+			"GET /indexer/api/v1/index_state": {{http.StatusBadGateway, ""}},
+		})
+
+		defer server.Close()
+
+		assert.Error(t, (&ClairV4{Address: server.URL}).Ping())
+	})
+}
@@ -0,0 +1,684 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/docker"
+)
+
+// maxDependencyFileSize is the size of the largest lockfile, binary or Java
+// archive read by the dependency scanner.
+const maxDependencyFileSize = 128 << 20
+
+var (
+	npmLockRegexp         = regexp.MustCompile(`(^|/)(package-lock|npm-shrinkwrap|\.package-lock)\.json$`)
+	yarnLockRegexp        = regexp.MustCompile(`(^|/)yarn\.lock$`)
+	requirementsRegexp    = regexp.MustCompile(`(^|/)requirements[^/]*\.txt$`)
+	poetryLockRegexp      = regexp.MustCompile(`(^|/)poetry\.lock$`)
+	pipfileLockRegexp     = regexp.MustCompile(`(^|/)Pipfile\.lock$`)
+	gemfileLockRegexp     = regexp.MustCompile(`(^|/)Gemfile\.lock$`)
+	composerLockRegexp    = regexp.MustCompile(`(^|/)composer\.lock$`)
+	javaArchiveRegexp     = regexp.MustCompile(`\.(jar|war|ear)$`)
+	pomPropertiesRegexp   = regexp.MustCompile(`^META-INF/maven/[^/]+/[^/]+/pom\.properties$`)
+	requirementLineRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*===?\s*([^\s;#]+)`)
+	gemfileSpecRegexp     = regexp.MustCompile(`^    ([^\s(]+) \(([^)]+)\)$`)
+	tomlStringRegexp      = regexp.MustCompile(`^(name|version)\s*=\s*"([^"]*)"`)
+
+	// dependencyReaders read the dependencies of the files (not matching
+	// other patterns) matching their patterns.
+	dependencyReaders = []struct {
+		pattern *regexp.Regexp
+		read    func([]byte) []Dependency
+	}{
+		{npmLockRegexp, readNPMLock},
+		{yarnLockRegexp, readYarnLock},
+		{npmManifestRegexp, readNPMManifest},
+		{requirementsRegexp, readRequirements},
+		{poetryLockRegexp, readPoetryLock},
+		{pipfileLockRegexp, readPipfileLock},
+		{pythonMetadataRegexp, readPythonMetadata},
+		{gemfileLockRegexp, readGemfileLock},
+		{gemspecRegexp, readGemspec},
+		{composerLockRegexp, readComposerLock},
+		{composerRegexp, readComposerInstalled},
+		{javaArchiveRegexp, readJavaArchiveDependencies},
+	}
+)
+
+// Dependency is a language package found on an image, on its lockfile or
+// installed package metadata (found at Path).
+type Dependency struct {
+	Ecosystem string
+	Name      string
+	Version   string
+	Path      string
+	Layer     string
+}
+
+// DependencyScanner implements the Scanner and PlatformScanner interfaces. It
+// finds the language packages (npm, PyPI, RubyGems, Go, Maven and Packagist
+// ones) on an image, from lockfiles, installed packages' metadata, Go
+// binaries' build info and Java archives, matching them against the
+// advisories of Database.
+type DependencyScanner struct {
+	Name     string
+	Source   Source
+	Database *OSVDatabase
+	Timeout  time.Duration
+}
+
+// Scan finds vulnerable dependencies on a container image. Only the first
+// platform of multi-platform images is analyzed (see ScanPlatforms).
+func (ds *DependencyScanner) Scan(image string) Result {
+	return ds.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms finds vulnerable dependencies on every platform of a
+// container image, returning a result per platform.
+func (ds *DependencyScanner) ScanPlatforms(image string) []Result {
+	return scanLayers(ds.Name, ds.Source, image, ds.analyze)
+}
+
+func (ds *DependencyScanner) analyze(dockerImage *docker.Image) (Result, error) {
+
+	files, err := flattenLayers(&http.Client{Timeout: ds.Timeout}, dockerImage, readDependencyFile)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	var findings []Finding
+
+	for _, dependency := range readDependencies(files) {
+		for _, finding := range ds.Database.Match(dependency.Ecosystem, dependency.Name, dependency.Version) {
+			finding.Scanner = ds.Name
+			finding.Path = dependency.Path
+			finding.Layer = dependency.Layer
+
+			findings = append(findings, finding)
+		}
+	}
+
+	return Result{Findings: findings}, nil
+}
+
+// readDependencyFile keeps the lockfiles and packages' metadata, as well as
+// the build info of Go binaries and the Maven coordinates of Java archives
+// (see goBinaryDependencies and javaArchiveDependencies).
+func readDependencyFile(entry LayerEntry) (bool, []byte, error) {
+
+	header := entry.Header
+
+	if header.Typeflag != tar.TypeReg || header.Size > maxDependencyFileSize {
+		return false, nil, nil
+	}
+
+	var pattern *regexp.Regexp
+
+	for _, reader := range dependencyReaders {
+		if reader.pattern.MatchString(entry.Path) {
+			pattern = reader.pattern
+			break
+		}
+	}
+
+	if pattern == nil && header.Mode&0111 == 0 {
+		return false, nil, nil
+	}
+
+	content := bufio.NewReader(entry.Content)
+
+	if pattern == nil {
+		// executables other than ELF binaries (e.g. scripts) are skipped
+		// without being read
+		if magic, _ := content.Peek(4); !bytes.Equal(magic, []byte("\x7fELF")) {
+			return false, nil, nil
+		}
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+
+	// Go binaries and Java archives are copied to a temporary file, instead of
+	// being read into memory, as only their build info or coordinates are kept.
+	switch pattern {
+	case nil:
+		data, err = readTemporaryFile(content, func(file io.ReaderAt, _ int64) []byte {
+			return goBinaryDependencies(file)
+		})
+	case javaArchiveRegexp:
+		data, err = readTemporaryFile(content, javaArchiveDependencies)
+	default:
+		data, err = ioutil.ReadAll(io.LimitReader(content, maxDependencyFileSize))
+	}
+
+	if err != nil {
+		return false, nil, err
+	}
+
+	return data != nil, data, nil
+}
+
+// readTemporaryFile copies content (up to maxDependencyFileSize bytes) to a
+// temporary file, which is read by read and then removed.
+func readTemporaryFile(content io.Reader, read func(file io.ReaderAt, size int64) []byte) ([]byte, error) {
+
+	file, err := ioutil.TempFile("", "cst-dependency-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size, err := io.Copy(file, io.LimitReader(content, maxDependencyFileSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return read(file, size), nil
+}
+
+// readDependencies reads the dependencies of an image's files, sorted by
+// path, ecosystem, name and version.
+func readDependencies(files map[string]layerFile) []Dependency {
+
+	var dependencies []Dependency
+
+	for name, file := range files {
+		read := readGoBinaryDependencies
+
+		for _, reader := range dependencyReaders {
+			if reader.pattern.MatchString(name) {
+				read = reader.read
+				break
+			}
+		}
+
+		for _, dependency := range read(file.content) {
+			if dependency.Name == "" || dependency.Version == "" {
+				continue
+			}
+
+			dependency.Path = name
+			dependency.Layer = file.layer
+
+			dependencies = append(dependencies, dependency)
+		}
+	}
+
+	sort.Slice(dependencies, func(i, j int) bool {
+		a, b := dependencies[i], dependencies[j]
+
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+
+		if a.Ecosystem != b.Ecosystem {
+			return a.Ecosystem < b.Ecosystem
+		}
+
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.Version < b.Version
+	})
+
+	return dependencies
+}
+
+// readNPMLock reads a package-lock.json: its "packages" (lockfile v2 and v3,
+// keyed by their paths) or its nested "dependencies" (v1).
+func readNPMLock(data []byte) []Dependency {
+
+	type npmLockDependency struct {
+		Version      string                       `json:"version"`
+		Dependencies map[string]npmLockDependency `json:"dependencies"`
+	}
+
+	var lock struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]npmLockDependency `json:"dependencies"`
+	}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil
+	}
+
+	var dependencies []Dependency
+
+	for key, pkg := range lock.Packages {
+		index := strings.LastIndex(key, "node_modules/")
+
+		if index < 0 || pkg.Link {
+			continue
+		}
+
+		name := pkg.Name
+
+		if name == "" {
+			name = key[index+len("node_modules/"):]
+		}
+
+		dependencies = append(dependencies, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: pkg.Version})
+	}
+
+	if len(lock.Packages) > 0 {
+		return dependencies
+	}
+
+	var walk func(map[string]npmLockDependency)
+
+	walk = func(nested map[string]npmLockDependency) {
+		for name, dependency := range nested {
+			dependencies = append(dependencies, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: dependency.Version})
+			walk(dependency.Dependencies)
+		}
+	}
+
+	walk(lock.Dependencies)
+
+	return dependencies
+}
+
+// readYarnLock reads a yarn.lock, whose entries start with their (quoted or
+// not) descriptors (e.g. `"@babel/core@^7.0.0", "@babel/core@^7.1.0":`),
+// followed by their versions (`version "7.1.2"` or `version: 7.1.2`).
+func readYarnLock(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	name := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line[0] != ' ' && strings.HasSuffix(line, ":"):
+			descriptor := strings.Trim(strings.SplitN(strings.TrimSuffix(line, ":"), ",", 2)[0], `" `)
+
+			name = ""
+
+			if index := strings.LastIndex(descriptor, "@"); index > 0 {
+				name = descriptor[:index]
+			}
+
+		case name != "" && strings.HasPrefix(strings.TrimSpace(line), "version"):
+			version := strings.TrimPrefix(strings.TrimSpace(line), "version")
+			version = strings.Trim(strings.TrimPrefix(strings.TrimSpace(version), ":"), `" `)
+
+			dependencies = append(dependencies, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: version})
+			name = ""
+		}
+	}
+
+	return dependencies
+}
+
+func readNPMManifest(data []byte) []Dependency {
+
+	var pkg struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil
+	}
+
+	return []Dependency{{Ecosystem: EcosystemNPM, Name: pkg.Name, Version: pkg.Version}}
+}
+
+// readRequirements reads the pinned requirements (name==version) of a pip
+// requirements file.
+func readRequirements(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		if match := requirementLineRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text())); match != nil {
+			dependencies = append(dependencies, Dependency{Ecosystem: EcosystemPyPI, Name: match[1], Version: match[3]})
+		}
+	}
+
+	return dependencies
+}
+
+// readPoetryLock reads the [[package]] tables of a poetry.lock.
+func readPoetryLock(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	var current *Dependency
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "[") {
+			current = nil
+
+			if line == "[[package]]" {
+				dependencies = append(dependencies, Dependency{Ecosystem: EcosystemPyPI})
+				current = &dependencies[len(dependencies)-1]
+			}
+
+			continue
+		}
+
+		if match := tomlStringRegexp.FindStringSubmatch(line); match != nil && current != nil {
+			if match[1] == "name" {
+				current.Name = match[2]
+			} else {
+				current.Version = match[2]
+			}
+		}
+	}
+
+	return dependencies
+}
+
+func readPipfileLock(data []byte) []Dependency {
+
+	var lock map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil
+	}
+
+	var dependencies []Dependency
+
+	for _, section := range []string{"default", "develop"} {
+		var packages map[string]struct {
+			Version string `json:"version"`
+		}
+
+		if err := json.Unmarshal(lock[section], &packages); err != nil {
+			continue
+		}
+
+		for name, pkg := range packages {
+			dependencies = append(dependencies, Dependency{
+				Ecosystem: EcosystemPyPI,
+				Name:      name,
+				Version:   strings.TrimLeft(pkg.Version, "="),
+			})
+		}
+	}
+
+	return dependencies
+}
+
+func readPythonMetadata(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	for _, pkg := range readPythonLicense(data) {
+		dependencies = append(dependencies, Dependency{Ecosystem: EcosystemPyPI, Name: pkg.Package, Version: pkg.Version})
+	}
+
+	return dependencies
+}
+
+// readGemfileLock reads the specs of a Gemfile.lock's GEM section, dropping
+// the platforms from versions (e.g. "1.13.1-x86_64-linux").
+func readGemfileLock(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line != "" && line[0] != ' ' {
+			section = line
+			continue
+		}
+
+		if match := gemfileSpecRegexp.FindStringSubmatch(line); match != nil && section == "GEM" {
+			dependencies = append(dependencies, Dependency{
+				Ecosystem: EcosystemRubyGems,
+				Name:      match[1],
+				Version:   strings.SplitN(match[2], "-", 2)[0],
+			})
+		}
+	}
+
+	return dependencies
+}
+
+func readGemspec(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	for _, pkg := range readGemLicense(data) {
+		dependencies = append(dependencies, Dependency{Ecosystem: EcosystemRubyGems, Name: pkg.Package, Version: pkg.Version})
+	}
+
+	return dependencies
+}
+
+func readComposerLock(data []byte) []Dependency {
+
+	type composerPackage struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	var lock struct {
+		Packages    []composerPackage `json:"packages"`
+		PackagesDev []composerPackage `json:"packages-dev"`
+	}
+
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil
+	}
+
+	var dependencies []Dependency
+
+	for _, pkg := range append(lock.Packages, lock.PackagesDev...) {
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: EcosystemPackagist,
+			Name:      pkg.Name,
+			Version:   strings.TrimPrefix(pkg.Version, "v"),
+		})
+	}
+
+	return dependencies
+}
+
+func readComposerInstalled(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	for _, pkg := range readComposerLicenses(data) {
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: EcosystemPackagist,
+			Name:      pkg.Package,
+			Version:   strings.TrimPrefix(pkg.Version, "v"),
+		})
+	}
+
+	return dependencies
+}
+
+// goBinaryDependencies returns the build info of a Go binary (as written by
+// `go version -m`), or nil when it isn't a Go binary.
+func goBinaryDependencies(binary io.ReaderAt) []byte {
+
+	info, err := buildinfo.Read(binary)
+
+	if err != nil {
+		return nil
+	}
+
+	return []byte(info.String())
+}
+
+// readGoBinaryDependencies reads the modules of a Go binary's build info,
+// and its standard library ("stdlib" module, versioned as Go itself).
+func readGoBinaryDependencies(data []byte) []Dependency {
+
+	info, err := debug.ParseBuildInfo(string(data))
+
+	if err != nil {
+		return nil
+	}
+
+	// ParseBuildInfo doesn't read the Go version ("go\tgo1.20.1" line)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "go\t") {
+			info.GoVersion = strings.TrimPrefix(line, "go\t")
+			break
+		}
+	}
+
+	dependencies := []Dependency{{
+		Ecosystem: EcosystemGo,
+		Name:      "stdlib",
+		Version:   strings.TrimPrefix(info.GoVersion, "go"),
+	}}
+
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+
+	for _, module := range modules {
+		if module.Replace != nil {
+			module = module.Replace
+		}
+
+		if module.Path == "" || module.Version == "(devel)" {
+			continue
+		}
+
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: EcosystemGo,
+			Name:      module.Path,
+			Version:   strings.TrimPrefix(module.Version, "v"),
+		})
+	}
+
+	return dependencies
+}
+
+// javaArchiveDependencies returns the Maven coordinates of the pom.properties
+// on a Java archive, and on the archives nested on it (e.g. fat jars' or
+// wars' libraries), as "groupId:artifactId version" lines.
+func javaArchiveDependencies(data io.ReaderAt, size int64) []byte {
+
+	var coordinates bytes.Buffer
+
+	var read func(data io.ReaderAt, size int64, depth int)
+
+	read = func(data io.ReaderAt, size int64, depth int) {
+		archive, err := zip.NewReader(data, size)
+
+		if err != nil {
+			return
+		}
+
+		for _, file := range archive.File {
+			isPom := pomPropertiesRegexp.MatchString(file.Name)
+			isNested := depth == 0 && javaArchiveRegexp.MatchString(file.Name)
+
+			if !isPom && !isNested {
+				continue
+			}
+
+			reader, err := file.Open()
+
+			if err != nil {
+				continue
+			}
+
+			content, err := ioutil.ReadAll(io.LimitReader(reader, maxDependencyFileSize))
+			reader.Close()
+
+			if err != nil {
+				continue
+			}
+
+			if isNested {
+				read(bytes.NewReader(content), int64(len(content)), depth+1)
+				continue
+			}
+
+			properties := readProperties(content)
+
+			fmt.Fprintf(&coordinates, "%s:%s %s\n", properties["groupId"], properties["artifactId"], properties["version"])
+		}
+	}
+
+	read(data, size, 0)
+
+	return coordinates.Bytes()
+}
+
+func readJavaArchiveDependencies(data []byte) []Dependency {
+
+	var dependencies []Dependency
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && !strings.HasPrefix(fields[0], ":") && !strings.HasSuffix(fields[0], ":") {
+			dependencies = append(dependencies, Dependency{Ecosystem: EcosystemMaven, Name: fields[0], Version: fields[1]})
+		}
+	}
+
+	return dependencies
+}
+
+// readProperties reads the "key=value" lines of a Java properties file.
+func readProperties(data []byte) map[string]string {
+
+	properties := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			properties[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return properties
+}
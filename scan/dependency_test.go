@@ -0,0 +1,171 @@
+package scan
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestJavaArchive writes a jar holding the pom.properties of the given
+// coordinates ("groupId:artifactId:version") and the nested archives.
+func newTestJavaArchive(t *testing.T, coordinates string, nested map[string][]byte) []byte {
+
+	var buffer bytes.Buffer
+
+	archive := zip.NewWriter(&buffer)
+
+	parts := strings.Split(coordinates, ":")
+
+	writer, err := archive.Create("META-INF/maven/" + parts[0] + "/" + parts[1] + "/pom.properties")
+	require.NoError(t, err)
+
+	writer.Write([]byte("#Generated by Maven\ngroupId=" + parts[0] + "\nartifactId=" + parts[1] + "\nversion=" + parts[2] + "\n"))
+
+	for name, content := range nested {
+		writer, err := archive.Create(name)
+		require.NoError(t, err)
+
+		writer.Write(content)
+	}
+
+	require.NoError(t, archive.Close())
+
+	return buffer.Bytes()
+}
+
+func TestReadDependencies(t *testing.T) {
+	t.Run(`Ensure dependencies are read from lockfiles and packages' metadata`, func(t *testing.T) {
+		files := map[string]string{
+			"app/package-lock.json":    `{"lockfileVersion":3,"packages":{"":{"name":"app"},"node_modules/lodash":{"version":"4.17.11"},"node_modules/a/node_modules/@types/node":{"version":"18.0.0"},"node_modules/local":{"link":true}}}`,
+			"legacy/package-lock.json": `{"lockfileVersion":1,"dependencies":{"express":{"version":"4.16.0","dependencies":{"qs":{"version":"6.5.1"}}}}}`,
+			"web/yarn.lock":            "# yarn lockfile v1\n\n\"@babel/core@^7.0.0\", \"@babel/core@^7.1.0\":\n  version \"7.1.2\"\n  resolved \"https://registry.yarnpkg.com/@babel/core/-/core-7.1.2.tgz\"\n\nminimist@^1.2.0:\n  version \"1.2.5\"\n",
+			"app/requirements.txt":     "# pinned\nDjango==3.2.1\nrequests[security] == 2.25.0 ; python_version > '3'\nflask>=1.0\n",
+			"app/poetry.lock":          "[[package]]\nname = \"jinja2\"\nversion = \"2.10\"\n\n[package.dependencies]\nname = \"ignored\"\n",
+			"app/Pipfile.lock":         `{"default":{"urllib3":{"version":"==1.26.4"}},"develop":{"pytest":{"version":"==6.2.2"}}}`,
+			"app/Gemfile.lock":         "GEM\n  remote: https://rubygems.org/\n  specs:\n    nokogiri (1.13.1-x86_64-linux)\n      racc (~> 1.4)\n    rack (2.2.3)\n\nPLATFORMS\n  x86_64-linux\n",
+			"app/composer.lock":        `{"packages":[{"name":"guzzlehttp/guzzle","version":"v7.4.1"}],"packages-dev":[{"name":"phpunit/phpunit","version":"9.5.10"}]}`,
+			"usr/lib/python3/site-packages/PyYAML-5.3.dist-info/METADATA": "Metadata-Version: 2.1\nName: PyYAML\nVersion: 5.3\n",
+		}
+
+		layerFiles := make(map[string]layerFile)
+
+		for name, content := range files {
+			layerFiles[name] = layerFile{layer: "sha256:layer0", typeflag: '0', content: []byte(content)}
+		}
+
+		jar := newTestJavaArchive(t, "com.example:app:1.0.0", map[string][]byte{"BOOT-INF/lib/jackson-databind-2.9.8.jar": newTestJavaArchive(t, "com.fasterxml.jackson.core:jackson-databind:2.9.8", nil)})
+
+		layerFiles["app/app.jar"] = layerFile{
+			layer:   "sha256:layer1",
+			content: javaArchiveDependencies(bytes.NewReader(jar), int64(len(jar))),
+		}
+
+		var found []string
+
+		for _, dependency := range readDependencies(layerFiles) {
+			found = append(found, dependency.Path+" "+dependency.Ecosystem+" "+dependency.Name+"@"+dependency.Version)
+		}
+
+		expected := []string{
+			"app/Gemfile.lock RubyGems nokogiri@1.13.1",
+			"app/Gemfile.lock RubyGems rack@2.2.3",
+			"app/Pipfile.lock PyPI pytest@6.2.2",
+			"app/Pipfile.lock PyPI urllib3@1.26.4",
+			"app/app.jar Maven com.example:app@1.0.0",
+			"app/app.jar Maven com.fasterxml.jackson.core:jackson-databind@2.9.8",
+			"app/composer.lock Packagist guzzlehttp/guzzle@7.4.1",
+			"app/composer.lock Packagist phpunit/phpunit@9.5.10",
+			"app/package-lock.json npm @types/node@18.0.0",
+			"app/package-lock.json npm lodash@4.17.11",
+			"app/poetry.lock PyPI jinja2@2.10",
+			"app/requirements.txt PyPI Django@3.2.1",
+			"app/requirements.txt PyPI requests@2.25.0",
+			"legacy/package-lock.json npm express@4.16.0",
+			"legacy/package-lock.json npm qs@6.5.1",
+			"usr/lib/python3/site-packages/PyYAML-5.3.dist-info/METADATA PyPI PyYAML@5.3",
+			"web/yarn.lock npm @babel/core@7.1.2",
+			"web/yarn.lock npm minimist@1.2.5",
+		}
+
+		assert.Equal(t, expected, found)
+	})
+}
+
+func TestDependencyScanner_Scan(t *testing.T) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	binary, err := ioutil.ReadFile(executable)
+	require.NoError(t, err)
+
+	goVersion := strings.TrimPrefix(runtime.Version(), "go")
+
+	stdlibAdvisory := `{
+		"id": "GO-2099-0001",
+		"summary": "Vulnerability in the standard library",
+		"affected": [{
+			"package": {"ecosystem": "Go", "name": "stdlib"},
+			"ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "999.0.0"}]}]
+		}]
+	}`
+
+	dir := newTestOSVDatabase(t, map[string]string{"lodash.json": testLodashAdvisory, "stdlib.json": stdlibAdvisory}, nil)
+
+	db, err := LoadOSVDatabase(dir)
+	require.NoError(t, err)
+
+	server, image := newTestRegistry(t,
+		[]testFile{
+			{name: "app/node_modules/lodash/package.json", content: `{"name":"lodash","version":"4.17.11"}`},
+			{name: "usr/local/bin/entrypoint.sh", content: "#!/bin/sh\nexec app\n", mode: 0755},
+			{name: "usr/local/bin/app", content: string(binary), mode: 0755},
+		},
+		[]testFile{
+			{name: "app/node_modules/lodash/.wh.package.json"},
+			{name: "srv/package-lock.json", content: `{"lockfileVersion":2,"packages":{"node_modules/lodash":{"version":"4.17.4"}}}`},
+		},
+	)
+
+	defer server.Close()
+
+	scanner := &DependencyScanner{
+		Name:     "osv",
+		Database: db,
+		Source: &MockSource{
+			MockFetch: func(string) ([]PlatformImage, error) {
+				return []PlatformImage{{Image: image}}, nil
+			},
+		},
+	}
+
+	result := scanner.Scan("tsuru/cst:latest")
+
+	require.Nil(t, result.Error)
+	require.Len(t, result.Findings, 2)
+
+	assert.Equal(t, Finding{
+		CVE:      "CVE-2019-10744",
+		Scanner:  "osv",
+		Package:  "lodash",
+		Version:  "4.17.4",
+		FixedBy:  "4.17.12",
+		Severity: SeverityCritical,
+		Link:     "https://osv.dev/vulnerability/GHSA-jf85-cpcp-j695",
+		Title:    "Prototype Pollution in lodash",
+		Path:     "srv/package-lock.json",
+		Layer:    "sha256:layer1",
+	}, result.Findings[0])
+
+	assert.Equal(t, "GO-2099-0001", result.Findings[1].CVE)
+	assert.Equal(t, "stdlib", result.Findings[1].Package)
+	assert.Equal(t, goVersion, result.Findings[1].Version)
+	assert.Equal(t, "usr/local/bin/app", result.Findings[1].Path)
+	assert.Equal(t, "sha256:layer0", result.Findings[1].Layer)
+}
@@ -0,0 +1,142 @@
+package scan
+
+import "sort"
+
+// SeverityChange holds a finding present on both scans whose severity has
+// changed.
+type SeverityChange struct {
+	Finding
+	PreviousSeverity string `json:"previousSeverity"`
+}
+
+// PackageDiff holds the changed findings of a single package.
+type PackageDiff struct {
+	Package string           `json:"package"`
+	Added   []Finding        `json:"added,omitempty"`
+	Removed []Finding        `json:"removed,omitempty"`
+	Changed []SeverityChange `json:"changed,omitempty"`
+}
+
+// Diff holds what changed, security-wise, from a scan (From) to another one
+// (To), grouped by package.
+type Diff struct {
+	Image    string        `json:"image"`
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Packages []PackageDiff `json:"packages"`
+}
+
+// DiffScans compares the findings of two scans. Findings are matched by
+// scanner, platform and key (see Finding.Key). A scanner and platform pair with errors on
+// either scan is ignored on both of them, so findings reported only by a failed
+// scanner are neither added nor removed.
+func DiffScans(from, to Scan) Diff {
+
+	failed := failedResults(from, to)
+
+	previous := findingsByKey(from, failed)
+	current := findingsByKey(to, failed)
+
+	packages := make(map[string]*PackageDiff)
+
+	packageDiff := func(name string) *PackageDiff {
+		if _, ok := packages[name]; !ok {
+			packages[name] = &PackageDiff{Package: name}
+		}
+
+		return packages[name]
+	}
+
+	for key, finding := range current {
+		old, ok := previous[key]
+
+		switch {
+		case !ok:
+			pd := packageDiff(finding.Package)
+			pd.Added = append(pd.Added, finding)
+		case old.Severity != finding.Severity:
+			pd := packageDiff(finding.Package)
+			pd.Changed = append(pd.Changed, SeverityChange{
+				Finding:          finding,
+				PreviousSeverity: old.Severity,
+			})
+		}
+	}
+
+	for key, finding := range previous {
+		if _, ok := current[key]; !ok {
+			pd := packageDiff(finding.Package)
+			pd.Removed = append(pd.Removed, finding)
+		}
+	}
+
+	diff := Diff{
+		Image:    to.Image,
+		From:     from.ID,
+		To:       to.ID,
+		Packages: make([]PackageDiff, 0, len(packages)),
+	}
+
+	for _, pd := range packages {
+		sortFindings(pd.Added)
+		sortFindings(pd.Removed)
+
+		sort.Slice(pd.Changed, func(i, j int) bool {
+			return pd.Changed[i].CVE < pd.Changed[j].CVE
+		})
+
+		diff.Packages = append(diff.Packages, *pd)
+	}
+
+	sort.Slice(diff.Packages, func(i, j int) bool {
+		return diff.Packages[i].Package < diff.Packages[j].Package
+	})
+
+	return diff
+}
+
+// failedResults returns the scanner and platform pairs (see resultKey) with
+// errors on any of the scans.
+func failedResults(scans ...Scan) map[string]bool {
+
+	failed := make(map[string]bool)
+
+	for _, s := range scans {
+		for _, result := range s.Result {
+			if result.Error != nil {
+				failed[resultKey(result)] = true
+			}
+		}
+	}
+
+	return failed
+}
+
+func findingsByKey(s Scan, failed map[string]bool) map[string]Finding {
+
+	findings := make(map[string]Finding)
+
+	for _, result := range s.Result {
+		if result.Error != nil || failed[resultKey(result)] {
+			continue
+		}
+
+		for _, finding := range result.Findings {
+			finding.Platform = result.Platform
+
+			findings[resultKey(result)+"|"+finding.Key()] = finding
+		}
+	}
+
+	return findings
+}
+
+func resultKey(result Result) string {
+	return result.Scanner + "|" + result.Platform
+}
+
+func sortFindings(findings []Finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].CVE < findings[j].CVE
+	})
+}
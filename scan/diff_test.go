@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffScans(t *testing.T) {
+
+	t.Run(`Ensure added, removed and severity changed findings are grouped by package`, func(t *testing.T) {
+		from := Scan{
+			ID:    "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Image: "tsuru/cst:latest",
+			Result: []Result{
+				Result{
+					Scanner: "clair",
+					Findings: []Finding{
+						{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Severity: "High"},
+						{CVE: "CVE-2018-0737", Scanner: "clair", Package: "openssl", Severity: "Low"},
+						{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Severity: "High"},
+					},
+				},
+			},
+		}
+
+		to := Scan{
+			ID:    "83633447-353f-4e87-aa95-2a44205eb89e",
+			Image: "tsuru/cst:latest",
+			Result: []Result{
+				Result{
+					Scanner: "clair",
+					Findings: []Finding{
+						{CVE: "CVE-2018-0737", Scanner: "clair", Package: "openssl", Severity: "Medium"},
+						{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Severity: "High"},
+						{CVE: "CVE-2018-14618", Scanner: "clair", Package: "curl", Severity: "Critical"},
+					},
+				},
+			},
+		}
+
+		expected := Diff{
+			Image: "tsuru/cst:latest",
+			From:  "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			To:    "83633447-353f-4e87-aa95-2a44205eb89e",
+			Packages: []PackageDiff{
+				{
+					Package: "curl",
+					Added:   []Finding{{CVE: "CVE-2018-14618", Scanner: "clair", Package: "curl", Severity: "Critical"}},
+				},
+				{
+					Package: "openssl",
+					Removed: []Finding{{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Severity: "High"}},
+					Changed: []SeverityChange{
+						{
+							Finding:          Finding{CVE: "CVE-2018-0737", Scanner: "clair", Package: "openssl", Severity: "Medium"},
+							PreviousSeverity: "Low",
+						},
+					},
+				},
+			},
+		}
+
+		assert.Equal(t, expected, DiffScans(from, to))
+	})
+
+	t.Run(`When scans have the same findings, should return no packages`, func(t *testing.T) {
+		s := Scan{
+			Result: []Result{
+				Result{Scanner: "clair", Findings: []Finding{{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"}}},
+				Result{Scanner: "another", Error: &Error{Code: ErrorCodeTimeout}},
+			},
+		}
+
+		assert.Empty(t, DiffScans(s, s).Packages)
+	})
+	t.Run(`When a scanner has failed on either scan, should ignore its findings on both of them`, func(t *testing.T) {
+		from := Scan{
+			Result: []Result{
+				Result{Scanner: "clair", Platform: "linux/amd64", Findings: []Finding{{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"}}},
+				Result{Scanner: "clair", Platform: "linux/arm64", Findings: []Finding{{CVE: "CVE-2018-0737", Scanner: "clair", Package: "openssl"}}},
+				Result{Scanner: "secrets", Error: &Error{Code: ErrorCodeTimeout}},
+			},
+		}
+
+		to := Scan{
+			Result: []Result{
+				Result{Scanner: "clair", Platform: "linux/amd64", Findings: []Finding{{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"}}},
+				Result{Scanner: "clair", Platform: "linux/arm64", Error: &Error{Code: ErrorCodeTimeout}},
+				Result{Scanner: "secrets", Findings: []Finding{{Rule: "aws-access-key", Scanner: "secrets", Path: "/app/.env"}}},
+			},
+		}
+
+		assert.Empty(t, DiffScans(from, to).Packages)
+		assert.Empty(t, DiffScans(to, from).Packages)
+	})
+
+	t.Run(`When a finding is fixed on a single platform, should report it removed from that platform`, func(t *testing.T) {
+		finding := Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl"}
+
+		from := Scan{
+			Result: []Result{
+				Result{Scanner: "clair", Platform: "linux/amd64", Findings: []Finding{finding}},
+				Result{Scanner: "clair", Platform: "linux/arm64", Findings: []Finding{finding}},
+			},
+		}
+
+		to := Scan{
+			Result: []Result{
+				Result{Scanner: "clair", Platform: "linux/amd64", Findings: []Finding{finding}},
+				Result{Scanner: "clair", Platform: "linux/arm64"},
+			},
+		}
+
+		diff := DiffScans(from, to)
+
+		if assert.Len(t, diff.Packages, 1) && assert.Len(t, diff.Packages[0].Removed, 1) {
+			assert.Empty(t, diff.Packages[0].Added)
+			assert.Equal(t, "linux/arm64", diff.Packages[0].Removed[0].Platform)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package scan
+
+import (
+	"fmt"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrorCode is a type used to classify why a scanner couldn't analyze an image.
+type ErrorCode string
+
+const (
+	// ErrorCodeImageNotFound indicates the image doesn't exist on registry.
+	ErrorCodeImageNotFound = ErrorCode("image-not-found")
+
+	// ErrorCodeInternal indicates an unexpected failure on scanner.
+	ErrorCodeInternal = ErrorCode("internal")
+
+	// ErrorCodeInvalidImage indicates the image reference couldn't be parsed.
+	ErrorCodeInvalidImage = ErrorCode("invalid-image")
+
+	// ErrorCodeTimeout indicates some service took too long to respond.
+	ErrorCodeTimeout = ErrorCode("timeout")
+
+	// ErrorCodeUnauthorized indicates the credentials were refused (or
+	// missing) to access the image.
+	ErrorCodeUnauthorized = ErrorCode("unauthorized")
+
+	// ErrorCodeUnavailable indicates some service (registry or security
+	// engine) couldn't be reached or replied with server errors.
+	ErrorCodeUnavailable = ErrorCode("unavailable")
+
+	// ErrorCodeUnsupported indicates some content of the image couldn't be
+	// read by the scanner (e.g. a rpm database on SQLite format), so its
+	// result may be incomplete.
+	ErrorCodeUnsupported = ErrorCode("unsupported")
+
+	// ErrorCodeUnknown indicates the failure reason couldn't be classified.
+	ErrorCodeUnknown = ErrorCode("unknown")
+
+	// ErrorCodeWorkerLost indicates the worker running the scan has gone
+	// away before finishing it.
+	ErrorCodeWorkerLost = ErrorCode("worker-lost")
+)
+
+// Phase is a type used to indicate the analysis step in which an error occurred.
+type Phase string
+
+const (
+	// PhaseAnalyze indicates the security engine was analyzing the image.
+	PhaseAnalyze = Phase("analyze")
+
+	// PhaseAuth indicates the scanner was authenticating on registry.
+	PhaseAuth = Phase("auth")
+
+	// PhasePull indicates the scanner was fetching the image's manifest and
+	// layers from registry.
+	PhasePull = Phase("pull")
+)
+
+// Error holds the details of why a scanner couldn't analyze an image.
+// Transient indicates the error was caused by a temporary condition (e.g.
+// network failures) and the analysis could succeed if retried.
+type Error struct {
+	Code      ErrorCode `bson:"code" json:"code"`
+	Phase     Phase     `bson:"phase,omitempty" json:"phase,omitempty"`
+	Message   string    `bson:"message" json:"message"`
+	Transient bool      `bson:"transient,omitempty" json:"transient,omitempty"`
+}
+
+func (e *Error) Error() string {
+
+	if e.Phase == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+
+	return fmt.Sprintf("%s (%s): %s", e.Code, e.Phase, e.Message)
+}
+
+// SetBSON implements the bson.Setter interface to keep compatibility with
+// results stored when errors were plain strings.
+func (e *Error) SetBSON(raw bson.Raw) error {
+
+	// 0x02 is the BSON kind for UTF-8 strings
+	if raw.Kind == 0x02 {
+		var message string
+
+		if err := raw.Unmarshal(&message); err != nil {
+			return err
+		}
+
+		*e = Error{
+			Code:    ErrorCodeUnknown,
+			Message: message,
+		}
+
+		return nil
+	}
+
+	type plainError Error
+
+	return raw.Unmarshal((*plainError)(e))
+}
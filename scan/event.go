@@ -0,0 +1,42 @@
+package scan
+
+import "time"
+
+// EventType indicates what has changed on a scan.
+type EventType string
+
+const (
+	// EventStatusChanged indicates the scan has moved to another status.
+	EventStatusChanged = EventType("status")
+
+	// EventResultAppended indicates some scanner has reported its result.
+	EventResultAppended = EventType("result")
+)
+
+// Event describes a change on a scan, e.g. its transition from scheduled to
+// running or the result of a scanner appended to it.
+type Event struct {
+	Type    EventType `bson:"type" json:"type"`
+	ScanID  string    `bson:"scanID" json:"scanID"`
+	Image   string    `bson:"image" json:"image"`
+	Status  Status    `bson:"status,omitempty" json:"status,omitempty"`
+	Scanner string    `bson:"scanner,omitempty" json:"scanner,omitempty"`
+	Error   *Error    `bson:"error,omitempty" json:"error,omitempty"`
+	Time    time.Time `bson:"time" json:"time"`
+}
+
+// IsFinal returns true when the scan has moved to a status it won't leave by
+// itself (i.e. finished, partial, failed, aborted or dead-letter).
+func (e Event) IsFinal() bool {
+
+	if e.Type != EventStatusChanged {
+		return false
+	}
+
+	switch e.Status {
+	case StatusFinished, StatusPartial, StatusFailed, StatusAborted, StatusDeadLetter:
+		return true
+	default:
+		return false
+	}
+}
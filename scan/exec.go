@@ -0,0 +1,413 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ExecProtocolVersion is the version of the protocol spoken with scanner
+// plugins (see ExecRequest and ExecResponse). Plugins must reply with the
+// same version they were requested with.
+const ExecProtocolVersion = "1"
+
+const (
+	// defaultExecTimeout is how long plugins can run when
+	// ExecScanner.Timeout is zero.
+	defaultExecTimeout = 10 * time.Minute
+
+	// defaultExecMaxOutputSize is the largest response read from plugins
+	// when ExecLimits.MaxOutputSize is zero.
+	defaultExecMaxOutputSize = 32 << 20
+
+	// execStderrSize is how much of the plugins' stderr is kept to describe
+	// their failures.
+	execStderrSize = 4 << 10
+)
+
+// execErrorCodes are the error codes plugins can reply with; other ones are
+// taken as ErrorCodeUnknown.
+var execErrorCodes = map[ErrorCode]bool{
+	ErrorCodeImageNotFound: true,
+	ErrorCodeInternal:      true,
+	ErrorCodeInvalidImage:  true,
+	ErrorCodeTimeout:       true,
+	ErrorCodeUnauthorized:  true,
+	ErrorCodeUnavailable:   true,
+	ErrorCodeUnknown:       true,
+}
+
+// ExecRequest is written as JSON on the plugins' stdin, once per analyzed
+// platform of the image. Registry is the registry's API base URL (e.g.
+// "https://registry-1.docker.io/v2"), which the image's manifest and layers
+// (from base to top) are fetched from, sending Authorization (when not
+// empty) as the Authorization header. Options are the plugin's options as
+// declared on its config.
+type ExecRequest struct {
+	Version       string            `json:"version"`
+	Scanner       string            `json:"scanner"`
+	Image         string            `json:"image"`
+	Platform      string            `json:"platform,omitempty"`
+	Registry      string            `json:"registry"`
+	Repository    string            `json:"repository"`
+	Tag           string            `json:"tag"`
+	Digest        string            `json:"digest,omitempty"`
+	Layers        []string          `json:"layers"`
+	Authorization string            `json:"authorization,omitempty"`
+	Options       map[string]string `json:"options,omitempty"`
+}
+
+// ExecResponse is read as JSON from the plugins' stdout. Findings are
+// normalized as the built-in scanners' ones (their scanner is overridden by
+// the plugin's name and severities are normalized), while Vulnerabilities
+// keeps the plugin's raw format. Plugins unable to analyze the image reply
+// with Error instead.
+type ExecResponse struct {
+	Version         string      `json:"version"`
+	Findings        []Finding   `json:"findings"`
+	Vulnerabilities interface{} `json:"vulnerabilities,omitempty"`
+	Error           *Error      `json:"error,omitempty"`
+}
+
+// ExecLimits bounds the resources of plugins' processes: Memory is the
+// largest virtual memory (in bytes) and CPU the CPU time (in seconds) they
+// can use, both disabled when zero and only enforced on Linux. Responses
+// larger than MaxOutputSize bytes are refused.
+type ExecLimits struct {
+	Memory        uint64 `yaml:"memory"`
+	CPU           uint64 `yaml:"cpu"`
+	MaxOutputSize int64  `yaml:"maxOutputSize"`
+}
+
+// ExecScanner implements the Scanner, PlatformScanner and Pinger interfaces by
+// running an external scanner (plugin): Command is run with Args for every
+// analyzed platform of the image, speaking the protocol described by
+// ExecRequest and ExecResponse. Plugins don't inherit the worker's
+// environment: Env entries are either "NAME=value" or "NAME", the latter
+// copying that variable from the worker's environment. Plugins are killed
+// (with their child processes) after Timeout.
+type ExecScanner struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     []string          `yaml:"env"`
+	Options map[string]string `yaml:"options"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Limits  ExecLimits        `yaml:"limits"`
+	Source  Source            `yaml:"-"`
+}
+
+// LoadExecScanners reads the plugins declared on a YAML file, e.g.:
+//
+//	scanners:
+//	- name: acme
+//	  command: /usr/local/bin/acme-scanner
+//	  args: [--format, cst]
+//	  env: [ACME_TOKEN, ACME_REGION=us-east-1]
+//	  options:
+//	    policy: strict
+//	  timeout: 5m
+//	  limits:
+//	    memory: 1073741824
+//	    cpu: 120
+func LoadExecScanners(file string) ([]*ExecScanner, error) {
+
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Scanners []*ExecScanner `yaml:"scanners"`
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+
+	for index, scanner := range config.Scanners {
+		if scanner.Name == "" || scanner.Command == "" {
+			return nil, fmt.Errorf("scanner plugin #%d: name and command are required", index+1)
+		}
+
+		if names[scanner.Name] {
+			return nil, fmt.Errorf("scanner plugin %q: name already declared", scanner.Name)
+		}
+
+		names[scanner.Name] = true
+	}
+
+	return config.Scanners, nil
+}
+
+// Ping checks whether the plugin's command can be found and executed.
+func (s *ExecScanner) Ping() error {
+	_, err := exec.LookPath(s.Command)
+	return err
+}
+
+// Scan runs the plugin over a container image. Only the first platform of
+// multi-platform images is analyzed (see ScanPlatforms).
+func (s *ExecScanner) Scan(image string) Result {
+	return s.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms runs the plugin over every platform of a container image,
+// returning a result per platform.
+func (s *ExecScanner) ScanPlatforms(image string) []Result {
+
+	log := logrus.
+		WithField("scanner", s.Name).
+		WithField("image", image)
+
+	log.Info("initializing scan on scanner plugin")
+
+	defer log.Info("finishing scan on scanner plugin")
+
+	source := s.Source
+
+	if source == nil {
+		source = &RegistrySource{}
+	}
+
+	pullStartedAt := time.Now()
+
+	images, err := source.Fetch(image)
+
+	metrics.ImagePullDuration.Observe(time.Since(pullStartedAt).Seconds(), s.Name)
+
+	if err != nil {
+		return []Result{errorResult(s.Name, PhasePull, err)}
+	}
+
+	results := make([]Result, 0, len(images))
+
+	for _, platformImage := range images {
+		result := s.analyze(image, platformImage)
+		result.Platform = platformImage.Platform
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (s *ExecScanner) analyze(image string, platformImage PlatformImage) Result {
+
+	layers := fetchLayers(platformImage.Image)
+	digest := resolveDigest(platformImage.Image)
+
+	response, err := s.run(execRequestOf(s.Name, image, platformImage, digest, s.Options))
+
+	if err != nil {
+		return errorResult(s.Name, PhaseAnalyze, err)
+	}
+
+	if response.Error != nil {
+		if !execErrorCodes[response.Error.Code] {
+			response.Error.Code = ErrorCodeUnknown
+		}
+
+		return errorResult(s.Name, PhaseAnalyze, response.Error)
+	}
+
+	for index := range response.Findings {
+		response.Findings[index].Scanner = s.Name
+		response.Findings[index].Severity = NormalizeSeverity(response.Findings[index].Severity)
+	}
+
+	attributeFindings(response.Findings, layers)
+
+	return Result{
+		Scanner:         s.Name,
+		Digest:          digest,
+		Vulnerabilities: response.Vulnerabilities,
+		Findings:        response.Findings,
+		Layers:          layers,
+	}
+}
+
+// run starts the plugin, writes the request on its stdin and reads its
+// response from stdout.
+func (s *ExecScanner) run(request ExecRequest) (ExecResponse, error) {
+
+	var response ExecResponse
+
+	input, err := json.Marshal(request)
+
+	if err != nil {
+		return response, err
+	}
+
+	maxOutputSize := s.Limits.MaxOutputSize
+
+	if maxOutputSize <= 0 {
+		maxOutputSize = defaultExecMaxOutputSize
+	}
+
+	timeout := s.Timeout
+
+	if timeout <= 0 {
+		timeout = defaultExecTimeout
+	}
+
+	stdout := &limitedBuffer{limit: maxOutputSize}
+	stderr := &limitedBuffer{limit: execStderrSize}
+
+	cmd := exec.Command(s.Command, s.Args...)
+	cmd.Env = s.environment()
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := startCommand(cmd, s.Limits); err != nil {
+		return response, &Error{
+			Code:    ErrorCodeInternal,
+			Message: fmt.Sprintf("could not run scanner plugin: %s", err),
+		}
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killCommand(cmd)
+		<-done
+
+		return response, &Error{
+			Code:      ErrorCodeTimeout,
+			Message:   fmt.Sprintf("scanner plugin has not finished after %s", timeout),
+			Transient: true,
+		}
+	}
+
+	if stdout.overflow {
+		return response, &Error{
+			Code:    ErrorCodeInternal,
+			Message: fmt.Sprintf("scanner plugin's response is larger than %d bytes", maxOutputSize),
+		}
+	}
+
+	// plugins may exit with failure after replying their error
+	if decodeErr := json.Unmarshal(stdout.Bytes(), &response); decodeErr != nil {
+		if err == nil {
+			err = decodeErr
+		}
+
+		return response, &Error{
+			Code:    ErrorCodeUnknown,
+			Message: fmt.Sprintf("scanner plugin failed: %s: %s", err, strings.TrimSpace(stderr.String())),
+		}
+	}
+
+	if response.Version != ExecProtocolVersion {
+		return response, &Error{
+			Code:    ErrorCodeInternal,
+			Message: fmt.Sprintf("scanner plugin replied with protocol version %q, expected %q", response.Version, ExecProtocolVersion),
+		}
+	}
+
+	if err != nil && response.Error == nil {
+		return response, &Error{
+			Code:    ErrorCodeUnknown,
+			Message: fmt.Sprintf("scanner plugin failed: %s: %s", err, strings.TrimSpace(stderr.String())),
+		}
+	}
+
+	return response, nil
+}
+
+// environment returns the plugin's environment variables.
+func (s *ExecScanner) environment() []string {
+
+	env := make([]string, 0, len(s.Env))
+
+	for _, variable := range s.Env {
+		if strings.Contains(variable, "=") {
+			env = append(env, variable)
+			continue
+		}
+
+		if value, ok := os.LookupEnv(variable); ok {
+			env = append(env, variable+"="+value)
+		}
+	}
+
+	return env
+}
+
+func execRequestOf(scanner, image string, platformImage PlatformImage, digest string, options map[string]string) ExecRequest {
+
+	layers := nonEmptyLayers(platformImage.Image)
+
+	if layers == nil {
+		layers = []string{}
+	}
+
+	return ExecRequest{
+		Version:       ExecProtocolVersion,
+		Scanner:       scanner,
+		Image:         image,
+		Platform:      platformImage.Platform,
+		Registry:      platformImage.Registry,
+		Repository:    platformImage.Name,
+		Tag:           platformImage.Tag,
+		Digest:        digest,
+		Layers:        layers,
+		Authorization: platformImage.Token,
+		Options:       options,
+	}
+}
+
+// limitedBuffer keeps up to limit bytes written to it, discarding (and
+// flagging) the exceeding ones, so processes writing too much aren't
+// blocked. The buffer isn't embedded, otherwise io.Copy would bypass Write
+// through bytes.Buffer's ReadFrom.
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+
+	if available := b.limit - int64(b.buffer.Len()); int64(len(p)) > available {
+		b.overflow = true
+
+		if available > 0 {
+			b.buffer.Write(p[:available])
+		}
+
+		return len(p), nil
+	}
+
+	return b.buffer.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buffer.String()
+}
@@ -0,0 +1,42 @@
+package scan
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// startCommand starts the plugin on its own process group, so its child
+// processes are killed along with it. Memory and CPU limits are set by a
+// shell which then replaces itself by the plugin, so they're already in
+// force when the plugin starts.
+func startCommand(cmd *exec.Cmd, limits ExecLimits) error {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var ulimits []string
+
+	if limits.Memory > 0 {
+		// ulimit takes the virtual memory in kibibytes
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", (limits.Memory+1023)/1024))
+	}
+
+	if limits.CPU > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -t %d", limits.CPU))
+	}
+
+	if len(ulimits) > 0 {
+		script := strings.Join(ulimits, " && ") + ` && exec "$0" "$@"`
+
+		cmd.Args = append([]string{"sh", "-c", script, cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = "/bin/sh"
+	}
+
+	return cmd.Start()
+}
+
+// killCommand kills the plugin's process group.
+func killCommand(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+// +build !linux
+
+package scan
+
+import (
+	"os/exec"
+
+	"github.com/sirupsen/logrus"
+)
+
+// startCommand starts the plugin. Resource limits are only enforced on
+// Linux.
+func startCommand(cmd *exec.Cmd, limits ExecLimits) error {
+
+	if limits.Memory > 0 || limits.CPU > 0 {
+		logrus.WithField("command", cmd.Path).Warn("scanner plugins' memory and CPU limits are only enforced on Linux")
+	}
+
+	return cmd.Start()
+}
+
+// killCommand kills the plugin's process.
+func killCommand(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,330 @@
+package scan
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecScanner_Scan(t *testing.T) {
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && r.URL.Path == "/v2/tsuru/cst/manifests/latest" {
+			w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+			return
+		}
+
+		http.NotFound(w, r)
+	}))
+
+	defer registry.Close()
+
+	source := &MockSource{
+		MockFetch: func(string) ([]PlatformImage, error) {
+			return []PlatformImage{{
+				Platform: "linux/arm64",
+				Image: &docker.Image{
+					Registry: registry.URL + "/v2",
+					Name:     "tsuru/cst",
+					Tag:      "latest",
+					Token:    "Bearer registry-token",
+					FsLayers: []docker.FsLayer{{BlobSum: "sha256:layer0"}, {BlobSum: "sha256:layer1"}},
+				},
+			}}, nil
+		},
+	}
+
+	plugin, err := filepath.Abs(filepath.Join("testdata", "exec", "plugin.sh"))
+	require.NoError(t, err)
+
+	replayScanner := func(t *testing.T, response string) (*ExecScanner, string) {
+
+		dir, err := ioutil.TempDir("", "exec")
+		require.NoError(t, err)
+
+		responseFile, err := filepath.Abs(filepath.Join("testdata", "exec", response))
+		require.NoError(t, err)
+
+		requestFile := filepath.Join(dir, "request.json")
+
+		return &ExecScanner{
+			Name:    "acme",
+			Command: plugin,
+			Env:     []string{"PATH", "REQUEST_FILE=" + requestFile, "RESPONSE_FILE=" + responseFile},
+			Options: map[string]string{"policy": "strict"},
+			Timeout: 10 * time.Second,
+			Source:  source,
+		}, requestFile
+	}
+
+	t.Run(`Ensure the plugin is requested to analyze the image and its findings are normalized`, func(t *testing.T) {
+		scanner, requestFile := replayScanner(t, "response.json")
+		defer os.RemoveAll(filepath.Dir(requestFile))
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+
+		data, err := ioutil.ReadFile(requestFile)
+		require.NoError(t, err)
+
+		var request ExecRequest
+		require.NoError(t, json.Unmarshal(data, &request))
+
+		assert.Equal(t, ExecRequest{
+			Version:       ExecProtocolVersion,
+			Scanner:       "acme",
+			Image:         "registry.tld/tsuru/cst:latest",
+			Platform:      "linux/arm64",
+			Registry:      registry.URL + "/v2",
+			Repository:    "tsuru/cst",
+			Tag:           "latest",
+			Digest:        "sha256:manifest",
+			Layers:        []string{"sha256:layer0", "sha256:layer1"},
+			Authorization: "Bearer registry-token",
+			Options:       map[string]string{"policy": "strict"},
+		}, request)
+
+		assert.Equal(t, "acme", result.Scanner)
+		assert.Equal(t, "linux/arm64", result.Platform)
+		assert.Equal(t, "sha256:manifest", result.Digest)
+		assert.Len(t, result.Layers, 2)
+
+		assert.Equal(t, []Finding{
+			{
+				CVE:      "CVE-2023-0286",
+				Scanner:  "acme",
+				Package:  "openssl",
+				Version:  "1.1.1n-0+deb11u3",
+				FixedBy:  "1.1.1n-0+deb11u4",
+				Severity: SeverityHigh,
+				Link:     "https://security-tracker.debian.org/tracker/CVE-2023-0286",
+				Layer:    "sha256:layer1",
+			},
+			{
+				Scanner:  "acme",
+				Rule:     "acme-policy",
+				Title:    "Image built from an unapproved base image",
+				Severity: SeverityMedium,
+			},
+		}, result.Findings)
+
+		assert.Equal(t, map[string]interface{}{"engine": "acme", "count": float64(1)}, result.Vulnerabilities)
+	})
+
+	t.Run(`When plugin replies an error, should return it`, func(t *testing.T) {
+		scanner, requestFile := replayScanner(t, "error.json")
+		defer os.RemoveAll(filepath.Dir(requestFile))
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, &Error{Code: ErrorCodeUnauthorized, Phase: PhaseAuth, Message: "registry refused the credentials"}, result.Error)
+	})
+
+	t.Run(`When plugin replies an unknown error code, should classify it as unknown`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", `echo '{"version":"1","error":{"code":"quota-exceeded","message":"too many scans"}}'; exit 1`},
+			Env:     []string{"PATH"},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeUnknown, result.Error.Code)
+		assert.Equal(t, PhaseAnalyze, result.Error.Phase)
+		assert.Equal(t, "too many scans", result.Error.Message)
+	})
+
+	t.Run(`When plugin fails without replying, should return an error with its stderr`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", "echo 'database is corrupted' >&2; exit 3"},
+			Env:     []string{"PATH"},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeUnknown, result.Error.Code)
+		assert.Contains(t, result.Error.Message, "exit status 3")
+		assert.Contains(t, result.Error.Message, "database is corrupted")
+	})
+
+	t.Run(`When plugin replies another protocol version, should return an error`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", `echo '{"version":"2","findings":[]}'`},
+			Env:     []string{"PATH"},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeInternal, result.Error.Code)
+		assert.Contains(t, result.Error.Message, `protocol version "2"`)
+	})
+
+	t.Run(`When plugin's response is larger than the max output size, should return an error`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", "head -c 4096 /dev/zero"},
+			Env:     []string{"PATH"},
+			Limits:  ExecLimits{MaxOutputSize: 1024},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeInternal, result.Error.Code)
+		assert.Contains(t, result.Error.Message, "larger than 1024 bytes")
+	})
+
+	t.Run(`When plugin doesn't finish until the timeout, should kill it and return a transient timeout error`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", "sleep 30; echo '{}'"},
+			Env:     []string{"PATH"},
+			Timeout: 100 * time.Millisecond,
+			Source:  source,
+		}
+
+		startedAt := time.Now()
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		assert.True(t, time.Since(startedAt) < 10*time.Second)
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeTimeout, result.Error.Code)
+		assert.True(t, result.Error.Transient)
+	})
+
+	t.Run(`When plugin's command doesn't exist, should return an internal error`, func(t *testing.T) {
+		scanner := &ExecScanner{
+			Command: filepath.Join("testdata", "exec", "missing.sh"),
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.NotNil(t, result.Error)
+		assert.Equal(t, ErrorCodeInternal, result.Error.Code)
+	})
+
+	t.Run(`Ensure plugin gets only the declared environment variables`, func(t *testing.T) {
+		os.Setenv("CST_TEST_INHERITED", "inherited")
+		defer os.Unsetenv("CST_TEST_INHERITED")
+
+		os.Setenv("CST_TEST_NOT_DECLARED", "not declared")
+		defer os.Unsetenv("CST_TEST_NOT_DECLARED")
+
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", `printf '{"version":"1","vulnerabilities":"%s|%s|%s"}' "$CST_TEST_VALUE" "$CST_TEST_INHERITED" "$CST_TEST_NOT_DECLARED"`},
+			Env:     []string{"PATH", "CST_TEST_VALUE=value", "CST_TEST_INHERITED", "CST_TEST_MISSING"},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		assert.Equal(t, "value|inherited|", result.Vulnerabilities)
+	})
+
+	t.Run(`When memory and CPU limits are assigned, should limit plugin's process`, func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("resource limits are only enforced on Linux")
+		}
+
+		scanner := &ExecScanner{
+			Command: "sh",
+			Args:    []string{"-c", `printf '{"version":"1","vulnerabilities":"%s|%s"}' "$(ulimit -v)" "$(ulimit -t)"`},
+			Env:     []string{"PATH"},
+			Limits:  ExecLimits{Memory: 512 << 20, CPU: 30},
+			Source:  source,
+		}
+
+		result := scanner.Scan("registry.tld/tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		assert.Equal(t, "524288|30", result.Vulnerabilities)
+	})
+}
+
+func TestExecScanner_Ping(t *testing.T) {
+
+	t.Run(`When plugin's command exists, should not return error`, func(t *testing.T) {
+		assert.NoError(t, (&ExecScanner{Command: "sh"}).Ping())
+	})
+
+	t.Run(`When plugin's command doesn't exist, should return error`, func(t *testing.T) {
+		assert.Error(t, (&ExecScanner{Command: "/nonexistent/acme-scanner"}).Ping())
+	})
+}
+
+func TestLoadExecScanners(t *testing.T) {
+	t.Run(`Ensure plugins are read from the YAML file`, func(t *testing.T) {
+		scanners, err := LoadExecScanners(filepath.Join("testdata", "exec", "plugins.yml"))
+
+		require.NoError(t, err)
+		require.Len(t, scanners, 2)
+
+		assert.Equal(t, &ExecScanner{
+			Name:    "acme",
+			Command: "/usr/local/bin/acme-scanner",
+			Args:    []string{"--format", "cst"},
+			Env:     []string{"ACME_TOKEN", "ACME_REGION=us-east-1"},
+			Options: map[string]string{"policy": "strict"},
+			Timeout: 5 * time.Minute,
+			Limits:  ExecLimits{Memory: 1 << 30, CPU: 120},
+		}, scanners[0])
+
+		assert.Equal(t, &ExecScanner{Name: "in-house", Command: "in-house-scanner"}, scanners[1])
+	})
+
+	t.Run(`When plugins are invalid, should return an error`, func(t *testing.T) {
+		configs := []string{
+			"scanners:\n- name: acme\n",
+			"scanners:\n- command: acme-scanner\n",
+			"scanners:\n- name: acme\n  command: acme-scanner\n- name: acme\n  command: other-scanner\n",
+			"scanners:\n- name: acme\n  command: acme-scanner\n  timeout: forever\n",
+			"scanners:\n- name: acme\n  command: acme-scanner\n  unknown: field\n",
+		}
+
+		dir, err := ioutil.TempDir("", "exec")
+		require.NoError(t, err)
+
+		defer os.RemoveAll(dir)
+
+		for _, config := range configs {
+			file := filepath.Join(dir, "plugins.yml")
+			require.NoError(t, ioutil.WriteFile(file, []byte(config), 0644))
+
+			_, err := LoadExecScanners(file)
+
+			assert.Error(t, err, config)
+		}
+	})
+
+	t.Run(`When file doesn't exist, should return an error`, func(t *testing.T) {
+		_, err := LoadExecScanners(filepath.Join("testdata", "exec", "missing.yml"))
+
+		assert.Error(t, err)
+	})
+}
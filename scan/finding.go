@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/clair"
+)
+
+// Finding is a vulnerability reported on an image by some scanner, normalized
+// regardless of the scanner's result format. Scanners fill the vulnerability
+// fields, the other ones (e.g. Image and Platform) are filled when findings are
+// indexed.
+// Layer is the digest of the image layer that has introduced the package and
+// CreatedBy its Dockerfile instruction, when scanners can attribute them.
+// Findings other than vulnerable packages (e.g. secrets) are identified by
+// the scanner's Rule and the file they were found on (Path and Line), with a
+// redacted Evidence.
+type Finding struct {
+	CVE        string    `bson:"cve" json:"cve"`
+	Image      string    `bson:"image" json:"image"`
+	ScanID     string    `bson:"scanID" json:"scanID"`
+	Platform   string    `bson:"platform,omitempty" json:"platform,omitempty"`
+	Scanner    string    `bson:"scanner" json:"scanner"`
+	Package    string    `bson:"package" json:"package"`
+	Version    string    `bson:"version,omitempty" json:"version,omitempty"`
+	FixedBy    string    `bson:"fixedBy,omitempty" json:"fixedBy,omitempty"`
+	Severity   string    `bson:"severity,omitempty" json:"severity,omitempty"`
+	Link       string    `bson:"link,omitempty" json:"link,omitempty"`
+	Rule       string    `bson:"rule,omitempty" json:"rule,omitempty"`
+	Title      string    `bson:"title,omitempty" json:"title,omitempty"`
+	Path       string    `bson:"path,omitempty" json:"path,omitempty"`
+	Line       int       `bson:"line,omitempty" json:"line,omitempty"`
+	Evidence   string    `bson:"evidence,omitempty" json:"evidence,omitempty"`
+	Layer      string    `bson:"layer,omitempty" json:"layer,omitempty"`
+	CreatedBy  string    `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	DetectedAt time.Time `bson:"detectedAt" json:"detectedAt"`
+}
+
+// Key identifies a finding regardless of the scanner that has reported it.
+// Line and Evidence tell apart the findings of a rule on the same file (e.g.
+// secrets on different lines) or without file at all (e.g. the instructions
+// of image's config).
+func (f Finding) Key() string {
+	return strings.Join([]string{f.CVE, f.Package, f.Rule, f.Path, strconv.Itoa(f.Line), f.Evidence}, "|")
+}
+
+func findingsFromClair(scanner string, vulnerabilities []clair.Vulnerability) []Finding {
+
+	findings := make([]Finding, len(vulnerabilities))
+
+	for index, vulnerability := range vulnerabilities {
+		findings[index] = Finding{
+			CVE:      vulnerability.Name,
+			Scanner:  scanner,
+			Package:  vulnerability.FeatureName,
+			Version:  vulnerability.FeatureVersion,
+			FixedBy:  vulnerability.FixedBy,
+			Severity: vulnerability.Severity,
+			Link:     vulnerability.Link,
+		}
+	}
+
+	return findings
+}
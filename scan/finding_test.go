@@ -0,0 +1,67 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/optiopay/klar/clair"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindingsFromClair(t *testing.T) {
+
+	t.Run(`Ensure Clair vulnerabilities are normalized as findings`, func(t *testing.T) {
+		vulnerabilities := []clair.Vulnerability{
+			clair.Vulnerability{
+				Name:           "CVE-2018-0732",
+				Severity:       "High",
+				FixedBy:        "1.1.0i-r0",
+				FeatureName:    "openssl",
+				FeatureVersion: "1.1.0h-r0",
+				Link:           "https://security-tracker.debian.org/tracker/CVE-2018-0732",
+			},
+		}
+
+		expected := []Finding{
+			Finding{
+				CVE:      "CVE-2018-0732",
+				Scanner:  "clair",
+				Package:  "openssl",
+				Version:  "1.1.0h-r0",
+				FixedBy:  "1.1.0i-r0",
+				Severity: "High",
+				Link:     "https://security-tracker.debian.org/tracker/CVE-2018-0732",
+			},
+		}
+
+		assert.Equal(t, expected, findingsFromClair("clair", vulnerabilities))
+	})
+}
+
+func TestFinding_Key(t *testing.T) {
+
+	t.Run(`Ensure the same vulnerability reported by different scanners has the same key`, func(t *testing.T) {
+		clair := Finding{CVE: "CVE-2018-0732", Scanner: "clair", Package: "openssl", Severity: "High"}
+		trivy := Finding{CVE: "CVE-2018-0732", Scanner: "trivy", Package: "openssl", Severity: "HIGH"}
+
+		assert.Equal(t, clair.Key(), trivy.Key())
+	})
+
+	t.Run(`Ensure secrets found on different lines of the same file have different keys`, func(t *testing.T) {
+		first := Finding{Rule: "aws-access-key", Scanner: "secrets", Path: "/app/.env", Line: 1, Evidence: "AKIA****"}
+		second := Finding{Rule: "aws-access-key", Scanner: "secrets", Path: "/app/.env", Line: 2, Evidence: "AKIA****"}
+
+		assert.NotEqual(t, first.Key(), second.Key())
+	})
+
+	t.Run(`Ensure audit findings without file but with different evidences have different keys`, func(t *testing.T) {
+		first := Finding{Rule: "port-privileged", Scanner: "config-audit", Evidence: "EXPOSE 22/tcp"}
+		second := Finding{Rule: "port-privileged", Scanner: "config-audit", Evidence: "EXPOSE 80/tcp"}
+
+		assert.NotEqual(t, first.Key(), second.Key())
+
+		first = Finding{Rule: "env-secret", Scanner: "config-audit", Evidence: "ENV DB_PASSWORD=s3****"}
+		second = Finding{Rule: "env-secret", Scanner: "config-audit", Evidence: "ENV API_TOKEN=ab****"}
+
+		assert.NotEqual(t, first.Key(), second.Key())
+	})
+}
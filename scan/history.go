@@ -0,0 +1,215 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/optiopay/klar/docker"
+)
+
+// errNoImageConfig indicates the image's manifest doesn't reference an image
+// config.
+var errNoImageConfig = errors.New(`image manifest has no config`)
+
+const (
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// nopPrefix prefixes the instructions without shell commands (e.g. CMD)
+	// on images built by the legacy Docker builder.
+	nopPrefix = "/bin/sh -c #(nop)"
+)
+
+// Layer describes an image layer: its digest, the Dockerfile instruction that
+// has created it (taken from the image config's history, when available) and
+// whether it belongs to the base image the image was built from.
+type Layer struct {
+	Digest    string `bson:"digest" json:"digest"`
+	CreatedBy string `bson:"createdBy,omitempty" json:"createdBy,omitempty"`
+	Base      bool   `bson:"base" json:"base"`
+}
+
+// historyEntry is an entry of the image config's history.
+type historyEntry struct {
+	CreatedBy  string `json:"created_by"`
+	EmptyLayer bool   `json:"empty_layer"`
+}
+
+// fetchLayers describes the non-empty layers of image, from base to top. The
+// instructions and base layers come from the image config's history; layers
+// are described only by their digests when the registry doesn't serve it
+// (e.g. schema 1 manifests).
+func fetchLayers(image *docker.Image) []Layer {
+
+	var layers []Layer
+
+	for _, digest := range nonEmptyLayers(image) {
+		layers = append(layers, Layer{Digest: digest})
+	}
+
+	config, err := fetchConfig(image)
+
+	if err != nil || len(config.History) == 0 {
+		return layers
+	}
+
+	history := config.History
+
+	var instructions []string
+
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			instructions = append(instructions, entry.CreatedBy)
+		}
+	}
+
+	// schema 2 manifests have a layer per non-empty history's entry
+	if len(instructions) != len(image.FsLayers) {
+		return layers
+	}
+
+	baseLayers := countBaseLayers(history)
+	index := 0
+
+	for position, layer := range image.FsLayers {
+		if layer.BlobSum == emptyLayerDigest {
+			continue
+		}
+
+		layers[index].CreatedBy = instructions[position]
+		layers[index].Base = position < baseLayers
+
+		index++
+	}
+
+	return layers
+}
+
+// imageConfig is the image config referenced by an image's manifest.
+type imageConfig struct {
+	Config struct {
+		User         string              `json:"User"`
+		Env          []string            `json:"Env"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+		Healthcheck  *struct {
+			Test []string `json:"Test"`
+		} `json:"Healthcheck"`
+	} `json:"config"`
+	History []historyEntry `json:"history"`
+}
+
+// fetchConfig gets the image config referenced by the image's manifest.
+// Returns errNoImageConfig when the manifest doesn't reference one (e.g.
+// schema 1 manifests).
+func fetchConfig(image *docker.Image) (imageConfig, error) {
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+
+	var config imageConfig
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", image.Registry, image.Name, image.Tag)
+
+	if err := getRegistryJSON(image, url, manifestMediaTypes+", "+ociManifestMediaType, &manifest); err != nil {
+		return config, err
+	}
+
+	if manifest.Config.Digest == "" {
+		return config, errNoImageConfig
+	}
+
+	url = fmt.Sprintf("%s/%s/blobs/%s", image.Registry, image.Name, manifest.Config.Digest)
+
+	err := getRegistryJSON(image, url, "", &config)
+
+	return config, err
+}
+
+// getRegistryJSON decodes a registry's JSON response to value, reusing the
+// credentials of the image's previous pull.
+func getRegistryJSON(image *docker.Image, url, accept string, value interface{}) error {
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if image.Token != "" {
+		request.Header.Set("Authorization", image.Token)
+	}
+
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+
+	client := &http.Client{Timeout: registryTimeout}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return registryStatusError(response.StatusCode, "could not fetch "+url)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// countBaseLayers guesses how many layers come from the base image. Since
+// images usually finish with a CMD (or ENTRYPOINT) instruction, the base image
+// ends at the last of them followed by new layers.
+func countBaseLayers(history []historyEntry) int {
+
+	layers := 0
+	var ends []int
+
+	for _, entry := range history {
+		if !entry.EmptyLayer {
+			layers++
+			continue
+		}
+
+		instruction := strings.TrimSpace(strings.TrimPrefix(entry.CreatedBy, nopPrefix))
+
+		if strings.HasPrefix(instruction, "CMD ") || strings.HasPrefix(instruction, "ENTRYPOINT ") {
+			ends = append(ends, layers)
+		}
+	}
+
+	baseLayers := 0
+
+	for _, end := range ends {
+		if end < layers {
+			baseLayers = end
+		}
+	}
+
+	return baseLayers
+}
+
+// attributeFindings fills the Dockerfile instruction of the findings
+// attributed to some of layers.
+func attributeFindings(findings []Finding, layers []Layer) {
+
+	createdBy := make(map[string]string, len(layers))
+
+	for _, layer := range layers {
+		createdBy[layer.Digest] = layer.CreatedBy
+	}
+
+	for index := range findings {
+		if findings[index].Layer != "" {
+			findings[index].CreatedBy = createdBy[findings[index].Layer]
+		}
+	}
+}
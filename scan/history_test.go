@@ -0,0 +1,119 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountBaseLayers(t *testing.T) {
+	cases := []struct {
+		description string
+		history     []historyEntry
+		expected    int
+	}{
+		{
+			description: "image built from a base image",
+			history: []historyEntry{
+				{CreatedBy: "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / "},
+				{CreatedBy: `/bin/sh -c #(nop)  CMD ["bash"]`, EmptyLayer: true},
+				{CreatedBy: "RUN /bin/sh -c apt-get update && apt-get install -y curl # buildkit"},
+				{CreatedBy: "COPY app /app # buildkit"},
+				{CreatedBy: `CMD ["/app"]`, EmptyLayer: true},
+			},
+			expected: 1,
+		},
+		{
+			description: "image without CMD built from a base image",
+			history: []historyEntry{
+				{CreatedBy: "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / "},
+				{CreatedBy: "/bin/sh -c apt-get update"},
+				{CreatedBy: `/bin/sh -c #(nop)  ENTRYPOINT ["/entrypoint.sh"]`, EmptyLayer: true},
+				{CreatedBy: "/bin/sh -c #(nop) COPY file:a1b2c3 in /app "},
+			},
+			expected: 2,
+		},
+		{
+			description: "base image itself",
+			history: []historyEntry{
+				{CreatedBy: "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / "},
+				{CreatedBy: `/bin/sh -c #(nop)  CMD ["bash"]`, EmptyLayer: true},
+			},
+			expected: 0,
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, countBaseLayers(c.history), c.description)
+	}
+}
+
+func TestFetchLayers(t *testing.T) {
+	t.Run(`When registry serves the image config, should describe layers with their instructions`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+			switch r.URL.Path {
+			case "/v2/tsuru/cst/manifests/latest":
+				w.Write([]byte(`{"schemaVersion":2,"config":{"digest":"sha256:config"}}`))
+
+			case "/v2/tsuru/cst/blobs/sha256:config":
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"history": []map[string]interface{}{
+						{"created_by": "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / "},
+						{"created_by": `/bin/sh -c #(nop)  CMD ["bash"]`, "empty_layer": true},
+						{"created_by": "/bin/sh -c apt-get install -y curl"},
+					},
+				})
+
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		defer server.Close()
+
+		image := &docker.Image{
+			Registry: server.URL + "/v2",
+			Name:     "tsuru/cst",
+			Tag:      "latest",
+			Token:    "Bearer token",
+			FsLayers: []docker.FsLayer{{BlobSum: "sha256:base"}, {BlobSum: "sha256:app"}},
+		}
+
+		expected := []Layer{
+			{Digest: "sha256:base", CreatedBy: "/bin/sh -c #(nop) ADD file:4fc310c0cb879c8 in / ", Base: true},
+			{Digest: "sha256:app", CreatedBy: "/bin/sh -c apt-get install -y curl"},
+		}
+
+		assert.Equal(t, expected, fetchLayers(image))
+	})
+
+	t.Run(`When registry doesn't serve the image config, should describe layers by their digests`, func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+
+		defer server.Close()
+
+		image := &docker.Image{
+			Registry: server.URL + "/v2",
+			Name:     "tsuru/cst",
+			Tag:      "latest",
+			FsLayers: []docker.FsLayer{{BlobSum: "sha256:base"}, {BlobSum: emptyLayerDigest}},
+		}
+
+		assert.Equal(t, []Layer{{Digest: "sha256:base"}}, fetchLayers(image))
+	})
+}
+
+func TestAttributeFindings(t *testing.T) {
+	findings := []Finding{{CVE: "CVE-2018-1000120", Layer: "sha256:app"}, {CVE: "CVE-2018-1000001"}}
+
+	attributeFindings(findings, []Layer{{Digest: "sha256:app", CreatedBy: "/bin/sh -c apt-get install -y curl"}})
+
+	assert.Equal(t, "/bin/sh -c apt-get install -y curl", findings[0].CreatedBy)
+	assert.Empty(t, findings[1].CreatedBy)
+}
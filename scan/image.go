@@ -0,0 +1,157 @@
+package scan
+
+import (
+	"strings"
+	"time"
+)
+
+// Severity names used to count findings, from the most to the least severe.
+const (
+	SeverityCritical   = "critical"
+	SeverityHigh       = "high"
+	SeverityMedium     = "medium"
+	SeverityLow        = "low"
+	SeverityNegligible = "negligible"
+	SeverityUnknown    = "unknown"
+)
+
+// Severities lists the severity names, from the most to the least severe.
+var Severities = []string{
+	SeverityCritical,
+	SeverityHigh,
+	SeverityMedium,
+	SeverityLow,
+	SeverityNegligible,
+	SeverityUnknown,
+}
+
+// SeverityCounts holds how many findings an image has per severity.
+type SeverityCounts struct {
+	Critical   int `bson:"critical" json:"critical"`
+	High       int `bson:"high" json:"high"`
+	Medium     int `bson:"medium" json:"medium"`
+	Low        int `bson:"low" json:"low"`
+	Negligible int `bson:"negligible" json:"negligible"`
+	Unknown    int `bson:"unknown" json:"unknown"`
+}
+
+// Image summarizes the security state of a repository/tag, taken from its
+// latest completed scan.
+type Image struct {
+	Name          string         `bson:"_id" json:"name"`
+	Repository    string         `bson:"repository" json:"repository"`
+	Tag           string         `bson:"tag" json:"tag"`
+	Digest        string         `bson:"digest,omitempty" json:"digest,omitempty"`
+	LastScanID    string         `bson:"lastScanID,omitempty" json:"lastScanID,omitempty"`
+	LastStatus    Status         `bson:"lastStatus,omitempty" json:"lastStatus,omitempty"`
+	LastScannedAt time.Time      `bson:"lastScannedAt,omitempty" json:"lastScannedAt,omitempty"`
+	Severities    SeverityCounts `bson:"severities" json:"severities"`
+}
+
+// NormalizeSeverity maps the severity names used by scanners (e.g. Clair's
+// "Defcon1" or "High") to the ones on Severities.
+func NormalizeSeverity(severity string) string {
+
+	switch strings.ToLower(severity) {
+	case "defcon1", SeverityCritical:
+		return SeverityCritical
+	case SeverityHigh, "important":
+		return SeverityHigh
+	case SeverityMedium, "moderate":
+		return SeverityMedium
+	case SeverityLow:
+		return SeverityLow
+	case SeverityNegligible:
+		return SeverityNegligible
+	default:
+		return SeverityUnknown
+	}
+}
+
+// CountSeverities counts findings per severity. A finding reported by several
+// scanners (e.g. the same vulnerability on the same package) is counted once.
+func CountSeverities(findings []Finding) SeverityCounts {
+
+	var counts SeverityCounts
+
+	seen := make(map[string]bool)
+
+	for _, finding := range findings {
+		key := finding.Key()
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		switch NormalizeSeverity(finding.Severity) {
+		case SeverityCritical:
+			counts.Critical++
+		case SeverityHigh:
+			counts.High++
+		case SeverityMedium:
+			counts.Medium++
+		case SeverityLow:
+			counts.Low++
+		case SeverityNegligible:
+			counts.Negligible++
+		default:
+			counts.Unknown++
+		}
+	}
+
+	return counts
+}
+
+// SplitImageName splits an image name into repository and tag (or digest),
+// e.g. "registry.tld:5000/tsuru/cst:latest" returns "registry.tld:5000/tsuru/cst"
+// and "latest". Images without tag are assumed as "latest".
+func SplitImageName(name string) (string, string) {
+
+	if index := strings.LastIndex(name, "@"); index >= 0 {
+		return name[:index], name[index+1:]
+	}
+
+	index := strings.LastIndex(name, ":")
+
+	if index < 0 || strings.Contains(name[index+1:], "/") {
+		return name, "latest"
+	}
+
+	return name[:index], name[index+1:]
+}
+
+// NewImageSummary summarizes the security state of an image from a completed
+// scan.
+func NewImageSummary(s Scan) Image {
+
+	repository, tag := SplitImageName(s.Image)
+
+	image := Image{
+		Name:          s.Image,
+		Repository:    repository,
+		Tag:           tag,
+		LastScanID:    s.ID,
+		LastStatus:    s.Status,
+		LastScannedAt: s.FinishedAt,
+	}
+
+	var findings []Finding
+
+	for _, result := range s.Result {
+		if result.Error != nil {
+			continue
+		}
+
+		if image.Digest == "" {
+			image.Digest = result.Digest
+		}
+
+		findings = append(findings, result.Findings...)
+	}
+
+	image.Severities = CountSeverities(findings)
+
+	return image
+}
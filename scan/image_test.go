@@ -0,0 +1,69 @@
+package scan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitImageName(t *testing.T) {
+	cases := []struct {
+		name       string
+		repository string
+		tag        string
+	}{
+		{"tsuru/cst:latest", "tsuru/cst", "latest"},
+		{"tsuru/cst", "tsuru/cst", "latest"},
+		{"registry.tld:5000/tsuru/cst", "registry.tld:5000/tsuru/cst", "latest"},
+		{"registry.tld:5000/tsuru/cst:v1.2", "registry.tld:5000/tsuru/cst", "v1.2"},
+		{"tsuru/cst@sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b", "tsuru/cst", "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"},
+	}
+
+	for _, c := range cases {
+		repository, tag := SplitImageName(c.name)
+
+		assert.Equal(t, c.repository, repository, c.name)
+		assert.Equal(t, c.tag, tag, c.name)
+	}
+}
+
+func TestNewImageSummary(t *testing.T) {
+
+	t.Run(`Ensure findings are counted by severity, once per vulnerable package`, func(t *testing.T) {
+		finishedAt := time.Now()
+
+		s := Scan{
+			ID:         "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			Image:      "tsuru/cst:latest",
+			Status:     StatusPartial,
+			FinishedAt: finishedAt,
+			Result: []Result{
+				Result{
+					Scanner: "clair",
+					Digest:  "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+					Findings: []Finding{
+						{CVE: "CVE-2018-0732", Package: "openssl", Severity: "High"},
+						{CVE: "CVE-2018-0732", Package: "openssl", Severity: "High"},
+						{CVE: "CVE-2018-14618", Package: "curl", Severity: "Defcon1"},
+						{CVE: "CVE-2018-0737", Package: "openssl", Severity: "Low"},
+					},
+				},
+				Result{Scanner: "another", Error: &Error{Code: ErrorCodeTimeout}},
+			},
+		}
+
+		expected := Image{
+			Name:          "tsuru/cst:latest",
+			Repository:    "tsuru/cst",
+			Tag:           "latest",
+			Digest:        "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+			LastScanID:    "2b935a8f-4241-49f0-a1a2-e3c8ba347b95",
+			LastStatus:    StatusPartial,
+			LastScannedAt: finishedAt,
+			Severities:    SeverityCounts{Critical: 1, High: 1, Low: 1},
+		}
+
+		assert.Equal(t, expected, NewImageSummary(s))
+	})
+}
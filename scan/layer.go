@@ -0,0 +1,371 @@
+package scan
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/clair"
+	"github.com/optiopay/klar/docker"
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/metrics"
+)
+
+// LayerFindings holds the findings a scanner has reported for an image layer
+// together with its parents (i.e. for an image whose top layer is that one),
+// cached to be reused by other images sharing that layer on top of the same
+// parents. Name is the layer's chain name (see chainLayerNames), which
+// identifies the layer and its parents. DBVersion is the scanner's
+// vulnerability database version when the layer was analyzed.
+type LayerFindings struct {
+	Name      string    `bson:"name" json:"name"`
+	Scanner   string    `bson:"scanner" json:"scanner"`
+	DBVersion string    `bson:"dbVersion" json:"dbVersion"`
+	Findings  []Finding `bson:"findings" json:"findings"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
+// LayerCache keeps the findings of layers already analyzed by scanners, keyed
+// by layer chain name.
+type LayerCache interface {
+	// Get returns the findings (by layer chain name) of the cached layers
+	// among names, as analyzed by scanner on a vulnerability database version.
+	Get(scanner, dbVersion string, names []string) (map[string][]Finding, error)
+
+	// Put caches the findings (by layer chain name) analyzed by scanner on a
+	// vulnerability database version.
+	Put(scanner, dbVersion string, layers map[string][]Finding) error
+}
+
+// emptyLayerDigest is the digest of the empty tar used by images as layers
+// without any files, which aren't worth analyzing.
+const emptyLayerDigest = "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+
+type clairLayerEnvelope struct {
+	Layer *clairLayer `json:"Layer,omitempty"`
+}
+
+type clairLayer struct {
+	Name       string         `json:"Name,omitempty"`
+	Path       string         `json:"Path,omitempty"`
+	ParentName string         `json:"ParentName,omitempty"`
+	Format     string         `json:"Format,omitempty"`
+	Headers    *clairHeaders  `json:"Headers,omitempty"`
+	Features   []clairFeature `json:"Features,omitempty"`
+}
+
+type clairHeaders struct {
+	Authorization string `json:"Authorization,omitempty"`
+}
+
+type clairFeature struct {
+	Name            string                `json:"Name,omitempty"`
+	Version         string                `json:"Version,omitempty"`
+	AddedBy         string                `json:"AddedBy,omitempty"`
+	Vulnerabilities []clair.Vulnerability `json:"Vulnerabilities,omitempty"`
+}
+
+// analyzeLayers analyzes the image on CoreOS Clair (v1 API), attributing each
+// finding to the layer that has introduced its package. When Cache is
+// assigned, the cached findings of layers (analyzed on DBVersion) are reused,
+// looked up by their chain names: if the top layer is cached, Clair isn't
+// called at all; otherwise, only the layers from the first uncached one up
+// are pushed to Clair (the other ones are indexed already). The findings of
+// the new layers (each one with its parents) are cached afterwards.
+//
+// Layers are named on Clair after the digests of themselves and of their
+// parents, so images sharing the same base layers share them on Clair too.
+// Since Clair v1 API doesn't report its vulnerability database version,
+// DBVersion is configured and the cache's TTL bounds how stale the layers'
+// findings can be when it isn't bumped.
+func (c *Clair) analyzeLayers(dockerImage *docker.Image, log *logrus.Entry) ([]clair.Vulnerability, []Finding, error) {
+
+	digests := nonEmptyLayers(dockerImage)
+
+	if len(digests) == 0 {
+		log.Info("no need to analyze an image without non-empty layers")
+
+		return nil, nil, nil
+	}
+
+	names := chainLayerNames(digests)
+
+	var cached map[string][]Finding
+	var err error
+
+	if c.Cache != nil {
+		cached, err = c.Cache.Get(c.Name, c.DBVersion, names)
+
+		if err != nil {
+			log.WithError(err).Warn("could not get the image's layers from cache")
+
+			cached = nil
+		}
+	}
+
+	firstUncached := len(digests)
+
+	for index := range digests {
+		_, ok := cached[names[index]]
+
+		if !ok && index < firstUncached {
+			firstUncached = index
+		}
+
+		if c.Cache == nil {
+			continue
+		}
+
+		if ok {
+			metrics.LayerCacheLookups.Inc(c.Name, "hit")
+		} else {
+			metrics.LayerCacheLookups.Inc(c.Name, "miss")
+		}
+	}
+
+	top := names[len(names)-1]
+
+	if findings, ok := cached[top]; ok {
+		log.Info("image's layers found on cache")
+
+		return vulnerabilitiesFromFindings(findings), findings, nil
+	}
+
+	client := &http.Client{Timeout: c.Timeout}
+	address := clairV1Address(c.Address)
+
+	err = pushLayers(client, address, dockerImage, digests, names, firstUncached)
+
+	if err != nil && firstUncached > 0 {
+		log.WithError(err).Warn("could not push the uncached layers, pushing every layer")
+
+		err = pushLayers(client, address, dockerImage, digests, names, 0)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	features, err := getLayerFeatures(client, address, top)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vulnerabilities, findings := layerFindings(c.Name, features, digests, names)
+
+	if c.Cache == nil {
+		return vulnerabilities, findings, nil
+	}
+
+	// each layer is cached with the findings of the image made of it and its
+	// parents, rather than the ones it introduced to this image: upper
+	// layers may upgrade or remove the packages of lower ones
+	uncached := map[string][]Finding{top: findings}
+
+	for index := firstUncached; index < len(names)-1; index++ {
+		if _, ok := cached[names[index]]; ok {
+			continue
+		}
+
+		features, err := getLayerFeatures(client, address, names[index])
+
+		if err != nil {
+			log.WithError(err).Warn("could not get the features of a parent layer to cache it")
+
+			continue
+		}
+
+		_, uncached[names[index]] = layerFindings(c.Name, features, digests, names)
+	}
+
+	if err := c.Cache.Put(c.Name, c.DBVersion, uncached); err != nil {
+		log.WithError(err).Warn("could not cache the image's layers")
+	}
+
+	return vulnerabilities, findings, nil
+}
+
+// layerFindings turns the features (and their vulnerabilities) of a layer on
+// Clair into findings, attributed to the layers (among the image's digests,
+// named by names) which introduced their packages. Findings are ordered from
+// the base layer up; the ones whose layer Clair hasn't reported come last.
+func layerFindings(scanner string, features []clairFeature, digests, names []string) ([]clair.Vulnerability, []Finding) {
+
+	digestByName := make(map[string]string, len(names))
+
+	for index, name := range names {
+		digestByName[name] = digests[index]
+	}
+
+	layers := make(map[string][]Finding, len(digests))
+	var vulnerabilities []clair.Vulnerability
+
+	for _, feature := range features {
+		for _, vulnerability := range feature.Vulnerabilities {
+			vulnerability.FeatureName = feature.Name
+			vulnerability.FeatureVersion = feature.Version
+
+			digest := digestByName[feature.AddedBy]
+
+			finding := findingsFromClair(scanner, []clair.Vulnerability{vulnerability})[0]
+			finding.Layer = digest
+
+			layers[digest] = append(layers[digest], finding)
+			vulnerabilities = append(vulnerabilities, vulnerability)
+		}
+	}
+
+	findings := make([]Finding, 0, len(vulnerabilities))
+
+	for _, digest := range digests {
+		findings = append(findings, layers[digest]...)
+	}
+
+	findings = append(findings, layers[""]...)
+
+	return vulnerabilities, findings
+}
+
+// pushLayers pushes the image's layers to Clair, starting from index from.
+func pushLayers(client *http.Client, address string, image *docker.Image, digests, names []string, from int) error {
+
+	for index := from; index < len(digests); index++ {
+		layer := clairLayer{
+			Name:    names[index],
+			Path:    strings.Join([]string{image.Registry, image.Name, "blobs", digests[index]}, "/"),
+			Format:  "Docker",
+			Headers: &clairHeaders{Authorization: image.Token},
+		}
+
+		if index > 0 {
+			layer.ParentName = names[index-1]
+		}
+
+		body, err := json.Marshal(clairLayerEnvelope{Layer: &layer})
+
+		if err != nil {
+			return err
+		}
+
+		response, err := client.Post(address+"/v1/layers", "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			return fmt.Errorf("can't push layer to Clair: %s", err)
+		}
+
+		message, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusCreated {
+			return fmt.Errorf("push error %d: %s", response.StatusCode, message)
+		}
+	}
+
+	return nil
+}
+
+// getLayerFeatures gets the features (and their vulnerabilities) of a layer
+// and its parents on Clair.
+func getLayerFeatures(client *http.Client, address, name string) ([]clairFeature, error) {
+
+	response, err := client.Get(fmt.Sprintf("%s/v1/layers/%s?features&vulnerabilities", address, name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(response.Body)
+
+		return nil, fmt.Errorf("analyze error %d: %s", response.StatusCode, message)
+	}
+
+	var envelope clairLayerEnvelope
+
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+
+	if envelope.Layer == nil {
+		return nil, nil
+	}
+
+	return envelope.Layer.Features, nil
+}
+
+// nonEmptyLayers returns the digests of the image's layers (from base to
+// top), skipping the empty ones.
+func nonEmptyLayers(image *docker.Image) []string {
+
+	var digests []string
+
+	for _, layer := range image.FsLayers {
+		if layer.BlobSum != emptyLayerDigest {
+			digests = append(digests, layer.BlobSum)
+		}
+	}
+
+	return digests
+}
+
+// chainLayerNames names each layer after its digest and its parent's name.
+func chainLayerNames(digests []string) []string {
+
+	names := make([]string, len(digests))
+	parent := ""
+
+	for index, digest := range digests {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(parent + " " + digest)))
+
+		names[index] = hex.EncodeToString(sum[:])
+		parent = names[index]
+	}
+
+	return names
+}
+
+// clairV1Address completes the Clair address the same way klar does, i.e.
+// with HTTP scheme and 6060 port when missing.
+func clairV1Address(address string) string {
+
+	address = strings.TrimSuffix(address, "/")
+
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+
+	if strings.LastIndex(address, ":") < 6 {
+		address += ":6060"
+	}
+
+	return address
+}
+
+// vulnerabilitiesFromFindings rebuilds Clair's vulnerabilities from findings,
+// as the raw vulnerabilities of cached layers aren't kept.
+func vulnerabilitiesFromFindings(findings []Finding) []clair.Vulnerability {
+
+	vulnerabilities := make([]clair.Vulnerability, len(findings))
+
+	for index, finding := range findings {
+		vulnerabilities[index] = clair.Vulnerability{
+			Name:           finding.CVE,
+			FeatureName:    finding.Package,
+			FeatureVersion: finding.Version,
+			FixedBy:        finding.FixedBy,
+			Severity:       finding.Severity,
+			Link:           finding.Link,
+		}
+	}
+
+	return vulnerabilities
+}
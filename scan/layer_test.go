@@ -0,0 +1,306 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/optiopay/klar/clair"
+	"github.com/optiopay/klar/docker"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClair_analyzeLayers(t *testing.T) {
+	digests := []string{"sha256:base", "sha256:app"}
+	names := chainLayerNames(digests)
+
+	newImage := func(registry string) *docker.Image {
+		return &docker.Image{
+			Registry: registry + "/v2",
+			Name:     "tsuru/cst",
+			Tag:      "latest",
+			FsLayers: []docker.FsLayer{
+				{BlobSum: "sha256:base"},
+				{BlobSum: emptyLayerDigest},
+				{BlobSum: "sha256:app"},
+			},
+		}
+	}
+
+	log := logrus.NewEntry(logrus.New())
+
+	t.Run(`When the top layer is cached, should not call Clair`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.False(t, strings.HasPrefix(r.URL.Path, "/v1/"), "unexpected call to Clair: %s", r.URL.Path)
+		}))
+
+		defer server.Close()
+
+		clair := &Clair{
+			Address:   server.URL,
+			Name:      "clair",
+			DBVersion: "2018-08-01",
+			Cache: &MockLayerCache{
+				MockGet: func(scanner, dbVersion string, got []string) (map[string][]Finding, error) {
+					assert.Equal(t, "clair", scanner)
+					assert.Equal(t, "2018-08-01", dbVersion)
+					assert.Equal(t, names, got)
+
+					return map[string][]Finding{
+						names[0]: {{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Layer: "sha256:base"}},
+						names[1]: {{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Layer: "sha256:base"}},
+					}, nil
+				},
+				MockPut: func(string, string, map[string][]Finding) error {
+					t.Error("unexpected call to cache's Put")
+					return nil
+				},
+			},
+		}
+
+		_, findings, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.NoError(t, err)
+		assert.Equal(t, []Finding{{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Layer: "sha256:base"}}, findings)
+	})
+
+	t.Run(`When some layers aren't cached, should push only them and cache their findings`, func(t *testing.T) {
+		var pushed []string
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPost && r.URL.Path == "/v1/layers":
+				var envelope clairLayerEnvelope
+
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&envelope))
+
+				assert.Equal(t, names[0], envelope.Layer.ParentName)
+				assert.Equal(t, r.Host, strings.Split(strings.TrimPrefix(envelope.Layer.Path, "http://"), "/")[0])
+
+				pushed = append(pushed, envelope.Layer.Name)
+
+				w.WriteHeader(http.StatusCreated)
+
+			case r.URL.Path == "/v1/layers/"+names[1]:
+				json.NewEncoder(w).Encode(clairLayerEnvelope{
+					Layer: &clairLayer{
+						Features: []clairFeature{
+							{Name: "glibc", Version: "2.24", AddedBy: names[0], Vulnerabilities: []clair.Vulnerability{{Name: "CVE-2018-1000001"}}},
+							{Name: "curl", Version: "7.52", AddedBy: names[1], Vulnerabilities: []clair.Vulnerability{{Name: "CVE-2018-1000120"}}},
+						},
+					},
+				})
+			}
+		}))
+
+		defer server.Close()
+
+		var cached map[string][]Finding
+
+		clair := &Clair{
+			Address: server.URL,
+			Name:    "clair",
+			Cache: &MockLayerCache{
+				MockGet: func(string, string, []string) (map[string][]Finding, error) {
+					return map[string][]Finding{names[0]: {}}, nil
+				},
+				MockPut: func(scanner, dbVersion string, layers map[string][]Finding) error {
+					cached = layers
+					return nil
+				},
+			},
+		}
+
+		_, findings, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{names[1]}, pushed)
+		assert.Equal(t, []Finding{
+			{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Version: "2.24", Layer: "sha256:base"},
+			{CVE: "CVE-2018-1000120", Scanner: "clair", Package: "curl", Version: "7.52", Layer: "sha256:app"},
+		}, findings)
+		assert.Equal(t, map[string][]Finding{
+			names[1]: {
+				{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Version: "2.24", Layer: "sha256:base"},
+				{CVE: "CVE-2018-1000120", Scanner: "clair", Package: "curl", Version: "7.52", Layer: "sha256:app"},
+			},
+		}, cached)
+	})
+
+	t.Run(`When an upper layer replaces a vulnerable package, should cache the lower layer with it`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v1/layers":
+				w.WriteHeader(http.StatusCreated)
+
+			case "/v1/layers/" + names[0]:
+				json.NewEncoder(w).Encode(clairLayerEnvelope{
+					Layer: &clairLayer{
+						Features: []clairFeature{
+							{Name: "glibc", Version: "2.24", AddedBy: names[0], Vulnerabilities: []clair.Vulnerability{{Name: "CVE-2018-1000001"}}},
+						},
+					},
+				})
+
+			case "/v1/layers/" + names[1]:
+				json.NewEncoder(w).Encode(clairLayerEnvelope{
+					Layer: &clairLayer{
+						Features: []clairFeature{
+							{Name: "glibc", Version: "2.28", AddedBy: names[1]},
+						},
+					},
+				})
+			}
+		}))
+
+		defer server.Close()
+
+		var cached map[string][]Finding
+
+		clair := &Clair{
+			Address: server.URL,
+			Name:    "clair",
+			Cache: &MockLayerCache{
+				MockPut: func(scanner, dbVersion string, layers map[string][]Finding) error {
+					cached = layers
+					return nil
+				},
+			},
+		}
+
+		_, findings, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.NoError(t, err)
+		assert.Empty(t, findings)
+		assert.Equal(t, map[string][]Finding{
+			names[0]: {{CVE: "CVE-2018-1000001", Scanner: "clair", Package: "glibc", Version: "2.24", Layer: "sha256:base"}},
+			names[1]: {},
+		}, cached)
+
+		// another image built only from the base layer still has the
+		// vulnerable package
+		baseImage := newImage(server.URL)
+		baseImage.FsLayers = baseImage.FsLayers[:1]
+
+		clair.Cache = &MockLayerCache{
+			MockGet: func(string, string, []string) (map[string][]Finding, error) {
+				return cached, nil
+			},
+		}
+
+		_, findings, err = clair.analyzeLayers(baseImage, log)
+
+		require.NoError(t, err)
+		assert.Equal(t, cached[names[0]], findings)
+	})
+
+	t.Run(`When a layer is cached on top of other parents, should not reuse it`, func(t *testing.T) {
+		var pushed []string
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				var envelope clairLayerEnvelope
+
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&envelope))
+
+				pushed = append(pushed, envelope.Layer.Name)
+
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+
+			w.Write([]byte(`{"Layer":{"Features":[]}}`))
+		}))
+
+		defer server.Close()
+
+		other := chainLayerNames([]string{"sha256:other", "sha256:app"})
+
+		clair := &Clair{
+			Address: server.URL,
+			Name:    "clair",
+			Cache: &MockLayerCache{
+				MockGet: func(_, _ string, got []string) (map[string][]Finding, error) {
+					cached := make(map[string][]Finding)
+
+					for _, name := range got {
+						if name == other[1] {
+							cached[name] = []Finding{{CVE: "CVE-2018-1000120", Scanner: "clair", Package: "curl"}}
+						}
+					}
+
+					return cached, nil
+				},
+			},
+		}
+
+		_, findings, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.NoError(t, err)
+		assert.Equal(t, names, pushed)
+		assert.Empty(t, findings)
+	})
+
+	t.Run(`When there is no cache, should push every layer`, func(t *testing.T) {
+		var pushed []string
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				var envelope clairLayerEnvelope
+
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&envelope))
+
+				pushed = append(pushed, envelope.Layer.Name)
+
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+
+			w.Write([]byte(`{"Layer":{"Features":[]}}`))
+		}))
+
+		defer server.Close()
+
+		clair := &Clair{Address: server.URL, Name: "clair"}
+
+		_, findings, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.NoError(t, err)
+		assert.Equal(t, names, pushed)
+		assert.Empty(t, findings)
+	})
+
+	t.Run(`When Clair fails to push layers, should return a transient error`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/layers" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+
+		defer server.Close()
+
+		clair := &Clair{Address: server.URL, Name: "clair", Cache: &MockLayerCache{}}
+
+		_, _, err := clair.analyzeLayers(newImage(server.URL), log)
+
+		require.Error(t, err)
+
+		scanErr := classifyClairError(PhaseAnalyze, err)
+
+		assert.Equal(t, ErrorCodeUnavailable, scanErr.Code)
+		assert.True(t, scanErr.Transient)
+	})
+}
+
+func TestChainLayerNames(t *testing.T) {
+	names := chainLayerNames([]string{"sha256:base", "sha256:app"})
+	other := chainLayerNames([]string{"sha256:other", "sha256:app"})
+
+	assert.Len(t, names[0], 64)
+	assert.NotEqual(t, names[0], names[1])
+	assert.NotEqual(t, names[1], other[1], "same layer on top of another parent must be named differently")
+}
@@ -0,0 +1,324 @@
+package scan
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerEntry is an entry (file, directory, link...) of an image layer's
+// filesystem. Path is relative to the filesystem's root (e.g. "etc/passwd")
+// and Content reads a regular file's content.
+type LayerEntry struct {
+	Layer   string
+	Path    string
+	Header  *tar.Header
+	Content io.Reader
+}
+
+// scanLayers fetches every platform of image through source (pulling it from
+// registry when nil) and analyzes each one, attributing the findings to the
+// image's layers. Analyzers return results holding only their findings (or
+// other scanner-specific data), which are completed by scanLayers.
+func scanLayers(name string, source Source, image string, analyze func(*docker.Image) (Result, error)) []Result {
+
+	log := logrus.
+		WithField("scanner", name).
+		WithField("image", image)
+
+	log.Info("initializing scan of image's layers")
+
+	defer log.Info("finishing scan of image's layers")
+
+	if source == nil {
+		source = &RegistrySource{}
+	}
+
+	images, err := source.Fetch(image)
+
+	if err != nil {
+		return []Result{errorResult(name, PhasePull, err)}
+	}
+
+	results := make([]Result, 0, len(images))
+
+	for _, platformImage := range images {
+		layers := fetchLayers(platformImage.Image)
+
+		result, err := analyze(platformImage.Image)
+
+		if err != nil {
+			result := errorResult(name, PhaseAnalyze, err)
+			result.Platform = platformImage.Platform
+
+			results = append(results, result)
+			continue
+		}
+
+		attributeFindings(result.Findings, layers)
+
+		result.Scanner = name
+		result.Platform = platformImage.Platform
+		result.Digest = resolveDigest(platformImage.Image)
+		result.Layers = layers
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func errorResult(name string, phase Phase, err error) Result {
+
+	scanErr := classifyClairError(phase, err)
+
+	logrus.
+		WithField("scanner", name).
+		WithField("error.code", scanErr.Code).
+		WithField("error.phase", scanErr.Phase).
+		WithError(err).
+		Error("could not analyze that image")
+
+	return Result{
+		Scanner: name,
+		Error:   scanErr,
+	}
+}
+
+// walkLayers downloads the non-empty layers of image from its registry (from
+// base to top), visiting each entry of their filesystems. Whiteout files,
+// which mark files removed by upper layers, are visited as well.
+func walkLayers(client *http.Client, image *docker.Image, visit func(LayerEntry) error) error {
+
+	for _, digest := range nonEmptyLayers(image) {
+		if err := walkLayer(client, image, digest, visit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkLayer(client *http.Client, image *docker.Image, digest string, visit func(LayerEntry) error) error {
+
+	url := fmt.Sprintf("%s/%s/blobs/%s", image.Registry, image.Name, digest)
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if image.Token != "" {
+		request.Header.Set("Authorization", image.Token)
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return registryStatusError(response.StatusCode, "could not fetch layer "+digest)
+	}
+
+	reader, err := decompressLayer(response.Body)
+
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not read layer %s: %s", digest, err)
+		}
+
+		entry := LayerEntry{
+			Layer:   digest,
+			Path:    cleanLayerPath(header.Name),
+			Header:  header,
+			Content: archive,
+		}
+
+		if entry.Path == "" {
+			continue
+		}
+
+		if err := visit(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// decompressLayer detects whether a layer is gzip compressed (as pushed to
+// registries) or a plain tar (as on image tarballs).
+func decompressLayer(reader io.Reader) (io.Reader, error) {
+
+	buffered := bufio.NewReader(reader)
+
+	magic, err := buffered.Peek(2)
+
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+
+	return buffered, nil
+}
+
+// cleanLayerPath returns a layer's entry name relative to the filesystem's
+// root, e.g. "./etc/passwd" returns "etc/passwd".
+func cleanLayerPath(name string) string {
+
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// isWhiteout returns true when a layer's entry marks a file removed from the
+// lower layers.
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(path.Base(name), ".wh.")
+}
+
+// registryStatusError classifies the status code replied by registry.
+func registryStatusError(statusCode int, message string) *Error {
+
+	scanErr := &Error{
+		Code:    ErrorCodeUnknown,
+		Phase:   PhasePull,
+		Message: fmt.Sprintf("%s: registry replied with status code %d", message, statusCode),
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		scanErr.Code = ErrorCodeUnauthorized
+	case statusCode == http.StatusNotFound:
+		scanErr.Code = ErrorCodeImageNotFound
+	case statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError:
+		scanErr.Code = ErrorCodeUnavailable
+		scanErr.Transient = true
+	}
+
+	return scanErr
+}
+
+// layerFile is a file of the image's filesystem and the layer that has last
+// changed it. Content is only read for some files (see flattenLayers).
+type layerFile struct {
+	layer    string
+	typeflag byte
+	mode     int64
+	linkname string
+	content  []byte
+}
+
+// layerFileReader decides whether an entry is kept on the image's flattened
+// filesystem and what is kept of its content: the content itself or data
+// read from it (e.g. the modules of a Go binary).
+type layerFileReader func(entry LayerEntry) (keep bool, content []byte, err error)
+
+// flattenLayers walks the image's layers, applying each one over the lower
+// ones (i.e. removing the files marked by whiteouts), to get the image's
+// final filesystem. Only the entries kept by read (every entry, without
+// content, when nil) are returned.
+func flattenLayers(client *http.Client, image *docker.Image, read layerFileReader) (map[string]layerFile, error) {
+
+	files := make(map[string]layerFile)
+
+	err := walkLayers(client, image, func(entry LayerEntry) error {
+
+		dir, base := path.Split(entry.Path)
+
+		if base == ".wh..wh..opq" {
+			removeLowerFiles(files, strings.TrimSuffix(dir, "/"), entry.Layer, true)
+			return nil
+		}
+
+		if isWhiteout(entry.Path) {
+			removeLowerFiles(files, dir+strings.TrimPrefix(base, ".wh."), entry.Layer, false)
+			return nil
+		}
+
+		file := layerFile{
+			layer:    entry.Layer,
+			typeflag: entry.Header.Typeflag,
+			mode:     entry.Header.Mode,
+			linkname: entry.Header.Linkname,
+		}
+
+		if read != nil {
+			keep, content, err := read(entry)
+
+			if err != nil {
+				return err
+			}
+
+			// An upper entry replaces the lower one even when not kept
+			// (e.g. a lockfile replaced by a directory).
+			if !keep {
+				delete(files, entry.Path)
+				return nil
+			}
+
+			file.content = content
+		}
+
+		files[entry.Path] = file
+
+		return nil
+	})
+
+	return files, err
+}
+
+// readFiles keeps the entries whose paths are accepted by include, reading
+// the content of their regular files up to maxSize bytes.
+func readFiles(include func(string) bool, maxSize int64) layerFileReader {
+	return func(entry LayerEntry) (bool, []byte, error) {
+
+		if !include(entry.Path) {
+			return false, nil, nil
+		}
+
+		if entry.Header.Typeflag != tar.TypeReg || entry.Header.Size > maxSize {
+			return true, nil, nil
+		}
+
+		content, err := ioutil.ReadAll(io.LimitReader(entry.Content, maxSize))
+
+		return err == nil, content, err
+	}
+}
+
+// removeLowerFiles removes a file (or only its children, when childrenOnly)
+// added by layers other than the current one.
+func removeLowerFiles(files map[string]layerFile, name, layer string, childrenOnly bool) {
+
+	for file, info := range files {
+		if info.layer == layer {
+			continue
+		}
+
+		if (!childrenOnly && file == name) || strings.HasPrefix(file, name+"/") {
+			delete(files, file)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFile is a file of a test layer; directories end with "/" and symbolic
+// links have a link.
+type testFile struct {
+	name    string
+	content string
+	mode    int64
+	link    string
+}
+
+// newTestRegistry serves the layers (gzip compressed tarballs of files) of
+// "tsuru/cst" image, returning that image's manifest.
+func newTestRegistry(t *testing.T, layers ...[]testFile) (*httptest.Server, *docker.Image) {
+
+	blobs := make(map[string][]byte)
+	image := &docker.Image{Name: "tsuru/cst", Tag: "latest"}
+
+	for index, files := range layers {
+		var buffer bytes.Buffer
+
+		compressed := gzip.NewWriter(&buffer)
+		archive := tar.NewWriter(compressed)
+
+		for _, file := range files {
+			header := &tar.Header{Name: file.name, Mode: file.mode, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+
+			if strings.HasSuffix(file.name, "/") {
+				header.Typeflag = tar.TypeDir
+				header.Size = 0
+			}
+
+			if file.link != "" {
+				header.Typeflag = tar.TypeSymlink
+				header.Linkname = file.link
+				header.Size = 0
+			}
+
+			if header.Mode == 0 {
+				header.Mode = 0644
+			}
+
+			require.NoError(t, archive.WriteHeader(header))
+
+			archive.Write([]byte(file.content))
+		}
+
+		archive.Close()
+		compressed.Close()
+
+		digest := "sha256:layer" + string(rune('0'+index))
+
+		blobs[digest] = buffer.Bytes()
+		image.FsLayers = append(image.FsLayers, docker.FsLayer{BlobSum: digest})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blob, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/tsuru/cst/blobs/")]
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(blob)
+	}))
+
+	image.Registry = server.URL + "/v2"
+
+	return server, image
+}
+
+func TestWalkLayers(t *testing.T) {
+	t.Run(`Ensure entries of every layer are visited from base to top`, func(t *testing.T) {
+		server, image := newTestRegistry(t,
+			[]testFile{{name: "./etc/"}, {name: "./etc/passwd", content: "root:x:0:0"}},
+			[]testFile{{name: "app/.wh.config"}},
+		)
+
+		defer server.Close()
+
+		var visited []string
+
+		err := walkLayers(http.DefaultClient, image, func(entry LayerEntry) error {
+			visited = append(visited, entry.Layer+":"+entry.Path)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"sha256:layer0:etc", "sha256:layer0:etc/passwd", "sha256:layer1:app/.wh.config"}, visited)
+	})
+
+	t.Run(`When registry doesn't have the layer, should return an image not found error`, func(t *testing.T) {
+		server, image := newTestRegistry(t)
+
+		defer server.Close()
+
+		image.FsLayers = []docker.FsLayer{{BlobSum: "sha256:unknown"}}
+
+		err := walkLayers(http.DefaultClient, image, func(LayerEntry) error { return nil })
+
+		require.IsType(t, &Error{}, err)
+		assert.Equal(t, ErrorCodeImageNotFound, err.(*Error).Code)
+	})
+}
+
+func TestFlattenLayers(t *testing.T) {
+	t.Run(`When an upper layer replaces a kept file by an entry which isn't kept, should remove the file`, func(t *testing.T) {
+		server, image := newTestRegistry(t,
+			[]testFile{
+				{name: "srv/package-lock.json", content: `{"lockfileVersion":2}`},
+				{name: "srv/yarn.lock", content: "# yarn lockfile v1\n"},
+			},
+			[]testFile{
+				{name: "srv/package-lock.json", link: "/dev/null"},
+			},
+		)
+
+		defer server.Close()
+
+		files, err := flattenLayers(http.DefaultClient, image, readDependencyFile)
+
+		require.NoError(t, err)
+
+		assert.NotContains(t, files, "srv/package-lock.json")
+		assert.Contains(t, files, "srv/yarn.lock")
+	})
+}
@@ -0,0 +1,610 @@
+package scan
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/docker"
+)
+
+// maxLicenseFileSize is the size of the largest package database (or
+// manifest) read by the license scanner. Rpm databases are the largest ones.
+const maxLicenseFileSize = 128 << 20
+
+// Package types of PackageLicense, as on package URLs (purl).
+const (
+	PackageTypeAPK      = "apk"
+	PackageTypeComposer = "composer"
+	PackageTypeDeb      = "deb"
+	PackageTypeGem      = "gem"
+	PackageTypeNPM      = "npm"
+	PackageTypePyPI     = "pypi"
+	PackageTypeRPM      = "rpm"
+)
+
+// PackageLicense holds the licenses of a package installed on an image.
+// Declared is the license as written on the package's metadata (found at
+// Path) and Licenses are its SPDX identifiers (see NormalizeLicenses).
+type PackageLicense struct {
+	Type     string   `bson:"type" json:"type"`
+	Package  string   `bson:"package" json:"package"`
+	Version  string   `bson:"version,omitempty" json:"version,omitempty"`
+	Licenses []string `bson:"licenses,omitempty" json:"licenses,omitempty"`
+	Declared string   `bson:"declared,omitempty" json:"declared,omitempty"`
+	Path     string   `bson:"path,omitempty" json:"path,omitempty"`
+	Layer    string   `bson:"layer,omitempty" json:"layer,omitempty"`
+}
+
+var (
+	dpkgCopyrightRegexp  = regexp.MustCompile(`^usr/share/doc/[^/]+(/copyright)?$`)
+	dpkgStatusDirRegexp  = regexp.MustCompile(`^var/lib/dpkg/status\.d/[^/]+$`)
+	npmManifestRegexp    = regexp.MustCompile(`(^|/)node_modules/(@[^/]+/)?[^/]+/package\.json$`)
+	pythonMetadataRegexp = regexp.MustCompile(`(\.dist-info/METADATA|\.egg-info/PKG-INFO)$`)
+	gemspecRegexp        = regexp.MustCompile(`(^|/)specifications/[^/]+\.gemspec$`)
+	composerRegexp       = regexp.MustCompile(`(^|/)vendor/composer/installed\.json$`)
+
+	commonLicenseRegexp = regexp.MustCompile(`/usr/share/common-licenses/([A-Za-z0-9.+-]*[A-Za-z0-9+])`)
+	gemFieldRegexp      = regexp.MustCompile(`\.(name|version|licenses?)\s*=\s*(.+)`)
+	quotedRegexp        = regexp.MustCompile(`["']([^"']+)["']`)
+)
+
+// rpmDatabases lists where rpm keeps its database. Only the Berkeley DB ones
+// (Packages) are read, the others (SQLite and NDB) are looked up to report
+// the packages' licenses couldn't be read.
+var rpmDatabases = []string{
+	"var/lib/rpm/Packages", "usr/lib/sysimage/rpm/Packages",
+	"var/lib/rpm/rpmdb.sqlite", "usr/lib/sysimage/rpm/rpmdb.sqlite",
+	"var/lib/rpm/Packages.db", "usr/lib/sysimage/rpm/Packages.db",
+}
+
+// LicenseScanner implements the Scanner and PlatformScanner interfaces. It
+// reports the licenses of the packages installed on an image, read from OS
+// package databases (dpkg, rpm and apk) and language packages' manifests
+// (npm, Python, Ruby gems and Composer), on result's Licenses. Packages with
+// licenses on Deny (e.g. "AGPL", "SSPL-1.0") are reported as findings. When
+// an rpm database can't be read (e.g. on SQLite format), the result has an
+// ErrorCodeUnsupported error along with the licenses read elsewhere.
+type LicenseScanner struct {
+	Name    string
+	Source  Source
+	Deny    []string
+	Timeout time.Duration
+}
+
+// Scan reports the licenses of a container image. Only the first platform
+// of multi-platform images is analyzed (see ScanPlatforms).
+func (ls *LicenseScanner) Scan(image string) Result {
+	return ls.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms reports the licenses of every platform of a container image,
+// returning a result per platform.
+func (ls *LicenseScanner) ScanPlatforms(image string) []Result {
+	return scanLayers(ls.Name, ls.Source, image, ls.analyze)
+}
+
+func (ls *LicenseScanner) analyze(dockerImage *docker.Image) (Result, error) {
+
+	files, err := flattenLayers(&http.Client{Timeout: ls.Timeout}, dockerImage, readFiles(isLicenseSource, maxLicenseFileSize))
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	packages, unreadable := readLicenses(files)
+
+	var findings []Finding
+
+	for _, pkg := range packages {
+		denied := licenseDenied(pkg.Licenses, ls.Deny)
+
+		if len(denied) == 0 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Scanner:  ls.Name,
+			Rule:     "license-denied",
+			Title:    "Denied license: " + strings.Join(denied, ", "),
+			Severity: SeverityHigh,
+			Package:  pkg.Package,
+			Version:  pkg.Version,
+			Path:     pkg.Path,
+			Evidence: pkg.Declared,
+			Layer:    pkg.Layer,
+		})
+	}
+
+	result := Result{Findings: findings, Licenses: packages}
+
+	if unreadable != nil {
+		result.Error = &Error{
+			Code:    ErrorCodeUnsupported,
+			Phase:   PhaseAnalyze,
+			Message: unreadable.Error(),
+		}
+	}
+
+	return result, nil
+}
+
+func isLicenseSource(name string) bool {
+
+	switch name {
+	case "var/lib/dpkg/status", "lib/apk/db/installed":
+		return true
+	}
+
+	if isRPMDatabase(name) {
+		return true
+	}
+
+	for _, pattern := range []*regexp.Regexp{dpkgCopyrightRegexp, dpkgStatusDirRegexp, npmManifestRegexp, pythonMetadataRegexp, gemspecRegexp, composerRegexp} {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readLicenses reads the packages' licenses from the files of an image's
+// filesystem, sorted by type, name and version. Returns an error when some
+// package database couldn't be read, along with the other packages.
+func readLicenses(files map[string]layerFile) ([]PackageLicense, error) {
+
+	var packages []PackageLicense
+	var unreadable error
+
+	for name, file := range files {
+		if file.typeflag != tar.TypeReg {
+			continue
+		}
+
+		var found []PackageLicense
+
+		switch {
+		case name == "var/lib/dpkg/status" || dpkgStatusDirRegexp.MatchString(name):
+			found = readDpkgLicenses(files, file.content)
+		case name == "lib/apk/db/installed":
+			found = readAPKLicenses(file.content)
+		case isRPMDatabase(name):
+			var err error
+
+			if found, err = readRPMLicenses(name, file.content); err != nil {
+				unreadable = err
+			}
+		case npmManifestRegexp.MatchString(name):
+			found = readNPMLicense(file.content)
+		case pythonMetadataRegexp.MatchString(name):
+			found = readPythonLicense(file.content)
+		case gemspecRegexp.MatchString(name):
+			found = readGemLicense(file.content)
+		case composerRegexp.MatchString(name):
+			found = readComposerLicenses(file.content)
+		}
+
+		for _, pkg := range found {
+			if pkg.Path == "" {
+				pkg.Path = name
+				pkg.Layer = file.layer
+			}
+
+			pkg.Licenses = NormalizeLicenses(pkg.Declared)
+
+			packages = append(packages, pkg)
+		}
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+
+		return a.Path < b.Path
+	})
+
+	return packages, unreadable
+}
+
+// readDpkgLicenses lists the installed packages of a dpkg status file, taking
+// their licenses from their copyright files (usr/share/doc/<package>/copyright).
+func readDpkgLicenses(files map[string]layerFile, status []byte) []PackageLicense {
+
+	var packages []PackageLicense
+
+	for _, stanza := range readStanzas(status) {
+		if fields := strings.Fields(stanza["Status"]); len(fields) > 0 && fields[len(fields)-1] != "installed" {
+			continue
+		}
+
+		if stanza["Package"] == "" {
+			continue
+		}
+
+		pkg := PackageLicense{
+			Type:    PackageTypeDeb,
+			Package: stanza["Package"],
+			Version: stanza["Version"],
+		}
+
+		if name, copyright, ok := dpkgCopyright(files, pkg.Package); ok {
+			pkg.Declared = strings.Join(readDebianCopyright(copyright.content), " AND ")
+			pkg.Path = name
+			pkg.Layer = copyright.layer
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+// dpkgCopyright finds the copyright file of a package, following its doc
+// directory when it's a symbolic link (e.g. to the one of its source package).
+func dpkgCopyright(files map[string]layerFile, pkg string) (string, layerFile, bool) {
+
+	dir := path.Join("usr/share/doc", pkg)
+
+	if link, ok := files[dir]; ok && link.typeflag == tar.TypeSymlink {
+		if path.IsAbs(link.linkname) {
+			dir = strings.TrimPrefix(path.Clean(link.linkname), "/")
+		} else {
+			dir = path.Join("usr/share/doc", link.linkname)
+		}
+	}
+
+	name := path.Join(dir, "copyright")
+	file, ok := files[name]
+
+	return name, file, ok && file.typeflag == tar.TypeReg
+}
+
+// readDebianCopyright returns the licenses of a Debian copyright file: the
+// License fields of machine-readable ones (DEP-5) or the references to
+// /usr/share/common-licenses otherwise.
+func readDebianCopyright(copyright []byte) []string {
+
+	seen := make(map[string]bool)
+
+	var licenses []string
+
+	add := func(license string) {
+		if license = strings.TrimSpace(license); license != "" && !seen[license] {
+			seen[license] = true
+			licenses = append(licenses, license)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(copyright))
+
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "License:") {
+			add(strings.TrimPrefix(line, "License:"))
+		}
+	}
+
+	if len(licenses) > 0 {
+		return licenses
+	}
+
+	for _, match := range commonLicenseRegexp.FindAllSubmatch(copyright, -1) {
+		add(string(match[1]))
+	}
+
+	return licenses
+}
+
+// readAPKLicenses lists the packages of an apk database.
+func readAPKLicenses(installed []byte) []PackageLicense {
+
+	var packages []PackageLicense
+
+	for _, stanza := range readStanzas(installed) {
+		if stanza["P"] == "" {
+			continue
+		}
+
+		packages = append(packages, PackageLicense{
+			Type:     PackageTypeAPK,
+			Package:  stanza["P"],
+			Version:  stanza["V"],
+			Declared: stanza["L"],
+		})
+	}
+
+	return packages
+}
+
+func readRPMLicenses(name string, database []byte) ([]PackageLicense, error) {
+
+	rpms, err := readRPMDB(database)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read rpm packages from %s: %s", name, err)
+	}
+
+	packages := make([]PackageLicense, len(rpms))
+
+	for index, rpm := range rpms {
+		packages[index] = PackageLicense{
+			Type:     PackageTypeRPM,
+			Package:  rpm.name,
+			Version:  rpm.version,
+			Declared: rpm.license,
+		}
+	}
+
+	return packages, nil
+}
+
+func isRPMDatabase(name string) bool {
+
+	for _, database := range rpmDatabases {
+		if name == database {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readNPMLicense reads a package.json, whose license is either a SPDX
+// expression, an object ({"type": "MIT"}) or a (deprecated) list of objects.
+func readNPMLicense(manifest []byte) []PackageLicense {
+
+	var pkg struct {
+		Name     string          `json:"name"`
+		Version  string          `json:"version"`
+		License  json.RawMessage `json:"license"`
+		Licenses json.RawMessage `json:"licenses"`
+	}
+
+	if err := json.Unmarshal(manifest, &pkg); err != nil || pkg.Name == "" {
+		return nil
+	}
+
+	declared := jsonLicenses(pkg.License)
+
+	if declared == "" {
+		declared = jsonLicenses(pkg.Licenses)
+	}
+
+	return []PackageLicense{{Type: PackageTypeNPM, Package: pkg.Name, Version: pkg.Version, Declared: declared}}
+}
+
+// readPythonLicense reads the metadata of an installed Python distribution,
+// whose license is either a SPDX expression (License-Expression), a short
+// name (License) or trove classifiers ("License :: OSI Approved :: MIT
+// License").
+func readPythonLicense(metadata []byte) []PackageLicense {
+
+	pkg := PackageLicense{Type: PackageTypePyPI}
+
+	var license, expression string
+	var classifiers []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(metadata))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			break
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(parts[1])
+
+		switch parts[0] {
+		case "Name":
+			pkg.Package = value
+		case "Version":
+			pkg.Version = value
+		case "License":
+			license = value
+		case "License-Expression":
+			expression = value
+		case "Classifier":
+			if segments := strings.Split(value, "::"); len(segments) > 1 && strings.TrimSpace(segments[0]) == "License" {
+				classifiers = append(classifiers, strings.TrimSpace(segments[len(segments)-1]))
+			}
+		}
+	}
+
+	switch {
+	case expression != "":
+		pkg.Declared = expression
+	case license != "" && license != "UNKNOWN" && len(license) <= 64:
+		pkg.Declared = license
+	default:
+		pkg.Declared = strings.Join(classifiers, " OR ")
+	}
+
+	if pkg.Package == "" {
+		return nil
+	}
+
+	return []PackageLicense{pkg}
+}
+
+// readGemLicense reads an installed gem's specification, which is Ruby code
+// written by RubyGems (e.g. `s.licenses = ["MIT".freeze]`).
+func readGemLicense(spec []byte) []PackageLicense {
+
+	pkg := PackageLicense{Type: PackageTypeGem}
+
+	for _, match := range gemFieldRegexp.FindAllSubmatch(spec, -1) {
+		var values []string
+
+		for _, quoted := range quotedRegexp.FindAllSubmatch(match[2], -1) {
+			values = append(values, string(quoted[1]))
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		switch string(match[1]) {
+		case "name":
+			if pkg.Package == "" {
+				pkg.Package = values[0]
+			}
+		case "version":
+			if pkg.Version == "" {
+				pkg.Version = values[0]
+			}
+		default:
+			if pkg.Declared == "" {
+				pkg.Declared = strings.Join(values, " OR ")
+			}
+		}
+	}
+
+	if pkg.Package == "" {
+		return nil
+	}
+
+	return []PackageLicense{pkg}
+}
+
+// readComposerLicenses reads the packages installed by Composer, listed as an
+// array (Composer 1) or on "packages" (Composer 2).
+func readComposerLicenses(installed []byte) []PackageLicense {
+
+	type composerPackage struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		License json.RawMessage `json:"license"`
+	}
+
+	var composer struct {
+		Packages []composerPackage `json:"packages"`
+	}
+
+	if err := json.Unmarshal(installed, &composer.Packages); err != nil {
+		if err := json.Unmarshal(installed, &composer); err != nil {
+			return nil
+		}
+	}
+
+	var packages []PackageLicense
+
+	for _, pkg := range composer.Packages {
+		if pkg.Name == "" {
+			continue
+		}
+
+		packages = append(packages, PackageLicense{
+			Type:     PackageTypeComposer,
+			Package:  pkg.Name,
+			Version:  pkg.Version,
+			Declared: jsonLicenses(pkg.License),
+		})
+	}
+
+	return packages
+}
+
+// jsonLicenses reads a license written on JSON as a string, an object with a
+// type, or a list of those (joined as alternatives).
+func jsonLicenses(raw json.RawMessage) string {
+
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var license string
+
+	if err := json.Unmarshal(raw, &license); err == nil {
+		return license
+	}
+
+	var object struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(raw, &object); err == nil {
+		return object.Type
+	}
+
+	var list []json.RawMessage
+
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return ""
+	}
+
+	var licenses []string
+
+	for _, item := range list {
+		if license := jsonLicenses(item); license != "" {
+			licenses = append(licenses, license)
+		}
+	}
+
+	return strings.Join(licenses, " OR ")
+}
+
+// readStanzas reads paragraphs of "Key: value" lines (as on dpkg status files
+// and apk databases, whose keys are single letters, e.g. "P:pkg"), separated
+// by blank lines. Continuation lines are ignored.
+func readStanzas(data []byte) []map[string]string {
+
+	var stanzas []map[string]string
+
+	stanza := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			if len(stanza) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = make(map[string]string)
+			}
+
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			stanza[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	if len(stanza) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+
+	return stanzas
+}
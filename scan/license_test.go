@@ -0,0 +1,121 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLicenseScanner_Scan(t *testing.T) {
+	rpmdb := newTestRPMDB(rpmHeader(map[int32]string{rpmTagName: "bash", rpmTagVersion: "4.4.20", rpmTagRelease: "4.el8", rpmTagLicense: "GPLv3+"}, 0))
+
+	server, image := newTestRegistry(t,
+		[]testFile{
+			{name: "var/lib/dpkg/status", content: "Package: bash\nStatus: install ok installed\nVersion: 5.1-2\nDescription: GNU Bourne Again SHell\n Bash is an sh-compatible command language interpreter.\n\nPackage: libssl1.1\nStatus: install ok installed\nVersion: 1.1.1n-0\n\nPackage: vim\nStatus: deinstall ok config-files\nVersion: 2:8.2\n"},
+			{name: "usr/share/doc/bash/copyright", content: "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n\nFiles: *\nLicense: GPL-3+\n\nFiles: examples/*\nLicense: GPL-2+ or Artistic\n"},
+			{name: "usr/share/doc/openssl/copyright", content: "On Debian systems, the complete text of the Apache License, Version 2.0\ncan be found in `/usr/share/common-licenses/Apache-2.0'.\n"},
+			{name: "usr/share/doc/libssl1.1", link: "openssl"},
+			{name: "var/lib/rpm/Packages", content: string(rpmdb)},
+		},
+		[]testFile{
+			{name: "lib/apk/db/installed", content: "C:Q1\nP:musl\nV:1.2.3-r0\nL:MIT\n\nP:busybox\nV:1.35.0-r17\nL:GPL-2.0-only\n"},
+			{name: "app/node_modules/express/package.json", content: `{"name":"express","version":"4.18.2","license":"MIT"}`},
+			{name: "app/node_modules/@acme/reports/package.json", content: `{"name":"@acme/reports","version":"1.0.0","licenses":[{"type":"AGPL-3.0"}]}`},
+			{name: "usr/lib/python3/site-packages/requests-2.31.0.dist-info/METADATA", content: "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\nLicense: UNKNOWN\nClassifier: License :: OSI Approved :: Apache Software License\n\nLicense: MIT\n"},
+			{name: "usr/local/bundle/specifications/rack-2.2.8.gemspec", content: "Gem::Specification.new do |s|\n  s.name = \"rack\".freeze\n  s.version = \"2.2.8\"\n  s.licenses = [\"MIT\".freeze]\nend\n"},
+			{name: "app/vendor/composer/installed.json", content: `{"packages":[{"name":"monolog/monolog","version":"2.9.1","license":["MIT"]}]}`},
+			{name: "app/README.md", content: "License: AGPL"},
+		},
+	)
+
+	defer server.Close()
+
+	source := &MockSource{
+		MockFetch: func(string) ([]PlatformImage, error) {
+			return []PlatformImage{{Image: image}}, nil
+		},
+	}
+
+	t.Run(`Ensure licenses of OS and language packages are reported`, func(t *testing.T) {
+		scanner := &LicenseScanner{Name: "licenses", Source: source}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		assert.Empty(t, result.Findings)
+
+		expected := []PackageLicense{
+			{Type: PackageTypeAPK, Package: "busybox", Version: "1.35.0-r17", Licenses: []string{"GPL-2.0-only"}, Declared: "GPL-2.0-only", Path: "lib/apk/db/installed", Layer: "sha256:layer1"},
+			{Type: PackageTypeAPK, Package: "musl", Version: "1.2.3-r0", Licenses: []string{"MIT"}, Declared: "MIT", Path: "lib/apk/db/installed", Layer: "sha256:layer1"},
+			{Type: PackageTypeComposer, Package: "monolog/monolog", Version: "2.9.1", Licenses: []string{"MIT"}, Declared: "MIT", Path: "app/vendor/composer/installed.json", Layer: "sha256:layer1"},
+			{Type: PackageTypeDeb, Package: "bash", Version: "5.1-2", Licenses: []string{"Artistic-1.0-Perl", "GPL-2.0-or-later", "GPL-3.0-or-later"}, Declared: "GPL-3+ AND GPL-2+ or Artistic", Path: "usr/share/doc/bash/copyright", Layer: "sha256:layer0"},
+			{Type: PackageTypeDeb, Package: "libssl1.1", Version: "1.1.1n-0", Licenses: []string{"Apache-2.0"}, Declared: "Apache-2.0", Path: "usr/share/doc/openssl/copyright", Layer: "sha256:layer0"},
+			{Type: PackageTypeGem, Package: "rack", Version: "2.2.8", Licenses: []string{"MIT"}, Declared: "MIT", Path: "usr/local/bundle/specifications/rack-2.2.8.gemspec", Layer: "sha256:layer1"},
+			{Type: PackageTypeNPM, Package: "@acme/reports", Version: "1.0.0", Licenses: []string{"AGPL-3.0-only"}, Declared: "AGPL-3.0", Path: "app/node_modules/@acme/reports/package.json", Layer: "sha256:layer1"},
+			{Type: PackageTypeNPM, Package: "express", Version: "4.18.2", Licenses: []string{"MIT"}, Declared: "MIT", Path: "app/node_modules/express/package.json", Layer: "sha256:layer1"},
+			{Type: PackageTypePyPI, Package: "requests", Version: "2.31.0", Licenses: []string{"Apache-2.0"}, Declared: "Apache Software License", Path: "usr/lib/python3/site-packages/requests-2.31.0.dist-info/METADATA", Layer: "sha256:layer1"},
+			{Type: PackageTypeRPM, Package: "bash", Version: "4.4.20-4.el8", Licenses: []string{"GPL-3.0-or-later"}, Declared: "GPLv3+", Path: "var/lib/rpm/Packages", Layer: "sha256:layer0"},
+		}
+
+		assert.Equal(t, expected, result.Licenses)
+	})
+
+	t.Run(`When packages have denied licenses, should report them as findings`, func(t *testing.T) {
+		scanner := &LicenseScanner{Name: "licenses", Source: source, Deny: []string{"AGPL", "Artistic-1.0-Perl"}}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		require.Nil(t, result.Error)
+		require.Len(t, result.Findings, 2)
+
+		assert.Equal(t, Finding{
+			Scanner:  "licenses",
+			Rule:     "license-denied",
+			Title:    "Denied license: AGPL-3.0-only",
+			Severity: SeverityHigh,
+			Package:  "@acme/reports",
+			Version:  "1.0.0",
+			Path:     "app/node_modules/@acme/reports/package.json",
+			Evidence: "AGPL-3.0",
+			Layer:    "sha256:layer1",
+		}, result.Findings[1])
+
+		assert.Equal(t, "bash", result.Findings[0].Package)
+		assert.Equal(t, "Denied license: Artistic-1.0-Perl", result.Findings[0].Title)
+	})
+
+	t.Run(`When rpm database is on SQLite format, should report an unsupported error along with the other licenses`, func(t *testing.T) {
+		server, image := newTestRegistry(t,
+			[]testFile{
+				{name: "var/lib/rpm/rpmdb.sqlite", content: "SQLite format 3\x00" + strings.Repeat("\x00", 100)},
+				{name: "lib/apk/db/installed", content: "P:musl\nV:1.2.3-r0\nL:MIT\n"},
+			},
+		)
+
+		defer server.Close()
+
+		scanner := &LicenseScanner{
+			Name: "licenses",
+			Source: &MockSource{
+				MockFetch: func(string) ([]PlatformImage, error) {
+					return []PlatformImage{{Image: image}}, nil
+				},
+			},
+		}
+
+		result := scanner.Scan("tsuru/cst:latest")
+
+		if assert.NotNil(t, result.Error) {
+			assert.Equal(t, ErrorCodeUnsupported, result.Error.Code)
+			assert.Equal(t, PhaseAnalyze, result.Error.Phase)
+			assert.Contains(t, result.Error.Message, "var/lib/rpm/rpmdb.sqlite")
+			assert.False(t, result.IsTransient())
+		}
+
+		if assert.Len(t, result.Licenses, 1) {
+			assert.Equal(t, "musl", result.Licenses[0].Package)
+		}
+	})
+}
@@ -1,5 +1,7 @@
 package scan
 
+import "github.com/optiopay/klar/docker"
+
 // MockScanner is a mock implementation for testing purposes.
 type MockScanner struct {
 	MockScan func(string) Result
@@ -14,3 +16,60 @@ func (ms *MockScanner) Scan(image string) Result {
 
 	return Result{}
 }
+
+// MockSource is a mock implementation for testing purposes.
+type MockSource struct {
+	MockFetch func(string) ([]PlatformImage, error)
+}
+
+// Fetch is a mock implementation for testing purposes.
+func (ms *MockSource) Fetch(image string) ([]PlatformImage, error) {
+
+	if ms.MockFetch != nil {
+		return ms.MockFetch(image)
+	}
+
+	return []PlatformImage{{Image: &docker.Image{}}}, nil
+}
+
+// MockPlatformScanner is a mock implementation for testing purposes.
+type MockPlatformScanner struct {
+	MockScanner
+	MockScanPlatforms func(string) []Result
+}
+
+// ScanPlatforms is a mock implementation for testing purposes.
+func (mps *MockPlatformScanner) ScanPlatforms(image string) []Result {
+
+	if mps.MockScanPlatforms != nil {
+		return mps.MockScanPlatforms(image)
+	}
+
+	return []Result{mps.Scan(image)}
+}
+
+// MockLayerCache is a mock implementation for testing purposes.
+type MockLayerCache struct {
+	MockGet func(string, string, []string) (map[string][]Finding, error)
+	MockPut func(string, string, map[string][]Finding) error
+}
+
+// Get is a mock implementation for testing purposes.
+func (mlc *MockLayerCache) Get(scanner, dbVersion string, names []string) (map[string][]Finding, error) {
+
+	if mlc.MockGet != nil {
+		return mlc.MockGet(scanner, dbVersion, names)
+	}
+
+	return nil, nil
+}
+
+// Put is a mock implementation for testing purposes.
+func (mlc *MockLayerCache) Put(scanner, dbVersion string, layers map[string][]Finding) error {
+
+	if mlc.MockPut != nil {
+		return mlc.MockPut(scanner, dbVersion, layers)
+	}
+
+	return nil
+}
@@ -0,0 +1,597 @@
+package scan
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Ecosystems of language packages, named as on OSV advisories.
+const (
+	EcosystemGo        = "Go"
+	EcosystemMaven     = "Maven"
+	EcosystemNPM       = "npm"
+	EcosystemPackagist = "Packagist"
+	EcosystemPyPI      = "PyPI"
+	EcosystemRubyGems  = "RubyGems"
+)
+
+// osvLink is where the advisories of OSV database are published.
+const osvLink = "https://osv.dev/vulnerability/"
+
+var pypiNameRegexp = regexp.MustCompile(`[-_.]+`)
+
+// osvVulnerability is an advisory on OSV format (https://ossf.github.io/osv-schema/).
+type osvVulnerability struct {
+	ID        string        `json:"id"`
+	Aliases   []string      `json:"aliases"`
+	Summary   string        `json:"summary"`
+	Withdrawn string        `json:"withdrawn"`
+	Affected  []osvAffected `json:"affected"`
+	Severity  []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges []struct {
+		Type   string     `json:"type"`
+		Events []osvEvent `json:"events"`
+	} `json:"ranges"`
+	Versions []string `json:"versions"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced"`
+	Fixed        string `json:"fixed"`
+	LastAffected string `json:"last_affected"`
+	Limit        string `json:"limit"`
+}
+
+// OSVDatabase holds advisories on OSV format indexed by the ecosystem and
+// name of their affected packages.
+type OSVDatabase struct {
+	advisories map[string][]*osvVulnerability
+}
+
+// LoadOSVDatabase reads the OSV advisories under dir: JSON files, one per
+// advisory, or ZIP archives of them (as exported by osv.dev for each
+// ecosystem). Withdrawn advisories are ignored.
+func LoadOSVDatabase(dir string) (*OSVDatabase, error) {
+
+	db := &OSVDatabase{advisories: make(map[string][]*osvVulnerability)}
+
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json":
+			data, err := ioutil.ReadFile(name)
+
+			if err != nil {
+				return err
+			}
+
+			return db.add(name, data)
+
+		case ".zip":
+			return db.addArchive(name)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Len returns how many advisories (per affected package) are on database.
+func (db *OSVDatabase) Len() int {
+
+	count := 0
+
+	for _, advisories := range db.advisories {
+		count += len(advisories)
+	}
+
+	return count
+}
+
+func (db *OSVDatabase) addArchive(name string) error {
+
+	archive, err := zip.OpenReader(name)
+
+	if err != nil {
+		return err
+	}
+
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if !strings.HasSuffix(strings.ToLower(file.Name), ".json") {
+			continue
+		}
+
+		reader, err := file.Open()
+
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if err := db.add(name+"/"+file.Name, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *OSVDatabase) add(name string, data []byte) error {
+
+	var vulnerability osvVulnerability
+
+	if err := json.Unmarshal(data, &vulnerability); err != nil {
+		return &os.PathError{Op: "parse", Path: name, Err: err}
+	}
+
+	if vulnerability.Withdrawn != "" {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+
+	for _, affected := range vulnerability.Affected {
+		key := osvKey(affected.Package.Ecosystem, affected.Package.Name)
+
+		if !seen[key] {
+			seen[key] = true
+			db.advisories[key] = append(db.advisories[key], &vulnerability)
+		}
+	}
+
+	return nil
+}
+
+// Match returns the findings of the advisories affecting a package's version.
+// An advisory also known by a CVE (one of its aliases) is reported by that
+// CVE, once per package.
+func (db *OSVDatabase) Match(ecosystem, name, version string) []Finding {
+
+	key := osvKey(ecosystem, name)
+
+	var findings []Finding
+
+	seen := make(map[string]bool)
+
+	for _, vulnerability := range db.advisories[key] {
+		for _, affected := range vulnerability.Affected {
+			if osvKey(affected.Package.Ecosystem, affected.Package.Name) != key {
+				continue
+			}
+
+			fixedBy, ok := affected.affects(version)
+
+			if !ok {
+				continue
+			}
+
+			id := vulnerability.identifier()
+
+			if seen[id] {
+				break
+			}
+
+			seen[id] = true
+
+			findings = append(findings, Finding{
+				CVE:      id,
+				Package:  name,
+				Version:  version,
+				FixedBy:  fixedBy,
+				Severity: vulnerability.severity(),
+				Link:     osvLink + vulnerability.ID,
+				Title:    vulnerability.Summary,
+			})
+
+			break
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].CVE < findings[j].CVE
+	})
+
+	return findings
+}
+
+// identifier returns the advisory's CVE, when it has one, or its ID.
+func (v *osvVulnerability) identifier() string {
+
+	for _, alias := range v.Aliases {
+		if strings.HasPrefix(alias, "CVE-") {
+			return alias
+		}
+	}
+
+	return v.ID
+}
+
+// severity returns the severity given by the advisory's database (e.g. GitHub
+// Advisory Database's "MODERATE") or the one of its CVSS v3 score.
+func (v *osvVulnerability) severity() string {
+
+	if severity := NormalizeSeverity(v.DatabaseSpecific.Severity); severity != SeverityUnknown {
+		return severity
+	}
+
+	for _, severity := range v.Severity {
+		if severity.Type == "CVSS_V3" {
+			return cvss3Severity(severity.Score)
+		}
+	}
+
+	return SeverityUnknown
+}
+
+// affects returns true when version is on the affected versions: listed
+// explicitly or on some (SEMVER or ECOSYSTEM) range. Returns the version
+// fixing it as well, when known.
+func (a osvAffected) affects(version string) (string, bool) {
+
+	for _, listed := range a.Versions {
+		if compareEcosystemVersions(version, listed) == 0 {
+			return "", true
+		}
+	}
+
+	for _, r := range a.Ranges {
+		if r.Type != "SEMVER" && r.Type != "ECOSYSTEM" {
+			continue
+		}
+
+		if fixedBy, ok := rangeAffects(r.Events, version); ok {
+			return fixedBy, true
+		}
+	}
+
+	return "", false
+}
+
+// rangeAffects evaluates the events of a range, sorted by version, up to a
+// given version: introduced events make the versions from there affected,
+// while fixed (and versions after last_affected) ones make them unaffected.
+func rangeAffects(events []osvEvent, version string) (string, bool) {
+
+	events = append([]osvEvent(nil), events...)
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return compareEcosystemVersions(events[i].version(), events[j].version()) < 0
+	})
+
+	affected := false
+
+	for _, event := range events {
+		switch {
+		case event.Introduced != "":
+			if event.Introduced != "0" && compareEcosystemVersions(version, event.Introduced) < 0 {
+				return "", affected
+			}
+
+			affected = true
+
+		case event.Fixed != "":
+			if compareEcosystemVersions(version, event.Fixed) < 0 {
+				return event.Fixed, affected
+			}
+
+			affected = false
+
+		case event.LastAffected != "":
+			if compareEcosystemVersions(version, event.LastAffected) <= 0 {
+				return "", affected
+			}
+
+			affected = false
+		}
+	}
+
+	return "", affected
+}
+
+func (e osvEvent) version() string {
+
+	switch {
+	case e.Introduced == "0":
+		return ""
+	case e.Introduced != "":
+		return e.Introduced
+	case e.Fixed != "":
+		return e.Fixed
+	case e.LastAffected != "":
+		return e.LastAffected
+	}
+
+	return e.Limit
+}
+
+// osvKey identifies a package on OSV database. Ecosystems' suffixes (e.g.
+// "Maven:central") are dropped and PyPI's names normalized (PEP 503).
+func osvKey(ecosystem, name string) string {
+
+	ecosystem = strings.SplitN(ecosystem, ":", 2)[0]
+
+	switch ecosystem {
+	case EcosystemPyPI:
+		name = pypiNameRegexp.ReplaceAllString(strings.ToLower(name), "-")
+	case EcosystemPackagist:
+		name = strings.ToLower(name)
+	}
+
+	return ecosystem + "|" + name
+}
+
+// versionQualifiers ranks the non-numeric parts of versions (e.g. "1.0rc1",
+// "1.0.0-beta.2", "2.0.Final"). Unknown ones rank as pre-releases.
+var versionQualifiers = map[string]int{
+	"dev":       0,
+	"alpha":     2,
+	"a":         2,
+	"beta":      3,
+	"b":         3,
+	"milestone": 4,
+	"m":         4,
+	"rc":        5,
+	"cr":        5,
+	"c":         5,
+	"pre":       5,
+	"preview":   5,
+	"snapshot":  6,
+	"":          7,
+	"final":     7,
+	"ga":        7,
+	"release":   7,
+	"post":      8,
+	"sp":        8,
+	"p":         8,
+}
+
+// compareEcosystemVersions compares versions of the supported ecosystems
+// (semantic versions, PEP 440, RubyGems, Maven and Composer ones) by their
+// numeric and qualifier parts, so "1.0.0-rc.1" < "1.0.0" < "1.0.1" and
+// "1.0" == "1.0.0". Returns a negative number when a is lower than b, zero
+// when equal, otherwise a positive number. The empty version is the lowest.
+func compareEcosystemVersions(a, b string) int {
+
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+
+	partsA, partsB := versionParts(a), versionParts(b)
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		partA, partB := "", ""
+
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+
+		if result := compareVersionParts(partA, partB); result != 0 {
+			return result
+		}
+	}
+
+	return 0
+}
+
+func compareVersionParts(a, b string) int {
+
+	numericA, numericB := isNumeric(a), isNumeric(b)
+
+	switch {
+	case numericA && numericB:
+		return compareNumbers(a, b)
+	case numericA:
+		// a missing part is zero when compared to numbers ("1.0" == "1.0.0")
+		if b == "" {
+			return compareNumbers(a, "0")
+		}
+
+		return 1
+	case numericB:
+		if a == "" {
+			return compareNumbers("0", b)
+		}
+
+		return -1
+	}
+
+	rankA, knownA := versionQualifiers[a]
+	rankB, knownB := versionQualifiers[b]
+
+	if !knownA {
+		rankA = 1
+	}
+
+	if !knownB {
+		rankB = 1
+	}
+
+	if rankA != rankB {
+		return rankA - rankB
+	}
+
+	return strings.Compare(a, b)
+}
+
+// versionParts splits a version into runs of digits and letters, dropping
+// separators, the "v" prefix and build metadata ("+build").
+func versionParts(version string) []string {
+
+	version = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V"))
+	version = strings.SplitN(version, "+", 2)[0]
+
+	var parts []string
+
+	start := -1
+
+	for i := 0; i <= len(version); i++ {
+		if start >= 0 && (i == len(version) || !isAlphanumeric(version[i]) || isDigit(version[i]) != isDigit(version[start])) {
+			parts = append(parts, version[start:i])
+			start = -1
+		}
+
+		if start < 0 && i < len(version) && isAlphanumeric(version[i]) {
+			start = i
+		}
+	}
+
+	return parts
+}
+
+// compareNumbers compares decimal numbers of any size.
+func compareNumbers(a, b string) int {
+
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	return s != "" && isDigit(s[0])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlphanumeric(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z')
+}
+
+// cvss3Weights are the metrics' weights of CVSS v3 base score.
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3Severity returns the qualitative severity of a CVSS v3 vector's base
+// score (e.g. "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H" is critical).
+func cvss3Severity(vector string) string {
+
+	metrics := make(map[string]string)
+
+	for _, metric := range strings.Split(vector, "/")[1:] {
+		if parts := strings.SplitN(metric, ":", 2); len(parts) == 2 {
+			metrics[parts[0]] = parts[1]
+		}
+	}
+
+	weights := make(map[string]float64)
+
+	for metric, values := range cvss3Weights {
+		weight, ok := values[metrics[metric]]
+
+		if !ok {
+			return SeverityUnknown
+		}
+
+		weights[metric] = weight
+	}
+
+	changed := metrics["S"] == "C"
+
+	if changed && metrics["PR"] == "L" {
+		weights["PR"] = 0.68
+	} else if changed && metrics["PR"] == "H" {
+		weights["PR"] = 0.5
+	}
+
+	iss := 1 - (1-weights["C"])*(1-weights["I"])*(1-weights["A"])
+	impact := 6.42 * iss
+
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+
+	exploitability := 8.22 * weights["AV"] * weights["AC"] * weights["PR"] * weights["UI"]
+
+	score := 0.0
+
+	switch {
+	case impact <= 0:
+	case changed:
+		score = cvss3RoundUp(math.Min(1.08*(impact+exploitability), 10))
+	default:
+		score = cvss3RoundUp(math.Min(impact+exploitability, 10))
+	}
+
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+
+	return SeverityNegligible
+}
+
+// cvss3RoundUp rounds up to one decimal place, as defined by CVSS v3.1.
+func cvss3RoundUp(value float64) float64 {
+
+	integer := int(math.Round(value * 100000))
+
+	if integer%10000 == 0 {
+		return float64(integer) / 100000
+	}
+
+	return float64(integer/10000+1) / 10
+}
@@ -0,0 +1,185 @@
+package scan
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLodashAdvisory = `{
+		"id": "GHSA-jf85-cpcp-j695",
+		"aliases": ["CVE-2019-10744"],
+		"summary": "Prototype Pollution in lodash",
+		"affected": [{
+			"package": {"ecosystem": "npm", "name": "lodash"},
+			"ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "4.17.12"}]}]
+		}],
+		"database_specific": {"severity": "CRITICAL"}
+	}`
+
+	testJinja2Advisory = `{
+		"id": "PYSEC-2019-217",
+		"summary": "Sandbox escape in Jinja2",
+		"affected": [{
+			"package": {"ecosystem": "PyPI", "name": "jinja2"},
+			"ranges": [
+				{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "2.10.1"}]},
+				{"type": "ECOSYSTEM", "events": [{"introduced": "2.11.0a1"}, {"last_affected": "2.11.0"}]}
+			],
+			"versions": ["2.4.1"]
+		}],
+		"severity": [{"type": "CVSS_V3", "score": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:N/A:N"}]
+	}`
+
+	testWithdrawnAdvisory = `{
+		"id": "GHSA-withdrawn",
+		"withdrawn": "2021-01-01T00:00:00Z",
+		"affected": [{"package": {"ecosystem": "npm", "name": "lodash"}, "versions": ["4.17.11"]}]
+	}`
+)
+
+// newTestOSVDatabase writes advisories as JSON files, and as a ZIP archive
+// of JSON files, to a temporary dir.
+func newTestOSVDatabase(t *testing.T, files map[string]string, archived map[string]string) string {
+
+	dir, err := ioutil.TempDir("", "osv")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	if len(archived) > 0 {
+		file, err := os.Create(filepath.Join(dir, "all.zip"))
+		require.NoError(t, err)
+
+		archive := zip.NewWriter(file)
+
+		for name, content := range archived {
+			writer, err := archive.Create(name)
+			require.NoError(t, err)
+
+			writer.Write([]byte(content))
+		}
+
+		require.NoError(t, archive.Close())
+		require.NoError(t, file.Close())
+	}
+
+	return dir
+}
+
+func TestLoadOSVDatabase(t *testing.T) {
+	t.Run(`Ensure advisories are loaded from JSON files and ZIP archives`, func(t *testing.T) {
+		dir := newTestOSVDatabase(t,
+			map[string]string{"npm/GHSA-jf85-cpcp-j695.json": testLodashAdvisory, "npm/GHSA-withdrawn.json": testWithdrawnAdvisory, "README.md": "advisories"},
+			map[string]string{"PYSEC-2019-217.json": testJinja2Advisory},
+		)
+
+		db, err := LoadOSVDatabase(dir)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, db.Len())
+	})
+
+	t.Run(`When an advisory isn't valid JSON, should returns an error`, func(t *testing.T) {
+		dir := newTestOSVDatabase(t, map[string]string{"invalid.json": "{"}, nil)
+
+		_, err := LoadOSVDatabase(dir)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestOSVDatabase_Match(t *testing.T) {
+	dir := newTestOSVDatabase(t, map[string]string{"lodash.json": testLodashAdvisory, "jinja2.json": testJinja2Advisory}, nil)
+
+	db, err := LoadOSVDatabase(dir)
+	require.NoError(t, err)
+
+	t.Run(`When version is on an affected range, should returns its advisory`, func(t *testing.T) {
+		expected := []Finding{{
+			CVE:      "CVE-2019-10744",
+			Package:  "lodash",
+			Version:  "4.17.11",
+			FixedBy:  "4.17.12",
+			Severity: SeverityCritical,
+			Link:     "https://osv.dev/vulnerability/GHSA-jf85-cpcp-j695",
+			Title:    "Prototype Pollution in lodash",
+		}}
+
+		assert.Equal(t, expected, db.Match(EcosystemNPM, "lodash", "4.17.11"))
+		assert.Empty(t, db.Match(EcosystemNPM, "lodash", "4.17.12"))
+		assert.Empty(t, db.Match(EcosystemPyPI, "lodash", "4.17.11"))
+	})
+
+	t.Run(`Ensure ranges, listed versions and normalized names are matched`, func(t *testing.T) {
+		cases := map[string]bool{
+			"2.10":     true,
+			"2.10.1":   false,
+			"2.11.0b2": true,
+			"2.11.0":   true,
+			"2.11.1":   false,
+			"2.4.1":    true,
+		}
+
+		for version, affected := range cases {
+			findings := db.Match(EcosystemPyPI, "Jinja2", version)
+
+			if assert.Equal(t, affected, len(findings) == 1, version) && affected {
+				assert.Equal(t, "PYSEC-2019-217", findings[0].CVE)
+				assert.Equal(t, SeverityHigh, findings[0].Severity)
+			}
+		}
+	})
+}
+
+func TestCompareEcosystemVersions(t *testing.T) {
+	t.Run(`Ensure versions of every ecosystem are ordered`, func(t *testing.T) {
+		ordered := [][]string{
+			{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10.0"},
+			{"1.0.dev1", "1.0a1", "1.0b2", "1.0rc1", "1.0", "1.0.post1", "1.1"},
+			{"5.0.0.beta1", "5.0.0.rc1", "5.0.0", "5.0.0.1"},
+			{"2.0-SNAPSHOT", "2.0", "2.0.1.Final", "2.0.2"},
+			{"v0.3.7", "0.3.8", "v0.10.0"},
+		}
+
+		for _, versions := range ordered {
+			for i := 1; i < len(versions); i++ {
+				assert.True(t, compareEcosystemVersions(versions[i-1], versions[i]) < 0, "%s < %s", versions[i-1], versions[i])
+				assert.True(t, compareEcosystemVersions(versions[i], versions[i-1]) > 0, "%s > %s", versions[i], versions[i-1])
+			}
+		}
+
+		assert.Equal(t, 0, compareEcosystemVersions("1.0", "1.0.0"))
+		assert.Equal(t, 0, compareEcosystemVersions("v1.2.3+build.5", "1.2.3"))
+	})
+}
+
+func TestCVSS3Severity(t *testing.T) {
+	t.Run(`Ensure CVSS v3 vectors are rated by their base scores`, func(t *testing.T) {
+		cases := map[string]string{
+			"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": SeverityCritical, // 9.8
+			"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N": SeverityMedium,   // 6.1
+			"CVSS:3.0/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N": SeverityMedium,   // 5.5
+			"CVSS:3.1/AV:N/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N": SeverityLow,      // 2.0
+			"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N": SeverityNegligible,
+			"CVSS:3.1/AV:X": SeverityUnknown,
+		}
+
+		for vector, severity := range cases {
+			assert.Equal(t, severity, cvss3Severity(vector), vector)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+const (
+	bdbHashMagic        = 0x061561
+	bdbPageHeaderSize   = 26
+	bdbHashPageType     = 13
+	bdbHashUnsortedType = 2
+	bdbOverflowPageType = 7
+	bdbOffPageItemType  = 3
+
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagLicense = 1014
+
+	rpmInt32Type       = 4
+	rpmStringType      = 6
+	rpmStringArrayType = 8
+	rpmI18NStringType  = 9
+)
+
+var (
+	// errUnsupportedRPMDB indicates a rpm database which isn't on Berkeley DB
+	// format (e.g. SQLite, used since Fedora 33 and RHEL 9).
+	errUnsupportedRPMDB = errors.New(`unsupported rpm database format`)
+
+	errInvalidRPMDB = errors.New(`invalid rpm database`)
+)
+
+// rpmPackage is a package installed on the rpm database.
+type rpmPackage struct {
+	name    string
+	version string
+	license string
+}
+
+// readRPMDB lists the packages of a rpm database on Berkeley DB hash format
+// (i.e. /var/lib/rpm/Packages), whose values are rpm headers. Only headers
+// stored on overflow pages are read, as packages' headers never fit a page.
+func readRPMDB(data []byte) ([]rpmPackage, error) {
+
+	if len(data) < 72 {
+		return nil, errInvalidRPMDB
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+
+	switch {
+	case binary.LittleEndian.Uint32(data[12:]) == bdbHashMagic:
+	case binary.BigEndian.Uint32(data[12:]) == bdbHashMagic:
+		order = binary.BigEndian
+	default:
+		return nil, errUnsupportedRPMDB
+	}
+
+	pageSize := int(order.Uint32(data[20:]))
+	lastPage := int(order.Uint32(data[32:]))
+
+	// Page numbers are compared instead of offsets, which could overflow.
+	if pageSize < 512 || lastPage < 0 || lastPage >= len(data)/pageSize {
+		return nil, errInvalidRPMDB
+	}
+
+	page := func(number int) []byte {
+		return data[number*pageSize : (number+1)*pageSize]
+	}
+
+	var packages []rpmPackage
+
+	for number := 1; number <= lastPage; number++ {
+		current := page(number)
+
+		if pageType := current[25]; pageType != bdbHashPageType && pageType != bdbHashUnsortedType {
+			continue
+		}
+
+		entries := int(order.Uint16(current[20:]))
+
+		if bdbPageHeaderSize+2*entries > pageSize {
+			return nil, errInvalidRPMDB
+		}
+
+		// Entries alternate between keys and values, whose offsets follow
+		// the page's header.
+		for entry := 1; entry < entries; entry += 2 {
+			offset := int(order.Uint16(current[bdbPageHeaderSize+2*entry:]))
+
+			if offset+12 > pageSize || current[offset] != bdbOffPageItemType {
+				continue
+			}
+
+			value, err := readOverflowPages(data, pageSize, order.Uint32(current[offset+4:]), order)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if pkg, ok := parseRPMHeader(value); ok {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, nil
+}
+
+// readOverflowPages reads a value stored across a chain of overflow pages.
+func readOverflowPages(data []byte, pageSize int, number uint32, order binary.ByteOrder) ([]byte, error) {
+
+	var value bytes.Buffer
+
+	pages := uint32(len(data) / pageSize)
+
+	for visited := uint32(0); number != 0; visited++ {
+		if number >= pages || visited > pages {
+			return nil, errInvalidRPMDB
+		}
+
+		current := data[int(number)*pageSize : (int(number)+1)*pageSize]
+
+		if current[25] != bdbOverflowPageType {
+			return nil, errInvalidRPMDB
+		}
+
+		next := order.Uint32(current[16:])
+		end := pageSize
+
+		if next == 0 {
+			end = bdbPageHeaderSize + int(order.Uint16(current[22:]))
+		}
+
+		if end > pageSize {
+			return nil, errInvalidRPMDB
+		}
+
+		value.Write(current[bdbPageHeaderSize:end])
+
+		number = next
+	}
+
+	return value.Bytes(), nil
+}
+
+// parseRPMHeader reads the name, version and license of a package from its
+// rpm header (without the header's magic, as stored on rpm databases).
+func parseRPMHeader(header []byte) (rpmPackage, bool) {
+
+	if len(header) < 8 {
+		return rpmPackage{}, false
+	}
+
+	entries := int(binary.BigEndian.Uint32(header))
+	size := int(binary.BigEndian.Uint32(header[4:]))
+	store := 8 + 16*entries
+
+	if entries <= 0 || store+size > len(header) || store < 0 {
+		return rpmPackage{}, false
+	}
+
+	tags := make(map[int32]string)
+
+	for index := 0; index < entries; index++ {
+		entry := header[8+16*index:]
+
+		tag := int32(binary.BigEndian.Uint32(entry))
+		kind := binary.BigEndian.Uint32(entry[4:])
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:])))
+
+		if offset < 0 || offset >= size {
+			continue
+		}
+
+		value := header[store+offset : store+size]
+
+		switch kind {
+		case rpmStringType, rpmStringArrayType, rpmI18NStringType:
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				tags[tag] = string(value[:end])
+			}
+
+		case rpmInt32Type:
+			if len(value) >= 4 {
+				tags[tag] = strconv.Itoa(int(int32(binary.BigEndian.Uint32(value))))
+			}
+		}
+	}
+
+	pkg := rpmPackage{
+		name:    tags[rpmTagName],
+		version: tags[rpmTagVersion],
+		license: tags[rpmTagLicense],
+	}
+
+	if release := tags[rpmTagRelease]; release != "" {
+		pkg.version += "-" + release
+	}
+
+	if epoch := tags[rpmTagEpoch]; epoch != "" && epoch != "0" {
+		pkg.version = epoch + ":" + pkg.version
+	}
+
+	return pkg, pkg.name != ""
+}
@@ -0,0 +1,159 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rpmHeader encodes an rpm header (as stored on rpm databases) with string
+// tags, plus an epoch when non-zero.
+func rpmHeader(tags map[int32]string, epoch int32) []byte {
+
+	var index, store bytes.Buffer
+
+	entry := func(tag int32, kind uint32, count uint32) {
+		binary.Write(&index, binary.BigEndian, tag)
+		binary.Write(&index, binary.BigEndian, kind)
+		binary.Write(&index, binary.BigEndian, int32(store.Len()))
+		binary.Write(&index, binary.BigEndian, count)
+	}
+
+	if epoch != 0 {
+		entry(rpmTagEpoch, rpmInt32Type, 1)
+		binary.Write(&store, binary.BigEndian, epoch)
+	}
+
+	for _, tag := range []int32{rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagLicense} {
+		if value, ok := tags[tag]; ok {
+			entry(tag, rpmStringType, 1)
+			store.WriteString(value + "\x00")
+		}
+	}
+
+	var header bytes.Buffer
+
+	binary.Write(&header, binary.BigEndian, uint32(index.Len()/16))
+	binary.Write(&header, binary.BigEndian, uint32(store.Len()))
+	header.Write(index.Bytes())
+	header.Write(store.Bytes())
+
+	return header.Bytes()
+}
+
+// newTestRPMDB writes a Berkeley DB hash database (little endian, 512 bytes
+// pages) whose values are the given rpm headers, each one on an overflow page.
+func newTestRPMDB(headers ...[]byte) []byte {
+
+	const pageSize = 512
+
+	pages := make([][]byte, 2+len(headers))
+
+	for index := range pages {
+		pages[index] = make([]byte, pageSize)
+	}
+
+	meta := pages[0]
+	binary.LittleEndian.PutUint32(meta[12:], bdbHashMagic)
+	binary.LittleEndian.PutUint32(meta[20:], pageSize)
+	binary.LittleEndian.PutUint32(meta[32:], uint32(len(pages)-1))
+	meta[25] = 8
+
+	hash := pages[1]
+	hash[25] = bdbHashPageType
+	binary.LittleEndian.PutUint16(hash[20:], uint16(2*len(headers)))
+
+	offset := pageSize
+
+	for index, header := range headers {
+		overflow := pages[2+index]
+		overflow[25] = bdbOverflowPageType
+		binary.LittleEndian.PutUint16(overflow[22:], uint16(len(header)))
+		copy(overflow[bdbPageHeaderSize:], header)
+
+		// key: record number
+		offset -= 5
+		hash[offset] = 1
+		binary.LittleEndian.PutUint32(hash[offset+1:], uint32(index+1))
+		binary.LittleEndian.PutUint16(hash[bdbPageHeaderSize+4*index:], uint16(offset))
+
+		// value: reference to the overflow page
+		offset -= 12
+		hash[offset] = bdbOffPageItemType
+		binary.LittleEndian.PutUint32(hash[offset+4:], uint32(2+index))
+		binary.LittleEndian.PutUint32(hash[offset+8:], uint32(len(header)))
+		binary.LittleEndian.PutUint16(hash[bdbPageHeaderSize+4*index+2:], uint16(offset))
+	}
+
+	return bytes.Join(pages, nil)
+}
+
+func TestReadRPMDB(t *testing.T) {
+	t.Run(`Ensure packages are read from the headers on overflow pages`, func(t *testing.T) {
+		database := newTestRPMDB(
+			rpmHeader(map[int32]string{rpmTagName: "bash", rpmTagVersion: "4.4.20", rpmTagRelease: "4.el8", rpmTagLicense: "GPLv3+"}, 0),
+			rpmHeader(map[int32]string{rpmTagName: "openssl-libs", rpmTagVersion: "1.1.1k", rpmTagRelease: "9.el8", rpmTagLicense: "OpenSSL and ASL 2.0"}, 1),
+		)
+
+		packages, err := readRPMDB(database)
+
+		require.NoError(t, err)
+
+		expected := []rpmPackage{
+			{name: "bash", version: "4.4.20-4.el8", license: "GPLv3+"},
+			{name: "openssl-libs", version: "1:1.1.1k-9.el8", license: "OpenSSL and ASL 2.0"},
+		}
+
+		assert.Equal(t, expected, packages)
+	})
+
+	t.Run(`When database isn't on Berkeley DB format, should returns an unsupported error`, func(t *testing.T) {
+		database := append([]byte("SQLite format 3\x00"), make([]byte, 1024)...)
+
+		_, err := readRPMDB(database)
+
+		assert.Equal(t, errUnsupportedRPMDB, err)
+	})
+
+	t.Run(`When an overflow page is out of the database, should returns an invalid error`, func(t *testing.T) {
+		database := newTestRPMDB(rpmHeader(map[int32]string{rpmTagName: "bash"}, 0))
+
+		// the value of the first record is at the hash page (1) end, after its key
+		binary.LittleEndian.PutUint32(database[512+512-5-12+4:], 42)
+
+		_, err := readRPMDB(database)
+
+		assert.Equal(t, errInvalidRPMDB, err)
+	})
+
+	t.Run(`When database is malformed, should returns an invalid error instead of panicking`, func(t *testing.T) {
+		tests := map[string]func(database []byte){
+			"entries past the page end": func(database []byte) {
+				binary.LittleEndian.PutUint16(database[512+20:], 0xffff)
+			},
+			"last page overflowing the page offsets": func(database []byte) {
+				binary.LittleEndian.PutUint32(database[20:], 0xffffffff)
+				binary.LittleEndian.PutUint32(database[32:], 0xffffffff)
+			},
+			"overflow page number past the database": func(database []byte) {
+				binary.LittleEndian.PutUint32(database[512+512-5-12+4:], 0xffffffff)
+			},
+			"overflow page pointing to itself": func(database []byte) {
+				binary.LittleEndian.PutUint32(database[2*512+16:], 2)
+			},
+		}
+
+		for name, corrupt := range tests {
+			database := newTestRPMDB(rpmHeader(map[int32]string{rpmTagName: "bash"}, 0))
+			corrupt(database)
+
+			require.NotPanics(t, func() {
+				_, err := readRPMDB(database)
+				assert.Equal(t, errInvalidRPMDB, err, name)
+			}, name)
+		}
+	})
+}
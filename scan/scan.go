@@ -9,9 +9,21 @@ const (
 	// StatusAborted indicates scan was aborted.
 	StatusAborted = Status("aborted")
 
+	// StatusDeadLetter indicates scan kept failing after all attempts allowed
+	// and it's waiting to be inspected (and possibly re-driven).
+	StatusDeadLetter = Status("dead-letter")
+
+	// StatusFailed indicates scan was finished but no scanner could analyze
+	// the image.
+	StatusFailed = Status("failed")
+
 	// StatusFinished indicates scan was finished.
 	StatusFinished = Status("finished")
 
+	// StatusPartial indicates scan was finished but some scanners couldn't
+	// analyze the image.
+	StatusPartial = Status("partial")
+
 	// StatusRunning indicates scan is running.
 	StatusRunning = Status("running")
 
@@ -26,17 +38,78 @@ type Scan struct {
 	Image      string    `bson:"image,omitempty" json:"image"`
 	CreatedAt  time.Time `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
 	FinishedAt time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+	Attempts   int       `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	Lease      *Lease    `bson:"lease,omitempty" json:"lease,omitempty"`
 	Result     []Result  `bson:"result,omitempty" json:"result,omitempty"`
 }
 
+// Lease holds which worker is running a scan and until when that worker is
+// considered alive. Workers renew their leases periodically (heartbeats), so
+// an expired lease indicates the worker has gone away.
+type Lease struct {
+	Worker    string    `bson:"worker" json:"worker"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
+
 // Result holds an analysis result reported by a specific security scanner.
+// Vulnerabilities keeps the scanner's raw format, while Findings holds them
+// normalized. Digest is the image's manifest digest, when the scanner could
+// resolve it. Platform is the analyzed platform of a multi-platform image.
+// Layers describes the image's layers, which findings are attributed to.
+// Licenses lists the licenses of the image's packages (license scanner only).
 type Result struct {
-	Scanner         string      `bson:"scanner" json:"scanner"`
-	Vulnerabilities interface{} `bson:"vulnerabilities,omitempty" json:"vulnerabilities,omitempty"`
-	Error           string      `bson:"error,omitempty" json:"error,omitempty"`
+	Scanner         string           `bson:"scanner" json:"scanner"`
+	Platform        string           `bson:"platform,omitempty" json:"platform,omitempty"`
+	Digest          string           `bson:"digest,omitempty" json:"digest,omitempty"`
+	Vulnerabilities interface{}      `bson:"vulnerabilities,omitempty" json:"vulnerabilities,omitempty"`
+	Findings        []Finding        `bson:"findings,omitempty" json:"findings,omitempty"`
+	Layers          []Layer          `bson:"layers,omitempty" json:"layers,omitempty"`
+	Licenses        []PackageLicense `bson:"licenses,omitempty" json:"licenses,omitempty"`
+	Error           *Error           `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// IsTransient returns true when result has an error caused by a temporary
+// condition.
+func (r Result) IsTransient() bool {
+	return r.Error != nil && r.Error.Transient
+}
+
+// FinalStatus returns the status of a scan finished with given results:
+// StatusFailed when all results have errors, StatusPartial when only some of
+// them have, otherwise StatusFinished.
+func FinalStatus(results []Result) Status {
+
+	failures := 0
+
+	for _, result := range results {
+		if result.Error != nil {
+			failures++
+		}
+	}
+
+	switch {
+	case failures == 0:
+		return StatusFinished
+	case failures == len(results):
+		return StatusFailed
+	default:
+		return StatusPartial
+	}
 }
 
 // Scanner defines the actions about a common security scanner.
 type Scanner interface {
 	Scan(string) Result
 }
+
+// PlatformScanner is implemented by scanners able to analyze each platform of
+// multi-platform images, returning a result per platform.
+type PlatformScanner interface {
+	ScanPlatforms(string) []Result
+}
+
+// Pinger is implemented by scanners able to check whether the services they
+// depend on (e.g. a security engine API) are reachable.
+type Pinger interface {
+	Ping() error
+}
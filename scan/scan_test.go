@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinalStatus(t *testing.T) {
+	failed := Result{Scanner: "clair", Error: &Error{Code: ErrorCodeTimeout}}
+	succeeded := Result{Scanner: "clair"}
+
+	assert.Equal(t, StatusFinished, FinalStatus(nil))
+	assert.Equal(t, StatusFinished, FinalStatus([]Result{succeeded}))
+	assert.Equal(t, StatusPartial, FinalStatus([]Result{succeeded, failed}))
+	assert.Equal(t, StatusFailed, FinalStatus([]Result{failed, failed}))
+}
+
+func TestError_SetBSON(t *testing.T) {
+	t.Run(`When error is stored as a plain string, should keep it as message with unknown code`, func(t *testing.T) {
+		data, err := bson.Marshal(bson.M{"scanner": "clair", "error": "could not analyze that image on CoreOS Clair"})
+		require.NoError(t, err)
+
+		var result Result
+		require.NoError(t, bson.Unmarshal(data, &result))
+
+		assert.Equal(t, &Error{Code: ErrorCodeUnknown, Message: "could not analyze that image on CoreOS Clair"}, result.Error)
+	})
+
+	t.Run(`When error is stored as a document, should decode all its fields`, func(t *testing.T) {
+		expected := &Error{Code: ErrorCodeUnauthorized, Phase: PhaseAuth, Message: "Token request returned 401"}
+
+		data, err := bson.Marshal(Result{Scanner: "clair", Error: expected})
+		require.NoError(t, err)
+
+		var result Result
+		require.NoError(t, bson.Unmarshal(data, &result))
+
+		assert.Equal(t, expected, result.Error)
+	})
+}
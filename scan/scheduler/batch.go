@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/reference"
+	"github.com/tsuru/cst/scan"
+	"github.com/tsuru/cst/tracing"
+)
+
+// MaxBatchSize is how many images a single batch can hold.
+const MaxBatchSize = 1000
+
+var (
+	// ErrBatchTooLarge indicates a batch with more images than MaxBatchSize.
+	ErrBatchTooLarge = errors.New(`batch has too many images`)
+)
+
+// ScheduleBatch schedules the scans of several images (on their canonical
+// form) through scheduler, recording on storage what happened to each one: a
+// new scan was created, an already scheduled scan was reused (deduplicated),
+// the image is invalid or scheduling has failed.
+func ScheduleBatch(ctx context.Context, scheduler Scheduler, images []string) (scan.Batch, error) {
+
+	if len(images) > MaxBatchSize {
+		return scan.Batch{}, ErrBatchTooLarge
+	}
+
+	ctx, span := tracing.Start(ctx, "ScheduleBatch", tracing.SpanKindInternal)
+	defer span.End()
+
+	batch := scan.Batch{
+		ID:        uuid.NewV4().String(),
+		CreatedAt: time.Now(),
+		Items:     make([]scan.BatchItem, 0, len(images)),
+	}
+
+	span.SetAttribute("batch.id", batch.ID)
+	span.SetAttribute("batch.size", len(images))
+
+	scheduled := make(map[string]string)
+
+	for _, image := range images {
+		item := scan.BatchItem{Image: image}
+
+		canonical, err := reference.Canonicalize(image)
+
+		if err != nil {
+			item.Status = scan.BatchItemInvalid
+			item.Error = err.Error()
+
+			batch.Items = append(batch.Items, item)
+
+			continue
+		}
+
+		image = canonical
+		item.Image = canonical
+
+		if scanID, ok := scheduled[image]; ok {
+			item.Status = scan.BatchItemDeduplicated
+			item.ScanID = scanID
+
+			batch.Items = append(batch.Items, item)
+
+			continue
+		}
+
+		newScan, err := scheduler.Schedule(ctx, image)
+
+		switch err {
+		case nil:
+			item.Status = scan.BatchItemCreated
+			item.ScanID = newScan.ID
+		case ErrImageHasAlreadyBeenScheduled:
+			item.Status = scan.BatchItemDeduplicated
+			item.ScanID = scheduledScanID(image)
+		default:
+			item.Status = scan.BatchItemFailed
+			item.Error = err.Error()
+		}
+
+		if item.ScanID != "" {
+			scheduled[image] = item.ScanID
+		}
+
+		batch.Items = append(batch.Items, item)
+	}
+
+	if err := db.GetStorage().SaveBatch(batch); err != nil {
+		span.SetError(err)
+
+		return scan.Batch{}, err
+	}
+
+	return batch, nil
+}
+
+// scheduledScanID returns the identifier of the scheduled scan of image, if
+// any.
+func scheduledScanID(image string) string {
+
+	scans, err := db.GetStorage().GetScansByImage(image)
+
+	if err != nil {
+		return ""
+	}
+
+	for _, s := range scans {
+		if s.Status == scan.StatusScheduled {
+			return s.ID
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestScheduleBatch(t *testing.T) {
+	defer db.SetStorage(nil)
+
+	t.Run(`Ensure each image is reported as created, deduplicated, invalid or failed`, func(t *testing.T) {
+		var saved scan.Batch
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansByImage: func(image string) ([]scan.Scan, error) {
+				return []scan.Scan{
+					{ID: "83633447-353f-4e87-aa95-2a44205eb89e", Image: image, Status: scan.StatusFinished},
+					{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Image: image, Status: scan.StatusScheduled},
+				}, nil
+			},
+
+			MockSaveBatch: func(batch scan.Batch) error {
+				saved = batch
+
+				return nil
+			},
+		})
+
+		scheduler := &MockScheduler{
+			MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+				switch image {
+				case "docker.io/tsuru/api:latest":
+					return scan.Scan{}, ErrImageHasAlreadyBeenScheduled
+				case "docker.io/tsuru/broken:latest":
+					return scan.Scan{}, errors.New("just another error on storage")
+				default:
+					return scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95", Image: image}, nil
+				}
+			},
+		}
+
+		batch, err := ScheduleBatch(context.Background(), scheduler, []string{
+			"tsuru/cst:latest",
+			"tsuru/api:latest",
+			"Tsuru/CST latest",
+			"tsuru/broken:latest",
+			"docker.io/tsuru/cst",
+		})
+
+		require.NoError(t, err)
+		assert.NotEmpty(t, batch.ID)
+		assert.Equal(t, batch, saved)
+
+		expected := []scan.BatchItem{
+			{Image: "docker.io/tsuru/cst:latest", Status: scan.BatchItemCreated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+			{Image: "docker.io/tsuru/api:latest", Status: scan.BatchItemDeduplicated, ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf"},
+			{Image: "Tsuru/CST latest", Status: scan.BatchItemInvalid, Error: "invalid reference format"},
+			{Image: "docker.io/tsuru/broken:latest", Status: scan.BatchItemFailed, Error: "just another error on storage"},
+			{Image: "docker.io/tsuru/cst:latest", Status: scan.BatchItemDeduplicated, ScanID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"},
+		}
+
+		assert.Equal(t, expected, batch.Items)
+	})
+
+	t.Run(`When batch has too many images, should return ErrBatchTooLarge error`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{})
+
+		_, err := ScheduleBatch(context.Background(), &MockScheduler{}, make([]string, MaxBatchSize+1))
+
+		assert.Equal(t, ErrBatchTooLarge, err)
+	})
+
+	t.Run(`When storage can't save the batch, should return its error`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockSaveBatch: func(scan.Batch) error {
+				return errors.New("just another error on storage")
+			},
+		})
+
+		_, err := ScheduleBatch(context.Background(), &MockScheduler{}, []string{"tsuru/cst:latest"})
+
+		assert.Error(t, err)
+	})
+}
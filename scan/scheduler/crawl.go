@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/registry"
+	"github.com/tsuru/cst/scan"
+)
+
+// ScheduleBatches schedules the scans of images through scheduler, in as many
+// batches as needed to respect MaxBatchSize.
+func ScheduleBatches(ctx context.Context, scheduler Scheduler, images []string) ([]scan.Batch, error) {
+
+	var batches []scan.Batch
+
+	for start := 0; start < len(images); start += MaxBatchSize {
+		end := start + MaxBatchSize
+
+		if end > len(images) {
+			end = len(images)
+		}
+
+		batch, err := ScheduleBatch(ctx, scheduler, images[start:end])
+
+		if err != nil {
+			return batches, err
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
+
+// CrawlJob periodically crawls registries looking for images to be scanned,
+// e.g. to scan every new tag pushed to "registry.tld/team/*".
+type CrawlJob struct {
+	Crawler   *registry.Crawler
+	Scheduler Scheduler
+	Specs     []registry.CrawlSpec
+	Interval  time.Duration
+}
+
+// Run crawls the specs at each interval until stop is closed.
+func (cj *CrawlJob) Run(stop <-chan struct{}) {
+
+	ticker := time.NewTicker(cj.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			cj.Crawl()
+		}
+	}
+}
+
+// Crawl schedules the scans of the images found by each spec.
+func (cj *CrawlJob) Crawl() {
+
+	for _, spec := range cj.Specs {
+		log := logrus.WithField("crawl.target", spec.Target)
+
+		images, err := cj.Crawler.Crawl(spec)
+
+		if err != nil {
+			log.WithError(err).Error("could not crawl registry")
+			continue
+		}
+
+		batches, err := ScheduleBatches(context.Background(), cj.Scheduler, images)
+
+		if err != nil {
+			log.WithError(err).Error("could not schedule the scans of crawled images")
+		}
+
+		for _, batch := range batches {
+			log.
+				WithField("batch.id", batch.ID).
+				WithField("batch.size", len(batch.Items)).
+				Info("scheduled scans of crawled images")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/registry"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestCrawlJob_Crawl(t *testing.T) {
+	defer db.SetStorage(nil)
+
+	t.Run(`Ensure images found on registry are scheduled in a batch`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/_catalog":
+				json.NewEncoder(w).Encode(map[string][]string{"repositories": {"team/api", "other/api"}})
+			case "/v2/team/api/tags/list":
+				json.NewEncoder(w).Encode(map[string][]string{"tags": {"v1", "v2"}})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		defer server.Close()
+
+		var batches []scan.Batch
+
+		db.SetStorage(&db.MockStorage{
+			MockSaveBatch: func(batch scan.Batch) error {
+				batches = append(batches, batch)
+
+				return nil
+			},
+		})
+
+		var scheduled []string
+
+		job := &CrawlJob{
+			Crawler: &registry.Crawler{},
+			Scheduler: &MockScheduler{
+				MockSchedule: func(ctx context.Context, image string) (scan.Scan, error) {
+					scheduled = append(scheduled, image)
+
+					return scan.Scan{ID: "2b935a8f-4241-49f0-a1a2-e3c8ba347b95"}, nil
+				},
+			},
+			Specs: []registry.CrawlSpec{
+				{Target: server.URL + "/team/*"},
+			},
+		}
+
+		job.Crawl()
+
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		assert.Equal(t, []string{host + "/team/api:v2", host + "/team/api:v1"}, scheduled)
+		require.Len(t, batches, 1)
+		assert.Len(t, batches[0].Items, 2)
+	})
+}
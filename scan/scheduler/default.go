@@ -1,14 +1,15 @@
 package scheduler
 
 import (
+	"context"
 	"time"
 
-	"github.com/tsuru/monsterqueue"
-
 	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/queue"
 	"github.com/tsuru/cst/scan"
+	"github.com/tsuru/cst/tracing"
 )
 
 // DefaultScheduler implements a Scheduler interface.
@@ -16,8 +17,14 @@ type DefaultScheduler struct{}
 
 // Schedule registers a new analysis of a given image. It returns the complete
 // entry of scan if successful else retuns an error instance to indicate the
-// wrong state.
-func (ds *DefaultScheduler) Schedule(image string) (scan.Scan, error) {
+// wrong state. A scan whose job couldn't be enqueued is moved to dead-letter
+// status, so it doesn't block new scans of the same image.
+func (ds *DefaultScheduler) Schedule(ctx context.Context, image string) (scan.Scan, error) {
+
+	ctx, span := tracing.Start(ctx, "DefaultScheduler.Schedule", tracing.SpanKindInternal)
+	defer span.End()
+
+	span.SetAttribute("image", image)
 
 	storage := db.GetStorage()
 
@@ -33,23 +40,106 @@ func (ds *DefaultScheduler) Schedule(image string) (scan.Scan, error) {
 		Result:    []scan.Result{},
 	}
 
+	span.SetAttribute("scan.id", newScan.ID)
+
 	if err := storage.Save(newScan); err != nil {
+		span.SetError(err)
+
 		return scan.Scan{}, err
 	}
 
-	enqueueScan(newScan)
+	if err := enqueueScan(ctx, newScan); err != nil {
+		deadLetter(newScan.ID, err)
+
+		return scan.Scan{}, err
+	}
 
 	return newScan, nil
 }
 
-func enqueueScan(scan scan.Scan) {
+// Redrive schedules again a scan (by its id) which is in dead-letter status,
+// discarding its previous results and attempts. When its job couldn't be
+// enqueued, the scan is put back into dead-letter status.
+func (ds *DefaultScheduler) Redrive(ctx context.Context, id string) (scan.Scan, error) {
+
+	ctx, span := tracing.Start(ctx, "DefaultScheduler.Redrive", tracing.SpanKindInternal)
+	defer span.End()
+
+	span.SetAttribute("scan.id", id)
+
+	storage := db.GetStorage()
+
+	deadScan, err := storage.GetScanByID(id)
 
-	q := queue.GetQueue()
+	if err != nil {
+		return scan.Scan{}, err
+	}
 
-	params := monsterqueue.JobParams{
-		"id":    scan.ID,
-		"image": scan.Image,
+	if deadScan.Status != scan.StatusDeadLetter {
+		return scan.Scan{}, ErrScanIsNotDeadLetter
 	}
 
-	q.Enqueue(queue.ScanTaskName, params)
+	if err := storage.RescheduleScanByID(id, 0); err != nil {
+		return scan.Scan{}, err
+	}
+
+	deadScan.Status = scan.StatusScheduled
+	deadScan.Attempts = 0
+	deadScan.FinishedAt = time.Time{}
+	deadScan.Result = []scan.Result{}
+
+	if err := enqueueScan(ctx, deadScan); err != nil {
+		deadLetter(id, err)
+
+		return scan.Scan{}, err
+	}
+
+	return deadScan, nil
+}
+
+// enqueueScan publishes a job to analyze scan, carrying the trace context on
+// job's metadata so the worker continues the same trace.
+func enqueueScan(ctx context.Context, scan scan.Scan) error {
+
+	ctx, span := tracing.Start(ctx, "queue.Enqueue", tracing.SpanKindProducer)
+	defer span.End()
+
+	span.SetAttribute("scan.id", scan.ID)
+
+	job := queue.Job{
+		ScanID:   scan.ID,
+		Image:    scan.Image,
+		Metadata: map[string]string{},
+	}
+
+	tracing.Inject(ctx, job.Metadata)
+
+	id, err := queue.GetQueue().Enqueue(job)
+
+	if err != nil {
+		span.SetError(err)
+
+		return err
+	}
+
+	span.SetAttribute("job.id", id)
+
+	return nil
+}
+
+// deadLetter moves a scan whose job couldn't be enqueued to dead-letter
+// status, rather than leaving it scheduled without a job to run it.
+func deadLetter(id string, reason error) {
+
+	log := logrus.
+		WithField("scan.id", id).
+		WithError(reason)
+
+	log.Error("could not enqueue scan, moving it to dead-letter")
+
+	now := time.Now()
+
+	if err := db.GetStorage().UpdateScanByID(id, scan.StatusDeadLetter, &now); err != nil {
+		log.WithError(err).Error("could not update scan's status on storage")
+	}
 }
@@ -1,15 +1,17 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tsuru/cst/db"
 	"github.com/tsuru/cst/queue"
 	"github.com/tsuru/cst/scan"
-	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/cst/tracing"
 )
 
 func TestDefaultScheduler_Schedule(t *testing.T) {
@@ -30,7 +32,7 @@ func TestDefaultScheduler_Schedule(t *testing.T) {
 		db.SetStorage(storage)
 
 		ds := &DefaultScheduler{}
-		_, err := ds.Schedule("tsuru/cst:latest")
+		_, err := ds.Schedule(context.Background(), "tsuru/cst:latest")
 
 		require.Error(t, err)
 		assert.Equal(t, ErrImageHasAlreadyBeenScheduled, err)
@@ -49,7 +51,7 @@ func TestDefaultScheduler_Schedule(t *testing.T) {
 
 		ds := &DefaultScheduler{}
 
-		newScan, err := ds.Schedule("tsuru/cst:latest")
+		newScan, err := ds.Schedule(context.Background(), "tsuru/cst:latest")
 
 		require.NoError(t, err)
 		assert.NotEmpty(t, newScan.ID)
@@ -74,22 +76,53 @@ func TestDefaultScheduler_Schedule(t *testing.T) {
 
 		ds := &DefaultScheduler{}
 
-		_, err := ds.Schedule("tsuru/cst:latest")
+		_, err := ds.Schedule(context.Background(), "tsuru/cst:latest")
 
 		require.Error(t, err)
 	})
 
+	t.Run(`When queue returns error on Enqueue method, should move the scan to dead-letter and return the error`, func(t *testing.T) {
+		enqueueErr := errors.New("just another error on queue")
+
+		queue.SetQueue(&queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				return "", enqueueErr
+			},
+		})
+
+		var gotID string
+		var gotStatus scan.Status
+
+		db.SetStorage(&db.MockStorage{
+			MockHasScheduledScanByImage: func(img string) bool {
+				return false
+			},
+
+			MockUpdateScanByID: func(id string, status scan.Status, finishedAt *time.Time) error {
+				gotID, gotStatus = id, status
+
+				return nil
+			},
+		})
+
+		ds := &DefaultScheduler{}
+
+		_, err := ds.Schedule(context.Background(), "tsuru/cst:latest")
+
+		assert.Equal(t, enqueueErr, err)
+		assert.NotEmpty(t, gotID)
+		assert.Equal(t, scan.StatusDeadLetter, gotStatus)
+	})
+
 	t.Run(`Ensure queue.Enqueue is called with expected params`, func(t *testing.T) {
-		gotTaskName := ""
-		gotParams := monsterqueue.JobParams{}
+		gotJob := queue.Job{}
 
 		q := &queue.MockQueue{
-			MockEnqueue: func(task string, params monsterqueue.JobParams) (monsterqueue.Job, error) {
+			MockEnqueue: func(job queue.Job) (string, error) {
 
-				gotTaskName = task
-				gotParams = params
+				gotJob = job
 
-				return nil, nil
+				return "5b9f9c1e0c9d440001a1b2c3", nil
 			},
 		}
 
@@ -100,10 +133,138 @@ func TestDefaultScheduler_Schedule(t *testing.T) {
 			Image: "tsuru/cst:latest",
 		}
 
-		enqueueScan(newScan)
+		require.NoError(t, enqueueScan(context.Background(), newScan))
+
+		assert.Equal(t, newScan.ID, gotJob.ScanID)
+		assert.Equal(t, newScan.Image, gotJob.Image)
+	})
+
+	t.Run(`Ensure trace context is carried on job's metadata`, func(t *testing.T) {
+		gotJob := queue.Job{}
+
+		queue.SetQueue(&queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				gotJob = job
+
+				return "5b9f9c1e0c9d440001a1b2c3", nil
+			},
+		})
+
+		ctx, span := tracing.Start(context.Background(), "POST /v1/scan", tracing.SpanKindServer)
+
+		enqueueScan(ctx, scan.Scan{ID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", Image: "tsuru/cst:latest"})
+
+		assert.Contains(t, gotJob.Metadata[tracing.TraceParentKey], "-"+span.SpanContext().TraceID.String()+"-")
+	})
+}
+
+func TestDefaultScheduler_Redrive(t *testing.T) {
+	defer func() {
+		db.SetStorage(nil)
+		queue.SetQueue(nil)
+	}()
+
+	t.Run(`When scan is not in dead-letter status, should return ErrScanIsNotDeadLetter error`, func(t *testing.T) {
+		queue.SetQueue(&queue.MockQueue{})
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{ID: id, Status: scan.StatusFinished}, nil
+			},
+		})
+
+		ds := &DefaultScheduler{}
+		_, err := ds.Redrive(context.Background(), "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf")
+
+		assert.Equal(t, ErrScanIsNotDeadLetter, err)
+	})
+
+	t.Run(`When scan does not exist, should return the storage error`, func(t *testing.T) {
+		queue.SetQueue(&queue.MockQueue{})
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{}, db.ErrScanNotFound
+			},
+		})
+
+		ds := &DefaultScheduler{}
+		_, err := ds.Redrive(context.Background(), "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf")
+
+		assert.Equal(t, db.ErrScanNotFound, err)
+	})
+
+	t.Run(`When scan is in dead-letter status, should reset its attempts and enqueue it again`, func(t *testing.T) {
+		gotJob := queue.Job{}
+		gotAttempts := -1
+
+		queue.SetQueue(&queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				gotJob = job
+
+				return "5b9f9c1e0c9d440001a1b2c3", nil
+			},
+		})
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{
+					ID:       id,
+					Image:    "tsuru/cst:latest",
+					Status:   scan.StatusDeadLetter,
+					Attempts: 5,
+				}, nil
+			},
+
+			MockRescheduleScanByID: func(id string, attempts int) error {
+				gotAttempts = attempts
+
+				return nil
+			},
+		})
+
+		ds := &DefaultScheduler{}
+		redriven, err := ds.Redrive(context.Background(), "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf")
+
+		require.NoError(t, err)
+		assert.Equal(t, scan.StatusScheduled, redriven.Status)
+		assert.Equal(t, 0, gotAttempts)
+		assert.Equal(t, "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", gotJob.ScanID)
+		assert.Equal(t, "tsuru/cst:latest", gotJob.Image)
+	})
+	t.Run(`When queue returns error on Enqueue method, should put the scan back into dead-letter and return the error`, func(t *testing.T) {
+		enqueueErr := errors.New("just another error on queue")
+
+		queue.SetQueue(&queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				return "", enqueueErr
+			},
+		})
+
+		var gotStatuses []scan.Status
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScanByID: func(id string) (scan.Scan, error) {
+				return scan.Scan{ID: id, Image: "tsuru/cst:latest", Status: scan.StatusDeadLetter}, nil
+			},
+
+			MockRescheduleScanByID: func(id string, attempts int) error {
+				gotStatuses = append(gotStatuses, scan.StatusScheduled)
+
+				return nil
+			},
+
+			MockUpdateScanByID: func(id string, status scan.Status, finishedAt *time.Time) error {
+				gotStatuses = append(gotStatuses, status)
+
+				return nil
+			},
+		})
+
+		ds := &DefaultScheduler{}
+		_, err := ds.Redrive(context.Background(), "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf")
 
-		assert.Equal(t, queue.ScanTaskName, gotTaskName)
-		assert.Equal(t, newScan.ID, gotParams["id"])
-		assert.Equal(t, newScan.Image, gotParams["image"])
+		assert.Equal(t, enqueueErr, err)
+		assert.Equal(t, []scan.Status{scan.StatusScheduled, scan.StatusDeadLetter}, gotStatuses)
 	})
 }
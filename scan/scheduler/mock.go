@@ -1,17 +1,32 @@
 package scheduler
 
-import "github.com/tsuru/cst/scan"
+import (
+	"context"
+
+	"github.com/tsuru/cst/scan"
+)
 
 // MockScheduler implements a Scheduler interface for testing purposes.
 type MockScheduler struct {
-	MockSchedule func(string) (scan.Scan, error)
+	MockRedrive  func(context.Context, string) (scan.Scan, error)
+	MockSchedule func(context.Context, string) (scan.Scan, error)
+}
+
+// Redrive is a mock implementation for testing purposes.
+func (ms *MockScheduler) Redrive(ctx context.Context, id string) (scan.Scan, error) {
+
+	if ms.MockRedrive != nil {
+		return ms.MockRedrive(ctx, id)
+	}
+
+	return scan.Scan{}, nil
 }
 
 // Schedule is a mock implementation for testing purposes.
-func (ms *MockScheduler) Schedule(image string) (scan.Scan, error) {
+func (ms *MockScheduler) Schedule(ctx context.Context, image string) (scan.Scan, error) {
 
 	if ms.MockSchedule != nil {
-		return ms.MockSchedule(image)
+		return ms.MockSchedule(ctx, image)
 	}
 
 	return scan.Scan{}, nil
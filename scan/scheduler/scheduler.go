@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
 
 	"github.com/tsuru/cst/scan"
@@ -10,9 +11,15 @@ var (
 	// ErrImageHasAlreadyBeenScheduled indicates that current image couldn't be
 	// scheduled because it's in a queue to be processed yet.
 	ErrImageHasAlreadyBeenScheduled = errors.New(`this image has already been scheduled for scanning`)
+
+	// ErrScanIsNotDeadLetter indicates that current scan couldn't be
+	// re-driven because it isn't in the dead-letter status.
+	ErrScanIsNotDeadLetter = errors.New(`this scan is not in dead-letter status`)
 )
 
-// Scheduler is a basic interface to scheduling scans.
+// Scheduler is a basic interface to scheduling scans. The context carries the
+// trace of the request which asked for scheduling.
 type Scheduler interface {
-	Schedule(string) (scan.Scan, error)
+	Redrive(context.Context, string) (scan.Scan, error)
+	Schedule(context.Context, string) (scan.Scan, error)
 }
@@ -0,0 +1,407 @@
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/optiopay/klar/docker"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultSecretMaxFileSize is the size of the largest file searched for
+// secrets when SecretScanner.MaxFileSize is zero.
+const defaultSecretMaxFileSize = 10 << 20
+
+// SecretRule describes a kind of secret. Regex matches the secret on file
+// contents (only its "secret" named group, when it has one, is taken as the
+// secret); Path restricts the rule to files whose path matches it. Rules
+// without Regex flag the matching files themselves (e.g. ".env" files).
+// Secrets whose Shannon entropy is lower than MinEntropy are ignored.
+type SecretRule struct {
+	ID          string  `yaml:"id"`
+	Description string  `yaml:"description"`
+	Severity    string  `yaml:"severity"`
+	Regex       string  `yaml:"regex"`
+	Path        string  `yaml:"path"`
+	MinEntropy  float64 `yaml:"minEntropy"`
+}
+
+// SecretAllowlist holds regexps of file paths and secrets not reported.
+type SecretAllowlist struct {
+	Paths   []string `yaml:"paths"`
+	Secrets []string `yaml:"secrets"`
+}
+
+// SecretConfig is the rule set used to search for secrets. Rules and
+// Allowlist are added to the default ones (see DefaultSecretConfig), unless
+// DisableDefaults is set.
+type SecretConfig struct {
+	Rules           []SecretRule    `yaml:"rules"`
+	Allowlist       SecretAllowlist `yaml:"allowlist"`
+	DisableDefaults bool            `yaml:"disableDefaults"`
+}
+
+// DefaultSecretConfig returns the default rules to search for secrets.
+func DefaultSecretConfig() SecretConfig {
+	return SecretConfig{
+		Rules: []SecretRule{
+			{
+				ID:          "private-key",
+				Description: "Private key",
+				Severity:    SeverityCritical,
+				Regex:       `-----BEGIN[ A-Z0-9_-]*PRIVATE KEY( BLOCK)?-----\s*(?P<secret>[A-Za-z0-9+/=]{16,})`,
+			},
+			{
+				ID:          "aws-access-key-id",
+				Description: "AWS access key ID",
+				Severity:    SeverityCritical,
+				Regex:       `\b(?P<secret>(A3T[A-Z0-9]|AKIA|ASIA)[A-Z0-9]{16})\b`,
+			},
+			{
+				ID:          "aws-secret-access-key",
+				Description: "AWS secret access key",
+				Severity:    SeverityCritical,
+				Regex:       `(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})\b`,
+			},
+			{
+				ID:          "gcp-service-account",
+				Description: "Google Cloud service account key",
+				Severity:    SeverityCritical,
+				Regex:       `"private_key_id"\s*:\s*"(?P<secret>[a-f0-9]{40})"`,
+			},
+			{
+				ID:          "github-token",
+				Description: "GitHub token",
+				Severity:    SeverityCritical,
+				Regex:       `\b(?P<secret>gh[pousr]_[A-Za-z0-9]{36,255})\b`,
+			},
+			{
+				ID:          "slack-token",
+				Description: "Slack token",
+				Severity:    SeverityHigh,
+				Regex:       `\b(?P<secret>xox[baprs]-[0-9A-Za-z-]{10,72})\b`,
+			},
+			{
+				ID:          "dotenv-file",
+				Description: "Environment file (.env)",
+				Severity:    SeverityMedium,
+				Path:        `(^|/)\.env(\.[a-z]+)?$`,
+			},
+			{
+				ID:          "generic-secret",
+				Description: "High entropy secret assigned to a password, token or key",
+				Severity:    SeverityHigh,
+				Regex:       `(?i)(password|passwd|secret|token|api_?key|access_?key)["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=_.-]{16,})`,
+				MinEntropy:  4,
+			},
+		},
+		Allowlist: SecretAllowlist{
+			Paths: []string{
+				`^usr/share/(doc|man|locale|ca-certificates)/`,
+				`^etc/ssl/certs/`,
+				`(^|/)node_modules/.+/(tests?|fixtures?|examples?)/`,
+				`(^|/)(site|dist)-packages/.+/(tests?|fixtures?|examples?)/`,
+			},
+			Secrets: []string{
+				`(?i)example`,
+				`(?i)^(dummy|placeholder|changeme|x+|\*+)$`,
+			},
+		},
+	}
+}
+
+// LoadSecretConfig reads a rule set from a YAML file, e.g.:
+//
+//	rules:
+//	- id: internal-token
+//	  description: Internal API token
+//	  severity: high
+//	  regex: '\bitk_(?P<secret>[a-z0-9]{32})\b'
+//	allowlist:
+//	  paths: ['^opt/app/testdata/']
+func LoadSecretConfig(file string) (SecretConfig, error) {
+
+	var config SecretConfig
+
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return config, err
+	}
+
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, err
+	}
+
+	_, err = compileSecretConfig(config)
+
+	return config, err
+}
+
+// SecretScanner implements the Scanner and PlatformScanner interfaces. It
+// searches for secrets (private keys, access keys, tokens...) on the files of
+// every image layer, following Config. Files larger than MaxFileSize are
+// skipped.
+type SecretScanner struct {
+	Name        string
+	Source      Source
+	Config      SecretConfig
+	MaxFileSize int64
+	Timeout     time.Duration
+}
+
+// Scan searches for secrets on a container image. Only the first platform of
+// multi-platform images is analyzed (see ScanPlatforms).
+func (ss *SecretScanner) Scan(image string) Result {
+	return ss.ScanPlatforms(image)[0]
+}
+
+// ScanPlatforms searches for secrets on every platform of a container image,
+// returning a result per platform.
+func (ss *SecretScanner) ScanPlatforms(image string) []Result {
+	return scanLayers(ss.Name, ss.Source, image, ss.analyze)
+}
+
+func (ss *SecretScanner) analyze(dockerImage *docker.Image) (Result, error) {
+
+	matcher, err := compileSecretConfig(ss.Config)
+
+	if err != nil {
+		return Result{}, &Error{Code: ErrorCodeInternal, Phase: PhaseAnalyze, Message: err.Error()}
+	}
+
+	maxFileSize := ss.MaxFileSize
+
+	if maxFileSize <= 0 {
+		maxFileSize = defaultSecretMaxFileSize
+	}
+
+	var findings []Finding
+
+	err = walkLayers(&http.Client{Timeout: ss.Timeout}, dockerImage, func(entry LayerEntry) error {
+
+		if entry.Header.Typeflag != tar.TypeReg || isWhiteout(entry.Path) || matcher.allowedPath(entry.Path) {
+			return nil
+		}
+
+		var content []byte
+
+		if entry.Header.Size <= maxFileSize {
+			data, err := ioutil.ReadAll(io.LimitReader(entry.Content, maxFileSize))
+
+			if err != nil {
+				return err
+			}
+
+			content = data
+		}
+
+		for _, secret := range matcher.match(entry.Path, content) {
+			findings = append(findings, Finding{
+				Scanner:  ss.Name,
+				Rule:     secret.rule.ID,
+				Title:    secret.rule.Description,
+				Severity: secret.rule.Severity,
+				Path:     entry.Path,
+				Line:     secret.line,
+				Evidence: secret.evidence,
+				Layer:    entry.Layer,
+			})
+		}
+
+		return nil
+	})
+
+	return Result{Findings: findings}, err
+}
+
+type secretMatcher struct {
+	rules          []compiledSecretRule
+	allowedPaths   []*regexp.Regexp
+	allowedSecrets []*regexp.Regexp
+}
+
+type compiledSecretRule struct {
+	SecretRule
+	regex *regexp.Regexp
+	path  *regexp.Regexp
+}
+
+type secretMatch struct {
+	rule     SecretRule
+	line     int
+	evidence string
+}
+
+func compileSecretConfig(config SecretConfig) (*secretMatcher, error) {
+
+	if !config.DisableDefaults {
+		defaults := DefaultSecretConfig()
+
+		config.Rules = append(defaults.Rules, config.Rules...)
+		config.Allowlist.Paths = append(defaults.Allowlist.Paths, config.Allowlist.Paths...)
+		config.Allowlist.Secrets = append(defaults.Allowlist.Secrets, config.Allowlist.Secrets...)
+	}
+
+	matcher := &secretMatcher{}
+
+	for _, rule := range config.Rules {
+		if rule.ID == "" || (rule.Regex == "" && rule.Path == "") {
+			return nil, fmt.Errorf("secret rule %q must have an ID and a regex or path", rule.ID)
+		}
+
+		compiled := compiledSecretRule{SecretRule: rule}
+
+		var err error
+
+		if rule.Regex != "" {
+			if compiled.regex, err = regexp.Compile(rule.Regex); err != nil {
+				return nil, fmt.Errorf("invalid regex of secret rule %q: %s", rule.ID, err)
+			}
+		}
+
+		if rule.Path != "" {
+			if compiled.path, err = regexp.Compile(rule.Path); err != nil {
+				return nil, fmt.Errorf("invalid path of secret rule %q: %s", rule.ID, err)
+			}
+		}
+
+		matcher.rules = append(matcher.rules, compiled)
+	}
+
+	for _, pattern := range config.Allowlist.Paths {
+		regex, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlisted path %q: %s", pattern, err)
+		}
+
+		matcher.allowedPaths = append(matcher.allowedPaths, regex)
+	}
+
+	for _, pattern := range config.Allowlist.Secrets {
+		regex, err := regexp.Compile(pattern)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlisted secret %q: %s", pattern, err)
+		}
+
+		matcher.allowedSecrets = append(matcher.allowedSecrets, regex)
+	}
+
+	return matcher, nil
+}
+
+func (sm *secretMatcher) allowedPath(name string) bool {
+	return matchAny(sm.allowedPaths, name)
+}
+
+// match searches for secrets on a file. Binary files are only matched by
+// path.
+func (sm *secretMatcher) match(name string, content []byte) []secretMatch {
+
+	var matches []secretMatch
+
+	binary := bytes.IndexByte(content[:minInt(len(content), 8000)], 0) >= 0
+
+	for _, rule := range sm.rules {
+		if rule.path != nil && !rule.path.MatchString(name) {
+			continue
+		}
+
+		if rule.regex == nil {
+			matches = append(matches, secretMatch{rule: rule.SecretRule})
+			continue
+		}
+
+		if binary {
+			continue
+		}
+
+		for _, location := range rule.regex.FindAllSubmatchIndex(content, -1) {
+			start, end := location[0], location[1]
+
+			if group := rule.regex.SubexpIndex("secret"); group > 0 && location[2*group] >= 0 {
+				start, end = location[2*group], location[2*group+1]
+			}
+
+			secret := string(content[start:end])
+
+			if shannonEntropy(secret) < rule.MinEntropy || matchAny(sm.allowedSecrets, secret) {
+				continue
+			}
+
+			evidence := string(content[location[0]:start]) + redact(secret) + string(content[end:location[1]])
+
+			matches = append(matches, secretMatch{
+				rule:     rule.SecretRule,
+				line:     bytes.Count(content[:location[0]], []byte("\n")) + 1,
+				evidence: strings.Join(strings.Fields(evidence), " "),
+			})
+		}
+	}
+
+	return matches
+}
+
+// redact keeps only the first characters of a secret, hiding its length.
+func redact(secret string) string {
+
+	if len(secret) <= 8 {
+		return "****"
+	}
+
+	return secret[:4] + "****"
+}
+
+// shannonEntropy measures the randomness of s, in bits per character.
+func shannonEntropy(s string) float64 {
+
+	if s == "" {
+		return 0
+	}
+
+	frequencies := make(map[rune]float64)
+
+	for _, r := range s {
+		frequencies[r]++
+	}
+
+	entropy := 0.0
+	length := float64(len([]rune(s)))
+
+	for _, count := range frequencies {
+		probability := count / length
+		entropy -= probability * math.Log2(probability)
+	}
+
+	return entropy
+}
+
+func matchAny(regexps []*regexp.Regexp, s string) bool {
+
+	for _, regex := range regexps {
+		if regex.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func minInt(a, b int) int {
+
+	if a < b {
+		return a
+	}
+
+	return b
+}
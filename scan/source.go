@@ -0,0 +1,255 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/tsuru/cst/reference"
+)
+
+const (
+	manifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	imageIndexMediaType   = "application/vnd.oci.image.index.v1+json"
+)
+
+// PlatformImage is the manifest of an image built for a given platform (e.g.
+// "linux/arm64/v8"). Platform is empty when the image isn't multi-platform.
+type PlatformImage struct {
+	Platform string
+	*docker.Image
+}
+
+// Source fetches the manifests of an image, returning them with the layers
+// ready to be pushed to a security engine (e.g. CoreOS Clair). Multi-platform
+// images have a manifest per platform.
+type Source interface {
+	Fetch(image string) ([]PlatformImage, error)
+}
+
+// RegistrySource pulls the images' manifests from their registries. When an
+// image's tag points to a manifest list (or OCI image index), the manifest of
+// every platform is pulled, unless Platforms restricts them (e.g.
+// "linux/amd64" or "linux/arm64").
+type RegistrySource struct {
+	Platforms []string
+
+	// InsecureRegistries lists the registries (e.g. "registry.tld:5000")
+	// pulled over plain HTTP rather than HTTPS.
+	InsecureRegistries []string
+}
+
+// Fetch pulls the manifests of image from its registry.
+func (rs *RegistrySource) Fetch(image string) ([]PlatformImage, error) {
+
+	dockerImage, err := rs.pull(image, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	platforms, err := fetchManifestList(dockerImage)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(platforms) == 0 {
+		return []PlatformImage{{Image: dockerImage}}, nil
+	}
+
+	var images []PlatformImage
+
+	for _, platform := range platforms {
+		if !MatchPlatform(platform.Platform, rs.Platforms) {
+			continue
+		}
+
+		platformImage, err := rs.pull(image, platform.Digest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, PlatformImage{
+			Platform: platform.Platform,
+			Image:    platformImage,
+		})
+	}
+
+	if len(images) == 0 {
+		return nil, &Error{
+			Code:    ErrorCodeImageNotFound,
+			Message: fmt.Sprintf("image has none of the platforms: %s", strings.Join(rs.Platforms, ", ")),
+		}
+	}
+
+	return images, nil
+}
+
+// pull fetches the manifest of image (or of a given manifest digest of that
+// image's repository).
+func (rs *RegistrySource) pull(image, digest string) (*docker.Image, error) {
+
+	dockerImage, err := docker.NewImage(&docker.Config{
+		ImageName:        klarImageName(image),
+		InsecureRegistry: rs.insecure(image),
+	})
+
+	if err != nil {
+		return nil, &Error{
+			Code:    ErrorCodeInvalidImage,
+			Message: err.Error(),
+		}
+	}
+
+	if digest != "" {
+		dockerImage.Tag = digest
+	}
+
+	if err := dockerImage.Pull(); err != nil {
+		return nil, err
+	}
+
+	// klar doesn't check the manifest's response code, so a missing image
+	// looks like an image without layers
+	if len(dockerImage.FsLayers) == 0 {
+		return nil, &Error{
+			Code:    ErrorCodeImageNotFound,
+			Message: "image manifest not found on registry",
+		}
+	}
+
+	return dockerImage, nil
+}
+
+// insecure returns true when the registry of image is an insecure one.
+func (rs *RegistrySource) insecure(image string) bool {
+
+	ref, err := reference.Parse(image)
+
+	if err != nil {
+		return false
+	}
+
+	for _, registry := range rs.InsecureRegistries {
+		if registry == ref.Domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// platformManifest is a platform's entry on a manifest list.
+type platformManifest struct {
+	Platform string
+	Digest   string
+}
+
+// fetchManifestList asks the registry whether the image's tag points to a
+// manifest list (or OCI image index), returning its platforms. It reuses the
+// credentials of the image's previous pull. Returns no platforms when the
+// image isn't multi-platform.
+func fetchManifestList(image *docker.Image) ([]platformManifest, error) {
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", image.Registry, image.Name, image.Tag)
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if image.Token != "" {
+		request.Header.Set("Authorization", image.Token)
+	}
+
+	request.Header.Set("Accept", strings.Join([]string{manifestListMediaType, imageIndexMediaType, manifestMediaTypes}, ", "))
+
+	client := &http.Client{Timeout: registryTimeout}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	mediaType := response.Header.Get("Content-Type")
+
+	if response.StatusCode != http.StatusOK || (mediaType != manifestListMediaType && mediaType != imageIndexMediaType) {
+		return nil, nil
+	}
+
+	var list struct {
+		Manifests []struct {
+			Digest   string `json:"digest"`
+			Platform struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+				Variant      string `json:"variant"`
+			} `json:"platform"`
+		} `json:"manifests"`
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var platforms []platformManifest
+
+	for _, manifest := range list.Manifests {
+		// skips what isn't an image, e.g. build attestations
+		if manifest.Platform.OS == "" || manifest.Platform.OS == "unknown" {
+			continue
+		}
+
+		platforms = append(platforms, platformManifest{
+			Platform: FormatPlatform(manifest.Platform.OS, manifest.Platform.Architecture, manifest.Platform.Variant),
+			Digest:   manifest.Digest,
+		})
+	}
+
+	return platforms, nil
+}
+
+// FormatPlatform returns a platform as "os/architecture[/variant]".
+func FormatPlatform(os, architecture, variant string) string {
+
+	platform := os + "/" + architecture
+
+	if variant != "" {
+		platform += "/" + variant
+	}
+
+	return platform
+}
+
+// MatchPlatform returns true when platform (e.g. "linux/arm64/v8") is one of
+// filters, either with or without variant (e.g. "linux/arm64"). Every
+// platform matches when there are no filters.
+func MatchPlatform(platform string, filters []string) bool {
+
+	if len(filters) == 0 {
+		return true
+	}
+
+	for _, filter := range filters {
+		if platform == filter || strings.HasPrefix(platform, filter+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// klarImageName strips the Docker Hub domain from canonical image names
+// (e.g. "docker.io/library/nginx:latest"), since klar only recognizes Docker
+// Hub images when they have no domain.
+func klarImageName(image string) string {
+	return strings.TrimPrefix(image, reference.DefaultDomain+"/")
+}
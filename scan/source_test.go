@@ -0,0 +1,163 @@
+package scan
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/optiopay/klar/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClair_Scan(t *testing.T) {
+	t.Run(`When source can't fetch the image, should return an error result on pull phase`, func(t *testing.T) {
+		clair := &Clair{
+			Name: "clair",
+			Source: &MockSource{
+				MockFetch: func(image string) ([]PlatformImage, error) {
+					assert.Equal(t, "archive://abc", image)
+
+					return nil, &Error{Code: ErrorCodeImageNotFound, Message: "image archive not found"}
+				},
+			},
+		}
+
+		result := clair.Scan("archive://abc")
+
+		assert.Equal(t, "clair", result.Scanner)
+
+		if assert.NotNil(t, result.Error) {
+			assert.Equal(t, ErrorCodeImageNotFound, result.Error.Code)
+			assert.Equal(t, PhasePull, result.Error.Phase)
+		}
+	})
+}
+
+func TestClair_ScanPlatforms(t *testing.T) {
+	t.Run(`Ensure a result is returned for each platform of the image`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+
+		defer server.Close()
+
+		layers := []docker.FsLayer{{BlobSum: "sha256:5bef08742407efd622d243692b79ba0055383bbce12900324f75e56f589aedb0"}}
+
+		clair := &Clair{
+			Address: server.URL,
+			Name:    "clair",
+			Source: &MockSource{
+				MockFetch: func(image string) ([]PlatformImage, error) {
+					return []PlatformImage{
+						{Platform: "linux/amd64", Image: &docker.Image{Name: "tsuru/cst", FsLayers: layers}},
+						{Platform: "linux/arm64/v8", Image: &docker.Image{Name: "tsuru/cst", FsLayers: layers}},
+					}, nil
+				},
+			},
+		}
+
+		results := clair.ScanPlatforms("docker.io/tsuru/cst:latest")
+
+		require.Len(t, results, 2)
+		assert.Equal(t, "linux/amd64", results[0].Platform)
+		assert.Equal(t, "linux/arm64/v8", results[1].Platform)
+
+		for _, result := range results {
+			assert.Equal(t, "clair", result.Scanner)
+
+			if assert.NotNil(t, result.Error) {
+				assert.Equal(t, PhaseAnalyze, result.Error.Phase)
+			}
+		}
+	})
+}
+
+func TestRegistrySource_Fetch(t *testing.T) {
+	t.Run(`When image's registry is an insecure one, should pull it over plain HTTP`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v2/tsuru/cst/manifests/v1", r.URL.Path)
+
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Write([]byte(`{"schemaVersion": 2, "layers": [{"digest": "sha256:aaa"}]}`))
+		}))
+
+		defer server.Close()
+
+		host := strings.TrimPrefix(server.URL, "http://")
+
+		source := &RegistrySource{InsecureRegistries: []string{host}}
+
+		images, err := source.Fetch(host + "/tsuru/cst:v1")
+
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		assert.Equal(t, server.URL+"/v2", images[0].Registry)
+		assert.Equal(t, "sha256:aaa", images[0].FsLayers[0].BlobSum)
+	})
+
+	t.Run(`When image's registry isn't an insecure one, should pull it over HTTPS`, func(t *testing.T) {
+		source := &RegistrySource{InsecureRegistries: []string{"registry.tld:5000"}}
+
+		assert.False(t, source.insecure("registry.tld/tsuru/cst:v1"))
+		assert.True(t, source.insecure("registry.tld:5000/tsuru/cst:v1"))
+	})
+}
+
+func TestFetchManifestList(t *testing.T) {
+	t.Run(`Ensure the image platforms are listed from a manifest list`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v2/library/nginx/manifests/latest", r.URL.Path)
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			assert.Contains(t, r.Header.Get("Accept"), manifestListMediaType)
+
+			w.Header().Set("Content-Type", manifestListMediaType)
+			w.Write([]byte(`{
+				"schemaVersion": 2,
+				"manifests": [
+					{"digest": "sha256:aaa", "platform": {"architecture": "amd64", "os": "linux"}},
+					{"digest": "sha256:bbb", "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+					{"digest": "sha256:ccc", "platform": {"architecture": "unknown", "os": "unknown"}}
+				]
+			}`))
+		}))
+
+		defer server.Close()
+
+		platforms, err := fetchManifestList(&docker.Image{Registry: server.URL + "/v2", Name: "library/nginx", Tag: "latest", Token: "Bearer token"})
+
+		require.NoError(t, err)
+		assert.Equal(t, []platformManifest{
+			{Platform: "linux/amd64", Digest: "sha256:aaa"},
+			{Platform: "linux/arm64/v8", Digest: "sha256:bbb"},
+		}, platforms)
+	})
+
+	t.Run(`When tag points to a single image manifest, should return no platforms`, func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+			w.Write([]byte(`{"schemaVersion": 2, "layers": []}`))
+		}))
+
+		defer server.Close()
+
+		platforms, err := fetchManifestList(&docker.Image{Registry: server.URL + "/v2", Name: "tsuru/cst", Tag: "latest"})
+
+		require.NoError(t, err)
+		assert.Empty(t, platforms)
+	})
+}
+
+func TestMatchPlatform(t *testing.T) {
+	assert.True(t, MatchPlatform("linux/arm64/v8", nil))
+	assert.True(t, MatchPlatform("linux/arm64/v8", []string{"linux/amd64", "linux/arm64"}))
+	assert.True(t, MatchPlatform("linux/amd64", []string{"linux/amd64"}))
+	assert.False(t, MatchPlatform("linux/arm/v7", []string{"linux/amd64", "linux/arm64"}))
+	assert.False(t, MatchPlatform("linux/amd64", []string{"linux/amd"}))
+}
+
+func TestKlarImageName(t *testing.T) {
+	assert.Equal(t, "library/nginx:latest", klarImageName("docker.io/library/nginx:latest"))
+	assert.Equal(t, "registry.tld:5000/tsuru/cst:v1", klarImageName("registry.tld:5000/tsuru/cst:v1"))
+}
@@ -0,0 +1,193 @@
+package scan
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// spdxAliases maps license names found on package metadata (lower cased) to
+// their SPDX identifiers.
+var spdxAliases = map[string]string{
+	"0bsd":                                 "0BSD",
+	"afl-2.1":                              "AFL-2.1",
+	"apache":                               "Apache-2.0",
+	"apache-1.1":                           "Apache-1.1",
+	"apache 2":                             "Apache-2.0",
+	"apache 2.0":                           "Apache-2.0",
+	"apache-2":                             "Apache-2.0",
+	"apache-2.0":                           "Apache-2.0",
+	"apache2":                              "Apache-2.0",
+	"apache license":                       "Apache-2.0",
+	"apache license 2.0":                   "Apache-2.0",
+	"apache license, version 2.0":          "Apache-2.0",
+	"apache software license":              "Apache-2.0",
+	"asl 2.0":                              "Apache-2.0",
+	"artistic":                             "Artistic-1.0-Perl",
+	"artistic-1.0-perl":                    "Artistic-1.0-Perl",
+	"artistic-2.0":                         "Artistic-2.0",
+	"boost":                                "BSL-1.0",
+	"bsd":                                  "BSD-3-Clause",
+	"bsd license":                          "BSD-3-Clause",
+	"bsd-2-clause":                         "BSD-2-Clause",
+	"bsd-3-clause":                         "BSD-3-Clause",
+	"bsd-4-clause":                         "BSD-4-Clause",
+	"bsl-1.0":                              "BSL-1.0",
+	"cc-by-4.0":                            "CC-BY-4.0",
+	"cc-by-sa-4.0":                         "CC-BY-SA-4.0",
+	"cc0":                                  "CC0-1.0",
+	"cc0-1.0":                              "CC0-1.0",
+	"cddl-1.0":                             "CDDL-1.0",
+	"curl":                                 "curl",
+	"epl-1.0":                              "EPL-1.0",
+	"epl-2.0":                              "EPL-2.0",
+	"eupl-1.2":                             "EUPL-1.2",
+	"expat":                                "MIT",
+	"isc":                                  "ISC",
+	"isc license":                          "ISC",
+	"mit":                                  "MIT",
+	"mit license":                          "MIT",
+	"mit/x11":                              "MIT",
+	"mpl-1.1":                              "MPL-1.1",
+	"mpl-2.0":                              "MPL-2.0",
+	"mpl 2.0":                              "MPL-2.0",
+	"mpl2":                                 "MPL-2.0",
+	"mozilla public license 2.0 (mpl 2.0)": "MPL-2.0",
+	"openssl":                              "OpenSSL",
+	"postgresql":                           "PostgreSQL",
+	"psf":                                  "Python-2.0",
+	"psf-2.0":                              "Python-2.0",
+	"python":                               "Python-2.0",
+	"python-2.0":                           "Python-2.0",
+	"python software foundation license":   "Python-2.0",
+	"ruby":                                 "Ruby",
+	"sspl-1.0":                             "SSPL-1.0",
+	"the unlicense":                        "Unlicense",
+	"unlicense":                            "Unlicense",
+	"vim":                                  "Vim",
+	"wtfpl":                                "WTFPL",
+	"x11":                                  "X11",
+	"zlib":                                 "Zlib",
+	"zlib/libpng":                          "Zlib",
+	"zpl-2.1":                              "ZPL-2.1",
+}
+
+var (
+	// gplRegexp matches the GPL family written on SPDX, Debian (e.g. "GPL-2+")
+	// or RPM (e.g. "GPLv2+") styles.
+	gplRegexp = regexp.MustCompile(`^(a|l)?gpl(?:[-\s]?v?(\d(?:\.\d)?))?(\+|-only|-or-later|\s+or\s+later)?$`)
+
+	// licenseSplitRegexp splits license expressions (e.g. "GPL-2+ or
+	// Artistic", "MIT AND (BSD-3-Clause OR Apache-2.0)") into licenses.
+	licenseSplitRegexp = regexp.MustCompile(`(?i)\s+(?:and|or)\s+|\s*[|&;]\s*`)
+
+	licenseExceptionRegexp = regexp.MustCompile(`(?i)\s+with\s+.*$`)
+	licenseParenRegexp     = regexp.MustCompile(`\(([^()]+)\)\s*$`)
+)
+
+// NormalizeLicenses splits a declared license (a single name or an
+// expression) into its licenses, normalized to SPDX identifiers when known
+// and kept as declared otherwise. Exceptions (e.g. "WITH
+// Classpath-exception-2.0") are dropped.
+func NormalizeLicenses(declared string) []string {
+
+	seen := make(map[string]bool)
+
+	var licenses []string
+
+	add := func(license string) {
+		if license != "" && !seen[license] {
+			seen[license] = true
+			licenses = append(licenses, license)
+		}
+	}
+
+	if license, ok := normalizeLicense(declared); ok {
+		add(license)
+		return licenses
+	}
+
+	for _, part := range licenseSplitRegexp.Split(declared, -1) {
+		part = licenseExceptionRegexp.ReplaceAllString(part, "")
+
+		license, ok := normalizeLicense(part)
+
+		if !ok {
+			license = strings.Trim(part, " \t()[],.")
+		}
+
+		add(license)
+	}
+
+	sort.Strings(licenses)
+
+	return licenses
+}
+
+// normalizeLicense returns the SPDX identifier of a single license's name,
+// also trying the abbreviation at its end (e.g. "GNU General Public License
+// v2 (GPLv2)").
+func normalizeLicense(name string) (string, bool) {
+
+	name = strings.ToLower(strings.Trim(name, " \t,."))
+	clean := strings.Trim(name, " \t()[]")
+
+	if license, ok := spdxAliases[clean]; ok {
+		return license, true
+	}
+
+	if match := gplRegexp.FindStringSubmatch(clean); match != nil && (match[2] != "" || match[3] == "+") {
+		return gplIdentifier(match[1], match[2], match[3]), true
+	}
+
+	if match := licenseParenRegexp.FindStringSubmatch(name); match != nil {
+		return normalizeLicense(match[1])
+	}
+
+	return "", false
+}
+
+// gplIdentifier builds the SPDX identifier of a (A/L)GPL license: a version
+// without "+" (or "or later") means "only" that version, while no version
+// means any version.
+func gplIdentifier(prefix, version, suffix string) string {
+
+	if version == "" {
+		version = "1.0"
+
+		if prefix == "l" {
+			version = "2.0"
+		}
+	}
+
+	if !strings.Contains(version, ".") {
+		version += ".0"
+	}
+
+	qualifier := "-only"
+
+	if suffix != "" && suffix != "-only" {
+		qualifier = "-or-later"
+	}
+
+	return strings.ToUpper(prefix) + "GPL-" + version + qualifier
+}
+
+// licenseDenied returns the licenses matching some of deny: exactly (case
+// insensitive) or as the family of a license (e.g. "AGPL" denies
+// "AGPL-3.0-only", "GPL-3.0" denies "GPL-3.0-or-later").
+func licenseDenied(licenses, deny []string) []string {
+
+	var denied []string
+
+	for _, license := range licenses {
+		for _, entry := range deny {
+			if strings.EqualFold(license, entry) || strings.HasPrefix(strings.ToLower(license), strings.ToLower(entry)+"-") {
+				denied = append(denied, license)
+				break
+			}
+		}
+	}
+
+	return denied
+}
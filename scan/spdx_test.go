@@ -0,0 +1,48 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLicenses(t *testing.T) {
+	t.Run(`Ensure licenses are normalized to their SPDX identifiers`, func(t *testing.T) {
+		cases := map[string][]string{
+			"MIT":                         {"MIT"},
+			"Expat":                       {"MIT"},
+			"Apache License, Version 2.0": {"Apache-2.0"},
+			"ASL 2.0":                     {"Apache-2.0"},
+			"GPL-2":                       {"GPL-2.0-only"},
+			"GPL-2+":                      {"GPL-2.0-or-later"},
+			"GPLv3+":                      {"GPL-3.0-or-later"},
+			"GPL+":                        {"GPL-1.0-or-later"},
+			"LGPL-2.1":                    {"LGPL-2.1-only"},
+			"LGPL-2.1-or-later":           {"LGPL-2.1-or-later"},
+			"AGPL-3.0-only":               {"AGPL-3.0-only"},
+			"GNU Affero General Public License v3 (AGPLv3)": {"AGPL-3.0-only"},
+			"BSD-3-clause":                                  {"BSD-3-Clause"},
+			"GPLv2+ and LGPLv2+ and BSD":                    {"BSD-3-Clause", "GPL-2.0-or-later", "LGPL-2.0-or-later"},
+			"GPL-2+ or Artistic":                            {"Artistic-1.0-Perl", "GPL-2.0-or-later"},
+			"MIT AND (BSD-2-Clause OR Apache-2.0)":          {"Apache-2.0", "BSD-2-Clause", "MIT"},
+			"GPL-2.0-or-later WITH Classpath-exception-2.0": {"GPL-2.0-or-later"},
+			"public-domain":                                 {"public-domain"},
+			"":                                              nil,
+		}
+
+		for declared, expected := range cases {
+			assert.Equal(t, expected, NormalizeLicenses(declared), declared)
+		}
+	})
+}
+
+func TestLicenseDenied(t *testing.T) {
+	t.Run(`Ensure licenses are denied by identifier or family`, func(t *testing.T) {
+		licenses := []string{"AGPL-3.0-only", "GPL-3.0-or-later", "LGPL-2.1-only", "MIT"}
+
+		assert.Equal(t, []string{"AGPL-3.0-only"}, licenseDenied(licenses, []string{"agpl"}))
+		assert.Equal(t, []string{"GPL-3.0-or-later"}, licenseDenied(licenses, []string{"GPL-3.0"}))
+		assert.Equal(t, []string{"MIT"}, licenseDenied(licenses, []string{"SSPL-1.0", "MIT"}))
+		assert.Empty(t, licenseDenied(licenses, nil))
+	})
+}
@@ -0,0 +1,4 @@
+#!/bin/sh
+# Replays a recorded response, keeping the request for the tests to inspect.
+cat > "$REQUEST_FILE"
+cat "$RESPONSE_FILE"
@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// LayerCache implements the scan.LayerCache interface, keeping the layers'
+// findings on storage for TTL. Since layers are also keyed by the scanner's
+// vulnerability database version, they're discarded earlier when a scanner
+// reports a new version.
+type LayerCache struct {
+	TTL time.Duration
+}
+
+// Get returns the findings (by layer chain name) of the unexpired layers
+// cached among names.
+func (lc *LayerCache) Get(scanner, dbVersion string, names []string) (map[string][]scan.Finding, error) {
+
+	layers, err := db.GetStorage().GetLayerFindings(scanner, dbVersion, names, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	findings := make(map[string][]scan.Finding, len(layers))
+
+	for _, layer := range layers {
+		findings[layer.Name] = layer.Findings
+	}
+
+	return findings, nil
+}
+
+// Put caches the findings of layers (by their chain names) for TTL.
+func (lc *LayerCache) Put(scanner, dbVersion string, layers map[string][]scan.Finding) error {
+
+	expiresAt := time.Now().Add(lc.TTL)
+	entries := make([]scan.LayerFindings, 0, len(layers))
+
+	for name, findings := range layers {
+		if findings == nil {
+			findings = []scan.Finding{}
+		}
+
+		entries = append(entries, scan.LayerFindings{
+			Name:      name,
+			Scanner:   scanner,
+			DBVersion: dbVersion,
+			Findings:  findings,
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	return db.GetStorage().SaveLayerFindings(entries)
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestLayerCache(t *testing.T) {
+	t.Run(`Ensure cached layers are returned by chain name`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetLayerFindings: func(scanner, dbVersion string, names []string, now time.Time) ([]scan.LayerFindings, error) {
+				assert.Equal(t, "clair", scanner)
+				assert.Equal(t, "v1", dbVersion)
+				assert.Equal(t, []string{"sha256:base", "sha256:app"}, names)
+
+				return []scan.LayerFindings{
+					{Name: "sha256:base", Findings: []scan.Finding{{CVE: "CVE-2018-1000001"}}},
+				}, nil
+			},
+		})
+
+		cache := &LayerCache{TTL: time.Hour}
+
+		got, err := cache.Get("clair", "v1", []string{"sha256:base", "sha256:app"})
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]scan.Finding{"sha256:base": {{CVE: "CVE-2018-1000001"}}}, got)
+	})
+
+	t.Run(`Ensure layers are saved to expire after TTL`, func(t *testing.T) {
+		var saved []scan.LayerFindings
+
+		db.SetStorage(&db.MockStorage{
+			MockSaveLayerFindings: func(layers []scan.LayerFindings) error {
+				saved = layers
+
+				return nil
+			},
+		})
+
+		cache := &LayerCache{TTL: time.Hour}
+
+		require.NoError(t, cache.Put("clair", "v1", map[string][]scan.Finding{"sha256:app": nil}))
+
+		require.Len(t, saved, 1)
+		assert.Equal(t, "sha256:app", saved[0].Name)
+		assert.Equal(t, "clair", saved[0].Scanner)
+		assert.Equal(t, "v1", saved[0].DBVersion)
+		assert.Equal(t, []scan.Finding{}, saved[0].Findings)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), saved[0].ExpiresAt, time.Minute)
+	})
+}
@@ -1,72 +1,278 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/metrics"
 	"github.com/tsuru/cst/queue"
 	"github.com/tsuru/cst/scan"
-	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/cst/tracing"
 )
 
-// ScanTask implements a monsterqueue.Task interface.
+// errTransientFailure indicates some scanner couldn't analyze the image due to
+// a temporary condition.
+var errTransientFailure = errors.New(`scan has transient failures`)
+
+// ScanTask implements a queue.Task interface. Only scheduled scans are run, so
+// stale jobs are dropped. When LeaseDuration is assigned, the running scans
+// are leased to WorkerID and kept alive by heartbeats, so a Reaper can
+// recover them if this worker goes away.
 type ScanTask struct {
-	Scanners []scan.Scanner
+	Scanners      []scan.Scanner
+	Retry         RetryPolicy
+	WorkerID      string
+	LeaseDuration time.Duration
 }
 
-// Run executes a scheduled scan over all scanners available.
-func (st *ScanTask) Run(job monsterqueue.Job) {
-	log := logrus.WithField("job.id", job.ID())
+// Run executes a scheduled scan over all scanners available. When any scanner
+// reports a transient failure, the scan is scheduled again (following the
+// retry policy) or moved to dead-letter status when it has no attempts left.
+// Otherwise, the scan is finished, partial or failed depending on how many
+// scanners have reported errors.
+func (st *ScanTask) Run(job queue.Job) {
+
+	ctx, span := tracing.Start(
+		tracing.Extract(context.Background(), job.Metadata),
+		"ScanTask.Run",
+		tracing.SpanKindConsumer,
+	)
+
+	defer span.End()
+
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.attempt", job.Attempt)
+	span.SetAttribute("scan.id", job.ScanID)
+	span.SetAttribute("image", job.Image)
+
+	log := logrus.
+		WithField("job.id", job.ID).
+		WithField("job.attempt", job.Attempt).
+		WithField("trace.id", span.SpanContext().TraceID.String())
 
 	log.Info("initializing a new job")
 
 	defer log.Info("finishing job")
 
-	scanID := job.Parameters()["id"].(string)
-	image := job.Parameters()["image"].(string)
-
+	q := queue.GetQueue()
 	storage := db.GetStorage()
 
-	err := storage.UpdateScanByID(scanID, scan.StatusRunning, nil)
+	attempt := job.Attempt
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	var (
+		hb    *heartbeat
+		lease *scan.Lease
+	)
+
+	if st.LeaseDuration > 0 {
+		hb = &heartbeat{
+			scanID:   job.ScanID,
+			attempt:  attempt,
+			worker:   st.WorkerID,
+			duration: st.LeaseDuration,
+		}
+
+		newLease := hb.newLease()
+		lease = &newLease
+	}
+
+	// the scan is run (and leased) only while it's scheduled, so a stale job
+	// (e.g. delivered again by the queue) doesn't run it twice
+	err := storage.StartScanByID(job.ScanID, attempt, lease)
+
+	if err == db.ErrScanNotScheduled {
+		log.Warn("scan isn't scheduled anymore, dropping job")
+		q.Ack(job)
+
+		return
+	}
 
 	if err != nil {
 		log.WithError(err).Error("could not update scan's status on storage")
-		job.Error(err)
+		q.Fail(job, err)
 
 		return
 	}
 
-	results := make([]scan.Result, len(st.Scanners))
+	if hb != nil {
+		hb.start(log)
+	}
+
+	hasTransientFailure := false
+	results := make([]scan.Result, 0, len(st.Scanners))
+
+	for _, scanner := range st.Scanners {
+
+		for _, result := range st.scan(ctx, scanner, job.Image) {
+
+			if result.IsTransient() {
+				hasTransientFailure = true
+			}
+
+			results = append(results, result)
+
+			err = storage.AppendResultToScanByID(job.ScanID, st.WorkerID, result)
+
+			if err != nil {
+				log.
+					WithError(err).
+					Error("could not update scan's result with analysis result")
+			}
+		}
+	}
+
+	if hb != nil && hb.Stop() {
+		log.Warn("scan's lease was lost, leaving it to another attempt")
+		q.Ack(job)
 
-	for index, scanner := range st.Scanners {
+		return
+	}
 
-		result := scanner.Scan(image)
-		results[index] = result
+	status := scan.FinalStatus(results)
 
-		err = storage.AppendResultToScanByID(scanID, result)
+	if hasTransientFailure {
+		if st.Retry.CanRetry(attempt) {
+			st.retry(ctx, job, attempt, log)
 
-		if err != nil {
-			log.
-				WithError(err).
-				Error("could not update scan's result with analysis result")
+			return
 		}
+
+		log.Warn("scan has no attempts left, moving it to dead-letter")
+
+		status = scan.StatusDeadLetter
 	}
 
 	now := time.Now()
-	err = storage.UpdateScanByID(scanID, scan.StatusFinished, &now)
+	err = storage.UpdateScanByID(job.ScanID, status, &now)
 
 	if err != nil {
 		log.WithError(err).Error("could not update scan's status on storage")
-		job.Error(err)
+		q.Fail(job, err)
+
+		return
+	}
+
+	metrics.ScansFinished.Inc(string(status))
+
+	if status == scan.StatusDeadLetter {
+		q.Fail(job, errTransientFailure)
+
+		return
+	}
+
+	q.Ack(job)
+}
+
+// scan analyzes image on scanner, returning a result per platform when the
+// scanner is a scan.PlatformScanner.
+func (st *ScanTask) scan(ctx context.Context, scanner scan.Scanner, image string) []scan.Result {
+
+	_, span := tracing.Start(ctx, "Scanner.Scan", tracing.SpanKindClient)
+	defer span.End()
+
+	startedAt := time.Now()
+
+	var results []scan.Result
+
+	if platformScanner, ok := scanner.(scan.PlatformScanner); ok {
+		results = platformScanner.ScanPlatforms(image)
+	} else {
+		results = []scan.Result{scanner.Scan(image)}
+	}
+
+	duration := time.Since(startedAt)
+
+	// a platform scanner reports a result per platform, though the scan was
+	// handed over to it once
+	if len(results) > 0 {
+		metrics.ScansScheduled.Inc(results[0].Scanner)
+	}
+
+	for _, result := range results {
+		observeResult(result, duration)
+
+		span.SetAttribute("scanner", result.Scanner)
+
+		if result.Error != nil {
+			span.SetAttribute("error.code", string(result.Error.Code))
+			span.SetError(result.Error)
+		}
+	}
+
+	return results
+}
+
+func (st *ScanTask) retry(ctx context.Context, job queue.Job, attempt int, log *logrus.Entry) {
+
+	q := queue.GetQueue()
+
+	err := db.GetStorage().RescheduleScanByID(job.ScanID, attempt)
+
+	if err != nil {
+		log.WithError(err).Error("could not reschedule scan on storage")
+		q.Fail(job, err)
 
 		return
 	}
 
-	job.Success(results)
+	backoff := st.Retry.Backoff(attempt)
+
+	metadata := make(map[string]string, len(job.Metadata)+1)
+
+	for key, value := range job.Metadata {
+		metadata[key] = value
+	}
+
+	tracing.Inject(ctx, metadata)
+
+	_, err = q.Enqueue(queue.Job{
+		ScanID:    job.ScanID,
+		Image:     job.Image,
+		Attempt:   attempt + 1,
+		NotBefore: time.Now().Add(backoff),
+		Metadata:  metadata,
+	})
+
+	// the scan is already rescheduled, so it's moved to dead-letter rather
+	// than being left scheduled without a job to run it
+	if err != nil {
+		log.WithError(err).Error("could not enqueue a new attempt of scan, moving it to dead-letter")
+
+		now := time.Now()
+
+		if err := db.GetStorage().UpdateScanByID(job.ScanID, scan.StatusDeadLetter, &now); err != nil {
+			log.WithError(err).Error("could not update scan's status on storage")
+		} else {
+			metrics.ScansFinished.Inc(string(scan.StatusDeadLetter))
+		}
+
+		q.Fail(job, err)
+
+		return
+	}
+
+	log.
+		WithField("retry.backoff", backoff).
+		Warn("scan has transient failures, it'll be tried again")
+
+	q.Ack(job)
 }
 
-// Name returns the name of this task.
-func (st *ScanTask) Name() string {
-	return queue.ScanTaskName
+func observeResult(result scan.Result, duration time.Duration) {
+
+	status := scan.StatusFinished
+
+	if result.Error != nil {
+		status = scan.StatusFailed
+	}
+
+	metrics.ScannerResults.Inc(result.Scanner, string(status))
+	metrics.ScannerDuration.Observe(duration.Seconds(), result.Scanner)
 }
@@ -2,21 +2,41 @@ package worker
 
 import (
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/prometheus/common/expfmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/metrics"
 	"github.com/tsuru/cst/queue"
 	"github.com/tsuru/cst/scan"
-	"github.com/tsuru/monsterqueue"
 )
 
-func TestScanTask_Name(t *testing.T) {
-	t.Run(`Ensure name returned by scanTaks.Name method`, func(t *testing.T) {
-		st := &ScanTask{}
-		assert.Equal(t, queue.ScanTaskName, st.Name())
-	})
+// scansScheduled scrapes the default registry and returns how many scans were
+// scheduled on scanner.
+func scansScheduled(t *testing.T, scanner string) float64 {
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	var parser expfmt.TextParser
+
+	families, err := parser.TextToMetricFamilies(recorder.Body)
+	require.NoError(t, err)
+
+	for _, metric := range families["cst_scans_scheduled_total"].GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "scanner" && label.GetValue() == scanner {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return 0
 }
 
 func TestScanTask_Run(t *testing.T) {
@@ -41,20 +61,19 @@ func TestScanTask_Run(t *testing.T) {
 			},
 		}
 
-		job := queue.MockJob{
-			MockParameters: func() monsterqueue.JobParams {
-				return monsterqueue.JobParams{
-					"id":    "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
-					"image": "tsuru/cst:latest",
-				}
-			},
+		job := queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		}
 
-			MockSucess: func(result monsterqueue.JobResult) (bool, error) {
-				wasSuccessful = true
+		queue.SetQueue(queue.MockQueue{
+			MockAck: func(got queue.Job) error {
+				wasSuccessful = got.ID == job.ID
 
-				return false, nil
+				return nil
 			},
-		}
+		})
 
 		storage := &db.MockStorage{
 			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
@@ -62,7 +81,7 @@ func TestScanTask_Run(t *testing.T) {
 
 				return nil
 			},
-			MockAppendResultToScanByID: func(id string, result scan.Result) error {
+			MockAppendResultToScanByID: func(id, _ string, result scan.Result) error {
 				gotResult = result
 
 				return nil
@@ -79,35 +98,30 @@ func TestScanTask_Run(t *testing.T) {
 		assert.True(t, wasSuccessful)
 	})
 
-	t.Run(`When storage returns any error on UpdateScanByID method with scan.StatusRunning param, should abort execution and call the job.Error method`, func(t *testing.T) {
+	t.Run(`When storage returns any error on StartScanByID method, should abort execution and call the job.Error method`, func(t *testing.T) {
 		gotJobError := false
 
 		storage := &db.MockStorage{
-			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
-				if status == scan.StatusRunning {
-					return errors.New("just another error on storage")
-				}
-
-				return nil
+			MockStartScanByID: func(string, int, *scan.Lease) error {
+				return errors.New("just another error on storage")
 			},
 		}
 
 		db.SetStorage(storage)
 
-		job := queue.MockJob{
-			MockParameters: func() monsterqueue.JobParams {
-				return monsterqueue.JobParams{
-					"id":    "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
-					"image": "tsuru/cst:latest",
-				}
-			},
+		job := queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		}
 
-			MockError: func(err error) (bool, error) {
+		queue.SetQueue(queue.MockQueue{
+			MockFail: func(_ queue.Job, err error) error {
 				gotJobError = true
 
-				return false, err
+				return nil
 			},
-		}
+		})
 
 		st := &ScanTask{}
 
@@ -116,6 +130,49 @@ func TestScanTask_Run(t *testing.T) {
 		assert.True(t, gotJobError)
 	})
 
+	t.Run(`When scan isn't scheduled anymore (e.g. a stale job), should ack the job without running the scan`, func(t *testing.T) {
+		wasAcked := false
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						assert.Fail(t, "should not scan the image of a scan which isn't scheduled")
+
+						return scan.Result{}
+					},
+				},
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockStartScanByID: func(string, int, *scan.Lease) error {
+				return db.ErrScanNotScheduled
+			},
+			MockUpdateScanByID: func(string, scan.Status, *time.Time) error {
+				assert.Fail(t, "should not update the status of a scan which isn't scheduled")
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockAck: func(queue.Job) error {
+				wasAcked = true
+
+				return nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		})
+
+		assert.True(t, wasAcked)
+	})
+
 	t.Run(`When storage returns any error on UpdateScanByID method with scan.StatusFinished param, should abort execution and call the job.Error method`, func(t *testing.T) {
 		gotJobError := false
 
@@ -131,20 +188,19 @@ func TestScanTask_Run(t *testing.T) {
 
 		db.SetStorage(storage)
 
-		job := queue.MockJob{
-			MockParameters: func() monsterqueue.JobParams {
-				return monsterqueue.JobParams{
-					"id":    "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
-					"image": "tsuru/cst:latest",
-				}
-			},
+		job := queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		}
 
-			MockError: func(err error) (bool, error) {
+		queue.SetQueue(queue.MockQueue{
+			MockFail: func(_ queue.Job, err error) error {
 				gotJobError = true
 
-				return false, err
+				return nil
 			},
-		}
+		})
 
 		st := &ScanTask{}
 
@@ -152,4 +208,419 @@ func TestScanTask_Run(t *testing.T) {
 
 		assert.True(t, gotJobError)
 	})
+	t.Run(`When a scanner reports a transient failure and there are attempts left, should reschedule the scan with backoff`, func(t *testing.T) {
+		gotAttempts := 0
+		gotJob := queue.Job{}
+		wasAcked := false
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						return scan.Result{
+							Scanner: "mocked-scanner",
+							Error: &scan.Error{
+								Code:      scan.ErrorCodeUnavailable,
+								Phase:     scan.PhaseAnalyze,
+								Message:   "service unavailable",
+								Transient: true,
+							},
+						}
+					},
+				},
+			},
+			Retry: RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Minute,
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockRescheduleScanByID: func(_ string, attempts int) error {
+				gotAttempts = attempts
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				gotJob = job
+
+				return "5b9f9c1e0c9d440001a1b2c4", nil
+			},
+			MockAck: func(queue.Job) error {
+				wasAcked = true
+
+				return nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:      "5b9f9c1e0c9d440001a1b2c3",
+			ScanID:  "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:   "tsuru/cst:latest",
+			Attempt: 2,
+		})
+
+		assert.Equal(t, 2, gotAttempts)
+		assert.Equal(t, 3, gotJob.Attempt)
+		assert.Equal(t, "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf", gotJob.ScanID)
+		assert.WithinDuration(t, time.Now().Add(2*time.Minute), gotJob.NotBefore, 5*time.Second)
+		assert.True(t, wasAcked)
+	})
+
+	t.Run(`When the next attempt can't be enqueued, should move the rescheduled scan to dead-letter`, func(t *testing.T) {
+		gotStatus := scan.Status("")
+		wasFailed := false
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						return scan.Result{
+							Scanner: "mocked-scanner",
+							Error: &scan.Error{
+								Code:      scan.ErrorCodeUnavailable,
+								Phase:     scan.PhaseAnalyze,
+								Message:   "service unavailable",
+								Transient: true,
+							},
+						}
+					},
+				},
+			},
+			Retry: RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Minute,
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				gotStatus = status
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(queue.Job) (string, error) {
+				return "", errors.New("queue is unavailable")
+			},
+			MockAck: func(queue.Job) error {
+				require.Fail(t, "should not ack a job whose next attempt wasn't enqueued")
+
+				return nil
+			},
+			MockFail: func(queue.Job, error) error {
+				wasFailed = true
+
+				return nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:      "5b9f9c1e0c9d440001a1b2c3",
+			ScanID:  "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:   "tsuru/cst:latest",
+			Attempt: 1,
+		})
+
+		assert.Equal(t, scan.StatusDeadLetter, gotStatus)
+		assert.True(t, wasFailed)
+	})
+
+	t.Run(`When job carries a trace context, the next attempt should continue the same trace`, func(t *testing.T) {
+		gotJob := queue.Job{}
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						return scan.Result{
+							Scanner: "mocked-scanner",
+							Error:   &scan.Error{Code: scan.ErrorCodeTimeout, Transient: true},
+						}
+					},
+				},
+			},
+			Retry: RetryPolicy{MaxAttempts: 3},
+		}
+
+		db.SetStorage(&db.MockStorage{})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				gotJob = job
+
+				return "5b9f9c1e0c9d440001a1b2c4", nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+			Metadata: map[string]string{
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			},
+		})
+
+		assert.Contains(t, gotJob.Metadata["traceparent"], "-4bf92f3577b34da6a3ce929d0e0e4736-")
+		assert.NotContains(t, gotJob.Metadata["traceparent"], "-00f067aa0ba902b7-")
+	})
+
+	t.Run(`When a scanner reports a transient failure and there are no attempts left, should move the scan to dead-letter`, func(t *testing.T) {
+		gotStatus := scan.Status("")
+		wasFailed := false
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						return scan.Result{
+							Scanner: "mocked-scanner",
+							Error: &scan.Error{
+								Code:      scan.ErrorCodeUnavailable,
+								Phase:     scan.PhaseAnalyze,
+								Message:   "service unavailable",
+								Transient: true,
+							},
+						}
+					},
+				},
+			},
+			Retry: RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Minute,
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				gotStatus = status
+
+				return nil
+			},
+			MockRescheduleScanByID: func(string, int) error {
+				require.Fail(t, "should not reschedule a scan without attempts left")
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockFail: func(queue.Job, error) error {
+				wasFailed = true
+
+				return nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:      "5b9f9c1e0c9d440001a1b2c3",
+			ScanID:  "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:   "tsuru/cst:latest",
+			Attempt: 3,
+		})
+
+		assert.Equal(t, scan.StatusDeadLetter, gotStatus)
+		assert.True(t, wasFailed)
+	})
+	t.Run(`When scanners report permanent failures, should finish the scan as partial or failed`, func(t *testing.T) {
+		failedScanner := &scan.MockScanner{
+			MockScan: func(image string) scan.Result {
+				return scan.Result{
+					Scanner: "mocked-scanner",
+					Error: &scan.Error{
+						Code:    scan.ErrorCodeImageNotFound,
+						Phase:   scan.PhasePull,
+						Message: "image manifest not found on registry",
+					},
+				}
+			},
+		}
+
+		succeededScanner := &scan.MockScanner{
+			MockScan: func(image string) scan.Result {
+				return scan.Result{Scanner: "another-mocked-scanner"}
+			},
+		}
+
+		cases := []struct {
+			scanners []scan.Scanner
+			expected scan.Status
+		}{
+			{[]scan.Scanner{failedScanner}, scan.StatusFailed},
+			{[]scan.Scanner{failedScanner, succeededScanner}, scan.StatusPartial},
+		}
+
+		for _, c := range cases {
+			gotStatus := scan.Status("")
+			wasAcked := false
+
+			st := &ScanTask{
+				Scanners: c.scanners,
+				Retry:    RetryPolicy{MaxAttempts: 3},
+			}
+
+			db.SetStorage(&db.MockStorage{
+				MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+					gotStatus = status
+
+					return nil
+				},
+			})
+
+			queue.SetQueue(queue.MockQueue{
+				MockAck: func(queue.Job) error {
+					wasAcked = true
+
+					return nil
+				},
+			})
+
+			st.Run(queue.Job{
+				ID:     "5b9f9c1e0c9d440001a1b2c3",
+				ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+				Image:  "tsuru/cst:latest",
+			})
+
+			assert.Equal(t, c.expected, gotStatus)
+			assert.True(t, wasAcked)
+		}
+	})
+	t.Run(`When scanner analyzes each platform, should append a result per platform`, func(t *testing.T) {
+		var gotResults []scan.Result
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockPlatformScanner{
+					MockScanPlatforms: func(image string) []scan.Result {
+						return []scan.Result{
+							{Scanner: "mocked-scanner", Platform: "linux/amd64"},
+							{Scanner: "mocked-scanner", Platform: "linux/arm64"},
+						}
+					},
+				},
+			},
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockAppendResultToScanByID: func(_, _ string, result scan.Result) error {
+				gotResults = append(gotResults, result)
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{})
+
+		before := scansScheduled(t, "mocked-scanner")
+
+		st.Run(queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		})
+
+		if assert.Len(t, gotResults, 2) {
+			assert.Equal(t, "linux/amd64", gotResults[0].Platform)
+			assert.Equal(t, "linux/arm64", gotResults[1].Platform)
+		}
+
+		assert.Equal(t, before+1, scansScheduled(t, "mocked-scanner"))
+	})
+	t.Run(`When lease duration is assigned, should lease the scan to the worker with the current attempt`, func(t *testing.T) {
+		gotAttempt := 0
+		gotLease := scan.Lease{}
+		gotStatus := scan.Status("")
+
+		st := &ScanTask{
+			WorkerID:      "worker-01_42",
+			LeaseDuration: time.Minute,
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockStartScanByID: func(_ string, attempt int, lease *scan.Lease) error {
+				gotAttempt = attempt
+
+				if lease != nil {
+					gotLease = *lease
+				}
+
+				return nil
+			},
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				gotStatus = status
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{})
+
+		st.Run(queue.Job{
+			ID:      "5b9f9c1e0c9d440001a1b2c3",
+			ScanID:  "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:   "tsuru/cst:latest",
+			Attempt: 2,
+		})
+
+		assert.Equal(t, 2, gotAttempt)
+		assert.Equal(t, "worker-01_42", gotLease.Worker)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), gotLease.ExpiresAt, 5*time.Second)
+		assert.Equal(t, scan.StatusFinished, gotStatus)
+	})
+
+	t.Run(`When scan's lease is lost while running, should not finish the scan`, func(t *testing.T) {
+		leaseCalls := 0
+		wasAcked := false
+
+		st := &ScanTask{
+			Scanners: []scan.Scanner{
+				&scan.MockScanner{
+					MockScan: func(image string) scan.Result {
+						// waits for a heartbeat
+						time.Sleep(100 * time.Millisecond)
+
+						return scan.Result{Scanner: "mocked-scanner"}
+					},
+				},
+			},
+			WorkerID:      "worker-01_42",
+			LeaseDuration: 30 * time.Millisecond,
+		}
+
+		db.SetStorage(&db.MockStorage{
+			MockLeaseScanByID: func(string, int, scan.Lease) error {
+				leaseCalls++
+
+				return db.ErrLeaseLost
+			},
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				assert.Fail(t, "should not update the status of a scan whose lease was lost")
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockAck: func(queue.Job) error {
+				wasAcked = true
+
+				return nil
+			},
+		})
+
+		st.Run(queue.Job{
+			ID:     "5b9f9c1e0c9d440001a1b2c3",
+			ScanID: "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+			Image:  "tsuru/cst:latest",
+		})
+
+		assert.True(t, wasAcked)
+		assert.Equal(t, 1, leaseCalls)
+	})
 }
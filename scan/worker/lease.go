@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/scan"
+)
+
+// heartbeat periodically renews the lease of a running scan on storage.
+type heartbeat struct {
+	scanID   string
+	attempt  int
+	worker   string
+	duration time.Duration
+
+	lost int32
+	stop chan struct{}
+	done chan struct{}
+}
+
+// start keeps renewing the scan's lease, acquired when the scan was started
+// (see db.Storage.StartScanByID), every third of the lease duration in
+// another goroutine until Stop is called.
+func (hb *heartbeat) start(log *logrus.Entry) {
+
+	storage := db.GetStorage()
+
+	hb.stop = make(chan struct{})
+	hb.done = make(chan struct{})
+
+	go func() {
+		defer close(hb.done)
+
+		ticker := time.NewTicker(hb.duration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hb.stop:
+				return
+			case <-ticker.C:
+			}
+
+			err := storage.LeaseScanByID(hb.scanID, hb.attempt, hb.newLease())
+
+			if err == db.ErrLeaseLost {
+				log.Warn("scan's lease was lost, stopping heartbeats")
+				atomic.StoreInt32(&hb.lost, 1)
+
+				return
+			}
+
+			if err != nil {
+				log.WithError(err).Warn("could not renew scan's lease")
+			}
+		}
+	}()
+}
+
+// Stop finishes the heartbeats and reports whether the lease was lost
+// meanwhile.
+func (hb *heartbeat) Stop() bool {
+
+	close(hb.stop)
+	<-hb.done
+
+	return atomic.LoadInt32(&hb.lost) == 1
+}
+
+func (hb *heartbeat) newLease() scan.Lease {
+
+	return scan.Lease{
+		Worker:    hb.worker,
+		ExpiresAt: time.Now().Add(hb.duration),
+	}
+}
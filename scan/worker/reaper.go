@@ -0,0 +1,138 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/queue"
+	"github.com/tsuru/cst/scan"
+)
+
+// reaperScanner names the results reported by Reaper.
+const reaperScanner = `reaper`
+
+// Reaper recovers the scans left in running status by workers which have gone
+// away (e.g. crashed) without finishing them, detected by their expired
+// leases. Reaped scans are enqueued again when they have attempts left,
+// otherwise they fail with a worker-lost error result.
+type Reaper struct {
+	Interval time.Duration
+	Retry    RetryPolicy
+}
+
+// Run reaps the scans with expired leases at each interval until stop is
+// closed.
+func (r *Reaper) Run(stop <-chan struct{}) {
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.Reap()
+		}
+	}
+}
+
+// Reap looks for running scans whose lease has expired and recovers them.
+func (r *Reaper) Reap() {
+
+	storage := db.GetStorage()
+
+	now := time.Now()
+
+	scans, err := storage.GetScansWithExpiredLease(now)
+
+	if err != nil {
+		logrus.WithError(err).Error("could not get scans with expired lease from storage")
+		return
+	}
+
+	for _, expired := range scans {
+		r.recover(expired, now)
+	}
+}
+
+func (r *Reaper) recover(expired scan.Scan, now time.Time) {
+
+	log := logrus.WithField("scan.id", expired.ID)
+
+	if expired.Lease != nil {
+		log = log.
+			WithField("lease.worker", expired.Lease.Worker).
+			WithField("lease.expiresAt", expired.Lease.ExpiresAt)
+	}
+
+	storage := db.GetStorage()
+
+	err := storage.ExpireScanLeaseByID(expired.ID, now)
+
+	if err == db.ErrLeaseLost {
+		log.Debug("scan's lease was renewed or reaped by someone else")
+		return
+	}
+
+	if err != nil {
+		log.WithError(err).Error("could not expire scan's lease on storage")
+		return
+	}
+
+	attempt := expired.Attempts
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	if !r.Retry.CanRetry(attempt) {
+		log.Warn("scan's worker has gone away and there are no attempts left, failing it")
+
+		result := scan.Result{
+			Scanner: reaperScanner,
+			Error: &scan.Error{
+				Code:    scan.ErrorCodeWorkerLost,
+				Phase:   scan.PhaseAnalyze,
+				Message: fmt.Sprintf("worker has gone away after %d attempt(s)", attempt),
+			},
+		}
+
+		if err := storage.AppendResultToScanByID(expired.ID, "", result); err != nil {
+			log.WithError(err).Error("could not update scan's result on storage")
+		}
+
+		if err := storage.UpdateScanByID(expired.ID, scan.StatusFailed, &now); err != nil {
+			log.WithError(err).Error("could not update scan's status on storage")
+		}
+
+		return
+	}
+
+	if err := storage.RescheduleScanByID(expired.ID, attempt); err != nil {
+		log.WithError(err).Error("could not reschedule scan on storage")
+		return
+	}
+
+	_, err = queue.GetQueue().Enqueue(queue.Job{
+		ScanID:  expired.ID,
+		Image:   expired.Image,
+		Attempt: attempt + 1,
+	})
+
+	// the scan is already rescheduled, so it's moved to dead-letter rather
+	// than being left scheduled without a job to run it
+	if err != nil {
+		log.WithError(err).Error("could not enqueue a new attempt of scan, moving it to dead-letter")
+
+		if err := storage.UpdateScanByID(expired.ID, scan.StatusDeadLetter, &now); err != nil {
+			log.WithError(err).Error("could not update scan's status on storage")
+		}
+
+		return
+	}
+
+	log.Warn("scan's worker has gone away, scan was enqueued again")
+}
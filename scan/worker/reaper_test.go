@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tsuru/cst/db"
+	"github.com/tsuru/cst/queue"
+	"github.com/tsuru/cst/scan"
+)
+
+func TestReaper_Reap(t *testing.T) {
+	defer func() {
+		db.SetStorage(nil)
+		queue.SetQueue(nil)
+	}()
+
+	expiredScan := scan.Scan{
+		ID:       "d29b39eb-a5e5-4237-acb4-e7203cd6e2cf",
+		Image:    "tsuru/cst:latest",
+		Status:   scan.StatusRunning,
+		Attempts: 1,
+		Lease: &scan.Lease{
+			Worker:    "worker-01_42",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		},
+	}
+
+	t.Run(`When scan has attempts left, should reschedule and enqueue it again`, func(t *testing.T) {
+		gotAttempts := 0
+		gotJob := queue.Job{}
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansWithExpiredLease: func(time.Time) ([]scan.Scan, error) {
+				return []scan.Scan{expiredScan}, nil
+			},
+			MockRescheduleScanByID: func(_ string, attempts int) error {
+				gotAttempts = attempts
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				gotJob = job
+
+				return "5b9f9c1e0c9d440001a1b2c3", nil
+			},
+		})
+
+		r := &Reaper{Retry: RetryPolicy{MaxAttempts: 3}}
+		r.Reap()
+
+		assert.Equal(t, 1, gotAttempts)
+		assert.Equal(t, expiredScan.ID, gotJob.ScanID)
+		assert.Equal(t, expiredScan.Image, gotJob.Image)
+		assert.Equal(t, 2, gotJob.Attempt)
+	})
+
+	t.Run(`When the next attempt can't be enqueued, should move the rescheduled scan to dead-letter`, func(t *testing.T) {
+		gotStatus := scan.Status("")
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansWithExpiredLease: func(time.Time) ([]scan.Scan, error) {
+				return []scan.Scan{expiredScan}, nil
+			},
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				gotStatus = status
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(queue.Job) (string, error) {
+				return "", errors.New("queue is unavailable")
+			},
+		})
+
+		r := &Reaper{Retry: RetryPolicy{MaxAttempts: 3}}
+		r.Reap()
+
+		assert.Equal(t, scan.StatusDeadLetter, gotStatus)
+	})
+
+	t.Run(`When scan has no attempts left, should fail it with a worker-lost error`, func(t *testing.T) {
+		gotStatus := scan.Status("")
+		gotResult := scan.Result{}
+
+		db.SetStorage(&db.MockStorage{
+			MockGetScansWithExpiredLease: func(time.Time) ([]scan.Scan, error) {
+				return []scan.Scan{expiredScan}, nil
+			},
+			MockAppendResultToScanByID: func(_, _ string, result scan.Result) error {
+				gotResult = result
+
+				return nil
+			},
+			MockUpdateScanByID: func(_ string, status scan.Status, _ *time.Time) error {
+				gotStatus = status
+
+				return nil
+			},
+		})
+
+		queue.SetQueue(queue.MockQueue{
+			MockEnqueue: func(job queue.Job) (string, error) {
+				assert.Fail(t, "should not enqueue a scan without attempts left")
+
+				return "", nil
+			},
+		})
+
+		r := &Reaper{Retry: RetryPolicy{MaxAttempts: 1}}
+		r.Reap()
+
+		assert.Equal(t, scan.StatusFailed, gotStatus)
+		assert.Equal(t, "reaper", gotResult.Scanner)
+		require.NotNil(t, gotResult.Error)
+		assert.Equal(t, scan.ErrorCodeWorkerLost, gotResult.Error.Code)
+	})
+
+	t.Run(`When lease was taken by someone else, should leave the scan untouched`, func(t *testing.T) {
+		db.SetStorage(&db.MockStorage{
+			MockGetScansWithExpiredLease: func(time.Time) ([]scan.Scan, error) {
+				return []scan.Scan{expiredScan}, nil
+			},
+			MockExpireScanLeaseByID: func(string, time.Time) error {
+				return db.ErrLeaseLost
+			},
+			MockRescheduleScanByID: func(string, int) error {
+				assert.Fail(t, "should not reschedule a scan reaped by someone else")
+
+				return nil
+			},
+			MockUpdateScanByID: func(string, scan.Status, *time.Time) error {
+				assert.Fail(t, "should not update a scan reaped by someone else")
+
+				return nil
+			},
+		})
+
+		r := &Reaper{Retry: RetryPolicy{MaxAttempts: 3}}
+		r.Reap()
+	})
+}
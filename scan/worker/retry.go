@@ -0,0 +1,40 @@
+package worker
+
+import "time"
+
+// RetryPolicy defines how many times a scan with transient failures is tried
+// and how long to wait between attempts. The waiting time doubles at each new
+// attempt, starting at InitialBackoff and limited by MaxBackoff (when
+// assigned).
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// CanRetry returns true when a scan which has failed on a given attempt
+// (starting at 1) can be tried again.
+func (rp RetryPolicy) CanRetry(attempt int) bool {
+	return attempt < rp.MaxAttempts
+}
+
+// Backoff returns how long to wait before trying a scan again after a failed
+// attempt (starting at 1).
+func (rp RetryPolicy) Backoff(attempt int) time.Duration {
+
+	backoff := rp.InitialBackoff
+
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+
+		if rp.MaxBackoff > 0 && backoff >= rp.MaxBackoff {
+			return rp.MaxBackoff
+		}
+	}
+
+	if rp.MaxBackoff > 0 && backoff > rp.MaxBackoff {
+		return rp.MaxBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	t.Run(`Ensure backoff doubles at each attempt until reaching the max backoff`, func(t *testing.T) {
+		rp := RetryPolicy{
+			MaxAttempts:    10,
+			InitialBackoff: 30 * time.Second,
+			MaxBackoff:     5 * time.Minute,
+		}
+
+		expected := []time.Duration{
+			30 * time.Second,
+			time.Minute,
+			2 * time.Minute,
+			4 * time.Minute,
+			5 * time.Minute,
+			5 * time.Minute,
+		}
+
+		for index, backoff := range expected {
+			assert.Equal(t, backoff, rp.Backoff(index+1))
+		}
+	})
+}
+
+func TestRetryPolicy_CanRetry(t *testing.T) {
+	t.Run(`Ensure scans can be retried only while there are attempts left`, func(t *testing.T) {
+		rp := RetryPolicy{MaxAttempts: 3}
+
+		assert.True(t, rp.CanRetry(1))
+		assert.True(t, rp.CanRetry(2))
+		assert.False(t, rp.CanRetry(3))
+		assert.False(t, RetryPolicy{}.CanRetry(1))
+	})
+}
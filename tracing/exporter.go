@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultOTLPEndpoint is the traces endpoint of an OpenTelemetry collector
+// running locally.
+const DefaultOTLPEndpoint = `http://localhost:4318/v1/traces`
+
+// ErrUnsupportedExporter indicates the exporter name is unknown.
+var ErrUnsupportedExporter = errors.New(`unsupported tracing exporter`)
+
+// Exporter sends ended spans to some tracing backend.
+type Exporter = sdktrace.SpanExporter
+
+// NewExporter creates an exporter by its name: "otlp" (OTLP over HTTP, sending
+// to endpoint or DefaultOTLPEndpoint when it's empty), "stdout" (a JSON
+// document per span, useful to inspect traces locally) or "none" (same as
+// empty, that is, no exporter). Returns ErrUnsupportedExporter when name is
+// unknown.
+func NewExporter(name, endpoint string) (Exporter, error) {
+
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return exporter, nil
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return exporter, nil
+	default:
+		return nil, ErrUnsupportedExporter
+	}
+}
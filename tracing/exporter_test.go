@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPExporter(t *testing.T) {
+
+	t.Run(`Ensure spans are sent to collector using OTLP over HTTP`, func(t *testing.T) {
+		requests := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/traces", r.URL.Path)
+			assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			var request coltracepb.ExportTraceServiceRequest
+
+			require.NoError(t, proto.Unmarshal(body, &request))
+
+			requests <- &request
+		}))
+
+		defer server.Close()
+
+		exporter, err := NewExporter("otlp", server.URL+"/v1/traces")
+		require.NoError(t, err)
+
+		tracer := NewTracer("cst-worker", exporter)
+
+		ctx := Extract(context.Background(), map[string]string{
+			TraceParentKey: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		})
+
+		_, span := tracer.Start(ctx, "ScanTask.Run", SpanKindConsumer)
+		span.SetAttribute("job.attempt", 2)
+		span.SetError(errors.New("something went wrong"))
+		span.End()
+
+		tracer.Shutdown()
+
+		var request *coltracepb.ExportTraceServiceRequest
+
+		select {
+		case request = <-requests:
+		default:
+			require.Fail(t, "spans were not sent to collector")
+		}
+
+		require.Len(t, request.ResourceSpans, 1)
+
+		resourceSpans := request.ResourceSpans[0]
+
+		assert.Contains(t, resourceSpans.Resource.Attributes, &commonpb.KeyValue{
+			Key:   "service.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "cst-worker"}},
+		})
+
+		require.Len(t, resourceSpans.ScopeSpans, 1)
+		assert.Equal(t, "github.com/tsuru/cst", resourceSpans.ScopeSpans[0].Scope.Name)
+		require.Len(t, resourceSpans.ScopeSpans[0].Spans, 1)
+
+		got := resourceSpans.ScopeSpans[0].Spans[0]
+
+		assert.Equal(t, "ScanTask.Run", got.Name)
+		assert.Equal(t, tracepb.Span_SPAN_KIND_CONSUMER, got.Kind)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(got.TraceId).String())
+		assert.Equal(t, "00f067aa0ba902b7", SpanID(got.ParentSpanId).String())
+		assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, got.Status.Code)
+		assert.Equal(t, "something went wrong", got.Status.Message)
+
+		require.Len(t, got.Attributes, 1)
+		assert.Equal(t, "job.attempt", got.Attributes[0].Key)
+		assert.Equal(t, int64(2), got.Attributes[0].Value.GetIntValue())
+	})
+}
+
+func TestNewExporter(t *testing.T) {
+
+	t.Run(`Ensure exporters are created by their names`, func(t *testing.T) {
+		for _, name := range []string{"", "none"} {
+			exporter, err := NewExporter(name, "")
+
+			assert.NoError(t, err)
+			assert.Nil(t, exporter)
+		}
+
+		exporter, err := NewExporter("otlp", "")
+
+		assert.NoError(t, err)
+		assert.IsType(t, &otlptrace.Exporter{}, exporter)
+
+		exporter, err = NewExporter("stdout", "")
+
+		assert.NoError(t, err)
+		assert.IsType(t, &stdouttrace.Exporter{}, exporter)
+	})
+
+	t.Run(`When exporter is unknown, should return ErrUnsupportedExporter`, func(t *testing.T) {
+		_, err := NewExporter("zipkin", "")
+
+		assert.Equal(t, ErrUnsupportedExporter, err)
+	})
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanKind describes the relationship between a span and its parent.
+type SpanKind = trace.SpanKind
+
+const (
+	// SpanKindInternal indicates an internal operation.
+	SpanKindInternal = trace.SpanKindInternal
+
+	// SpanKindServer indicates a synchronous request handled by server.
+	SpanKindServer = trace.SpanKindServer
+
+	// SpanKindClient indicates a synchronous request to a remote service.
+	SpanKindClient = trace.SpanKindClient
+
+	// SpanKindProducer indicates a job published on queue.
+	SpanKindProducer = trace.SpanKindProducer
+
+	// SpanKindConsumer indicates a job consumed from queue.
+	SpanKindConsumer = trace.SpanKindConsumer
+)
+
+// Span represents a single operation within a trace.
+type Span struct {
+	span trace.Span
+}
+
+// SpanContext returns the identifiers of span.
+func (s *Span) SpanContext() SpanContext {
+	return spanContextOf(s.span.SpanContext())
+}
+
+// SetAttribute adds (or replaces) an attribute on span. Values other than
+// strings, booleans and numbers are formatted as strings.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(attributeOf(key, value))
+}
+
+// SetError marks span as failed with err. Nil errors are ignored.
+func (s *Span) SetError(err error) {
+
+	if err == nil {
+		return
+	}
+
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End finishes the span and sends it to exporter. Calls after the first one
+// are ignored.
+func (s *Span) End() {
+	s.span.End()
+}
+
+func attributeOf(key string, value interface{}) attribute.KeyValue {
+
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
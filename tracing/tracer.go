@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// instrumentationName identifies CST as the instrumentation scope of
+	// its spans.
+	instrumentationName = `github.com/tsuru/cst`
+
+	shutdownTimeout = 10 * time.Second
+)
+
+var (
+	tracer      = NewTracer("cst", nil)
+	tracerMutex sync.RWMutex
+)
+
+// Tracer creates spans and delivers the ended ones to an exporter in batches.
+// A tracer without exporter still creates and propagates spans, but discards
+// them.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+
+	once sync.Once
+}
+
+// Start creates a new span as child of the span on ctx (or of the span context
+// extracted from another process). The returned context holds the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind))
+
+	return ctx, &Span{span: span}
+}
+
+// Shutdown exports the pending spans and releases the exporter.
+func (t *Tracer) Shutdown() {
+
+	t.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := t.provider.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("could not shutdown tracing exporter")
+		}
+	})
+}
+
+// NewTracer creates a new instance of Tracer identified by serviceName. When
+// exporter is nil, spans are discarded.
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	}
+
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+
+	return &Tracer{
+		provider: provider,
+		tracer:   provider.Tracer(instrumentationName),
+	}
+}
+
+// SetTracer assigns the tracer used by Start.
+func SetTracer(t *Tracer) {
+
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+
+	tracer = t
+}
+
+// GetTracer returns the tracer used by Start.
+func GetTracer() *Tracer {
+
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+
+	return tracer
+}
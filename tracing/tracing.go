@@ -0,0 +1,59 @@
+// Package tracing records the path of a scan through API, queue and workers
+// as OpenTelemetry spans. Trace context is propagated between processes using
+// the W3C Trace Context format (traceparent).
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceParentKey is the key (HTTP header or job metadata) which carries the
+// trace context.
+const TraceParentKey = `traceparent`
+
+// propagator reads and writes the W3C traceparent on carriers.
+var propagator = propagation.TraceContext{}
+
+// TraceID identifies a trace.
+type TraceID = trace.TraceID
+
+// SpanID identifies a span within a trace.
+type SpanID = trace.SpanID
+
+// SpanContext holds the identifiers propagated to child spans, even across
+// processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Inject writes the trace context of ctx on carrier (e.g. job metadata).
+// Nothing is written when ctx has no span.
+func Inject(ctx context.Context, carrier map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(carrier))
+}
+
+// Extract reads the trace context from carrier and returns a copy of ctx in
+// which new spans continue that trace. Malformed values are ignored.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Start creates a new span, using the tracer assigned by SetTracer, as child
+// of the span on ctx (when there is one). The returned context holds the new
+// span.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return GetTracer().Start(ctx, name, kind)
+}
+
+func spanContextOf(sc trace.SpanContext) SpanContext {
+	return SpanContext{
+		TraceID: sc.TraceID(),
+		SpanID:  sc.SpanID(),
+		Sampled: sc.IsSampled(),
+	}
+}
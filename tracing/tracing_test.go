@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestInjectExtract(t *testing.T) {
+
+	t.Run(`Ensure spans started after extraction continue the injected trace`, func(t *testing.T) {
+		exporter := &recordingExporter{}
+		tracer := NewTracer("cst-server", exporter)
+
+		ctx, parent := tracer.Start(context.Background(), "DefaultScheduler.Schedule", SpanKindInternal)
+
+		carrier := map[string]string{}
+
+		Inject(ctx, carrier)
+
+		require.Contains(t, carrier, TraceParentKey)
+
+		_, child := tracer.Start(Extract(context.Background(), carrier), "ScanTask.Run", SpanKindConsumer)
+
+		assert.Equal(t, parent.SpanContext().TraceID, child.SpanContext().TraceID)
+		assert.NotEqual(t, parent.SpanContext().SpanID, child.SpanContext().SpanID)
+
+		child.End()
+		tracer.Shutdown()
+
+		require.Len(t, exporter.spans, 1)
+		assert.Equal(t, parent.SpanContext().SpanID, exporter.spans[0].Parent().SpanID())
+		assert.True(t, exporter.spans[0].Parent().IsRemote())
+	})
+
+	t.Run(`When context has no span, should not inject anything`, func(t *testing.T) {
+		carrier := map[string]string{}
+
+		Inject(context.Background(), carrier)
+
+		assert.Empty(t, carrier)
+	})
+}
+
+type recordingExporter struct {
+	spans    []sdktrace.ReadOnlySpan
+	shutdown bool
+}
+
+func (re *recordingExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	re.spans = append(re.spans, spans...)
+	return nil
+}
+
+func (re *recordingExporter) Shutdown(context.Context) error {
+	re.shutdown = true
+	return nil
+}
+
+func TestTracer_Shutdown(t *testing.T) {
+
+	t.Run(`Ensure ended spans are exported on shutdown`, func(t *testing.T) {
+		exporter := &recordingExporter{}
+		tracer := NewTracer("cst-worker", exporter)
+
+		ctx, parent := tracer.Start(context.Background(), "ScanTask.Run", SpanKindConsumer)
+		_, child := tracer.Start(ctx, "Scanner.Scan", SpanKindInternal)
+
+		child.SetAttribute("scanner", "clair")
+		child.SetError(errors.New("clair is unavailable"))
+		child.End()
+		parent.End()
+		parent.End()
+
+		tracer.Shutdown()
+
+		require.Len(t, exporter.spans, 2)
+		assert.Equal(t, "Scanner.Scan", exporter.spans[0].Name())
+		assert.Equal(t, []attribute.KeyValue{attribute.String("scanner", "clair")}, exporter.spans[0].Attributes())
+		assert.Equal(t, codes.Error, exporter.spans[0].Status().Code)
+		assert.Equal(t, "clair is unavailable", exporter.spans[0].Status().Description)
+		assert.Equal(t, parent.SpanContext().SpanID, exporter.spans[0].Parent().SpanID())
+		assert.Equal(t, "ScanTask.Run", exporter.spans[1].Name())
+		assert.True(t, exporter.shutdown)
+	})
+}